@@ -0,0 +1,68 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/mtail/internal/runtime/compiler"
+	"github.com/google/mtail/internal/runtime/debugger"
+)
+
+// debugMain runs the interactive program debugger, as `mtail debug`, and
+// returns the process exit code.
+func debugMain(args []string) int {
+	fs := flag.NewFlagSet("mtail debug", flag.ContinueOnError)
+	prog := fs.String("prog", "", "Path of the mtail program to debug.")
+	logPath := fs.String("log", "", "Path of the log file to run the program over.")
+	overrideTimezone := fs.String("override_timezone", "", "If set, use the provided timezone in timestamp conversion, instead of UTC.")
+	maxRegexpLength := fs.Int("max_regexp_length", 1024, "The maximum length a mtail regexp expression can have. Excessively long patterns are likely to cause compilation and runtime performance problems.")
+	maxRecursionDepth := fs.Int("max_recursion_depth", 100, "The maximum length a mtail statement can be, as measured by parsed tokens. Excessively long mtail expressions are likely to cause compilation and runtime performance problems.  Also limits the depth of nested calls to user-defined functions.")
+	importPath := fs.String("import_path", "", "List of directories, separated by the OS path list separator, searched in order for the modules named in the program's import statements, before the standard library bundled with mtail.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mtail debug --prog PROGRAM --log LOGFILE\n\n")
+		fmt.Fprintf(fs.Output(), "Steps PROGRAM through the lines of LOGFILE under control of commands read from standard input.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *prog == "" || *logPath == "" {
+		fs.Usage()
+		return 2
+	}
+	loc, err := time.LoadLocation(*overrideTimezone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't parse timezone %q: %s\n", *overrideTimezone, err)
+		return 1
+	}
+	source, err := os.ReadFile(*prog)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	f, err := os.Open(*logPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer f.Close()
+	d, err := debugger.New(filepath.Base(*prog), source, *logPath, f, os.Stdout, loc,
+		compiler.MaxRegexpLength(*maxRegexpLength),
+		compiler.MaxRecursionDepth(*maxRecursionDepth),
+		compiler.ImportPath(filepath.SplitList(*importPath)...))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := d.Run(os.Stdin); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
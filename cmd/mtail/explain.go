@@ -0,0 +1,102 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/mtail/internal/runtime"
+	"github.com/google/mtail/internal/runtime/compiler"
+)
+
+// explainMain explains how a program processes log lines, as `mtail
+// explain`, and returns the process exit code.
+func explainMain(args []string) int {
+	fs := flag.NewFlagSet("mtail explain", flag.ContinueOnError)
+	prog := fs.String("prog", "", "Path of the mtail program to explain.")
+	line := fs.String("line", "", "The log line to explain.  If empty, each line of the log file, or of standard input, is explained in turn.")
+	logPath := fs.String("log", "", "Path of a log file whose lines are explained, if --line is not given.")
+	overrideTimezone := fs.String("override_timezone", "", "If set, use the provided timezone in timestamp conversion, instead of UTC.")
+	maxRegexpLength := fs.Int("max_regexp_length", 1024, "The maximum length a mtail regexp expression can have. Excessively long patterns are likely to cause compilation and runtime performance problems.")
+	maxRecursionDepth := fs.Int("max_recursion_depth", 100, "The maximum length a mtail statement can be, as measured by parsed tokens. Excessively long mtail expressions are likely to cause compilation and runtime performance problems.  Also limits the depth of nested calls to user-defined functions.")
+	importPath := fs.String("import_path", "", "List of directories, separated by the OS path list separator, searched in order for the modules named in the program's import statements, before the standard library bundled with mtail.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mtail explain --prog PROGRAM [--line LINE | --log LOGFILE]\n\n")
+		fmt.Fprintf(fs.Output(), "Shows which patterns PROGRAM tried on each log line, which conditions and otherwise blocks were taken, and which metrics changed.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *prog == "" {
+		fs.Usage()
+		return 2
+	}
+	loc, err := time.LoadLocation(*overrideTimezone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't parse timezone %q: %s\n", *overrideTimezone, err)
+		return 1
+	}
+	var lines []string
+	filename := *logPath
+	switch {
+	case *line != "":
+		lines = []string{*line}
+	case *logPath != "":
+		f, err := os.Open(*logPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		lines, err = readLines(f)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	default:
+		filename = "-"
+		lines, err = readLines(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	source, err := os.Open(*prog)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer source.Close()
+	explanations, err := runtime.ExplainLines(filepath.Base(*prog), source, filename, lines, loc,
+		compiler.MaxRegexpLength(*maxRegexpLength),
+		compiler.MaxRecursionDepth(*maxRecursionDepth),
+		compiler.ImportPath(filepath.SplitList(*importPath)...))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	for i, e := range explanations {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Print(e)
+	}
+	return 0
+}
+
+func readLines(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	return lines, scanner.Err()
+}
@@ -9,18 +9,19 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/google/mtail/internal/config"
 	"github.com/google/mtail/internal/daemon"
 	"github.com/google/mtail/internal/exporter"
 	"github.com/google/mtail/internal/metrics"
 	"github.com/google/mtail/internal/mtail"
 	"github.com/google/mtail/internal/waker"
-	"go.opencensus.io/trace"
 )
 
 type seqStringFlag []string
@@ -43,10 +44,13 @@ var (
 	address            = flag.String("address", "", "Host or IP address on which to bind HTTP listener")
 	unixSocket         = flag.String("unix_socket", "", "UNIX Socket to listen on")
 	progs              = flag.String("progs", "", "Name of the directory containing mtail programs")
+	importPathFlag     = flag.String("import_path", "", "List of directories, separated by the OS path list separator, searched in order for the modules named in programs' import statements, before the standard library bundled with mtail.")
 	ignoreRegexPattern = flag.String("ignore_filename_regex_pattern", "", "")
 
 	version = flag.Bool("version", false, "Print mtail version information.")
 
+	configFile = flag.String("config", "", "Path of a YAML configuration file, whose keys are the names of these flags, and sources, a list of log path patterns with settings for the logs that match them.  Flags given on the commandline take precedence over the file.  Log sources are added, and their program routes updated, when the file is reloaded on SIGHUP.")
+
 	// Compiler behaviour flags.
 	oneShot       = flag.Bool("one_shot", false, "Compile the programs, then read the contents of the provided logs from start until EOF, print the values of the metrics store in the given format and exit. This is a debugging flag only, not for production use.")
 	oneShotFormat = flag.String("one_shot_format", "json", "Format to use with -one_shot. This is a debugging flag only, not for production use. Supported formats: json, prometheus.")
@@ -56,11 +60,17 @@ var (
 	dumpBytecode  = flag.Bool("dump_bytecode", false, "Dump bytecode of programs (to INFO log).")
 
 	// VM Runtime behaviour flags.
-	syslogUseCurrentYear = flag.Bool("syslog_use_current_year", true, "Patch yearless timestamps with the present year.")
-	overrideTimezone     = flag.String("override_timezone", "", "If set, use the provided timezone in timestamp conversion, instead of UTC.")
-	emitProgLabel        = flag.Bool("emit_prog_label", true, "Emit the 'prog' label in variable exports.")
-	emitMetricTimestamp  = flag.Bool("emit_metric_timestamp", false, "Emit the recorded timestamp of a metric.  If disabled (the default) no explicit timestamp is sent to a collector.")
-	logRuntimeErrors     = flag.Bool("vm_logs_runtime_errors", true, "Enables logging of runtime errors to the standard log.  Set to false to only have the errors printed to the HTTP console.")
+	syslogUseCurrentYear  = flag.Bool("syslog_use_current_year", true, "Patch yearless timestamps with the present year.")
+	overrideTimezone      = flag.String("override_timezone", "", "If set, use the provided timezone in timestamp conversion, instead of UTC.")
+	emitProgLabel         = flag.Bool("emit_prog_label", true, "Emit the 'prog' label in variable exports.")
+	emitMetricTimestamp   = flag.Bool("emit_metric_timestamp", false, "Emit the recorded timestamp of a metric.  If disabled (the default) no explicit timestamp is sent to a collector.")
+	logRuntimeErrors      = flag.Bool("vm_logs_runtime_errors", true, "Enables logging of runtime errors to the standard log.  Set to false to only have the errors printed to the HTTP console.")
+	lineInstructionBudget = flag.Int("vm_line_instruction_budget", 0, "The maximum number of instructions a program may execute on a single log line before the line is abandoned with a runtime error.  Zero means no limit.")
+	lineTimeBudget        = flag.Duration("vm_line_time_budget", 0, "The maximum time a program may spend on a single log line before the line is abandoned with a runtime error.  It is checked between instructions, so doesn't interrupt a regular expression match.  Zero means no limit.")
+	matchLengthBudget     = flag.Int("vm_match_length_budget", 0, "The maximum length in bytes of the text a program may match a regular expression against.  Lines with longer input are abandoned with a runtime error before the match, bounding the time of each match.  Zero means no limit.")
+	profileVM             = flag.Bool("vm_profile", false, "Count executions and time spent in each instruction of each program.  The profile is served at /progz?prog=NAME&profile=1 as annotated source, and with profile=pprof for go tool pprof.")
+	vmReplicas            = flag.Int("vm_replicas", 1, "Number of virtual machines to run in parallel for each program, with log lines shared between them.  Programs that declare pragma serial or a map variable always run on one.")
+	registerVM            = flag.Bool("experimental_register_vm", false, "Execute programs on the experimental register-based virtual machine instead of the stack machine.")
 
 	// Ops flags.
 	pollInterval                = flag.Duration("poll_interval", 250*time.Millisecond, "Set the interval to poll each log file for data; must be positive, or zero to disable polling.  With polling mode, only the files found at mtail startup will be polled.")
@@ -69,7 +79,11 @@ var (
 	staleLogGcTickInterval      = flag.Duration("stale_log_gc_interval", time.Hour, "interval between stale log garbage collection runs")
 	metricPushInterval          = flag.Duration("metric_push_interval", time.Minute, "interval between metric pushes to passive collectors")
 	maxRegexpLength             = flag.Int("max_regexp_length", 1024, "The maximum length a mtail regexp expression can have. Excessively long patterns are likely to cause compilation and runtime performance problems.")
-	maxRecursionDepth           = flag.Int("max_recursion_depth", 100, "The maximum length a mtail statement can be, as measured by parsed tokens. Excessively long mtail expressions are likely to cause compilation and runtime performance problems.")
+	watchPrograms               = flag.Bool("watch_programs", false, "Reload programs when files in the program directory change, as well as on SIGHUP.")
+	programWatchDebounce        = flag.Duration("program_watch_debounce", 500*time.Millisecond, "How long the program directory must be unchanged before programs are reloaded, so that a burst of changes causes one reload.")
+	programPollInterval         = flag.Duration("program_poll_interval", time.Second, "Set the interval to poll the program directory for changes when filesystem notifications are unavailable.")
+	maxSelfMetricLabelValues    = flag.Int("max_self_metric_label_values", 100, "The maximum number of log files exported as labels of each of mtail's own per-log metrics on /metrics; the counts of further log files are summed into the label value other.  Zero means no limit.  /debug/vars is not limited.")
+	maxRecursionDepth           = flag.Int("max_recursion_depth", 100, "The maximum length a mtail statement can be, as measured by parsed tokens. Excessively long mtail expressions are likely to cause compilation and runtime performance problems.  Also limits the depth of nested calls to user-defined functions.")
 
 	// Debugging flags.
 	blockProfileRate     = flag.Int("block_profile_rate", 0, "Nanoseconds of block time before goroutine blocking events reported. 0 turns off.  See https://golang.org/pkg/runtime/#SetBlockProfileRate")
@@ -77,13 +91,19 @@ var (
 	httpDebugEndpoints   = flag.Bool("http_debugging_endpoint", true, "Enable debugging endpoints (/debug/*).")
 	httpInfoEndpoints    = flag.Bool("http_info_endpoint", true, "Enable info endpoints (/progz,/varz).")
 
+	// Admin flags.
+	adminTokenFile = flag.String("admin_token_file", "", "Path of a file containing a secret token.  If set, the program admin API is served on /admin/ to requests with the header Authorization: Bearer TOKEN.")
+
 	// Tracing.
-	jaegerEndpoint    = flag.String("jaeger_endpoint", "", "If set, collector endpoint URL of jaeger thrift service")
+	otlpTraceEndpoint = flag.String("otlp_trace_endpoint", "", "If set, URL of the OTLP/HTTP traces endpoint of an OpenTelemetry collector to send traces to, e.g. http://localhost:4318/v1/traces")
 	traceSamplePeriod = flag.Int("trace_sample_period", 0, "Sample period for traces.  If non-zero, every nth trace will be sampled.")
 
 	// Deprecated.
 	_ = flag.Bool("disable_fsnotify", true, "DEPRECATED: this flag is no longer in use.")
 	_ = flag.Int("metric_push_interval_seconds", 0, "DEPRECATED: use --metric_push_interval instead")
+
+	// Removed, but still accepted so that mtail can say what to use instead.
+	jaegerEndpoint = flag.String("jaeger_endpoint", "", "REMOVED: use --otlp_trace_endpoint instead; mtail exits if this is set")
 )
 
 func init() {
@@ -107,12 +127,35 @@ func main() {
 		Revision: Revision,
 	}
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "debug":
+			os.Exit(debugMain(os.Args[2:]))
+		case "explain":
+			os.Exit(explainMain(os.Args[2:]))
+		}
+	}
+
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "%s\n", buildInfo.String())
 		fmt.Fprintf(os.Stderr, "\nUsage:\n")
 		flag.PrintDefaults()
+		fmt.Fprintf(os.Stderr, "\nTo step a program through a log interactively, see `%s debug --help'.\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "To see why a program did or didn't match a log line, see `%s explain --help'.\n", os.Args[0])
 	}
 	flag.Parse()
+	var cfg *config.Config
+	if *configFile != "" {
+		var err error
+		if cfg, err = config.Load(*configFile, flag.CommandLine); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration file:\n%s\n", err)
+			os.Exit(1)
+		}
+		if err = cfg.Apply(flag.CommandLine); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration file:\n%s\n", err)
+			os.Exit(1)
+		}
+	}
 	if *version {
 		fmt.Println(buildInfo.String())
 		os.Exit(0)
@@ -122,6 +165,9 @@ func main() {
 	if len(flag.Args()) > 0 {
 		glog.Exitf("Too many extra arguments specified: %q\n(the logs flag can be repeated, or the filenames separated by commas.)", flag.Args())
 	}
+	if *jaegerEndpoint != "" {
+		glog.Exitf("The flag --jaeger_endpoint is no longer supported; use --otlp_trace_endpoint instead.  Jaeger accepts OTLP on port 4318, e.g. --otlp_trace_endpoint http://localhost:4318/v1/traces")
+	}
 	loc, err := time.LoadLocation(*overrideTimezone)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Couldn't parse timezone %q: %s", *overrideTimezone, err)
@@ -139,14 +185,11 @@ func main() {
 		glog.Exitf("mtail requires programs that instruct it how to extract metrics from logs; please use the flag -progs to specify the directory containing the programs.")
 	}
 	if !(*dumpBytecode || *dumpAst || *dumpAstTypes || *compileOnly) {
-		if len(logs) == 0 {
-			glog.Exitf("mtail requires the names of logs to follow in order to extract logs from them; please use the flag -logs one or more times to specify glob patterns describing these logs.")
+		if len(logs) == 0 && (cfg == nil || len(cfg.Sources) == 0) {
+			glog.Exitf("mtail requires the names of logs to follow in order to extract logs from them; please use the flag -logs one or more times, or sources in the configuration file, to specify glob patterns describing these logs.")
 		}
 	}
 
-	if *traceSamplePeriod > 0 {
-		trace.ApplyConfig(trace.Config{DefaultSampler: trace.ProbabilitySampler(1 / float64(*traceSamplePeriod))})
-	}
 	if *pollInterval == 0 {
 		glog.Infof("no poll log data interval specified; defaulting to 250ms poll")
 		*pollInterval = time.Millisecond * 250
@@ -180,11 +223,31 @@ func main() {
 		mtail.MetricPushInterval(*metricPushInterval),
 		mtail.MaxRegexpLength(*maxRegexpLength),
 		mtail.MaxRecursionDepth(*maxRecursionDepth),
+		mtail.ImportPath(filepath.SplitList(*importPathFlag)...),
+		mtail.MaxSelfMetricLabelValues(*maxSelfMetricLabelValues),
+		mtail.VMReplicas(*vmReplicas),
+		mtail.LineInstructionBudget(*lineInstructionBudget),
+		mtail.LineTimeBudget(*lineTimeBudget),
+		mtail.MatchLengthBudget(*matchLengthBudget),
+	}
+	if cfg != nil {
+		for _, s := range cfg.LogSources() {
+			opts = append(opts, s)
+		}
 	}
 	eOpts := []exporter.Option{}
 	if *logRuntimeErrors {
 		opts = append(opts, mtail.LogRuntimeErrors)
 	}
+	if *watchPrograms {
+		opts = append(opts, mtail.WatchPrograms(*programWatchDebounce), mtail.ProgramPollInterval(*programPollInterval))
+	}
+	if *profileVM {
+		opts = append(opts, mtail.ProfileVM)
+	}
+	if *registerVM {
+		opts = append(opts, mtail.RegisterVM)
+	}
 	if *staleLogGcTickInterval > 0 {
 		staleLogGcWaker := waker.NewTimed(ctx, *staleLogGcTickInterval)
 		opts = append(opts, mtail.StaleLogGcWaker(staleLogGcWaker))
@@ -220,6 +283,13 @@ func main() {
 	if *httpInfoEndpoints {
 		opts = append(opts, mtail.HTTPInfoEndpoints)
 	}
+	if *adminTokenFile != "" {
+		token, err := os.ReadFile(*adminTokenFile)
+		if err != nil {
+			glog.Exitf("Couldn't read admin token: %s", err)
+		}
+		opts = append(opts, mtail.AdminToken(strings.TrimSpace(string(token))))
+	}
 	if *syslogUseCurrentYear {
 		opts = append(opts, mtail.SyslogUseCurrentYear)
 	}
@@ -231,8 +301,8 @@ func main() {
 		opts = append(opts, mtail.EmitMetricTimestamp)
 		eOpts = append(eOpts, exporter.EmitTimestamp())
 	}
-	if *jaegerEndpoint != "" {
-		opts = append(opts, mtail.JaegerReporter(*jaegerEndpoint))
+	if *otlpTraceEndpoint != "" {
+		opts = append(opts, mtail.OTLPTraceEndpoint(*otlpTraceEndpoint), mtail.TraceSamplePeriod(*traceSamplePeriod))
 	}
 	store := metrics.NewStore()
 	if *expiredMetricGcTickInterval > 0 {
@@ -244,6 +314,9 @@ func main() {
 		cancel()
 		os.Exit(1) //nolint:gocritic // false positive
 	}
+	if cfg != nil && !*oneShot {
+		go reloadConfigOnHangup(ctx, m, cfg)
+	}
 	err = m.Run()
 	if err != nil {
 		glog.Error(err)
@@ -282,3 +355,40 @@ func main() {
 		}
 	}
 }
+
+// reloadConfigOnHangup rereads the configuration file on SIGHUP, applying the
+// changes that can be made while mtail runs and warning of the others.
+func reloadConfigOnHangup(ctx context.Context, m *mtail.Server, cfg *config.Config) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		next, err := config.Load(cfg.Filename, flag.CommandLine)
+		if err != nil {
+			glog.Errorf("Not reloading invalid configuration file:\n%s", err)
+			continue
+		}
+		changes := cfg.Changes(next)
+		for _, r := range changes.Restart {
+			glog.Warningf("Configuration %s; restart mtail to apply", r)
+		}
+		var added []config.Source
+		for _, s := range changes.Added {
+			if err := m.AddLogSource(s.LogSource()); err != nil {
+				glog.Errorf("Adding log source %q: %s", s.Path, err)
+				continue
+			}
+			added = append(added, s)
+		}
+		cfg = cfg.Reloaded(next, added)
+		if err := m.SetLogSourceRoutes(cfg.LogSources()); err != nil {
+			glog.Errorf("Updating program routes: %s", err)
+		}
+		glog.Infof("Reloaded configuration file %s", cfg.Filename)
+	}
+}
@@ -0,0 +1,459 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+// Package config reads mtail's configuration file.
+//
+// The file is YAML.  Its top level maps the names of mtail's commandline
+// flags to their values, so that anything that can be set by a flag can be
+// set in the file, and flags given on the commandline take precedence over
+// the file.  Flags that may be repeated, like logs, take a list.  The one
+// other key, sources, lists log path patterns with settings for the logs that
+// match them:
+//
+//	progs: /etc/mtail
+//	port: 3903
+//	logs:
+//	  - /var/log/syslog
+//	sources:
+//	  - path: /var/log/nginx/*.log
+//	    poll_interval: 1s
+//	    encoding: latin1
+//	    programs: [nginx.mtail]
+package config
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/mtail/internal/mtail"
+	"github.com/google/mtail/internal/tailer/logstream"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// sourcesKey is the key of the list of log sources, which is not a flag.
+const sourcesKey = "sources"
+
+// configFlag is the name of the flag that names the configuration file, which can't be set in it.
+const configFlag = "config"
+
+// Setting is the value of a flag set in the configuration file.
+type Setting struct {
+	Name   string
+	Values []string // More than one for repeatable flags.
+	line   int
+}
+
+// Source is a log path pattern with settings for the logs that match it.
+type Source struct {
+	Path         string        // Log path pattern, as for the logs flag.
+	PollInterval time.Duration // Interval to poll the idle logs, instead of poll_interval, if positive.
+	Encoding     string        // Character encoding of the logs, utf-8 if empty.
+	Programs     []string      // Programs to send the lines of the logs to, instead of every program, if not empty.
+}
+
+// LogSource returns the mtail.Server option for the source.
+func (s Source) LogSource() mtail.LogSource {
+	return mtail.LogSource{Pattern: s.Path, PollInterval: s.PollInterval, Encoding: s.Encoding, Programs: s.Programs}
+}
+
+// Config is the contents of a configuration file.
+type Config struct {
+	Filename string
+	Settings []Setting // In the order they appear in the file.
+	Sources  []Source
+
+	overridden map[string]bool // Flags set on the commandline, which Apply left alone.
+}
+
+// Load reads and validates the configuration file filename, for the flags in fs.
+func Load(filename string, fs *flag.FlagSet) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading configuration")
+	}
+	return Parse(filename, data, fs)
+}
+
+// errorList collects the errors found in a configuration file, so they can all be reported at once.
+type errorList struct {
+	filename string
+	errs     []string
+}
+
+func (l *errorList) add(line int, format string, args ...interface{}) {
+	l.errs = append(l.errs, fmt.Sprintf("%s:%d: %s", l.filename, line, fmt.Sprintf(format, args...)))
+}
+
+func (l *errorList) err() error {
+	if len(l.errs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(l.errs, "\n"))
+}
+
+// Parse parses and validates configuration data read from filename, for the flags in fs.
+func Parse(filename string, data []byte, fs *flag.FlagSet) (*Config, error) {
+	c := &Config{Filename: filename}
+	errs := &errorList{filename: filename}
+	var doc yaml.Node
+	if err := yaml.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil && err != io.EOF {
+		return nil, errors.Wrapf(err, "parsing %s", filename)
+	}
+	if len(doc.Content) == 0 {
+		// An empty file sets nothing.
+		return c, nil
+	}
+	top := doc.Content[0]
+	if top.Kind != yaml.MappingNode {
+		errs.add(top.Line, "expected a mapping of settings")
+		return nil, errs.err()
+	}
+	seen := make(map[string]bool)
+	for i := 0; i+1 < len(top.Content); i += 2 {
+		key, value := top.Content[i], top.Content[i+1]
+		name := key.Value
+		if seen[name] {
+			errs.add(key.Line, "%s is set more than once", name)
+			continue
+		}
+		seen[name] = true
+		if name == sourcesKey {
+			c.Sources = parseSources(value, errs)
+			continue
+		}
+		if s, ok := parseSetting(name, key.Line, value, fs, errs); ok {
+			c.Settings = append(c.Settings, s)
+		}
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseSetting parses and validates the value of the flag name.
+func parseSetting(name string, line int, value *yaml.Node, fs *flag.FlagSet, errs *errorList) (Setting, bool) {
+	if name == configFlag {
+		errs.add(line, "%s can only be set on the commandline", name)
+		return Setting{}, false
+	}
+	f := fs.Lookup(name)
+	if f == nil {
+		errs.add(line, "unknown setting %q", name)
+		return Setting{}, false
+	}
+	s := Setting{Name: name, line: line}
+	switch value.Kind {
+	case yaml.ScalarNode:
+		if value.Tag == "!!null" {
+			errs.add(value.Line, "%s has no value", name)
+			return Setting{}, false
+		}
+		s.Values = []string{value.Value}
+	case yaml.SequenceNode:
+		if !repeatable(f) {
+			errs.add(value.Line, "%s takes a single value, not a list", name)
+			return Setting{}, false
+		}
+		for _, v := range value.Content {
+			if v.Kind != yaml.ScalarNode {
+				errs.add(v.Line, "%s takes a list of values", name)
+				return Setting{}, false
+			}
+			s.Values = append(s.Values, v.Value)
+		}
+	default:
+		errs.add(value.Line, "%s takes a value, not a mapping", name)
+		return Setting{}, false
+	}
+	ok := true
+	for _, v := range s.Values {
+		if err := validateValue(f, v); err != nil {
+			errs.add(value.Line, "invalid value %q for %s: %s", v, name, err)
+			ok = false
+		}
+	}
+	return s, ok
+}
+
+// repeatable returns whether the flag may be given more than once.  The flag
+// package's own flag types keep only the last value they are set to; those
+// that can be repeated, like logs, are mtail's own and are not flag.Getters.
+func repeatable(f *flag.Flag) bool {
+	_, ok := f.Value.(flag.Getter)
+	return !ok
+}
+
+// validateValue checks that v can be parsed as a value of the flag f, without setting it.
+func validateValue(f *flag.Flag, v string) error {
+	g, ok := f.Value.(flag.Getter)
+	if !ok {
+		return nil
+	}
+	var err error
+	switch g.Get().(type) {
+	case bool:
+		_, err = strconv.ParseBool(v)
+	case int:
+		_, err = strconv.ParseInt(v, 0, strconv.IntSize)
+	case int64:
+		_, err = strconv.ParseInt(v, 0, 64)
+	case uint:
+		_, err = strconv.ParseUint(v, 0, strconv.IntSize)
+	case uint64:
+		_, err = strconv.ParseUint(v, 0, 64)
+	case float64:
+		_, err = strconv.ParseFloat(v, 64)
+	case time.Duration:
+		_, err = time.ParseDuration(v)
+	}
+	if numErr, ok := err.(*strconv.NumError); ok {
+		err = numErr.Err
+	}
+	return err
+}
+
+// parseSources parses and validates the list of log sources.
+func parseSources(value *yaml.Node, errs *errorList) []Source {
+	if value.Kind != yaml.SequenceNode {
+		errs.add(value.Line, "%s takes a list of sources", sourcesKey)
+		return nil
+	}
+	var sources []Source
+	paths := make(map[string]int)
+	for _, n := range value.Content {
+		if n.Kind != yaml.MappingNode {
+			errs.add(n.Line, "a source is a mapping of settings")
+			continue
+		}
+		var s Source
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, v := n.Content[i], n.Content[i+1]
+			switch key.Value {
+			case "path":
+				s.Path = v.Value
+			case "poll_interval":
+				d, err := time.ParseDuration(v.Value)
+				if err != nil || d <= 0 {
+					errs.add(v.Line, "invalid poll_interval %q: must be a positive duration", v.Value)
+				}
+				s.PollInterval = d
+			case "encoding":
+				if err := logstream.ValidateEncoding(v.Value); err != nil {
+					errs.add(v.Line, "%s", err)
+				}
+				s.Encoding = v.Value
+			case "programs":
+				if v.Kind != yaml.SequenceNode {
+					errs.add(v.Line, "programs takes a list of program names")
+					continue
+				}
+				for _, p := range v.Content {
+					if p.Kind != yaml.ScalarNode || p.Value == "" || strings.ContainsAny(p.Value, `/\`) {
+						errs.add(p.Line, "invalid program name %q: must be the name of a file in the program directory", p.Value)
+						continue
+					}
+					s.Programs = append(s.Programs, p.Value)
+				}
+			default:
+				errs.add(key.Line, "unknown source setting %q", key.Value)
+			}
+		}
+		if s.Path == "" {
+			errs.add(n.Line, "source has no path")
+			continue
+		}
+		if line, ok := paths[s.Path]; ok {
+			errs.add(n.Line, "source %q is already listed on line %d", s.Path, line)
+			continue
+		}
+		paths[s.Path] = n.Line
+		sources = append(sources, s)
+	}
+	return sources
+}
+
+// Apply sets the flags in fs to the values in the configuration, except for
+// those that were set on the commandline.
+func (c *Config) Apply(fs *flag.FlagSet) error {
+	c.overridden = make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		c.overridden[f.Name] = true
+	})
+	errs := &errorList{filename: c.Filename}
+	for _, s := range c.Settings {
+		if c.overridden[s.Name] {
+			continue
+		}
+		for _, v := range s.Values {
+			if err := fs.Set(s.Name, v); err != nil {
+				errs.add(s.line, "setting %s: %s", s.Name, err)
+			}
+		}
+	}
+	return errs.err()
+}
+
+// LogSources returns the mtail.Server options for the sources.
+func (c *Config) LogSources() []mtail.LogSource {
+	var ls []mtail.LogSource
+	for _, s := range c.Sources {
+		ls = append(ls, s.LogSource())
+	}
+	return ls
+}
+
+// Changes are the differences between a running configuration and the
+// configuration file as it is now.
+type Changes struct {
+	Added   []Source // New sources, and new patterns in the logs setting, which can be tailed without a restart.
+	Restart []string // Descriptions of the changes that only take effect when mtail is restarted.
+}
+
+// Changes compares the running configuration c, which has been applied, with
+// next, the configuration file as it is now.  Adding logs and sources, and
+// changing the programs of sources, takes effect on reload; any other change
+// needs a restart.  Settings overridden on the commandline are ignored.
+func (c *Config) Changes(next *Config) Changes {
+	var ch Changes
+	current := make(map[string][]string)
+	for _, s := range c.Settings {
+		current[s.Name] = s.Values
+	}
+	names := make(map[string]struct{})
+	for n := range current {
+		names[n] = struct{}{}
+	}
+	for _, s := range next.Settings {
+		names[s.Name] = struct{}{}
+	}
+	nextValues := make(map[string][]string)
+	for _, s := range next.Settings {
+		nextValues[s.Name] = s.Values
+	}
+	var sorted []string
+	for n := range names {
+		sorted = append(sorted, n)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		if c.overridden[name] {
+			continue
+		}
+		was, now := current[name], nextValues[name]
+		if equal(was, now) {
+			continue
+		}
+		if name == "logs" && isPrefix(was, now) {
+			for _, p := range now[len(was):] {
+				ch.Added = append(ch.Added, Source{Path: p})
+			}
+			continue
+		}
+		ch.Restart = append(ch.Restart, fmt.Sprintf("%s changed from %q to %q", name, strings.Join(was, ","), strings.Join(now, ",")))
+	}
+
+	currentSources := make(map[string]Source)
+	for _, s := range c.Sources {
+		currentSources[s.Path] = s
+	}
+	for _, s := range next.Sources {
+		was, ok := currentSources[s.Path]
+		if !ok {
+			ch.Added = append(ch.Added, s)
+			continue
+		}
+		delete(currentSources, s.Path)
+		if was.PollInterval != s.PollInterval || !strings.EqualFold(was.Encoding, s.Encoding) {
+			ch.Restart = append(ch.Restart, fmt.Sprintf("settings of source %q changed", s.Path))
+		}
+	}
+	var removed []string
+	for p := range currentSources {
+		removed = append(removed, p)
+	}
+	sort.Strings(removed)
+	for _, p := range removed {
+		ch.Restart = append(ch.Restart, fmt.Sprintf("source %q removed", p))
+	}
+	return ch
+}
+
+// Reloaded returns the running configuration once the Changes from c to next
+// have been made, where added are those of the Added sources that are now
+// being tailed.  Settings that need a restart to change keep their running
+// values, so they are reported again by the next reload.  Removed sources are
+// still tailed, so they keep their settings and programs, while the sources
+// that remain take their programs from next.
+func (c *Config) Reloaded(next *Config, added []Source) *Config {
+	r := &Config{Filename: next.Filename, overridden: c.overridden}
+	tailed := make(map[string]bool)
+	for _, s := range added {
+		tailed[s.Path] = true
+	}
+
+	var newLogs []string
+	for _, s := range next.Settings {
+		if s.Name == "logs" {
+			for _, p := range s.Values {
+				if tailed[p] {
+					newLogs = append(newLogs, p)
+				}
+			}
+		}
+	}
+	for _, s := range c.Settings {
+		if s.Name == "logs" {
+			s.Values = append(append([]string{}, s.Values...), newLogs...)
+			newLogs = nil
+		}
+		r.Settings = append(r.Settings, s)
+	}
+	if len(newLogs) > 0 {
+		r.Settings = append(r.Settings, Setting{Name: "logs", Values: newLogs})
+	}
+
+	nextSources := make(map[string]Source)
+	for _, s := range next.Sources {
+		nextSources[s.Path] = s
+	}
+	running := make(map[string]bool)
+	for _, s := range c.Sources {
+		running[s.Path] = true
+		if n, ok := nextSources[s.Path]; ok {
+			s.Programs = n.Programs
+		}
+		r.Sources = append(r.Sources, s)
+	}
+	for _, s := range next.Sources {
+		if !running[s.Path] && tailed[s.Path] {
+			r.Sources = append(r.Sources, s)
+		}
+	}
+	return r
+}
+
+func equal(a, b []string) bool {
+	return len(a) == len(b) && isPrefix(a, b)
+}
+
+// isPrefix returns whether a is a prefix of b.
+func isPrefix(a, b []string) bool {
+	if len(a) > len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,214 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/mtail"
+	"github.com/google/mtail/internal/testutil"
+)
+
+// listFlag is a repeatable flag like mtail's logs.
+type listFlag []string
+
+func (f *listFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *listFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+type testFlags struct {
+	fs           *flag.FlagSet
+	progs        *string
+	port         *int
+	oneShot      *bool
+	pollInterval *time.Duration
+	logs         listFlag
+}
+
+func newTestFlags() *testFlags {
+	f := &testFlags{fs: flag.NewFlagSet("mtail", flag.ContinueOnError)}
+	f.progs = f.fs.String("progs", "", "")
+	f.port = f.fs.Int("port", 3903, "")
+	f.oneShot = f.fs.Bool("one_shot", false, "")
+	f.pollInterval = f.fs.Duration("poll_interval", 250*time.Millisecond, "")
+	f.fs.Var(&f.logs, "logs", "")
+	f.fs.String("config", "", "")
+	return f
+}
+
+const testConfig = `progs: /etc/mtail
+port: 3904
+poll_interval: 1s
+logs:
+  - /var/log/syslog
+  - /var/log/messages
+sources:
+  - path: /var/log/nginx/*.log
+    poll_interval: 5s
+    encoding: latin1
+    programs: [nginx.mtail, http.mtail]
+  - path: unix:///run/mtail.sock
+`
+
+func TestParseAndApply(t *testing.T) {
+	f := newTestFlags()
+	testutil.FatalIfErr(t, f.fs.Parse([]string{"--port", "9999"}))
+	c, err := Parse("mtail.yaml", []byte(testConfig), f.fs)
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, c.Apply(f.fs))
+
+	if *f.progs != "/etc/mtail" {
+		t.Errorf("progs = %q", *f.progs)
+	}
+	if *f.port != 9999 {
+		t.Errorf("port = %d, want the commandline's 9999", *f.port)
+	}
+	if *f.pollInterval != time.Second {
+		t.Errorf("poll_interval = %s", *f.pollInterval)
+	}
+	testutil.ExpectNoDiff(t, listFlag{"/var/log/syslog", "/var/log/messages"}, f.logs)
+	want := []mtail.LogSource{
+		{Pattern: "/var/log/nginx/*.log", PollInterval: 5 * time.Second, Encoding: "latin1", Programs: []string{"nginx.mtail", "http.mtail"}},
+		{Pattern: "unix:///run/mtail.sock"},
+	}
+	testutil.ExpectNoDiff(t, want, c.LogSources())
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{"unknown", "prgos: /etc/mtail\n", []string{`mtail.yaml:1: unknown setting "prgos"`}},
+		{"bad values", "port: http\none_shot: maybe\npoll_interval: 5\n", []string{
+			`mtail.yaml:1: invalid value "http" for port: invalid syntax`,
+			`mtail.yaml:2: invalid value "maybe" for one_shot: invalid syntax`,
+			`mtail.yaml:3: invalid value "5" for poll_interval: time: missing unit in duration "5"`,
+		}},
+		{"list for single value", "progs: [a, b]\n", []string{"mtail.yaml:1: progs takes a single value, not a list"}},
+		{"no value", "progs:\n", []string{"mtail.yaml:1: progs has no value"}},
+		{"config", "config: other.yaml\n", []string{"mtail.yaml:1: config can only be set on the commandline"}},
+		{"duplicate", "port: 1\nport: 2\n", []string{"mtail.yaml:2: port is set more than once"}},
+		{"not a mapping", "- progs\n", []string{"mtail.yaml:1: expected a mapping of settings"}},
+		{"sources", `sources:
+  - poll_interval: 1s
+  - path: /var/log/a
+    poll_interval: soon
+    encoding: ebcdic
+    programs: [../a.mtail]
+    colour: blue
+  - path: /var/log/a
+`, []string{
+			"mtail.yaml:2: source has no path",
+			`mtail.yaml:4: invalid poll_interval "soon": must be a positive duration`,
+			`mtail.yaml:5: unsupported character encoding "ebcdic"; supported encodings are utf-8 and latin1`,
+			`mtail.yaml:6: invalid program name "../a.mtail": must be the name of a file in the program directory`,
+			`mtail.yaml:7: unknown source setting "colour"`,
+			`mtail.yaml:8: source "/var/log/a" is already listed on line 3`,
+		}},
+		{"zero source poll_interval", "sources:\n  - path: /var/log/a\n    poll_interval: 0s\n", []string{
+			`mtail.yaml:3: invalid poll_interval "0s": must be a positive duration`,
+		}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Parse("mtail.yaml", []byte(tc.config), newTestFlags().fs)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			testutil.ExpectNoDiff(t, tc.want, strings.Split(err.Error(), "\n"))
+		})
+	}
+}
+
+func TestLoadEmpty(t *testing.T) {
+	name := filepath.Join(testutil.TestTempDir(t), "mtail.yaml")
+	testutil.FatalIfErr(t, os.WriteFile(name, nil, 0o600))
+	c, err := Load(name, newTestFlags().fs)
+	testutil.FatalIfErr(t, err)
+	if len(c.Settings) != 0 || len(c.Sources) != 0 {
+		t.Errorf("empty file has settings: %+v", c)
+	}
+	if _, err := Load(filepath.Join(testutil.TestTempDir(t), "missing.yaml"), newTestFlags().fs); err == nil {
+		t.Error("expected error loading missing file")
+	}
+}
+
+func TestChanges(t *testing.T) {
+	f := newTestFlags()
+	testutil.FatalIfErr(t, f.fs.Parse([]string{"--port", "9999"}))
+	c, err := Parse("mtail.yaml", []byte(testConfig), f.fs)
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, c.Apply(f.fs))
+
+	next, err := Parse("mtail.yaml", []byte(`progs: /etc/mtail
+port: 3905
+poll_interval: 2s
+logs:
+  - /var/log/syslog
+  - /var/log/messages
+  - /var/log/auth.log
+sources:
+  - path: /var/log/nginx/*.log
+    poll_interval: 5s
+    encoding: LATIN1
+    programs: [nginx.mtail]
+  - path: /var/log/apache/*.log
+`), f.fs)
+	testutil.FatalIfErr(t, err)
+	ch := c.Changes(next)
+	testutil.ExpectNoDiff(t, []string{"/var/log/auth.log", "/var/log/apache/*.log"}, sourcePaths(ch.Added))
+	// The port is set on the commandline, so its change is ignored; the
+	// nginx source's programs can change without a restart.
+	testutil.ExpectNoDiff(t, []string{
+		`poll_interval changed from "1s" to "2s"`,
+		`source "unix:///run/mtail.sock" removed`,
+	}, ch.Restart)
+
+	// The apache source couldn't be tailed, so it is added again next time,
+	// and the changes that need a restart are reported until it happens.
+	c = c.Reloaded(next, ch.Added[:1])
+	ch = c.Changes(next)
+	testutil.ExpectNoDiff(t, []string{"/var/log/apache/*.log"}, sourcePaths(ch.Added))
+	testutil.ExpectNoDiff(t, []string{
+		`poll_interval changed from "1s" to "2s"`,
+		`source "unix:///run/mtail.sock" removed`,
+	}, ch.Restart)
+
+	// The removed source is still tailed, with its own routes, and the
+	// nginx source takes its new programs.
+	testutil.ExpectNoDiff(t, []mtail.LogSource{
+		{Pattern: "/var/log/nginx/*.log", PollInterval: 5 * time.Second, Encoding: "latin1", Programs: []string{"nginx.mtail"}},
+		{Pattern: "unix:///run/mtail.sock"},
+	}, c.LogSources())
+
+	c = c.Reloaded(next, ch.Added)
+	ch = c.Changes(next)
+	if len(ch.Added) != 0 {
+		t.Errorf("sources added again after reload: %v", sourcePaths(ch.Added))
+	}
+	if len(ch.Restart) != 2 {
+		t.Errorf("changes that need a restart after reload: %v", ch.Restart)
+	}
+	if got := len(c.LogSources()); got != 3 {
+		t.Errorf("%d log sources after reload, want 3", got)
+	}
+}
+
+func sourcePaths(sources []Source) []string {
+	var paths []string
+	for _, s := range sources {
+		paths = append(paths, s.Path)
+	}
+	return paths
+}
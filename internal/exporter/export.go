@@ -20,6 +20,7 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/selfmetrics"
 	"github.com/pkg/errors"
 )
 
@@ -28,6 +29,19 @@ var (
 	writeDeadline = flag.Duration("metric_push_write_deadline", 10*time.Second, "Time to wait for a push to succeed before exiting with an error.")
 )
 
+// SelfMetrics describes the exporters' counters for export to Prometheus.
+var SelfMetrics = []selfmetrics.Var{
+	selfmetrics.Counter(exportVarzTotal, "exporter_varz_total", "number of metrics exported in varz format"),
+	selfmetrics.Counter(exportJSONErrors, "exporter_json_errors", "number of errors encoding metrics as JSON"),
+	selfmetrics.Counter(metricExportTotal, "metric_export_total", "number of metrics collected for Prometheus"),
+	selfmetrics.Counter(statsdExportTotal, "statsd_export_total", "number of metrics exported to statsd"),
+	selfmetrics.Counter(statsdExportSuccess, "statsd_export_success", "number of metrics successfully pushed to statsd"),
+	selfmetrics.Counter(graphiteExportTotal, "graphite_export_total", "number of metrics exported to graphite"),
+	selfmetrics.Counter(graphiteExportSuccess, "graphite_export_success", "number of metrics successfully pushed to graphite"),
+	selfmetrics.Counter(collectdExportTotal, "collectd_export_total", "number of metrics exported to collectd"),
+	selfmetrics.Counter(collectdExportSuccess, "collectd_export_success", "number of metrics successfully pushed to collectd"),
+}
+
 // Exporter manages the export of metrics to passive and active collectors.
 type Exporter struct {
 	ctx            context.Context
@@ -180,6 +180,44 @@ func (m *Metric) GetDatum(labelvalues ...string) (d datum.Datum, err error) {
 	return d, nil
 }
 
+// ChangedFrom describes how the kind, type, keys, or buckets of m differ from
+// those of old, a metric of the same name from an earlier version of the
+// program, or returns the empty string if old's datums are compatible with m.
+func (m *Metric) ChangedFrom(old *Metric) string {
+	switch {
+	case m.Kind != old.Kind:
+		return fmt.Sprintf("kind changed from %s to %s", old.Kind, m.Kind)
+	case m.Type != old.Type:
+		return fmt.Sprintf("type changed from %s to %s", old.Type, m.Type)
+	case !reflect.DeepEqual(m.Keys, old.Keys):
+		return fmt.Sprintf("keys changed from %q to %q", old.Keys, m.Keys)
+	case !reflect.DeepEqual(m.Buckets, old.Buckets):
+		return fmt.Sprintf("buckets changed from %v to %v", old.Buckets, m.Buckets)
+	}
+	return ""
+}
+
+// CarryOver moves the datums of old into m, replacing any m already has for
+// the same labels, so that a reloaded program keeps counting where the
+// previous version left off.  The metrics must be compatible, as reported by
+// ChangedFrom.
+func (m *Metric) CarryOver(old *Metric) error {
+	old.RLock()
+	defer old.RUnlock()
+	for _, lv := range old.LabelValues {
+		if err := m.RemoveDatum(lv.Labels...); err != nil {
+			return err
+		}
+		m.Lock()
+		err := m.AppendLabelValue(lv)
+		m.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // RemoveOldestDatum scans the Metric's LabelValues for the Datum with the oldest timestamp, and removes it.
 func (m *Metric) RemoveOldestDatum() {
 	var oldestLV *LabelValue
@@ -272,3 +272,39 @@ func TestMetricLabelValueRemovePastLimit(t *testing.T) {
 		t.Errorf("found label a which is unexpected: %#v", x)
 	}
 }
+
+func TestChangedFrom(t *testing.T) {
+	old := NewMetric("foo", "prog", Counter, Int, "a")
+	for _, tc := range []struct {
+		m    *Metric
+		want string
+	}{
+		{NewMetric("foo", "prog", Counter, Int, "a"), ""},
+		{NewMetric("foo", "prog", Gauge, Int, "a"), "kind changed from Counter to Gauge"},
+		{NewMetric("foo", "prog", Counter, Float, "a"), "type changed from Int to Float"},
+		{NewMetric("foo", "prog", Counter, Int, "a", "b"), `keys changed from ["a"] to ["a" "b"]`},
+	} {
+		if got := tc.m.ChangedFrom(old); got != tc.want {
+			t.Errorf("ChangedFrom(%v) = %q, want %q", tc.m, got, tc.want)
+		}
+	}
+}
+
+func TestCarryOver(t *testing.T) {
+	old := NewMetric("foo", "prog", Counter, Int, "a")
+	d, err := old.GetDatum("x")
+	testutil.FatalIfErr(t, err)
+	datum.SetInt(d, 3, time.Unix(0, 0))
+	m := NewMetric("foo", "prog", Counter, Int, "a")
+	_, err = m.GetDatum("x")
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, m.CarryOver(old))
+	d, err = m.GetDatum("x")
+	testutil.FatalIfErr(t, err)
+	if got := datum.GetInt(d); got != 3 {
+		t.Errorf("carried over datum = %d, want 3", got)
+	}
+	if len(m.LabelValues) != 1 {
+		t.Errorf("expected one label value, got %v", m.LabelValues)
+	}
+}
@@ -97,6 +97,63 @@ func (s *Store) Add(m *Metric) error {
 	return nil
 }
 
+// ReplaceProgram replaces all the metrics of the program prog in the Store
+// with ms, so that metrics the program no longer declares are removed.  The
+// replacement is made at once, so the Store is never seen with some of the
+// program's metrics missing.  An error is returned, and the Store left
+// unchanged, if a metric has a different kind to one of the same name from
+// another program.
+func (s *Store) ReplaceProgram(prog string, ms []*Metric) error {
+	s.insertMu.Lock()
+	defer s.insertMu.Unlock()
+	s.searchMu.RLock()
+	for _, m := range ms {
+		for _, v := range s.Metrics[m.Name] {
+			if v.Program != prog && v.Kind != m.Kind {
+				s.searchMu.RUnlock()
+				return errors.Errorf("metric %s has different kind %v to existing %v", m.Name, m.Kind, v.Kind)
+			}
+		}
+	}
+	s.searchMu.RUnlock()
+
+	s.searchMu.Lock()
+	defer s.searchMu.Unlock()
+	for name, ml := range s.Metrics {
+		var kept []*Metric
+		for _, v := range ml {
+			if v.Program != prog {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.Metrics, name)
+		} else {
+			s.Metrics[name] = kept
+		}
+	}
+	for _, m := range ms {
+		glog.V(1).Infof("Adding a new metric %v", m)
+		s.Metrics[m.Name] = append(s.Metrics[m.Name], m)
+	}
+	return nil
+}
+
+// ProgramMetrics returns the metrics in the Store from the program prog.
+func (s *Store) ProgramMetrics(prog string) []*Metric {
+	s.searchMu.RLock()
+	defer s.searchMu.RUnlock()
+	var ms []*Metric
+	for _, ml := range s.Metrics {
+		for _, m := range ml {
+			if m.Program == prog {
+				ms = append(ms, m)
+			}
+		}
+	}
+	return ms
+}
+
 // FindMetricOrNil returns a metric in a store, or returns nil if not found.
 func (s *Store) FindMetricOrNil(name, prog string) *Metric {
 	s.searchMu.RLock()
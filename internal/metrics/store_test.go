@@ -189,3 +189,31 @@ func TestExpireManyMetrics(t *testing.T) {
 		t.Logf("Store: %#v", s)
 	}
 }
+
+func TestReplaceProgram(t *testing.T) {
+	s := NewStore()
+	testutil.FatalIfErr(t, s.Add(NewMetric("foo", "prog", Counter, Int)))
+	testutil.FatalIfErr(t, s.Add(NewMetric("bar", "prog", Counter, Int)))
+	testutil.FatalIfErr(t, s.Add(NewMetric("foo", "prog1", Counter, Int)))
+
+	// A metric of a different kind to another program's is an error, leaving the store unchanged.
+	if err := s.ReplaceProgram("prog", []*Metric{NewMetric("foo", "prog", Gauge, Int)}); err == nil {
+		t.Error("expected kind mismatch error")
+	}
+	if got := len(s.ProgramMetrics("prog")); got != 2 {
+		t.Errorf("store changed after error: %d metrics for prog", got)
+	}
+
+	baz := NewMetric("baz", "prog", Gauge, Int)
+	foo := NewMetric("foo", "prog", Counter, Int, "a")
+	testutil.FatalIfErr(t, s.ReplaceProgram("prog", []*Metric{foo, baz}))
+	if _, ok := s.Metrics["bar"]; ok {
+		t.Errorf("dropped metric bar still in store: %v", s.Metrics["bar"])
+	}
+	if len(s.Metrics["foo"]) != 2 || s.FindMetricOrNil("foo", "prog") != foo || s.FindMetricOrNil("foo", "prog1") == nil {
+		t.Errorf("unexpected foo metrics: %v", s.Metrics["foo"])
+	}
+	if s.FindMetricOrNil("baz", "prog") != baz {
+		t.Errorf("baz not added: %v", s.Metrics["baz"])
+	}
+}
@@ -147,6 +147,15 @@ func TestCompileExamplePrograms(t *testing.T) {
 }
 
 func BenchmarkProgram(b *testing.B) {
+	benchmarkProgram(b)
+}
+
+func BenchmarkProgramRegisterVM(b *testing.B) {
+	benchmarkProgram(b, mtail.RegisterVM)
+}
+
+func benchmarkProgram(b *testing.B, options ...mtail.Option) {
+	b.Helper()
 	for _, bm := range exampleProgramTests {
 		bm := bm
 		b.Run(fmt.Sprintf("%s on %s", bm.programfile, bm.logfile), func(b *testing.B) {
@@ -158,7 +167,7 @@ func BenchmarkProgram(b *testing.B) {
 			waker, awaken := waker.NewTest(ctx, 1)
 			store := metrics.NewStore()
 			programFile := filepath.Join("../..", bm.programfile)
-			mtail, err := mtail.New(ctx, store, mtail.ProgramPath(programFile), mtail.LogPathPatterns(log.Name()), mtail.LogstreamPollWaker(waker))
+			mtail, err := mtail.New(ctx, store, append([]mtail.Option{mtail.ProgramPath(programFile), mtail.LogPathPatterns(log.Name()), mtail.LogstreamPollWaker(waker)}, options...)...)
 			testutil.FatalIfErr(b, err)
 
 			var wg sync.WaitGroup
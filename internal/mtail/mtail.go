@@ -10,6 +10,7 @@ import (
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"net/url"
 	"sync"
 	"time"
 
@@ -18,12 +19,15 @@ import (
 	"github.com/google/mtail/internal/logline"
 	"github.com/google/mtail/internal/metrics"
 	"github.com/google/mtail/internal/runtime"
+	"github.com/google/mtail/internal/runtime/vm"
+	"github.com/google/mtail/internal/selfmetrics"
 	"github.com/google/mtail/internal/tailer"
+	"github.com/google/mtail/internal/tailer/logstream"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/version"
-	"go.opencensus.io/zpages"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 // Server contains the state of the main mtail program.
@@ -52,14 +56,32 @@ type Server struct {
 	compileOnly        bool   // if set, mtail compiles programs then exit
 	httpDebugEndpoints bool   // if set, mtail will enable debug endpoints
 	httpInfoEndpoints  bool   // if set, mtail will enable info endpoints for progz and varz
+	adminToken         string // if set, mtail will enable the program admin API for requests bearing this token
+
+	maxSelfMetricLabelValues int // limit on the log paths exported as labels of mtail's own metrics
+
+	traceEndpoint     *url.URL                 // OTLP/HTTP endpoint to send traces to, if not nil
+	traceSamplePeriod int                      // sample one in this many traces, if positive
+	tp                *sdktrace.TracerProvider // exports spans, if traceEndpoint is set
+
+	sources []LogSource // log sources with settings, whose program routes are passed to `r`
 }
 
+// defaultMaxSelfMetricLabelValues is the number of log paths exported as
+// labels of each of mtail's own per-log metrics before the rest are summed
+// together.
+const defaultMaxSelfMetricLabelValues = 100
+
 // We can only copy the build info once to the version library.  Protects tests from data races.
 var buildInfoOnce sync.Once
 
 // initRuntime constructs a new runtime and performs the initial load of program files in the program directory.
 func (m *Server) initRuntime() (err error) {
-	m.r, err = runtime.New(m.lines, &m.wg, m.programPath, m.store, m.rOpts...)
+	rs, err := routes(m.sources)
+	if err != nil {
+		return err
+	}
+	m.r, err = runtime.New(m.lines, &m.wg, m.programPath, m.store, append(m.rOpts, runtime.Routes(rs))...)
 	return
 }
 
@@ -111,12 +133,16 @@ func (m *Server) initHTTPServer() error {
 		mux.HandleFunc("/favicon.ico", FaviconHandler)
 		mux.HandleFunc("/varz", http.HandlerFunc(m.e.HandleVarz))
 		mux.Handle("/progz", http.HandlerFunc(m.r.ProgzHandler))
+		mux.Handle("/explainz", http.HandlerFunc(m.r.ExplainHandler))
+		mux.Handle("/shadowz", http.HandlerFunc(m.r.ShadowzHandler))
+	}
+	if m.adminToken != "" {
+		mux.Handle("/admin/", requireToken(m.adminToken, http.HandlerFunc(m.r.AdminHandler)))
 	}
 	mux.Handle("/", m)
 	mux.Handle("/metrics", promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{}))
 	mux.HandleFunc("/json", http.HandlerFunc(m.e.HandleJSON))
 	mux.HandleFunc("/graphite", http.HandlerFunc(m.e.HandleGraphite))
-	zpages.Handle(mux, "/")
 
 	srv := &http.Server{
 		ReadTimeout:       1 * time.Second,
@@ -177,31 +203,21 @@ func New(ctx context.Context, store *metrics.Store, options ...Option) (*Server,
 		// Using a non-pedantic registry means we can be looser with metrics that
 		// are not fully specified at startup.
 		reg: prometheus.NewRegistry(),
+
+		maxSelfMetricLabelValues: defaultMaxSelfMetricLabelValues,
 	}
 	m.rOpts = append(m.rOpts, runtime.PrometheusRegisterer(m.reg))
 
-	// TODO(jaq): Should these move to initExporter?
-	expvarDescs := map[string]*prometheus.Desc{
-		// internal/tailer/file.go
-		"log_errors_total":    prometheus.NewDesc("log_errors_total", "number of IO errors encountered per log file", []string{"logfile"}, nil),
-		"log_rotations_total": prometheus.NewDesc("log_rotations_total", "number of log rotation events per log file", []string{"logfile"}, nil),
-		"log_truncates_total": prometheus.NewDesc("log_truncates_total", "number of log truncation events log file", []string{"logfile"}, nil),
-		"log_lines_total":     prometheus.NewDesc("log_lines_total", "number of lines read per log file", []string{"logfile"}, nil),
-		// internal/runtime/loader.go
-		"lines_total":               prometheus.NewDesc("lines_total", "number of lines received by the program loader", nil, nil),
-		"prog_loads_total":          prometheus.NewDesc("prog_loads_total", "number of program load events by program source filename", []string{"prog"}, nil),
-		"prog_load_errors_total":    prometheus.NewDesc("prog_load_errors_total", "number of errors encountered when loading per program source filename", []string{"prog"}, nil),
-		"prog_runtime_errors_total": prometheus.NewDesc("prog_runtime_errors_total", "number of errors encountered when executing programs per source filename", []string{"prog"}, nil),
-	}
 	m.reg.MustRegister(
 		collectors.NewGoCollector(),
 		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
-	// Prefix all expvar metrics with 'mtail_'
-	prometheus.WrapRegistererWithPrefix("mtail_", m.reg).MustRegister(
-		collectors.NewExpvarCollector(expvarDescs))
 	if err := m.SetOption(options...); err != nil {
 		return nil, err
 	}
+	m.registerSelfMetrics()
+	if err := m.initTracing(); err != nil {
+		return nil, err
+	}
 	if err := m.initExporter(); err != nil {
 		return nil, err
 	}
@@ -219,6 +235,23 @@ func New(ctx context.Context, store *metrics.Store, options ...Option) (*Server,
 	return m, nil
 }
 
+// registerSelfMetrics exports the counters that mtail keeps about itself,
+// also served on /debug/vars, to Prometheus with the prefix 'mtail_'.
+func (m *Server) registerSelfMetrics() {
+	var vars []selfmetrics.Var
+	for _, v := range [][]selfmetrics.Var{
+		tailer.SelfMetrics,
+		logstream.SelfMetrics,
+		runtime.SelfMetrics,
+		vm.SelfMetrics,
+		exporter.SelfMetrics,
+	} {
+		vars = append(vars, v...)
+	}
+	prometheus.WrapRegistererWithPrefix("mtail_", m.reg).MustRegister(
+		selfmetrics.NewCollector(m.maxSelfMetricLabelValues, vars...))
+}
+
 // SetOption takes one or more option functions and applies them in order to MtailServer.
 func (m *Server) SetOption(options ...Option) error {
 	for _, option := range options {
@@ -233,6 +266,7 @@ func (m *Server) SetOption(options ...Option) error {
 // TODO(jaq): remove this once the test server is able to trigger polls on the components.
 func (m *Server) Run() error {
 	m.wg.Wait()
+	m.shutdownTracing()
 	if m.compileOnly {
 		glog.Info("compile-only is set, exiting")
 		return nil
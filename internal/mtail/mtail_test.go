@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"runtime"
 	"testing"
+
+	"github.com/google/mtail/internal/testutil"
 )
 
 func TestBuildInfo(t *testing.T) {
@@ -28,3 +30,30 @@ func TestBuildInfo(t *testing.T) {
 		t.Errorf("Unexpected build info string, want: %q, got: %q", buildInfoWant, buildInfoGot)
 	}
 }
+
+func TestSelfMetrics(t *testing.T) {
+	m, stopM := TestStartServer(t, 0, ProgramPath("../../examples/linecount.mtail"))
+	defer stopM()
+
+	families, err := m.reg.Gather()
+	testutil.FatalIfErr(t, err)
+	help := make(map[string]string)
+	for _, f := range families {
+		help[f.GetName()] = f.GetHelp()
+	}
+	for _, name := range []string{
+		"mtail_lines_total",
+		"mtail_log_count",
+		"mtail_prog_loads_total",
+		"mtail_metric_export_total",
+	} {
+		h, ok := help[name]
+		if !ok {
+			t.Errorf("%s not exported", name)
+			continue
+		}
+		if h == "" {
+			t.Errorf("%s has no help text", name)
+		}
+	}
+}
@@ -10,12 +10,10 @@ import (
 	"path/filepath"
 	"time"
 
-	"contrib.go.opencensus.io/exporter/jaeger"
 	"github.com/google/mtail/internal/exporter"
 	"github.com/google/mtail/internal/runtime"
 	"github.com/google/mtail/internal/tailer"
 	"github.com/google/mtail/internal/waker"
-	"go.opencensus.io/trace"
 )
 
 // Option configures mtail.Server.
@@ -260,21 +258,20 @@ var LogRuntimeErrors = &niladicOption{
 	},
 }
 
-// JaegerReporter creates a new jaeger reporter that sends to the given Jaeger endpoint address.
-type JaegerReporter string
+// RegisterVM instructs the Server to execute programs on the experimental register-based VM.
+var RegisterVM = &niladicOption{
+	func(m *Server) error {
+		m.rOpts = append(m.rOpts, runtime.RegisterMachine())
+		return nil
+	},
+}
 
-func (opt JaegerReporter) apply(_ *Server) error {
-	je, err := jaeger.NewExporter(jaeger.Options{
-		CollectorEndpoint: string(opt),
-		Process: jaeger.Process{
-			ServiceName: "mtail",
-		},
-	})
-	if err != nil {
-		return err
-	}
-	trace.RegisterExporter(je)
-	return nil
+// ProfileVM instructs the Server to profile the execution of each program, for display on the /progz page.
+var ProfileVM = &niladicOption{
+	func(m *Server) error {
+		m.rOpts = append(m.rOpts, runtime.Profile())
+		return nil
+	},
 }
 
 // MetricPushInterval sets the interval between metrics pushes to passive collectors.
@@ -293,6 +290,38 @@ func (opt MaxRegexpLength) apply(m *Server) error {
 	return nil
 }
 
+// VMReplicas sets the number of VMs that run each program in parallel.
+type VMReplicas int
+
+func (opt VMReplicas) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.VMReplicas(int(opt)))
+	return nil
+}
+
+// LineInstructionBudget sets the maximum number of instructions a program may execute on one log line.
+type LineInstructionBudget int
+
+func (opt LineInstructionBudget) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.LineInstructionBudget(int(opt)))
+	return nil
+}
+
+// LineTimeBudget sets the maximum time a program may spend executing on one log line.
+type LineTimeBudget time.Duration
+
+func (opt LineTimeBudget) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.LineTimeBudget(time.Duration(opt)))
+	return nil
+}
+
+// MatchLengthBudget sets the maximum length of the text a program may match a regular expression against.
+type MatchLengthBudget int
+
+func (opt MatchLengthBudget) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.MatchLengthBudget(int(opt)))
+	return nil
+}
+
 // MaxRecursionDepth sets the maximum depth the abstract syntax tree built during lexation can have.
 type MaxRecursionDepth int
 
@@ -300,3 +329,54 @@ func (opt MaxRecursionDepth) apply(m *Server) error {
 	m.rOpts = append(m.rOpts, runtime.MaxRecursionDepth(int(opt)))
 	return nil
 }
+
+// ImportPath sets the directories searched, in order, for the modules named in
+// programs' import statements, before the standard library.
+func ImportPath(dirs ...string) Option {
+	return importPath(dirs)
+}
+
+type importPath []string
+
+func (opt importPath) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.ImportPath(opt...))
+	return nil
+}
+
+// WatchPrograms reloads programs when the program path changes, after no further changes for the given debounce interval.
+type WatchPrograms time.Duration
+
+func (opt WatchPrograms) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.WatchPrograms(time.Duration(opt)))
+	return nil
+}
+
+// ProgramPollInterval sets the interval to poll the program path for changes when filesystem notifications are unavailable.
+type ProgramPollInterval time.Duration
+
+func (opt ProgramPollInterval) apply(m *Server) error {
+	m.rOpts = append(m.rOpts, runtime.ProgramPollInterval(time.Duration(opt)))
+	return nil
+}
+
+// MaxSelfMetricLabelValues limits the number of log paths exported as labels of each of mtail's own per-log metrics, summing the rest into the label value "other".  Zero means no limit.
+type MaxSelfMetricLabelValues int
+
+func (opt MaxSelfMetricLabelValues) apply(m *Server) error {
+	if opt < 0 {
+		return errors.New("max self metric label values must not be negative")
+	}
+	m.maxSelfMetricLabelValues = int(opt)
+	return nil
+}
+
+// AdminToken enables the program admin API on the HTTP server, for requests bearing the given token.
+type AdminToken string
+
+func (opt AdminToken) apply(m *Server) error {
+	if opt == "" {
+		return errors.New("admin token must not be empty")
+	}
+	m.adminToken = string(opt)
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail
+
+import (
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/google/mtail/internal/runtime"
+	"github.com/google/mtail/internal/tailer"
+	"github.com/google/mtail/internal/waker"
+	"github.com/pkg/errors"
+)
+
+// LogSource adds a log path pattern, as LogPathPatterns does, with settings
+// for the logs that match it.
+type LogSource struct {
+	Pattern      string
+	PollInterval time.Duration // Interval to poll the idle logs that match, instead of the LogstreamPollWaker's, if positive.
+	Encoding     string        // Character encoding of the logs, UTF-8 if empty.
+	Programs     []string      // Names of the programs to send the logs' lines to, instead of every program, if not empty.
+}
+
+func (opt LogSource) apply(m *Server) error {
+	m.tOpts = append(m.tOpts, opt.tailerSource(m))
+	m.sources = append(m.sources, opt)
+	return nil
+}
+
+// tailerSource returns the tailer's settings for the source.
+func (opt LogSource) tailerSource(m *Server) tailer.LogSource {
+	s := tailer.LogSource{Pattern: opt.Pattern, Encoding: opt.Encoding}
+	if opt.PollInterval > 0 {
+		s.PollWaker = waker.NewTimed(m.ctx, opt.PollInterval)
+	}
+	return s
+}
+
+// routes returns the runtime routes for the sources that name programs.
+func routes(sources []LogSource) ([]runtime.Route, error) {
+	var rs []runtime.Route
+	for _, s := range sources {
+		if len(s.Programs) == 0 {
+			continue
+		}
+		p, err := routePattern(s.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		rs = append(rs, runtime.Route{Pattern: p, Programs: s.Programs})
+	}
+	return rs, nil
+}
+
+// routePattern returns the pattern matching the filenames of the log lines
+// read from the logs of a log path pattern: the absolute path for files, and
+// the address for sockets.
+func routePattern(pattern string) (string, error) {
+	u, err := url.Parse(pattern)
+	if err != nil {
+		return "", errors.Wrapf(err, "log path pattern %q", pattern)
+	}
+	path := pattern
+	switch u.Scheme {
+	case "unix", "unixgram":
+		return u.Path, nil
+	case "tcp", "udp":
+		return u.Host, nil
+	case "", "file":
+		path = u.Path
+	}
+	return filepath.Abs(path)
+}
+
+// AddLogSource starts tailing the logs matching a new LogSource on a running
+// Server.
+func (m *Server) AddLogSource(s LogSource) error {
+	return m.t.TailSource(s.tailerSource(m))
+}
+
+// SetLogSourceRoutes replaces the routes of log lines to programs with those
+// of the sources.
+func (m *Server) SetLogSourceRoutes(sources []LogSource) error {
+	rs, err := routes(sources)
+	if err != nil {
+		return err
+	}
+	return m.r.SetRoutes(rs)
+}
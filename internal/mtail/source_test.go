@@ -0,0 +1,34 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/mtail/internal/runtime"
+	"github.com/google/mtail/internal/testutil"
+)
+
+func TestRoutes(t *testing.T) {
+	relative, err := filepath.Abs("logs/*.log")
+	testutil.FatalIfErr(t, err)
+	got, err := routes([]LogSource{
+		{Pattern: "/var/log/nginx/*.log", Programs: []string{"nginx.mtail"}},
+		{Pattern: "/var/log/syslog"},
+		{Pattern: "logs/*.log", Programs: []string{"a.mtail"}},
+		{Pattern: "file:///var/log/app.log", Programs: []string{"app.mtail"}},
+		{Pattern: "unix:///run/mtail.sock", Programs: []string{"syslog.mtail"}},
+		{Pattern: "udp://localhost:514", Programs: []string{"syslog.mtail"}},
+	})
+	testutil.FatalIfErr(t, err)
+	want := []runtime.Route{
+		{Pattern: "/var/log/nginx/*.log", Programs: []string{"nginx.mtail"}},
+		{Pattern: relative, Programs: []string{"a.mtail"}},
+		{Pattern: "/var/log/app.log", Programs: []string{"app.mtail"}},
+		{Pattern: "/run/mtail.sock", Programs: []string{"syslog.mtail"}},
+		{Pattern: "localhost:514", Programs: []string{"syslog.mtail"}},
+	}
+	testutil.ExpectNoDiff(t, want, got)
+}
@@ -0,0 +1,97 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// defaultTraceSampleRate is the fraction of traces sampled when no
+// TraceSamplePeriod is given.
+const defaultTraceSampleRate = 1e-4
+
+// traceShutdownTimeout bounds the time spent exporting the remaining spans
+// when the Server shuts down.
+const traceShutdownTimeout = 5 * time.Second
+
+// OTLPTraceEndpoint sends traces to the OpenTelemetry collector at the given
+// URL of its OTLP/HTTP traces endpoint, e.g. http://localhost:4318/v1/traces.
+type OTLPTraceEndpoint string
+
+func (opt OTLPTraceEndpoint) apply(m *Server) error {
+	u, err := url.Parse(string(opt))
+	if err != nil {
+		return errors.Wrapf(err, "OTLP trace endpoint %q", string(opt))
+	}
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return errors.Errorf("OTLP trace endpoint %q must be an http or https URL", string(opt))
+	}
+	m.traceEndpoint = u
+	return nil
+}
+
+// TraceSamplePeriod samples one in every n traces.  If zero, a small default
+// fraction of traces are sampled.
+type TraceSamplePeriod int
+
+func (opt TraceSamplePeriod) apply(m *Server) error {
+	if opt < 0 {
+		return errors.Errorf("trace sample period must not be negative, got %d", int(opt))
+	}
+	m.traceSamplePeriod = int(opt)
+	return nil
+}
+
+// initTracing installs the global tracer provider that exports spans to the
+// OTLP trace endpoint, if one is set.  Otherwise spans are not recorded.
+func (m *Server) initTracing() error {
+	if m.traceEndpoint == nil {
+		return nil
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(m.traceEndpoint.Host)}
+	if m.traceEndpoint.Path != "" {
+		opts = append(opts, otlptracehttp.WithURLPath(m.traceEndpoint.Path))
+	}
+	if m.traceEndpoint.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	exp, err := otlptracehttp.New(m.ctx, opts...)
+	if err != nil {
+		return errors.Wrap(err, "creating OTLP trace exporter")
+	}
+	rate := defaultTraceSampleRate
+	if m.traceSamplePeriod > 0 {
+		rate = 1 / float64(m.traceSamplePeriod)
+	}
+	m.tp = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(rate))),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "mtail"))),
+	)
+	otel.SetTracerProvider(m.tp)
+	glog.Infof("Sending traces to %s", m.traceEndpoint)
+	return nil
+}
+
+// shutdownTracing exports the spans not yet sent and stops the tracer provider.
+func (m *Server) shutdownTracing() {
+	if m.tp == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), traceShutdownTimeout)
+	defer cancel()
+	if err := m.tp.Shutdown(ctx); err != nil {
+		glog.Infof("tracer provider shutdown: %s", err)
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package mtail
+
+import (
+	"testing"
+)
+
+func TestOTLPTraceEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint string
+		wantErr  bool
+	}{
+		{"http://localhost:4318/v1/traces", false},
+		{"https://collector.example.com:4318/v1/traces", false},
+		{"localhost:4318", true},
+		{"grpc://localhost:4317", true},
+		{"http://", true},
+	} {
+		m := &Server{}
+		err := OTLPTraceEndpoint(tc.endpoint).apply(m)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("OTLPTraceEndpoint(%q): got error %v, want error %v", tc.endpoint, err, tc.wantErr)
+		}
+	}
+	if err := TraceSamplePeriod(-1).apply(&Server{}); err == nil {
+		t.Error("expected error for negative trace sample period")
+	}
+}
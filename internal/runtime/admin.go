@@ -0,0 +1,383 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+// The admin API lets programs be deployed and rolled back over HTTP.  Programs
+// uploaded while the program path is a directory are also written there, so
+// that they survive reloads and restarts; likewise deleted programs are
+// removed from it.  Each program's content hash is served as its ETag, so
+// clients can make changes conditional on the program they last saw with
+// If-Match, or on the program not existing with `If-None-Match: *`.
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// maxProgramSize limits the size of an uploaded program.
+const maxProgramSize = 1 << 20
+
+// ProgramInfo describes a loaded program.
+type ProgramInfo struct {
+	Name        string `json:"name"`
+	ContentHash string `json:"content_hash"`
+	Error       string `json:"error,omitempty"` // Compile error from the last attempt to load the program, if any.
+}
+
+// Programs returns the loaded programs, ordered by name.
+func (r *Runtime) Programs() []ProgramInfo {
+	r.handleMu.RLock()
+	programs := make([]ProgramInfo, 0, len(r.handles))
+	for name, h := range r.handles {
+		programs = append(programs, ProgramInfo{Name: name, ContentHash: hex.EncodeToString(h.contentHash)})
+	}
+	r.handleMu.RUnlock()
+	r.programErrorMu.RLock()
+	for i := range programs {
+		if err := r.programErrors[programs[i].Name]; err != nil {
+			programs[i].Error = err.Error()
+		}
+	}
+	r.programErrorMu.RUnlock()
+	sort.Slice(programs, func(i, j int) bool { return programs[i].Name < programs[j].Name })
+	return programs
+}
+
+// ProgramSource returns the source and content hash of the named program, if it is loaded.
+func (r *Runtime) ProgramSource(name string) (source []byte, contentHash string, ok bool) {
+	r.handleMu.RLock()
+	defer r.handleMu.RUnlock()
+	h, ok := r.handles[name]
+	if !ok {
+		return nil, "", false
+	}
+	return h.source, hex.EncodeToString(h.contentHash), true
+}
+
+// AdminHandler serves the program admin API under /admin/:
+//
+//	GET    /admin/programs       lists the loaded programs as JSON
+//	GET    /admin/programs/NAME  fetches the source of a program
+//	PUT    /admin/programs/NAME  compiles and loads a new or changed program
+//	DELETE /admin/programs/NAME  unloads a program
+//	POST   /admin/reload         reloads all programs from the program path
+//
+//	GET    /admin/shadows/NAME          fetches the source of a program's shadow
+//	PUT    /admin/shadows/NAME          compiles and loads a new version of a program in shadow
+//	DELETE /admin/shadows/NAME          unloads a program's shadow
+//	POST   /admin/shadows/NAME/promote  replaces a program with its shadow
+//
+// The handler does no authentication of its own.
+func (r *Runtime) AdminHandler(w http.ResponseWriter, req *http.Request) {
+	switch path := strings.TrimPrefix(req.URL.Path, "/admin"); {
+	case path == "/programs" || path == "/programs/":
+		if req.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(r.Programs()); err != nil {
+			glog.Warning(err)
+		}
+	case strings.HasPrefix(path, "/programs/"):
+		name := strings.TrimPrefix(path, "/programs/")
+		if err := validProgramName(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+			r.adminGetProgram(w, req, name)
+		case http.MethodPut:
+			r.adminPutProgram(w, req, name)
+		case http.MethodDelete:
+			r.adminDeleteProgram(w, req, name)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete)
+		}
+	case strings.HasPrefix(path, "/shadows/"):
+		name, promote := strings.CutSuffix(strings.TrimPrefix(path, "/shadows/"), "/promote")
+		if err := validProgramName(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch {
+		case promote && req.Method == http.MethodPost:
+			r.adminPromoteShadow(w, req, name)
+		case promote:
+			methodNotAllowed(w, http.MethodPost)
+		case req.Method == http.MethodGet || req.Method == http.MethodHead:
+			r.adminGetShadow(w, req, name)
+		case req.Method == http.MethodPut:
+			r.adminPutShadow(w, req, name)
+		case req.Method == http.MethodDelete:
+			if !r.UnloadShadow(name) {
+				http.Error(w, fmt.Sprintf("no shadow of program %q is loaded", name), http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete)
+		}
+	case path == "/reload":
+		if req.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if err := r.LoadAllPrograms(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func methodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+}
+
+// validProgramName returns an error unless name is one LoadAllPrograms would load.
+func validProgramName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") || filepath.Ext(name) != fileExt {
+		return errors.Errorf("invalid program name %q: must be a file name ending in %s", name, fileExt)
+	}
+	return nil
+}
+
+// checkPreconditions reports whether the If-Match and If-None-Match headers of
+// req are satisfied by the program's current content hash, or by the program
+// not existing if ok is false.
+func checkPreconditions(req *http.Request, contentHash string, ok bool) bool {
+	etag := `"` + contentHash + `"`
+	if m := req.Header.Get("If-Match"); m != "" {
+		if !ok || (m != "*" && !etagListContains(m, etag)) {
+			return false
+		}
+	}
+	if m := req.Header.Get("If-None-Match"); m != "" {
+		if ok && (m == "*" || etagListContains(m, etag)) {
+			return false
+		}
+	}
+	return true
+}
+
+func etagListContains(list, etag string) bool {
+	for _, e := range strings.Split(list, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(e), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Runtime) adminGetProgram(w http.ResponseWriter, req *http.Request, name string) {
+	source, contentHash, ok := r.ProgramSource(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("program %q is not loaded", name), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", `"`+contentHash+`"`)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if req.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(source); err != nil {
+		glog.Warning(err)
+	}
+}
+
+func (r *Runtime) adminPutProgram(w http.ResponseWriter, req *http.Request, name string) {
+	source, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxProgramSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	r.installProgram(w, req, name, source)
+}
+
+// installProgram compiles and loads source as the named program, saving it in
+// the program directory, if the request's preconditions hold.  The caller
+// holds loadMu.
+func (r *Runtime) installProgram(w http.ResponseWriter, req *http.Request, name string, source []byte) {
+	_, contentHash, exists := r.ProgramSource(name)
+	if !checkPreconditions(req, contentHash, exists) {
+		w.Header().Set("ETag", `"`+contentHash+`"`)
+		http.Error(w, fmt.Sprintf("program %q has changed", name), http.StatusPreconditionFailed)
+		return
+	}
+	// Write the program beside its destination first, so a program path we
+	// can't write to is an error before anything is loaded, and the loaded
+	// program and the file on disk change together.
+	var tmp string
+	if dir, ok := r.programDir(); ok {
+		f, err := os.CreateTemp(dir, "."+name+".*")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tmp = f.Name()
+		defer os.Remove(tmp)
+		_, err = f.Write(source)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	err := r.CompileAndRun(name, bytes.NewReader(source))
+	r.programErrorMu.Lock()
+	if err == nil || !exists {
+		// A program that failed to compile over an existing one is still
+		// running; keep the errors of the running program.
+		r.programErrors[name] = err
+	}
+	r.programErrorMu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if tmp != "" {
+		if err := os.Rename(tmp, filepath.Join(filepath.Dir(tmp), name)); err != nil {
+			http.Error(w, fmt.Sprintf("program %q loaded but not saved: %s", name, err), http.StatusInternalServerError)
+			return
+		}
+	}
+	_, contentHash, _ = r.ProgramSource(name)
+	glog.Infof("Program %s uploaded with content hash %s", name, contentHash)
+	w.Header().Set("ETag", `"`+contentHash+`"`)
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (r *Runtime) adminDeleteProgram(w http.ResponseWriter, req *http.Request, name string) {
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	_, contentHash, exists := r.ProgramSource(name)
+	if !exists {
+		http.Error(w, fmt.Sprintf("program %q is not loaded", name), http.StatusNotFound)
+		return
+	}
+	if !checkPreconditions(req, contentHash, exists) {
+		w.Header().Set("ETag", `"`+contentHash+`"`)
+		http.Error(w, fmt.Sprintf("program %q has changed", name), http.StatusPreconditionFailed)
+		return
+	}
+	if dir, ok := r.programDir(); ok {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	r.UnloadProgram(name)
+	r.programErrorMu.Lock()
+	delete(r.programErrors, name)
+	r.programErrorMu.Unlock()
+	glog.Infof("Program %s deleted", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// programDir returns the program path if it is a directory, where uploaded programs are saved.
+func (r *Runtime) programDir() (string, bool) {
+	if r.programPath == "" {
+		return "", false
+	}
+	s, err := os.Stat(r.programPath)
+	if err != nil || !s.IsDir() {
+		return "", false
+	}
+	return r.programPath, true
+}
+
+func (r *Runtime) adminGetShadow(w http.ResponseWriter, req *http.Request, name string) {
+	source, contentHash, ok := r.ShadowSource(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no shadow of program %q is loaded", name), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("ETag", `"`+contentHash+`"`)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if req.Method == http.MethodHead {
+		return
+	}
+	if _, err := w.Write(source); err != nil {
+		glog.Warning(err)
+	}
+}
+
+func (r *Runtime) adminPutShadow(w http.ResponseWriter, req *http.Request, name string) {
+	source, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxProgramSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	_, contentHash, exists := r.ShadowSource(name)
+	if !checkPreconditions(req, contentHash, exists) {
+		w.Header().Set("ETag", `"`+contentHash+`"`)
+		http.Error(w, fmt.Sprintf("shadow of program %q has changed", name), http.StatusPreconditionFailed)
+		return
+	}
+	if err := r.LoadShadow(name, bytes.NewReader(source)); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	_, contentHash, _ = r.ShadowSource(name)
+	w.Header().Set("ETag", `"`+contentHash+`"`)
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// adminPromoteShadow installs the shadow as the program, with the request's
+// preconditions applying to the program being replaced, and unloads the shadow.
+func (r *Runtime) adminPromoteShadow(w http.ResponseWriter, req *http.Request, name string) {
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	source, _, ok := r.ShadowSource(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no shadow of program %q is loaded", name), http.StatusNotFound)
+		return
+	}
+	rec := &statusRecorder{ResponseWriter: w}
+	r.installProgram(rec, req, name, source)
+	if rec.status < 300 {
+		r.UnloadShadow(name)
+	}
+}
+
+// statusRecorder records the status code written to a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
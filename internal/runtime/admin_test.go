@@ -0,0 +1,196 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/testutil"
+)
+
+const (
+	adminProgV1 = "counter foo\n/foo/ {\n  foo++\n}\n"
+	adminProgV2 = "counter foo\n/foo|bar/ {\n  foo++\n}\n"
+)
+
+func adminRequest(tb testing.TB, r *Runtime, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	tb.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.AdminHandler(w, req)
+	return w
+}
+
+func TestAdminHandler(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	r, err := New(lines, &wg, dir, store)
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+
+	// Create a program, conditional on it not existing.
+	w := adminRequest(t, r, http.MethodPut, "/admin/programs/foo.mtail", adminProgV1, map[string]string{"If-None-Match": "*"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status %d, body %q", w.Code, w.Body.String())
+	}
+	etag := w.Header().Get("ETag")
+	if b, err := os.ReadFile(filepath.Join(dir, "foo.mtail")); err != nil || string(b) != adminProgV1 {
+		t.Errorf("program not saved: %q, %v", b, err)
+	}
+	w = adminRequest(t, r, http.MethodPut, "/admin/programs/foo.mtail", adminProgV1, map[string]string{"If-None-Match": "*"})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("create existing: status %d, want %d", w.Code, http.StatusPreconditionFailed)
+	}
+
+	// Fetch and list it.
+	w = adminRequest(t, r, http.MethodGet, "/admin/programs/foo.mtail", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != adminProgV1 || w.Header().Get("ETag") != etag {
+		t.Errorf("get: status %d, body %q, etag %q", w.Code, w.Body.String(), w.Header().Get("ETag"))
+	}
+	w = adminRequest(t, r, http.MethodGet, "/admin/programs", "", nil)
+	var programs []ProgramInfo
+	testutil.FatalIfErr(t, json.Unmarshal(w.Body.Bytes(), &programs))
+	testutil.ExpectNoDiff(t, []ProgramInfo{{Name: "foo.mtail", ContentHash: strings.Trim(etag, `"`)}}, programs)
+
+	// Invalid programs are rejected, leaving the old one running.
+	w = adminRequest(t, r, http.MethodPut, "/admin/programs/foo.mtail", "counter foo\n/(/ {\n  foo++\n}\n", nil)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "compile failed") {
+		t.Errorf("invalid: status %d, body %q", w.Code, w.Body.String())
+	}
+	if _, hash, _ := r.ProgramSource("foo.mtail"); `"`+hash+`"` != etag {
+		t.Errorf("invalid program replaced the running one")
+	}
+
+	// Updates are conditional on the current content hash.
+	w = adminRequest(t, r, http.MethodPut, "/admin/programs/foo.mtail", adminProgV2, map[string]string{"If-Match": `"stale"`})
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != etag {
+		t.Errorf("stale update: status %d, etag %q", w.Code, w.Header().Get("ETag"))
+	}
+	w = adminRequest(t, r, http.MethodPut, "/admin/programs/foo.mtail", adminProgV2, map[string]string{"If-Match": etag})
+	if w.Code != http.StatusNoContent || w.Header().Get("ETag") == etag {
+		t.Errorf("update: status %d, etag %q, body %q", w.Code, w.Header().Get("ETag"), w.Body.String())
+	}
+	if source, _, _ := r.ProgramSource("foo.mtail"); string(source) != adminProgV2 {
+		t.Errorf("update not loaded: %q", source)
+	}
+
+	// Reload keeps the uploaded program, as it was saved.
+	w = adminRequest(t, r, http.MethodPost, "/admin/reload", "", nil)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("reload: status %d, body %q", w.Code, w.Body.String())
+	}
+	if _, _, ok := r.ProgramSource("foo.mtail"); !ok {
+		t.Error("program unloaded by reload")
+	}
+
+	// Delete it.
+	w = adminRequest(t, r, http.MethodDelete, "/admin/programs/foo.mtail", "", nil)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("delete: status %d, body %q", w.Code, w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "foo.mtail")); !os.IsNotExist(err) {
+		t.Errorf("program file not removed: %v", err)
+	}
+	w = adminRequest(t, r, http.MethodGet, "/admin/programs/foo.mtail", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("get deleted: status %d", w.Code)
+	}
+	w = adminRequest(t, r, http.MethodDelete, "/admin/programs/foo.mtail", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("delete deleted: status %d", w.Code)
+	}
+}
+
+func TestAdminUploadDuringReload(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	r, err := New(lines, &wg, dir, store)
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+
+	// Reloads from the program directory run alongside the uploads, as they
+	// would on SIGHUP or a program directory change.
+	done := make(chan struct{})
+	var reloads sync.WaitGroup
+	reloads.Add(1)
+	go func() {
+		defer reloads.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				if err := r.LoadAllPrograms(); err != nil {
+					t.Error(err)
+				}
+			}
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("prog%d.mtail", i)
+		w := adminRequest(t, r, http.MethodPut, "/admin/programs/"+name, adminProgV1, nil)
+		if w.Code != http.StatusCreated {
+			t.Errorf("create %s: status %d, body %q", name, w.Code, w.Body.String())
+		}
+	}
+	close(done)
+	reloads.Wait()
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("prog%d.mtail", i)
+		if _, _, ok := r.ProgramSource(name); !ok {
+			t.Errorf("uploaded program %s unloaded by a reload", name)
+		}
+	}
+}
+
+func TestAdminHandlerBadRequests(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	r, err := New(lines, &wg, "", store)
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+	for _, tc := range []struct {
+		method, path string
+		want         int
+	}{
+		{http.MethodPut, "/admin/programs/foo.txt", http.StatusBadRequest},
+		{http.MethodPut, "/admin/programs/.foo.mtail", http.StatusBadRequest},
+		{http.MethodPut, "/admin/programs/a/foo.mtail", http.StatusBadRequest},
+		{http.MethodPost, "/admin/programs", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/admin/programs/foo.mtail", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/reload", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/admin/frob", http.StatusNotFound},
+	} {
+		if w := adminRequest(t, r, tc.method, tc.path, "", nil); w.Code != tc.want {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, w.Code, tc.want)
+		}
+	}
+}
@@ -4,15 +4,47 @@
 package code
 
 import (
+	"net/netip"
 	"regexp"
 
 	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/runtime/compiler/position"
 )
 
 // Object is the data and bytecode resulting from compiled program source.
 type Object struct {
-	Program []Instr           // The program bytecode.
-	Strings []string          // Static strings.
-	Regexps []*regexp.Regexp  // Static regular expressions.
-	Metrics []*metrics.Metric // Metrics accessible to this program.
+	Program        []Instr                  // The program bytecode.
+	Positions      []position.Position      // Source position of each instruction in Program.
+	RegProgram     []RegInstr               // The program bytecode for the register machine.
+	Registers      int                      // Number of registers used by RegProgram.
+	Strings        []string                 // Static strings.
+	Maps           []map[string]interface{} // Lookup tables, from map constants, and the initial contents of map variables.
+	Prefixes       []netip.Prefix           // Static networks, from constant CIDR arguments to incidr.
+	Regexps        []*regexp.Regexp         // Static regular expressions.
+	RegexpLiterals [][]string               // Literal substrings each of Regexps requires in order to match.
+	Metrics        []*metrics.Metric        // Metrics accessible to this program.
+	Branches       map[int]Branch           // Kind of each control flow jump in Program, by program counter.
+	Serial         bool                     // Program keeps state across lines and must not be replicated.
+	PersistTime    bool                     // The time register keeps its value from one line to the next.
+	Imports        bool                     // Program imports modules, so its compiled form can change without its source changing.
+	Functions      []Function               // User-defined functions in Program, indexed by the operand of Call.
+	MaxCallDepth   int                      // Limit on nested function calls at runtime, or zero for the VM default.
 }
+
+// Function describes a user-defined function compiled into the program.
+type Function struct {
+	Name   string // Name of the function, for disassembly.
+	Entry  int    // Program counter of the first instruction of the function body.
+	Params int    // Number of arguments the function takes from the stack.
+}
+
+// Branch classifies the conditional jumps that implement the control flow of
+// a program, as opposed to those that compute the value of an expression.
+type Branch int
+
+const (
+	_          Branch = iota
+	CondBranch        // Skips the block of a conditional statement when its condition is false.
+	AndBranch         // Short-circuits `&&' when its left hand side is false.
+	OrBranch          // Short-circuits `||' when its left hand side is true.
+)
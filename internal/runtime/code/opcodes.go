@@ -77,6 +77,59 @@ const (
 	// String opcodes.
 	Subst
 	Rsubst
+	Toupper   // Convert the string at the top of the stack to uppercase.
+	Trim      // Remove leading and trailing white space from the string at the top of the stack.
+	Substr    // Pop a length, a start offset, and a string, and push the substring.
+	Split     // Pop an index, a separator, and a string, and push the indexed field of the string split by the separator.
+	Index     // Pop a substring and a string, and push the offset of the substring in the string, or -1.
+	Hasprefix // Pop a prefix and a string, and push whether the string starts with the prefix.
+	Hassuffix // Pop a suffix and a string, and push whether the string ends with the suffix.
+	Sprintf   // Pop `operand` - 1 arguments and a format string, and push the formatted string.
+	Duration  // Parse the duration string at the top of the stack, and push its length in seconds.
+	Bytes     // Parse the size string at the top of the stack, and push its length in bytes.
+
+	// Network address opcodes.
+	Ipnet    // Pop a prefix length and an IP address, and push the network containing the address.
+	Incidr   // Pop a network, or use the one at operand if set, and an IP address, and push whether the network contains the address.
+	Isipv6   // Push whether TOS is an IPv6 address.
+	Clientip // Pop a forwarded-for list and a peer address, and push the originating client address.
+
+	// Time opcodes.
+	Strftime    // Pop a timestamp and a layout, and push the timestamp formatted with the layout.
+	Hour        // Push the hour of the day of the timestamp at TOS.
+	Weekday     // Push the day of the week of the timestamp at TOS, with Sunday as 0.
+	Dayofyear   // Push the day of the year of the timestamp at TOS.
+	Now         // Push the current system time.
+	TimestampNs // Push the value of the timestamp register in nanoseconds.
+
+	// Function opcodes.
+	Call  // Call the function at operand, with its arguments on the stack.
+	Ret   // Return from the current function, leaving TOS as its result.
+	Lload // Load the operandth argument of the current function onto the stack.
+
+	// Map opcodes.
+	Mapget    // Pop a map index and a key, and push the value of the key in the map.
+	Mapin     // Pop a map index and a key, and push whether the key is in the map.
+	Maplookup // Pop a default, a key, and a map index, and push the value of the key in the map, or the default.
+	Mapset    // Pop a value, a map index and a key, and set the key in the map to the value.
+	Mapdel    // Pop a map index and a key, and delete the key from the map.
+
+	// Math opcodes.
+	Iabs   // Push the absolute value of the integer TOS.
+	Fabs   // Push the absolute value of the floating point TOS.
+	Imin   // Pop two integers and push the lesser.
+	Fmin   // Pop two floats and push the lesser.
+	Imax   // Pop two integers and push the greater.
+	Fmax   // Pop two floats and push the greater.
+	Iclamp // Pop an upper bound, a lower bound, and an integer, and push the integer limited to the bounds.
+	Fclamp // Pop an upper bound, a lower bound, and a float, and push the float limited to the bounds.
+	Floor  // Round the float TOS down to an integral value.
+	Ceil   // Round the float TOS up to an integral value.
+	Round  // Round the float TOS to the nearest integral value, halves away from zero.
+	Log    // Push the natural logarithm of TOS.
+	Log10  // Push the base 10 logarithm of TOS.
+	Exp    // Push e to the power of TOS.
+	Sqrt   // Push the square root of TOS.
 
 	lastOpcode
 )
@@ -93,6 +146,7 @@ var opNames = map[Opcode]string{
 	Strptime:    "strptime",
 	Timestamp:   "timestamp",
 	Settime:     "settime",
+	TimestampNs: "timestamp_ns",
 	Push:        "push",
 	Capref:      "capref",
 	Str:         "str",
@@ -140,6 +194,48 @@ var opNames = map[Opcode]string{
 	Scmp:        "scmp",
 	Subst:       "subst",
 	Rsubst:      "rsubst",
+	Toupper:     "toupper",
+	Trim:        "trim",
+	Substr:      "substr",
+	Split:       "split",
+	Index:       "index",
+	Hasprefix:   "hasprefix",
+	Hassuffix:   "hassuffix",
+	Sprintf:     "sprintf",
+	Duration:    "duration",
+	Bytes:       "bytes",
+	Ipnet:       "ipnet",
+	Incidr:      "incidr",
+	Isipv6:      "isipv6",
+	Clientip:    "clientip",
+	Strftime:    "strftime",
+	Hour:        "hour",
+	Weekday:     "weekday",
+	Dayofyear:   "dayofyear",
+	Now:         "now",
+	Iabs:        "iabs",
+	Fabs:        "fabs",
+	Imin:        "imin",
+	Fmin:        "fmin",
+	Imax:        "imax",
+	Fmax:        "fmax",
+	Iclamp:      "iclamp",
+	Fclamp:      "fclamp",
+	Floor:       "floor",
+	Ceil:        "ceil",
+	Round:       "round",
+	Log:         "log",
+	Log10:       "log10",
+	Exp:         "exp",
+	Sqrt:        "sqrt",
+	Call:        "call",
+	Ret:         "ret",
+	Lload:       "lload",
+	Mapget:      "mapget",
+	Mapin:       "mapin",
+	Maplookup:   "maplookup",
+	Mapset:      "mapset",
+	Mapdel:      "mapdel",
 }
 
 func (o Opcode) String() string {
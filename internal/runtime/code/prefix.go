@@ -0,0 +1,23 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package code
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParsePrefix parses the network in CIDR notation in s, ignoring surrounding
+// whitespace, and returns it with the host bits cleared.  The compiler checks
+// constant networks with it, and the VM parses networks computed at runtime,
+// so that both read the same networks.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(strings.TrimSpace(s))
+	if err != nil {
+		return netip.Prefix{}, errors.Errorf("invalid CIDR %q", s)
+	}
+	return p.Masked(), nil
+}
@@ -0,0 +1,33 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package code
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrefix(t *testing.T) {
+	for _, tc := range []struct {
+		s       string
+		want    netip.Prefix
+		wantErr bool
+	}{
+		{"10.0.0.0/8", netip.MustParsePrefix("10.0.0.0/8"), false},
+		{" 10.0.0.0/8\n", netip.MustParsePrefix("10.0.0.0/8"), false},
+		{"10.1.2.3/8", netip.MustParsePrefix("10.0.0.0/8"), false},
+		{"2001:db8::1/32", netip.MustParsePrefix("2001:db8::/32"), false},
+		{"10.0.0.0/33", netip.Prefix{}, true},
+		{"10.0.0.0", netip.Prefix{}, true},
+	} {
+		got, err := ParsePrefix(tc.s)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParsePrefix(%q) error: %v, want error %v", tc.s, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParsePrefix(%q) = %v, want %v", tc.s, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package code
+
+import "fmt"
+
+// RegInstr is an instruction for the register machine.  It shares its opcodes
+// with the stack machine, but instead of popping arguments off a data stack
+// each instruction reads them from a run of registers starting at Reg, and
+// writes any result back to Reg.
+type RegInstr struct {
+	Opcode     Opcode
+	Operand    interface{}
+	Reg        int // First register read, and the register written, by this instruction.
+	SourceLine int // Line number of the original source file, zero-based numbering.
+}
+
+// debug print for register instructions.
+func (i RegInstr) String() string {
+	return fmt.Sprintf("{%s %v r%d %d}", opNames[i.Opcode], i.Operand, i.Reg, i.SourceLine)
+}
+
+// StackEffect returns the number of values the stack machine instruction i
+// pops off the data stack, and the number it pushes back on.  The second
+// result is always zero or one.
+func StackEffect(i Instr) (pop, push int, err error) {
+	// nargs returns the count of arguments given in the operand, for
+	// instructions that are emitted with either an argument count or nil.
+	nargs := func(def int) int {
+		if n, ok := i.Operand.(int); ok {
+			return n
+		}
+		return def
+	}
+	switch i.Opcode {
+	case Stop, Jmp, Setmatched:
+		return 0, 0, nil
+	case Match, Timestamp, TimestampNs, Now, Push, Str, Mload, Otherwise, Getfilename, Lload:
+		return 0, 1, nil
+	case Jnm, Jm, Settime:
+		return 1, 0, nil
+	case Smatch, Capref, Neg, Not, Iget, Fget, Sget, Tolower, Toupper, Trim, Length, I2f, S2f, I2s, F2s, Duration, Bytes, Isipv6,
+		Hour, Weekday, Dayofyear,
+		Iabs, Fabs, Floor, Ceil, Round, Log, Log10, Exp, Sqrt:
+		return 1, 1, nil
+	case Cmp, Icmp, Fcmp, Scmp, Cat,
+		Iadd, Isub, Imul, Idiv, Imod, Ipow, And, Or, Xor, Shl, Shr,
+		Fadd, Fsub, Fmul, Fdiv, Fmod, Fpow, Index, Hasprefix, Hassuffix,
+		Imin, Fmin, Imax, Fmax, Ipnet, Clientip, Strftime:
+		return 2, 1, nil
+	case Mapget, Mapin:
+		return 2, 1, nil
+	case Maplookup:
+		return 3, 1, nil
+	case Mapset:
+		return 3, 0, nil
+	case Mapdel:
+		return 2, 0, nil
+	case Sset, Iset, Fset, Strptime:
+		return 2, 0, nil
+	case Subst, Rsubst, Substr, Split, Iclamp, Fclamp:
+		return 3, 1, nil
+	case Sprintf:
+		return nargs(1), 1, nil
+	case Incidr:
+		// A non-nil operand means the network is a constant.
+		if i.Operand != nil {
+			return 1, 1, nil
+		}
+		return 2, 1, nil
+	case Inc, Dec:
+		// A non-nil operand means the delta is also on the stack.
+		if i.Operand != nil {
+			return 2, 1, nil
+		}
+		return 1, 1, nil
+	case S2i:
+		// strtol is emitted with an argument count that includes the base.
+		if i.Operand != nil {
+			return nargs(1), 1, nil
+		}
+		return 1, 1, nil
+	case Dload:
+		return nargs(0) + 1, 1, nil
+	case Del:
+		return nargs(0) + 1, 0, nil
+	case Expire:
+		return nargs(0) + 2, 0, nil
+	}
+	return 0, 0, fmt.Errorf("no stack effect known for instruction %s", i)
+}
@@ -209,6 +209,7 @@ type VarDecl struct {
 	Hidden       bool
 	Keys         []string
 	Limit        int64
+	LimitConst   string // If not empty, names the Int constant holding the limit.
 	Buckets      []float64
 	Kind         metrics.Kind
 	ExportedName string
@@ -312,6 +313,85 @@ func (n *PatternFragment) Type() types.Type {
 	return types.Pattern
 }
 
+// DurationLit holds a duration, which can only be the value of a constant.
+type DurationLit struct {
+	P position.Position
+	D time.Duration
+}
+
+func (n *DurationLit) Pos() *position.Position {
+	return &n.P
+}
+
+func (n *DurationLit) Type() types.Type {
+	return types.Duration
+}
+
+// MapLit holds a table of literal keys and values, which can only be the value
+// of a constant or the initial contents of a map variable.
+type MapLit struct {
+	P      position.Position
+	Keys   []Node // String or integer literals.
+	Values []Node // String, integer, or float literals, one for each key.
+
+	typMu sync.RWMutex
+	typ   types.Type
+}
+
+func (n *MapLit) Pos() *position.Position {
+	return &n.P
+}
+
+func (n *MapLit) Type() types.Type {
+	n.typMu.RLock()
+	defer n.typMu.RUnlock()
+	if n.typ == nil {
+		return types.Undef
+	}
+	return n.typ
+}
+
+func (n *MapLit) SetType(t types.Type) {
+	n.typMu.Lock()
+	defer n.typMu.Unlock()
+	n.typ = t
+}
+
+// ConstDecl holds a named constant whose value is a literal other than a pattern.
+type ConstDecl struct {
+	ID     Node
+	Value  Node
+	Symbol *symbol.Symbol
+}
+
+func (n *ConstDecl) Pos() *position.Position {
+	return n.ID.Pos()
+}
+
+func (n *ConstDecl) Type() types.Type {
+	return n.Value.Type()
+}
+
+// MapDecl declares a map variable, a lookup table the program can change,
+// with the initial contents of Init if it is not nil.
+type MapDecl struct {
+	P      position.Position
+	Name   string
+	Init   *MapLit
+	Symbol *symbol.Symbol
+}
+
+func (n *MapDecl) Pos() *position.Position {
+	return &n.P
+}
+
+func (n *MapDecl) Type() types.Type {
+	if n.Symbol != nil {
+		return n.Symbol.Type
+	}
+	return types.Error
+}
+
 type DecoDecl struct {
 	P      position.Position
 	Name   string
@@ -331,6 +411,88 @@ func (n *DecoDecl) Type() types.Type {
 	return types.Int
 }
 
+// FuncDecl holds the declaration and definition of a function.
+type FuncDecl struct {
+	P          position.Position
+	Name       string
+	Params     []*ParamDecl
+	ReturnType string // Name of the type of the result.
+	Block      Node
+	Symbol     *symbol.Symbol
+	Scope      *symbol.Scope // The parameters of the function.
+}
+
+func (n *FuncDecl) Pos() *position.Position {
+	return position.Merge(&n.P, n.Block.Pos())
+}
+
+func (n *FuncDecl) Type() types.Type {
+	if n.Symbol != nil {
+		return n.Symbol.Type
+	}
+	return types.None
+}
+
+// ParamDecl holds the declaration of a parameter of a function.
+type ParamDecl struct {
+	P        position.Position
+	Name     string
+	TypeName string
+	Symbol   *symbol.Symbol
+}
+
+func (n *ParamDecl) Pos() *position.Position {
+	return &n.P
+}
+
+func (n *ParamDecl) Type() types.Type {
+	if n.Symbol != nil {
+		return n.Symbol.Type
+	}
+	return types.Error
+}
+
+// CallExpr is a call to a function declared in the program.
+type CallExpr struct {
+	P    position.Position
+	Name string
+	Args Node
+	Decl *FuncDecl // Pointer to the declaration of the function this expression calls.
+
+	typMu sync.RWMutex
+	typ   types.Type
+}
+
+func (n *CallExpr) Pos() *position.Position {
+	return &n.P
+}
+
+func (n *CallExpr) Type() types.Type {
+	n.typMu.RLock()
+	defer n.typMu.RUnlock()
+	return n.typ
+}
+
+func (n *CallExpr) SetType(t types.Type) {
+	n.typMu.Lock()
+	defer n.typMu.Unlock()
+	n.typ = t
+}
+
+// ReturnStmt returns the value of an expression from a function.
+type ReturnStmt struct {
+	P    position.Position
+	Expr Node
+}
+
+func (n *ReturnStmt) Pos() *position.Position {
+	return &n.P
+}
+
+func (n *ReturnStmt) Type() types.Type {
+	return types.None
+}
+
 type DecoStmt struct {
 	P     position.Position
 	Name  string
@@ -372,9 +534,10 @@ func (n *OtherwiseStmt) Type() types.Type {
 }
 
 type DelStmt struct {
-	P      position.Position
-	N      Node
-	Expiry time.Duration
+	P           position.Position
+	N           Node
+	Expiry      time.Duration
+	ExpiryConst string // If not empty, names the Duration constant holding the expiry.
 }
 
 func (n *DelStmt) Pos() *position.Position {
@@ -433,6 +596,36 @@ func (n *StopStmt) Type() types.Type {
 	return types.None
 }
 
+// PragmaStmt is a directive to the runtime about how the program is to be
+// executed, for example `pragma serial`.
+type PragmaStmt struct {
+	P    position.Position
+	Name string
+}
+
+func (n *PragmaStmt) Pos() *position.Position {
+	return &n.P
+}
+
+func (n *PragmaStmt) Type() types.Type {
+	return types.None
+}
+
+// ImportStmt brings the declarations of a module into the program.
+type ImportStmt struct {
+	P        position.Position
+	Name     string
+	Children []Node // The module's declarations, after import resolution; empty if the module was already imported.
+}
+
+func (n *ImportStmt) Pos() *position.Position {
+	return &n.P
+}
+
+func (n *ImportStmt) Type() types.Type {
+	return types.None
+}
+
 // mergepositionlist is a helper that merges the positions of all the nodes in a list.
 func mergepositionlist(l []Node) *position.Position {
 	if len(l) == 0 {
@@ -72,6 +72,17 @@ func Walk(v Visitor, node Node) Node {
 	case *DecoStmt:
 		n.Block = Walk(v, n.Block)
 
+	case *FuncDecl:
+		n.Block = Walk(v, n.Block)
+
+	case *CallExpr:
+		if n.Args != nil {
+			n.Args = Walk(v, n.Args)
+		}
+
+	case *ReturnStmt:
+		n.Expr = Walk(v, n.Expr)
+
 	case *ConvExpr:
 		n.N = Walk(v, n.N)
 
@@ -81,7 +92,10 @@ func Walk(v Visitor, node Node) Node {
 	case *PatternFragment:
 		n.Expr = Walk(v, n.Expr)
 
-	case *IDTerm, *CaprefTerm, *VarDecl, *StringLit, *IntLit, *FloatLit, *PatternLit, *NextStmt, *OtherwiseStmt, *DelStmt, *StopStmt:
+	case *ImportStmt:
+		n.Children = walknodelist(v, n.Children)
+
+	case *IDTerm, *CaprefTerm, *VarDecl, *StringLit, *IntLit, *FloatLit, *DurationLit, *MapLit, *MapDecl, *ConstDecl, *ParamDecl, *PatternLit, *NextStmt, *OtherwiseStmt, *DelStmt, *StopStmt, *PragmaStmt:
 		// These nodes are terminals, thus have no children to walk.
 
 	default:
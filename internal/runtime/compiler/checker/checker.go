@@ -6,14 +6,17 @@ package checker
 import (
 	goerrors "errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/runtime/code"
 	"github.com/google/mtail/internal/runtime/compiler/ast"
 	"github.com/google/mtail/internal/runtime/compiler/errors"
 	"github.com/google/mtail/internal/runtime/compiler/parser"
+	"github.com/google/mtail/internal/runtime/compiler/position"
 	"github.com/google/mtail/internal/runtime/compiler/symbol"
 	"github.com/google/mtail/internal/runtime/compiler/types"
 )
@@ -23,12 +26,30 @@ const (
 	defaultMaxRecursionDepth = 100
 )
 
+// pragmas lists the names accepted by the `pragma' statement.
+var pragmas = map[string]struct{}{
+	// serial programs keep state across lines, so must not be run on more than one VM replica.
+	"serial": {},
+	// persist_time programs keep the time register from one line to the next, which implies serial.
+	"persist_time": {},
+}
+
+// typeNames maps the names of types in function declarations to the types.
+var typeNames = map[string]types.Type{
+	"bool":   types.Bool,
+	"float":  types.Float,
+	"int":    types.Int,
+	"string": types.String,
+}
+
 // checker holds data for a semantic checker.
 type checker struct {
 	scope *symbol.Scope // the current scope
 
 	decoScopes []*symbol.Scope // A stack of scopes used for resolving symbols in decorated nodes
 
+	funcs []*ast.FuncDecl // A stack of the functions being checked, innermost last.
+
 	errors errors.ErrorList
 
 	depth             int
@@ -123,6 +144,11 @@ func (c *checker) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 			c.depth--
 			return nil, n
 		}
+		if n.LimitConst != "" {
+			if l, ok := c.lookupConst(n.Pos(), n.LimitConst, types.Int, "`limit'").(*ast.IntLit); ok {
+				n.Limit = l.I
+			}
+		}
 		if len(n.Buckets) > 0 && n.Kind != metrics.Histogram {
 			c.errors.Add(n.Pos(), fmt.Sprintf("Can't specify buckets for non-histogram metric `%s'.", n.Name))
 			c.depth--
@@ -152,6 +178,18 @@ func (c *checker) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 				glog.V(2).Infof("Found patternsymbol Sym %v", sym)
 				sym.Used = true
 				n.Symbol = sym
+			} else if sym := c.scope.Lookup(n.Name, symbol.ConstSymbol); sym != nil {
+				glog.V(2).Infof("Found constsymbol Sym %v", sym)
+				sym.Used = true
+				n.Symbol = sym
+			} else if sym := c.scope.Lookup(n.Name, symbol.ParamSymbol); sym != nil {
+				glog.V(2).Infof("Found paramsymbol Sym %v", sym)
+				sym.Used = true
+				n.Symbol = sym
+			} else if sym := c.scope.Lookup(n.Name, symbol.MapSymbol); sym != nil {
+				glog.V(2).Infof("Found mapsymbol Sym %v", sym)
+				sym.Used = true
+				n.Symbol = sym
 			} else {
 				// Apply a terribly bad heuristic to choose a suggestion.
 				sug := fmt.Sprintf("Try adding `counter %s' to the top of the program.", n.Name)
@@ -179,6 +217,59 @@ func (c *checker) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		c.decoScopes = append(c.decoScopes, symbol.NewScope(nil))
 		return c, n
 
+	case *ast.FuncDecl:
+		if c.scope.Parent != nil {
+			c.errors.Add(n.Pos(), fmt.Sprintf("Can't declare function `%s' here.\n\tFunctions are only allowed at the top level of a program.", n.Name))
+			c.depth--
+			return nil, n
+		}
+		n.Symbol = symbol.NewSymbol(n.Name, symbol.FuncSymbol, &n.P)
+		n.Symbol.Binding = n
+		if alt := c.scope.Insert(n.Symbol); alt != nil {
+			c.errors.Add(n.Pos(), fmt.Sprintf("Redeclaration of function `%s' previously declared at %s", n.Name, alt.Pos))
+			c.depth--
+			return nil, n
+		}
+		// The parameters are declared in their own scope, enclosing the block.
+		n.Scope = symbol.NewScope(c.scope)
+		ok := true
+		fTypes := make([]types.Type, 0, len(n.Params)+1)
+		for i, p := range n.Params {
+			t, found := typeNames[p.TypeName]
+			if !found {
+				c.errors.Add(p.Pos(), fmt.Sprintf("Unknown type `%s' for parameter `%s'.\n\tTry one of bool, float, int, or string.", p.TypeName, p.Name))
+				ok = false
+			}
+			p.Symbol = symbol.NewSymbol(p.Name, symbol.ParamSymbol, p.Pos())
+			p.Symbol.Type = t
+			p.Symbol.Binding = p
+			p.Symbol.Addr = i
+			if alt := n.Scope.Insert(p.Symbol); alt != nil {
+				c.errors.Add(p.Pos(), fmt.Sprintf("Redeclaration of parameter `%s' previously declared at %s", p.Name, alt.Pos))
+				ok = false
+			}
+			fTypes = append(fTypes, t)
+		}
+		rType, found := typeNames[n.ReturnType]
+		if !found {
+			c.errors.Add(&n.P, fmt.Sprintf("Unknown result type `%s' for function `%s'.\n\tTry one of bool, float, int, or string.", n.ReturnType, n.Name))
+			ok = false
+		}
+		if !ok {
+			c.depth--
+			return nil, n
+		}
+		// The function's type is set before its block is checked, so that it can call itself.
+		n.Symbol.Type = types.Function(append(fTypes, rType)...)
+		c.scope = n.Scope
+		c.funcs = append(c.funcs, n)
+		n.Block = ast.Walk(c, n.Block)
+		c.funcs = c.funcs[:len(c.funcs)-1]
+		c.checkSymbolTable()
+		c.scope = n.Scope.Parent
+		c.depth--
+		return nil, n
+
 	case *ast.DecoStmt:
 		if sym := c.scope.Lookup(n.Name, symbol.DecoSymbol); sym != nil {
 			if sym.Binding == nil {
@@ -231,9 +322,86 @@ func (c *checker) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		n.Symbol.Type = types.Pattern
 		return c, n
 
+	case *ast.ConstDecl:
+		id, ok := n.ID.(*ast.IDTerm)
+		if !ok {
+			c.errors.Add(n.Pos(), fmt.Sprintf("Internal error: no identifier attached to constant %#v", n))
+			c.depth--
+			return nil, n
+		}
+		n.Symbol = symbol.NewSymbol(id.Name, symbol.ConstSymbol, id.Pos())
+		if alt := c.scope.Insert(n.Symbol); alt != nil {
+			c.errors.Add(n.Pos(), fmt.Sprintf("Redefinition of constant `%s' previously defined at %s", id.Name, alt.Pos))
+			c.depth--
+			return nil, n
+		}
+		n.Symbol.Binding = n
+		if m, ok := n.Value.(*ast.MapLit); ok {
+			c.checkMapLit(id.Name, m)
+		}
+		n.Symbol.Type = n.Value.Type()
+		c.depth--
+		return nil, n
+
+	case *ast.MapDecl:
+		if c.scope.Parent != nil {
+			c.errors.Add(n.Pos(), fmt.Sprintf("Can't declare map `%s' here.\n\tMaps are only allowed at the top level of a program.", n.Name))
+			c.depth--
+			return nil, n
+		}
+		n.Symbol = symbol.NewSymbol(n.Name, symbol.MapSymbol, n.Pos())
+		if alt := c.scope.Insert(n.Symbol); alt != nil {
+			c.errors.Add(n.Pos(), fmt.Sprintf("Redeclaration of map `%s' previously declared at %s", n.Name, alt.Pos))
+			c.depth--
+			return nil, n
+		}
+		n.Symbol.Binding = n
+		// The values take their type from the initial contents, or else from
+		// their first use.
+		if n.Init != nil {
+			c.checkMapLit(n.Name, n.Init)
+			n.Symbol.Type = n.Init.Type()
+		} else {
+			n.Symbol.Type = types.Map(types.String, types.NewVariable())
+		}
+		c.depth--
+		return nil, n
+
 	case *ast.DelStmt:
+		if n.ExpiryConst != "" {
+			if d, ok := c.lookupConst(n.Pos(), n.ExpiryConst, types.Duration, "`after'").(*ast.DurationLit); ok {
+				n.Expiry = d.D
+			}
+		}
 		n.N = ast.Walk(c, n.N)
 		return c, n
+
+	case *ast.ImportStmt:
+		// The module's declarations are checked in the importing scope, but
+		// the program needn't use them all.
+		for i, child := range n.Children {
+			n.Children[i] = ast.Walk(c, child)
+			switch d := n.Children[i].(type) {
+			case *ast.PatternFragment:
+				if d.Symbol != nil {
+					d.Symbol.Used = true
+				}
+			case *ast.ConstDecl:
+				if d.Symbol != nil {
+					d.Symbol.Used = true
+				}
+			case *ast.DecoDecl:
+				if d.Symbol != nil {
+					d.Symbol.Used = true
+				}
+			case *ast.FuncDecl:
+				if d.Symbol != nil {
+					d.Symbol.Used = true
+				}
+			}
+		}
+		c.depth--
+		return nil, n
 	}
 	return c, node
 }
@@ -285,6 +453,10 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 		switch n.Cond.(type) {
 		case *ast.BinaryExpr, *ast.OtherwiseStmt, *ast.UnaryExpr:
 			// OK as conditions
+		case *ast.CallExpr, *ast.BuiltinExpr:
+			if !types.Equals(types.Bool, n.Cond.Type()) && !types.IsTypeError(n.Cond.Type()) {
+				c.errors.Add(n.Cond.Pos(), fmt.Sprintf("Can't interpret %s as a boolean expression here.\n\tTry using comparison operators to make the condition explicit.", n.Cond.Type()))
+			}
 		case *ast.PatternExpr:
 			// If the parser saw an IDTerm with type Pattern, then we know it's really a pattern constant and need to wrap it in an unary match in this context.
 			cond := &ast.UnaryExpr{Expr: n.Cond, Op: parser.MATCH}
@@ -304,6 +476,16 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 		c.scope = n.Scope.Parent
 		return n
 
+	case *ast.PragmaStmt:
+		if c.scope.Parent != nil {
+			c.errors.Add(n.Pos(), fmt.Sprintf("Can't use pragma `%s' here.\n\tPragmas are only allowed at the top level of a program.", n.Name))
+			return n
+		}
+		if _, ok := pragmas[n.Name]; !ok {
+			c.errors.Add(n.Pos(), fmt.Sprintf("Unknown pragma `%s'.", n.Name))
+		}
+		return n
+
 	case *ast.NextStmt:
 		// The last element in this list will be the empty stack created by the
 		// DecoDecl on the way in.  If there's no last element, then we can't
@@ -345,6 +527,15 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 			n.SetType(rT)
 			return n
 		}
+		if n.Op == parser.IN {
+			if c.rejectMap(n.Pos(), n.LHS) {
+				n.SetType(types.Error)
+				return n
+			}
+		} else if c.rejectMap(n.Pos(), n.LHS, n.RHS) {
+			n.SetType(types.Error)
+			return n
+		}
 		var rType types.Type
 		switch n.Op {
 		case parser.DIV, parser.MOD, parser.MUL, parser.MINUS, parser.PLUS, parser.POW:
@@ -496,6 +687,9 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 			}
 
 		case parser.ASSIGN, parser.ADD_ASSIGN:
+			if ix, ok := n.LHS.(*ast.IndexedExpr); ok && isMapVar(ix.LHS) {
+				return c.checkMapAssign(n, ix)
+			}
 			// e1 = e2; e1 += e2
 			// O ⊢ e1 : Tl, O ⊢ e2 : Tr
 			// Tr <= Tl
@@ -514,8 +708,18 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 			// If the LHS is assignable, mark it as an lvalue, otherwise error.
 			switch v := n.LHS.(type) {
 			case *ast.IDTerm:
+				if v.Symbol != nil && v.Symbol.Kind == symbol.ParamSymbol {
+					c.errors.Add(v.Pos(), fmt.Sprintf("Can't assign to parameter `%s'.", v.Name))
+					n.SetType(types.Error)
+					return n
+				}
 				v.Lvalue = true
 			case *ast.IndexedExpr:
+				if isMapConst(v.LHS) {
+					c.errors.Add(n.LHS.Pos(), "Can't assign to expression on left; expecting a variable here.")
+					n.SetType(types.Error)
+					return n
+				}
 				v.LHS.(*ast.IDTerm).Lvalue = true
 			default:
 				glog.V(2).Infof("The lhs is a %T %v", n.LHS, n.LHS)
@@ -524,6 +728,24 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 				return n
 			}
 
+		case parser.IN:
+			// e1 in e2
+			// O ⊢ e1 : String , O ⊢ e2 : Map[String]T
+			// ⇒ O ⊢ e : Bool
+			if !isMap(n.RHS) {
+				c.errors.Add(n.RHS.Pos(), fmt.Sprintf("Can't test membership in %s.\n\tTry a map on the right of `in'.", rT))
+				n.SetType(types.Error)
+				return n
+			}
+			key, ok := mapKey(n.LHS)
+			if !ok {
+				c.errors.Add(n.LHS.Pos(), fmt.Sprintf("Can't look up %s in a map; expecting String.", lT))
+				n.SetType(types.Error)
+				return n
+			}
+			n.LHS = key
+			rType = types.Bool
+
 		case parser.MATCH, parser.NOT_MATCH:
 			// e1 =~ e2, e1 !~ e2
 			// O ⊢ e1 : String , O ⊢ e2 : Pattern
@@ -590,8 +812,28 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 			// If the expr is assignable, mark it as an lvalue, otherwise error.
 			switch v := n.Expr.(type) {
 			case *ast.IDTerm:
+				if v.Symbol != nil && v.Symbol.Kind == symbol.ParamSymbol {
+					c.errors.Add(v.Pos(), fmt.Sprintf("Can't assign to parameter `%s'.", v.Name))
+					n.SetType(types.Error)
+					return n
+				}
 				v.Lvalue = true
 			case *ast.IndexedExpr:
+				if isMapConst(v.LHS) {
+					c.errors.Add(n.Expr.Pos(), "Can't assign to expression; expecting a variable here.")
+					n.SetType(types.Error)
+					return n
+				}
+				if isMapVar(v.LHS) {
+					name := v.LHS.(*ast.IDTerm).Name
+					verb, op := "increment", "+"
+					if n.Op == parser.DEC {
+						verb, op = "decrement", "-"
+					}
+					c.errors.Add(n.Pos(), fmt.Sprintf("Can't %s an element of map `%s'.\n\tTry `%s[k] = lookup(%s, k, 0) %s 1' instead.", verb, name, name, name, op))
+					n.SetType(types.Error)
+					return n
+				}
 				v.LHS.(*ast.IDTerm).Lvalue = true
 			default:
 				glog.V(2).Infof("the expr is a %T %v", n.Expr, n.Expr)
@@ -686,6 +928,10 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 			}
 			argTypes = append(argTypes, arg.Type())
 		}
+		if c.rejectMap(n.Pos(), exprList.Children...) {
+			n.SetType(types.Error)
+			return n
+		}
 
 		switch v := n.LHS.(type) {
 		case *ast.IDTerm:
@@ -696,6 +942,38 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 				return n
 			}
 
+			if v.Symbol.Kind == symbol.ParamSymbol {
+				if len(argTypes) > 0 {
+					c.errors.Add(n.Pos(), fmt.Sprintf("Index taken on parameter `%s'.", v.Name))
+					n.SetType(types.Error)
+					return n
+				}
+				return v
+			}
+
+			if v.Symbol.Kind == symbol.MapSymbol {
+				return c.checkMapIndex(n, v)
+			}
+
+			if v.Symbol.Kind == symbol.ConstSymbol {
+				if _, ok := v.Symbol.Binding.(*ast.ConstDecl).Value.(*ast.MapLit); ok {
+					return c.checkMapIndex(n, v)
+				}
+				if len(argTypes) > 0 {
+					c.errors.Add(n.Pos(), fmt.Sprintf("Index taken on constant `%s'.", v.Name))
+					n.SetType(types.Error)
+					return n
+				}
+				if types.Equals(types.Duration, v.Type()) {
+					c.errors.Add(v.Pos(), fmt.Sprintf("Can't use duration constant `%s' in an expression.\n\tDuration constants can only be used in `del ... after'.", v.Name))
+					n.SetType(types.Error)
+					return n
+				}
+				// Replace the constant with a copy of its value, so that it
+				// can be checked and folded like a literal in this place.
+				return constValue(v.Symbol.Binding.(*ast.ConstDecl).Value, v.P)
+			}
+
 			if types.Equals(types.Pattern, v.Type()) {
 				// We now have enough information to tell that something the
 				// parser thought was an IDTerm is really a pattern constant,
@@ -774,6 +1052,27 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 				argTypes = append(argTypes, arg.Type())
 			}
 		}
+		if args, ok := n.Args.(*ast.ExprList); ok {
+			// Only lookup takes a map, as its first argument.
+			maybeMaps := args.Children
+			if n.Name == "lookup" && len(maybeMaps) > 0 {
+				maybeMaps = maybeMaps[1:]
+			}
+			if c.rejectMap(n.Pos(), maybeMaps...) {
+				n.SetType(types.Error)
+				return n
+			}
+		}
+		switch n.Name {
+		case "sprintf":
+			return c.checkSprintf(n)
+		case "split":
+			if len(argTypes) == 2 {
+				c.errors.Add(n.Pos(), "call to `split': the result must be indexed.\n\tTry split(s, sep)[i] to select a field.")
+				n.SetType(types.Error)
+				return n
+			}
+		}
 		rType := types.NewVariable()
 		argTypes = append(argTypes, rType)
 
@@ -821,13 +1120,145 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 				return n
 			}
 
-		case "tolower":
+		case "lookup":
+			args := n.Args.(*ast.ExprList).Children
+			if !isMap(args[0]) {
+				c.errors.Add(args[0].Pos(), fmt.Sprintf("Expecting a map for argument 1 of lookup(), not %v.", gotType.Args[0]))
+				n.SetType(types.Error)
+				return n
+			}
+			// The default must have the type of the map's values.
+			vType := args[0].Type().(*types.Operator).Args[1]
+			def, ok := coerce(args[2], vType)
+			if !ok {
+				c.errors.Add(args[2].Pos(), fmt.Sprintf("Expecting a default of type %v for argument 3 of lookup(), not %v.", vType, args[2].Type()))
+				n.SetType(types.Error)
+				return n
+			}
+			args[2] = def
+			n.SetType(vType)
+
+		case "abs", "min", "max", "clamp", "floor", "ceil", "round":
+			// Choose the Int overload if every argument is an Int, otherwise
+			// promote the arguments to Float.
+			t := gotType.Args[0]
+			for _, arg := range gotType.Args[1 : len(gotType.Args)-1] {
+				t = types.LeastUpperBound(t, arg)
+			}
+			if !types.Equals(types.Unify(types.Numeric, t), types.Int) {
+				t = types.Float
+			}
+			convertArgs(n, t)
+			n.SetType(t)
+
+		case "log", "log10", "exp", "sqrt":
+			convertArgs(n, types.Float)
+
+		case "settime":
+			// Integer seconds are used as is, other times are converted to
+			// Float seconds to keep any fraction of a second.
+			if !types.Equals(types.Unify(types.Numeric, gotType.Args[0]), types.Int) {
+				convertArgs(n, types.Float)
+			}
+
+		case "strftime", "hour", "weekday", "dayofyear":
+			// The timestamp is the last argument, and is converted to Float
+			// seconds so that fractions of a second can be formatted.
+			args := n.Args.(*ast.ExprList).Children
+			ts := args[len(args)-1]
+			if !types.Equals(ts.Type(), types.Float) {
+				conv := &ast.ConvExpr{N: ts}
+				conv.SetType(types.Float)
+				args[len(args)-1] = conv
+			}
+
+		case "incidr":
+			if cidr, ok := n.Args.(*ast.ExprList).Children[1].(*ast.StringLit); ok {
+				if _, err := code.ParsePrefix(cidr.Text); err != nil {
+					c.errors.Add(cidr.Pos(), fmt.Sprintf("Invalid CIDR %q for argument 2 of incidr().\n\tExpecting a network like \"10.0.0.0/8\" or \"2001:db8::/32\".", cidr.Text))
+					n.SetType(types.Error)
+					return n
+				}
+			}
+
+		case "ipnet":
+			if bits, ok := n.Args.(*ast.ExprList).Children[1].(*ast.IntLit); ok && (bits.I < 0 || bits.I > 128) {
+				c.errors.Add(bits.Pos(), fmt.Sprintf("Prefix length %d for argument 2 of ipnet() is out of range.", bits.I))
+				n.SetType(types.Error)
+				return n
+			}
+
+		case "tolower", "toupper", "trim":
 			if !types.Equals(gotType.Args[0], types.String) {
-				c.errors.Add(n.Args.(*ast.ExprList).Children[0].Pos(), fmt.Sprintf("Expecting a String for argument 1 of tolower(), not %v.", gotType.Args[0]))
+				c.errors.Add(n.Args.(*ast.ExprList).Children[0].Pos(), fmt.Sprintf("Expecting a String for argument 1 of %s(), not %v.", n.Name, gotType.Args[0]))
+				n.SetType(types.Error)
+				return n
+			}
+		}
+		return n
+
+	case *ast.CallExpr:
+		// f(e1, e2, ..., en)
+		// O ⊢ f : T1⨯T2⨯...Tn⨯Tr
+		// O ⊢ e1,e2,...,en : T1,T2,...,Tn
+		// ⇒ O ⊢ e : Tr
+		sym := c.scope.Lookup(n.Name, symbol.FuncSymbol)
+		if sym == nil {
+			c.errors.Add(n.Pos(), fmt.Sprintf("Function `%s' is not defined.\n\tTry adding a definition `func %s(...) ... {}' earlier in the program.", n.Name, n.Name))
+			n.SetType(types.Error)
+			return n
+		}
+		sym.Used = true
+		n.Decl = sym.Binding.(*ast.FuncDecl)
+		fType, ok := sym.Type.(*types.Operator)
+		if !ok || !types.IsFunction(fType) {
+			// The declaration had errors, already reported.
+			n.SetType(types.Error)
+			return n
+		}
+		var args []ast.Node
+		if l, ok := n.Args.(*ast.ExprList); ok {
+			args = l.Children
+		}
+		if len(args) != len(n.Decl.Params) {
+			c.errors.Add(n.Pos(), fmt.Sprintf("call to `%s': expecting %d arguments, received %d.", n.Name, len(n.Decl.Params), len(args)))
+			n.SetType(types.Error)
+			return n
+		}
+		for i, arg := range args {
+			if types.IsTypeError(arg.Type()) {
+				n.SetType(arg.Type())
+				return n
+			}
+			conv, ok := coerce(arg, fType.Args[i])
+			if !ok {
+				c.errors.Add(arg.Pos(), fmt.Sprintf("call to `%s': type mismatch; expected %s received %s for argument %d.", n.Name, fType.Args[i], arg.Type(), i+1))
 				n.SetType(types.Error)
 				return n
 			}
+			args[i] = conv
 		}
+		n.SetType(fType.Args[len(fType.Args)-1])
+		return n
+
+	case *ast.ReturnStmt:
+		last := len(c.funcs) - 1
+		if last < 0 {
+			c.errors.Add(n.Pos(), "Can't use `return' outside of a function.")
+			return n
+		}
+		if types.IsTypeError(n.Expr.Type()) {
+			return n
+		}
+		f := c.funcs[last]
+		fType := f.Symbol.Type.(*types.Operator)
+		rType := fType.Args[len(fType.Args)-1]
+		conv, ok := coerce(n.Expr, rType)
+		if !ok {
+			c.errors.Add(n.Expr.Pos(), fmt.Sprintf("Can't return %s from function `%s'; expecting %s.", n.Expr.Type(), f.Name, rType))
+			return n
+		}
+		n.Expr = conv
 		return n
 
 	case *ast.PatternExpr:
@@ -853,6 +1284,16 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 
 	case *ast.DelStmt:
 		if ix, ok := n.N.(*ast.IndexedExpr); ok {
+			if isMapConst(ix.LHS) {
+				c.errors.Add(n.N.Pos(), fmt.Sprintf("Can't delete from map constant `%s'.", ix.LHS.(*ast.IDTerm).Name))
+				return n
+			}
+			if isMapVar(ix.LHS) {
+				if n.Expiry > 0 {
+					c.errors.Add(n.Pos(), fmt.Sprintf("Can't delete from map `%s' after a delay.\n\tOnly metrics expire.", ix.LHS.(*ast.IDTerm).Name))
+				}
+				return n
+			}
 			if len(ix.Index.(*ast.ExprList).Children) == 0 {
 				c.errors.Add(n.N.Pos(), "Cannot delete this.\n\tTry deleting an index from this dimensioned metric.")
 				return n
@@ -866,6 +1307,282 @@ func (c *checker) VisitAfter(node ast.Node) ast.Node {
 	return node
 }
 
+// checkMapLit sets the type of the map literal m, the value of the map
+// constant or initial contents of the map variable named name, from the types
+// of its values.  Integer keys are rewritten as strings, and integer values as
+// floats in a map that also has float values, so that the code generator can
+// take the literals as they are.
+func (c *checker) checkMapLit(name string, m *ast.MapLit) {
+	seen := make(map[string]bool, len(m.Keys))
+	for i, k := range m.Keys {
+		if l, ok := k.(*ast.IntLit); ok {
+			k = &ast.StringLit{P: l.P, Text: strconv.FormatInt(l.I, 10)}
+			m.Keys[i] = k
+		}
+		key := k.(*ast.StringLit).Text
+		if seen[key] {
+			c.errors.Add(k.Pos(), fmt.Sprintf("Duplicate key %q in map `%s'.", key, name))
+			m.SetType(types.Error)
+			return
+		}
+		seen[key] = true
+	}
+	vType := m.Values[0].Type()
+	for _, v := range m.Values[1:] {
+		t := v.Type()
+		switch {
+		case types.Equals(vType, t):
+		case types.Equals(vType, types.Int) && types.Equals(t, types.Float):
+			vType = types.Float
+		case types.Equals(vType, types.Float) && types.Equals(t, types.Int):
+		default:
+			c.errors.Add(v.Pos(), fmt.Sprintf("Map `%s' has values of type %v and %v.\n\tAll the values in a map must have the same type.", name, vType, t))
+			m.SetType(types.Error)
+			return
+		}
+	}
+	if types.Equals(vType, types.Float) {
+		for i, v := range m.Values {
+			if l, ok := v.(*ast.IntLit); ok {
+				m.Values[i] = &ast.FloatLit{P: l.P, F: float64(l.I)}
+			}
+		}
+	}
+	m.SetType(types.Map(types.String, vType))
+}
+
+// checkMapIndex checks the index expression n on the map constant or variable
+// named by id, returning id itself if the map is not indexed.
+func (c *checker) checkMapIndex(n *ast.IndexedExpr, id *ast.IDTerm) ast.Node {
+	if types.IsTypeError(id.Type()) {
+		n.SetType(id.Type())
+		return n
+	}
+	args := n.Index.(*ast.ExprList).Children
+	switch len(args) {
+	case 0:
+		return id
+	case 1:
+		key, ok := mapKey(args[0])
+		if !ok {
+			c.errors.Add(args[0].Pos(), fmt.Sprintf("Map `%s' is indexed by String, not %v.", id.Name, args[0].Type()))
+			n.SetType(types.Error)
+			return n
+		}
+		args[0] = key
+		n.SetType(id.Type().(*types.Operator).Args[1])
+		return n
+	}
+	c.errors.Add(n.Pos(), fmt.Sprintf("Map `%s' takes one index, not %d.", id.Name, len(args)))
+	n.SetType(types.Error)
+	return n
+}
+
+// isMapConst returns true if n names a map constant.
+func isMapConst(n ast.Node) bool {
+	id, ok := n.(*ast.IDTerm)
+	return ok && id.Symbol != nil && id.Symbol.Kind == symbol.ConstSymbol && types.IsMap(id.Type())
+}
+
+// isMapVar returns true if n names a map variable.
+func isMapVar(n ast.Node) bool {
+	id, ok := n.(*ast.IDTerm)
+	return ok && id.Symbol != nil && id.Symbol.Kind == symbol.MapSymbol
+}
+
+// isMap returns true if n names a map constant or variable.
+func isMap(n ast.Node) bool {
+	return isMapConst(n) || isMapVar(n)
+}
+
+// checkMapAssign checks the assignment n to the element ix of a map variable.
+// The value must have the type of the map's values, except that an integer
+// is promoted for a map of floats.
+func (c *checker) checkMapAssign(n *ast.BinaryExpr, ix *ast.IndexedExpr) ast.Node {
+	name := ix.LHS.(*ast.IDTerm).Name
+	if n.Op != parser.ASSIGN {
+		c.errors.Add(n.Pos(), fmt.Sprintf("Can't add to an element of map `%s'.\n\tTry `%s[k] = lookup(%s, k, 0) + ...' instead.", name, name, name))
+		n.SetType(types.Error)
+		return n
+	}
+	vType := ix.Type()
+	val, ok := coerce(n.RHS, vType)
+	if !ok {
+		c.errors.Add(n.RHS.Pos(), fmt.Sprintf("Can't assign %v to an element of map `%s' of %v.", n.RHS.Type(), name, vType))
+		n.SetType(types.Error)
+		return n
+	}
+	n.RHS = val
+	n.SetType(vType)
+	return n
+}
+
+// mapKey returns the expression n, converted if necessary to the String key
+// of a map.  Numbers are keys too, by their decimal string.  It returns false
+// if n can't be a key.
+func mapKey(n ast.Node) (ast.Node, bool) {
+	got := n.Type()
+	if _, ok := got.Root().(*types.Variable); ok {
+		return n, !types.IsTypeError(types.Unify(types.String, got))
+	}
+	switch {
+	case types.Equals(got, types.String):
+		return n, true
+	case types.Equals(got, types.Int), types.Equals(got, types.Float):
+		conv := &ast.ConvExpr{N: n}
+		conv.SetType(types.String)
+		return conv, true
+	}
+	return n, false
+}
+
+// rejectMap adds an error at pos and returns true if any of nodes is a map,
+// which has no value of its own.
+func (c *checker) rejectMap(pos *position.Position, nodes ...ast.Node) bool {
+	for _, n := range nodes {
+		if isMap(n) {
+			c.errors.Add(pos, "Can't use a map here.\n\tMaps can only be indexed, tested with `in', or given to lookup().")
+			return true
+		}
+	}
+	return false
+}
+
+// convertArgs promotes each argument of n that is not of type t to t.
+func convertArgs(n *ast.BuiltinExpr, t types.Type) {
+	args := n.Args.(*ast.ExprList)
+	for i, arg := range args.Children {
+		if !types.Equals(arg.Type(), t) {
+			conv := &ast.ConvExpr{N: arg}
+			conv.SetType(t)
+			args.Children[i] = conv
+		}
+	}
+}
+
+// checkSprintf checks a call to sprintf, which takes a format string and any
+// number of arguments to format.  If the format is a string literal, the
+// number of arguments is checked against the verbs in the format.
+func (c *checker) checkSprintf(n *ast.BuiltinExpr) ast.Node {
+	args, ok := n.Args.(*ast.ExprList)
+	if !ok || len(args.Children) == 0 {
+		c.errors.Add(n.Pos(), "call to `sprintf': expecting a format string.")
+		n.SetType(types.Error)
+		return n
+	}
+	for i, arg := range args.Children {
+		t := arg.Type()
+		var err *types.TypeError
+		if types.AsTypeError(t, &err) {
+			n.SetType(err)
+			return n
+		}
+		if i == 0 {
+			if !types.Equals(t.Root(), types.String) {
+				c.errors.Add(arg.Pos(), fmt.Sprintf("Expecting a format string for argument 1 of sprintf(), not %v.", t))
+				n.SetType(types.Error)
+				return n
+			}
+			continue
+		}
+		if types.Equals(t, types.Pattern) || types.Equals(t, types.None) {
+			c.errors.Add(arg.Pos(), fmt.Sprintf("Can't format a value of type %v in argument %d of sprintf().", t, i+1))
+			n.SetType(types.Error)
+			return n
+		}
+	}
+	if f, ok := args.Children[0].(*ast.StringLit); ok {
+		if want := countVerbs(f.Text); want >= 0 && want != len(args.Children)-1 {
+			c.errors.Add(n.Pos(), fmt.Sprintf("call to `sprintf': format %q expects %d arguments, received %d.", f.Text, want, len(args.Children)-1))
+			n.SetType(types.Error)
+			return n
+		}
+	}
+	n.SetType(types.String)
+	return n
+}
+
+// countVerbs returns the number of arguments consumed by the fmt-style format
+// string format, including those consumed by `*' widths and precisions.  It
+// returns -1 if the format uses explicit argument indexes.
+func countVerbs(format string) int {
+	count := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		if i < len(format) && format[i] == '%' {
+			continue
+		}
+		// Skip flags, width and precision up to the verb.
+		for ; i < len(format) && strings.IndexByte("+-# 0123456789.*[", format[i]) >= 0; i++ {
+			switch format[i] {
+			case '*':
+				count++
+			case '[':
+				return -1
+			}
+		}
+		count++
+	}
+	return count
+}
+
+// lookupConst returns the value of the constant named name, for use by the
+// clause described by use.  If the constant is not declared or is not of type
+// want, an error is added at pos and nil returned.
+func (c *checker) lookupConst(pos *position.Position, name string, want types.Type, use string) ast.Node {
+	sym := c.scope.Lookup(name, symbol.ConstSymbol)
+	if sym == nil {
+		c.errors.Add(pos, fmt.Sprintf("Constant `%s' not declared.\n\tTry adding `const %s' earlier in the program.", name, name))
+		return nil
+	}
+	sym.Used = true
+	if !types.Equals(want, sym.Type) {
+		c.errors.Add(pos, fmt.Sprintf("Constant `%s' has type %s, but %s expects %s.", name, sym.Type, use, want))
+		return nil
+	}
+	return sym.Binding.(*ast.ConstDecl).Value
+}
+
+// constValue returns a copy of the literal value of a constant, positioned
+// where the constant is used.
+func constValue(v ast.Node, pos position.Position) ast.Node {
+	switch l := v.(type) {
+	case *ast.StringLit:
+		return &ast.StringLit{P: pos, Text: l.Text}
+	case *ast.IntLit:
+		return &ast.IntLit{P: pos, I: l.I}
+	case *ast.FloatLit:
+		return &ast.FloatLit{P: pos, F: l.F}
+	case *ast.DurationLit:
+		return &ast.DurationLit{P: pos, D: l.D}
+	}
+	return v
+}
+
+// coerce returns the expression n, converted if necessary to the type want of
+// a function parameter or result.  The types are unified as for a builtin, but
+// the result must be want, and the only implicit conversion is the promotion
+// of an Int to a Float.  It returns false if n can't be given the type want.
+func coerce(n ast.Node, want types.Type) (ast.Node, bool) {
+	got := n.Type()
+	t := types.Unify(want, got)
+	if types.IsTypeError(t) || !types.Equals(t, want) {
+		return n, false
+	}
+	if _, ok := got.Root().(*types.Variable); ok || types.Equals(got, want) {
+		return n, true
+	}
+	if types.Equals(got, types.Int) && types.Equals(want, types.Float) {
+		conv := &ast.ConvExpr{N: n}
+		conv.SetType(want)
+		return conv, true
+	}
+	return n, false
+}
+
 // checkRegex is a helper method to compile and check a regular expression, and
 // to generate its capture groups as symbols.
 func (c *checker) checkRegex(pattern string, n ast.Node) {
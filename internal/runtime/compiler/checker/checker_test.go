@@ -37,6 +37,18 @@ var checkerInvalidPrograms = []struct {
 			"visible to this scope.", "\tCheck that there are at least 2 pairs of parentheses."},
 	},
 
+	{
+		"unknown pragma",
+		"pragma parallel\n",
+		[]string{"unknown pragma:1:8-15: Unknown pragma `parallel'."},
+	},
+
+	{
+		"nested pragma",
+		"/foo/ {\n  pragma serial\n}\n",
+		[]string{"nested pragma:2:10-15: Can't use pragma `serial' here.", "\tPragmas are only allowed at the top level of a program."},
+	},
+
 	{
 		"undefined decorator",
 		"@foo {}\n",
@@ -312,6 +324,97 @@ l++=l
 		[]string{"tolower non string:1:9: Expecting a String for argument 1 of tolower(), not Int."},
 	},
 
+	{
+		"toupper non string",
+		`toupper(2)
+`,
+		[]string{"toupper non string:1:9: Expecting a String for argument 1 of toupper(), not Int."},
+	},
+
+	{
+		"abs of string",
+		`abs("a")
+`,
+		[]string{"abs of string:1:1-8: call to `abs': type mismatch; expected Int|Float received String"},
+	},
+
+	{
+		"log of pattern",
+		`log(/a/)
+`,
+		[]string{"log of pattern:1:5-8: call to `log': type mismatch; expected Int|Float received Pattern"},
+	},
+
+	{
+		"hour of string",
+		`hour("noon")
+`,
+		[]string{"hour of string:1:1-12: call to `hour': type mismatch; expected Int|Float received String"},
+	},
+
+	{
+		"settime of string",
+		`settime("noon")
+`,
+		[]string{"settime of string:1:1-15: call to `settime': type mismatch; expected Int|Float received String"},
+	},
+
+	{
+		"incidr invalid cidr",
+		`incidr("10.1.2.3", "10.0.0.0/33")
+`,
+		[]string{"incidr invalid cidr:1:20-32: Invalid CIDR \"10.0.0.0/33\" for argument 2 of incidr().", "\tExpecting a network like \"10.0.0.0/8\" or \"2001:db8::/32\"."},
+	},
+
+	{
+		"ipnet prefix out of range",
+		`ipnet("10.1.2.3", 129)
+`,
+		[]string{"ipnet prefix out of range:1:19-21: Prefix length 129 for argument 2 of ipnet() is out of range."},
+	},
+
+	{
+		"split not indexed",
+		`split("a:b", ":")
+`,
+		[]string{"split not indexed:1:1-17: call to `split': the result must be indexed.", "\tTry split(s, sep)[i] to select a field."},
+	},
+
+	{
+		"sprintf no format",
+		`sprintf()
+`,
+		[]string{"sprintf no format:1:1-9: call to `sprintf': expecting a format string."},
+	},
+
+	{
+		"sprintf format not string",
+		`sprintf(1, 2)
+`,
+		[]string{"sprintf format not string:1:9: Expecting a format string for argument 1 of sprintf(), not Int."},
+	},
+
+	{
+		"sprintf pattern arg",
+		`sprintf("%v", /foo/)
+`,
+		[]string{"sprintf pattern arg:1:15-19: Can't format a value of type Pattern in argument 2 of sprintf()."},
+	},
+
+	{
+		"sprintf argument count",
+		`sprintf("%s %d", "a")
+`,
+		[]string{"sprintf argument count:1:1-21: call to `sprintf': format \"%s %d\" expects 2 arguments, received 1."},
+	},
+
+	{
+		"hasprefix wrong arity",
+		`hasprefix("a")
+`,
+		[]string{"hasprefix wrong arity:1:1-14: call to `hasprefix': type mismatch; expected String→String→Bool received incomplete type"},
+	},
+
 	{
 		"dec non var",
 		`strptime("", "")--
@@ -338,6 +441,222 @@ l++=l
 		[]string{"negate None:1:2-17: type mismatch; expected Int received None for `~' operator."},
 	},
 
+	{
+		"unused constant",
+		"const N 1\n",
+		[]string{"unused constant:1:7: Declaration of constant `N' here is never used."},
+	},
+
+	{
+		"redefined constant",
+		"const N 1\nconst N \"one\"\ncounter a\na = N\n",
+		[]string{"redefined constant:2:7: Redefinition of constant `N' previously defined at redefined constant:1:7"},
+	},
+
+	{
+		"assign to constant",
+		"const N 1\nN = 2\n",
+		[]string{"assign to constant:2:1: Can't assign to expression on left; expecting a variable here."},
+	},
+
+	{
+		"indexed constant",
+		"const N 1\ncounter a\na = N[0]\n",
+		[]string{"indexed constant:3:5-7: Index taken on constant `N'."},
+	},
+
+	{
+		"duration constant in expression",
+		"const D 1h\ncounter a\na = D\n",
+		[]string{"duration constant in expression:3:5: Can't use duration constant `D' in an expression.", "\tDuration constants can only be used in `del ... after'."},
+	},
+
+	{
+		"limit of wrong type",
+		"const MAX 1.5\ncounter a by b limit MAX\na[\"x\"]++\n",
+		[]string{"limit of wrong type:2:9: Constant `MAX' has type Float, but `limit' expects Int."},
+	},
+
+	{
+		"undefined expiry",
+		"counter a by b\ndel a[\"x\"] after EXPIRY\n",
+		[]string{"undefined expiry:2:1-23: Constant `EXPIRY' not declared.", "\tTry adding `const EXPIRY' earlier in the program."},
+	},
+
+	{
+		"invalid strptime format constant",
+		"const FORMAT \"2017-10-16 06:50:25\"\n/(.*)/ {\n  strptime($1, FORMAT)\n}\n",
+		[]string{"invalid strptime format constant:3:16-21: invalid time format string \"2017-10-16 06:50:25\"", "\tRefer to the documentation at https://golang.org/pkg/time/#pkg-constants for advice."},
+	},
+
+	{
+		"undefined function",
+		"counter a\na = f(1)\n",
+		[]string{"undefined function:2:5-8: Function `f' is not defined.", "\tTry adding a definition `func f(...) ... {}' earlier in the program."},
+	},
+
+	{
+		"function wrong argument count",
+		"func f(x int) int {\n  return x\n}\ncounter a\na = f(1, 2)\n",
+		[]string{"function wrong argument count:5:5-11: call to `f': expecting 1 arguments, received 2."},
+	},
+
+	{
+		"function argument type mismatch",
+		"func f(x int) int {\n  return x\n}\ncounter a\na = f(\"one\")\n",
+		[]string{"function argument type mismatch:5:7-11: call to `f': type mismatch; expected Int received String for argument 1."},
+	},
+
+	{
+		"function result type mismatch",
+		"func f(x string) int {\n  return x\n}\ncounter a\na = f(\"one\")\n",
+		[]string{"function result type mismatch:2:10: Can't return String from function `f'; expecting Int."},
+	},
+
+	{
+		"function argument not promoted to string",
+		"func f(s string) string {\n  return s\n}\ntext a\na = f(1)\n",
+		[]string{"function argument not promoted to string:5:7: call to `f': type mismatch; expected String received Int for argument 1."},
+	},
+
+	{
+		"function argument not demoted to int",
+		"func f(x int) int {\n  return x\n}\ncounter a\na = f(1.5)\n",
+		[]string{"function argument not demoted to int:5:7-9: call to `f': type mismatch; expected Int received Float for argument 1."},
+	},
+
+	{
+		"function result not promoted to string",
+		"func f(x int) string {\n  return x\n}\ntext a\na = f(1)\n",
+		[]string{"function result not promoted to string:2:10: Can't return Int from function `f'; expecting String."},
+	},
+
+	{
+		"return outside function",
+		"/foo/ {\n  return 1\n}\n",
+		[]string{"return outside function:2:3-8: Can't use `return' outside of a function."},
+	},
+
+	{
+		"function not at top level",
+		"/foo/ {\n  func f() int {\n    return 1\n  }\n}\n",
+		[]string{"function not at top level:2:8: Can't declare function `f' here.", "\tFunctions are only allowed at the top level of a program."},
+	},
+
+	{
+		"assign to parameter",
+		"func f(x int) int {\n  x = 2\n  return x\n}\ncounter a\na = f(1)\n",
+		[]string{"assign to parameter:2:3: Can't assign to parameter `x'."},
+	},
+
+	{
+		"unknown parameter type",
+		"func f(x num) int {\n  return 1\n}\ncounter a\na = f(1)\n",
+		[]string{"unknown parameter type:1:8: Unknown type `num' for parameter `x'.", "\tTry one of bool, float, int, or string."},
+	},
+
+	{
+		"unused parameter",
+		"func f(x int) int {\n  return 1\n}\ncounter a\na = f(1)\n",
+		[]string{"unused parameter:1:8: Declaration of parameter `x' here is never used."},
+	},
+
+	{
+		"duplicate map key",
+		"const M {200: \"ok\", \"200\": \"OK\"}\ncounter a by b\na[M[\"200\"]]++\n",
+		[]string{"duplicate map key:1:21-25: Duplicate key \"200\" in map `M'."},
+	},
+
+	{
+		"mixed map values",
+		"const M {\"a\": 1, \"b\": \"two\"}\ncounter a by b\na[M[\"a\"]]++\n",
+		[]string{"mixed map values:1:23-27: Map `M' has values of type Int and String.", "\tAll the values in a map must have the same type."},
+	},
+
+	{
+		"assign to map",
+		"const M {\"a\": 1}\nM[\"a\"] = 2\n",
+		[]string{"assign to map:2:1-5: Can't assign to expression on left; expecting a variable here."},
+	},
+
+	{
+		"map as value",
+		"const M {\"a\": 1}\ngauge g\ng = M\n",
+		[]string{"map as value:3:1-5: Can't use a map here.", "\tMaps can only be indexed, tested with `in', or given to lookup()."},
+	},
+
+	{
+		"map with two indexes",
+		"const M {\"a\": 1}\ngauge g\ng = M[\"a\", \"b\"]\n",
+		[]string{"map with two indexes:3:5-14: Map `M' takes one index, not 2."},
+	},
+
+	{
+		"membership in non-map",
+		"const N 1\n\"a\" in N {\n}\n",
+		[]string{"membership in non-map:2:8: Can't test membership in Int.", "\tTry a map on the right of `in'."},
+	},
+
+	{
+		"delete from map",
+		"const M {\"a\": 1}\ndel M[\"a\"]\n",
+		[]string{"delete from map:2:5-9: Can't delete from map constant `M'."},
+	},
+
+	{
+		"lookup default of wrong type",
+		"const M {\"a\": 1}\ngauge g\ng = lookup(M, \"a\", \"none\")\n",
+		[]string{"lookup default of wrong type:3:20-25: Expecting a default of type Int for argument 3 of lookup(), not String."},
+	},
+
+	{
+		"lookup default not converted to string",
+		"const M {\"a\": \"x\"}\ntext t\nt = lookup(M, \"a\", 1)\n",
+		[]string{"lookup default not converted to string:3:20: Expecting a default of type String for argument 3 of lookup(), not Int."},
+	},
+
+	{
+		"map key of wrong type",
+		"const M {\"a\": 1}\ngauge g\ng = M[1 < 2]\n",
+		[]string{"map key of wrong type:3:7-11: Map `M' is indexed by String, not Bool."},
+	},
+
+	{
+		"map declared in a block",
+		"/a/ {\n  hidden map m\n}\n",
+		[]string{"map declared in a block:2:14: Can't declare map `m' here.", "\tMaps are only allowed at the top level of a program."},
+	},
+
+	{
+		"unused map",
+		"hidden map m\n",
+		[]string{"unused map:1:12: Declaration of map `m' here is never used."},
+	},
+
+	{
+		"add to map element",
+		"hidden map m\n/(.*)/ {\n  m[$1] += 1\n}\n",
+		[]string{"add to map element:3:3-12: Can't add to an element of map `m'.", "\tTry `m[k] = lookup(m, k, 0) + ...' instead."},
+	},
+
+	{
+		"increment map element",
+		"hidden map m\n/(.*)/ {\n  m[$1]++\n}\n",
+		[]string{"increment map element:3:3-9: Can't increment an element of map `m'.", "\tTry `m[k] = lookup(m, k, 0) + 1' instead."},
+	},
+
+	{
+		"map element of wrong type",
+		"hidden map m {\"a\": 1}\n/(.*)/ {\n  m[$1] = \"x\"\n}\n",
+		[]string{"map element of wrong type:3:11-13: Can't assign String to an element of map `m' of Int."},
+	},
+
+	{
+		"delete from map after a delay",
+		"hidden map m\n/(.*)/ {\n  m[$1] = 1\n  del m[$1] after 1h\n}\n",
+		[]string{"delete from map after a delay:4:3-20: Can't delete from map `m' after a delay.", "\tOnly metrics expire."},
+	},
+
 	// 	{"match against gauge",
 	// 		`gauge t
 	// t = 6 =~ t
@@ -377,6 +696,93 @@ var checkerValidPrograms = []struct {
 	name    string
 	program string
 }{
+	{
+		"math builtins",
+		`gauge ratio
+counter bytes
+/(\d+) (\d+\.\d+)/ {
+  ratio = clamp(round($2 * 100) / max($1, 1), 0, 1)
+  bytes += abs($1 - 10) + int(floor(sqrt($2)) + ceil(log10($2)) + log(exp($1)))
+  ratio = min(ratio, 0.5)
+}
+`,
+	},
+	{
+		"unit builtins",
+		`gauge latency
+counter size_total
+/(?P<latency>\S+) (?P<size>\S+)/ {
+  latency = duration($latency) * 1000
+  size_total += bytes($size)
+}
+`,
+	},
+	{
+		"network builtins",
+		`counter requests_total by network
+counter internal_total
+/(?P<peer>\S+) (?P<forwarded>\S+)/ {
+  isipv6($peer) {
+    requests_total[ipnet(clientip($peer, $forwarded), 48)]++
+  } else {
+    requests_total[ipnet(clientip($peer, $forwarded), 24)]++
+  }
+  incidr($peer, "10.0.0.0/8") || incidr($peer, $forwarded) {
+    internal_total++
+  }
+}
+`,
+	},
+	{
+		"incidr constant network with spaces",
+		`counter internal_total
+/(?P<peer>\S+)/ {
+  incidr($peer, " 10.0.0.0/8 ") {
+    internal_total++
+  }
+}
+`,
+	},
+	{
+		"time builtins",
+		`counter requests_total by hour, weekday
+gauge latency
+text day
+/(?P<start>\S+) (?P<end>\S+)/ {
+  strptime($start, "15:04:05.000")
+  requests_total[hour(timestamp())][weekday(timestamp())]++
+  day = strftime("2006-01-02", now()) + " " + string(dayofyear(now()))
+  latency = $end - timestamp()
+}
+`,
+	},
+	{
+		"nanosecond timestamps",
+		`gauge start_ns
+gauge latency_ms
+/(?P<ts>\d+\.\d+) start/ {
+  settime($ts)
+  start_ns = timestamp_ns()
+}
+/end/ {
+  settime(float(timestamp_ns()) / 1e9)
+  latency_ms = (timestamp_ns() - start_ns) / 1000000.0
+}
+`,
+	},
+	{
+		"string builtins",
+		`text user
+text host
+/(\S+) (\S+)/ {
+  user = toupper(trim($1))
+  host = split($2, ".")[0]
+  hasprefix($2, "www") && hassuffix($2, ".com") {
+    user = sprintf("%s@%s", substr($1, 0, index($1, ":")), host)
+  }
+}
+`,
+	},
 	{
 		"capture group",
 		`counter foo
@@ -577,6 +983,108 @@ foo = subst(",", "", $1)
 	{"regexp subst", `
 subst(/\d+/, "d", "1234")
 `},
+	{"typed constants", `
+const FORMAT "2006-01-02T15:04:05"
+const MAX_KEYS 100
+const SCALE 1000.0
+const EXPIRY 24h
+const PREFIX "request_"
+gauge latency by handler limit MAX_KEYS
+/(?P<date>\S+) (?P<handler>\S+) (?P<ms>\d+)/ {
+  strptime($date, FORMAT)
+  latency[PREFIX + $handler] = $ms / SCALE
+  del latency[$handler] after EXPIRY
+}`},
+	{"map constants", `
+const CLASS {
+  200: "ok",
+  404: "not found",
+  503: "unavailable",
+}
+const SCALE {"ms": 0.001, "s": 1}
+counter requests by class
+gauge latency
+/(?P<code>\d+) (?P<t>\d+)(?P<unit>\S+)/ {
+  $code in CLASS {
+    requests[CLASS[$code]]++
+  } else {
+    requests["other"]++
+  }
+  latency = $t * lookup(SCALE, $unit, 1)
+}`},
+	{"map variables", `
+hidden map host_dc
+hidden map scale {"ms": 0.001}
+counter requests by dc
+gauge latency
+/^dc (?P<host>\S+) (?P<dc>\S+)$/ {
+  host_dc[$host] = $dc
+}
+/^unplaced (?P<host>\S+)$/ {
+  del host_dc[$host]
+}
+/^(?P<host>\S+) (?P<t>\d+)(?P<unit>\S+)$/ {
+  $host in host_dc {
+    requests[host_dc[$host]]++
+  }
+  scale["s"] = 1
+  latency = $t * lookup(scale, $unit, 1)
+  requests[lookup(host_dc, $host, "unknown")]++
+}`},
+	{"functions", `
+func fact(n int) int {
+  n <= 1 {
+    return 1
+  }
+  return n * fact(n - 1)
+}
+func half(x float) float {
+  return x / 2
+}
+func is_error(code int) bool {
+  return code >= 500
+}
+counter errors
+gauge g
+/(?P<code>\d+)/ {
+  is_error($code) {
+    errors++
+  }
+  g = half(fact($code))
+}`},
+}
+
+// builtinNames are the builtins added after programs could already use their
+// names for metrics and variables.
+var builtinNames = []string{
+	"toupper", "trim", "substr", "split", "index", "sprintf", "hasprefix", "hassuffix",
+	"abs", "min", "max", "clamp", "floor", "ceil", "round", "log", "log10", "exp", "sqrt",
+	"duration", "bytes", "ipnet", "incidr", "isipv6", "clientip",
+	"strftime", "hour", "weekday", "dayofyear", "now", "timestamp_ns",
+}
+
+// builtinNameProgram uses NAME as a metric, label key and capture group, while
+// calling the max() builtin on a metric ending in its name.
+const builtinNameProgram = `counter NAME by NAME
+hidden gauge NAME_max
+/(?P<NAME>\d+)/ {
+  NAME[$NAME]++
+  NAME_max = max(NAME_max, $NAME)
+}
+`
+
+func TestCheckBuiltinNamesAsMetrics(t *testing.T) {
+	for _, name := range builtinNames {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			prog := strings.ReplaceAll(builtinNameProgram, "NAME", name)
+			ast, err := parser.Parse(name, strings.NewReader(prog))
+			testutil.FatalIfErr(t, err)
+			if _, err := checker.Check(ast, 0, 0); err != nil {
+				t.Errorf("check failed: %s", err)
+			}
+		})
+	}
 }
 
 func TestCheckValidPrograms(t *testing.T) {
@@ -630,6 +1138,39 @@ var checkerTypeExpressionTests = []struct {
 		},
 		types.Float,
 	},
+	{
+		"min(Int, Int) -> Int",
+		&ast.BuiltinExpr{
+			Name: "min",
+			Args: &ast.ExprList{Children: []ast.Node{
+				&ast.CaprefTerm{Symbol: &symbol.Symbol{Kind: symbol.CaprefSymbol, Type: types.Int}},
+				&ast.IntLit{I: 1},
+			}},
+		},
+		types.Int,
+	},
+	{
+		"clamp(Int, Float, Int) -> Float",
+		&ast.BuiltinExpr{
+			Name: "clamp",
+			Args: &ast.ExprList{Children: []ast.Node{
+				&ast.CaprefTerm{Symbol: &symbol.Symbol{Kind: symbol.CaprefSymbol, Type: types.Int}},
+				&ast.FloatLit{F: 0.5},
+				&ast.IntLit{I: 10},
+			}},
+		},
+		types.Float,
+	},
+	{
+		"sqrt(Int) -> Float",
+		&ast.BuiltinExpr{
+			Name: "sqrt",
+			Args: &ast.ExprList{Children: []ast.Node{
+				&ast.CaprefTerm{Symbol: &symbol.Symbol{Kind: symbol.CaprefSymbol, Type: types.Int}},
+			}},
+		},
+		types.Float,
+	},
 }
 
 func TestCheckTypeExpressions(t *testing.T) {
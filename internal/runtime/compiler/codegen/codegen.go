@@ -28,8 +28,9 @@ type codegen struct {
 	errors errors.ErrorList // Any compile errors detected are accumulated here.
 	obj    code.Object      // The object to return, if successful.
 
-	l     []int           // Label table for recording jump destinations.
-	decos []*ast.DecoStmt // Decorator stack to unwind when entering decorated blocks.
+	l     []int               // Label table for recording jump destinations.
+	decos []*ast.DecoStmt     // Decorator stack to unwind when entering decorated blocks.
+	maps  map[*ast.MapLit]int // Index in obj.Maps of each map constant used.
 }
 
 // CodeGen is the function that compiles the program to bytecode and data.
@@ -40,6 +41,14 @@ func CodeGen(name string, n ast.Node) (*code.Object, error) {
 	if len(c.errors) > 0 {
 		return nil, c.errors
 	}
+	// The register machine has no call frames, so programs with functions
+	// only run on the stack machine.
+	if len(c.obj.Functions) == 0 {
+		c.lowerRegisters()
+	}
+	if len(c.errors) > 0 {
+		return nil, c.errors
+	}
 	return &c.obj, nil
 }
 
@@ -51,6 +60,15 @@ func (c *codegen) errorf(pos *position.Position, format string, args ...interfac
 func (c *codegen) emit(n ast.Node, opcode code.Opcode, operand interface{}) {
 	glog.V(2).Infof("emitting `%s %v' from line %d node %#v\n", opcode, operand, n.Pos().Line, n)
 	c.obj.Program = append(c.obj.Program, code.Instr{opcode, operand, n.Pos().Line})
+	c.obj.Positions = append(c.obj.Positions, *n.Pos())
+}
+
+// branch records the kind of control flow implemented by the last instruction, a jump.
+func (c *codegen) branch(b code.Branch) {
+	if c.obj.Branches == nil {
+		c.obj.Branches = make(map[int]code.Branch)
+	}
+	c.obj.Branches[c.pc()] = b
 }
 
 // newLabel creates a new label to jump to.
@@ -181,6 +199,7 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		if n.Cond != nil {
 			n.Cond = ast.Walk(c, n.Cond)
 			c.emit(n, code.Jnm, lElse)
+			c.branch(code.CondBranch)
 		}
 		// Set matched flag false for children.
 		c.emit(n, code.Setmatched, false)
@@ -204,14 +223,47 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 			return nil, n
 		}
 		c.obj.Regexps = append(c.obj.Regexps, re)
+		c.obj.RegexpLiterals = append(c.obj.RegexpLiterals, requiredLiterals(n.Pattern))
 		// Store the location of this regular expression in the PatternExpr
 		n.Index = len(c.obj.Regexps) - 1
 		return nil, n
 
+	case *ast.BuiltinExpr:
+		// A constant network is parsed once, when the program is compiled.
+		// The checker has already reported any that don't parse.
+		if args, ok := n.Args.(*ast.ExprList); ok && n.Name == "incidr" && len(args.Children) == 2 {
+			if cidr, ok := args.Children[1].(*ast.StringLit); ok {
+				p, err := code.ParsePrefix(cidr.Text)
+				if err != nil {
+					c.errorf(cidr.Pos(), "internal error: %s", err)
+					return nil, n
+				}
+				args.Children[0] = ast.Walk(c, args.Children[0])
+				c.obj.Prefixes = append(c.obj.Prefixes, p)
+				c.emit(n, code.Incidr, len(c.obj.Prefixes)-1)
+				return nil, n
+			}
+		}
+
 	case *ast.PatternFragment:
 		// Skip, const pattern fragments are concatenated into PatternExpr storage, not executable.
 		return nil, n
 
+	case *ast.ConstDecl:
+		// Skip, the checker has replaced each use of the constant with its value.
+		return nil, n
+
+	case *ast.MapDecl:
+		// The table holds the map's initial contents, and each VM changes its own copy.
+		init := n.Init
+		if init == nil {
+			init = &ast.MapLit{}
+		}
+		n.Symbol.Addr = c.mapIndex(init)
+		// The contents are kept from one line to the next, so the program can't be replicated.
+		c.obj.Serial = true
+		return nil, n
+
 	case *ast.StringLit:
 		c.obj.Strings = append(c.obj.Strings, n.Text)
 		c.emit(n, code.Str, len(c.obj.Strings)-1)
@@ -225,7 +277,35 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 	case *ast.StopStmt:
 		c.emit(n, code.Stop, nil)
 
+	case *ast.ImportStmt:
+		c.obj.Imports = true
+
+	case *ast.PragmaStmt:
+		switch n.Name {
+		case "serial":
+			c.obj.Serial = true
+		case "persist_time":
+			// The time register only persists within one VM, so the program can't be replicated.
+			c.obj.PersistTime = true
+			c.obj.Serial = true
+		}
+
 	case *ast.IDTerm:
+		if n.Symbol != nil && n.Symbol.Kind == symbol.ParamSymbol {
+			c.emit(n, code.Lload, n.Symbol.Addr)
+			break
+		}
+		if n.Symbol != nil && n.Symbol.Kind == symbol.ConstSymbol {
+			// Only map constants are left in the AST by the checker.
+			if m, ok := n.Symbol.Binding.(*ast.ConstDecl).Value.(*ast.MapLit); ok {
+				c.emit(n, code.Push, c.mapIndex(m))
+			}
+			break
+		}
+		if n.Symbol != nil && n.Symbol.Kind == symbol.MapSymbol {
+			c.emit(n, code.Push, n.Symbol.Addr)
+			break
+		}
 		if n.Symbol == nil || n.Symbol.Kind != symbol.VarSymbol {
 			break
 		}
@@ -275,23 +355,31 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		}
 
 	case *ast.IndexedExpr:
-		if args, ok := n.Index.(*ast.ExprList); ok {
-			for _, arg := range args.Children {
-				_ = ast.Walk(c, arg)
-				if types.Equals(arg.Type(), types.Float) {
-					c.emit(n, code.F2s, nil)
-				} else if types.Equals(arg.Type(), types.Int) {
-					c.emit(n, code.I2s, nil)
-				}
-			}
+		c.emitIndex(n)
+		if types.IsMap(n.LHS.Type()) {
+			c.emit(n, code.Mapget, nil)
 		}
-		ast.Walk(c, n.LHS)
 		return nil, n
 
 	case *ast.DecoDecl:
 		// Do nothing, defs are inlined.
 		return nil, n
 
+	case *ast.FuncDecl:
+		// The function body is emitted in place, and jumped over when the
+		// program runs through the declaration.
+		lEnd := c.newLabel()
+		c.emit(n, code.Jmp, lEnd)
+		n.Symbol.Addr = len(c.obj.Functions)
+		c.obj.Functions = append(c.obj.Functions, code.Function{Name: n.Name, Entry: c.pc() + 1, Params: len(n.Params)})
+		ast.Walk(c, n.Block)
+		// Reaching the end of the body returns the zero value of the result type.
+		args := n.Symbol.Type.(*types.Operator).Args
+		c.emit(n, code.Push, zeroValue(args[len(args)-1]))
+		c.emit(n, code.Ret, nil)
+		c.setLabel(lEnd)
+		return nil, n
+
 	case *ast.DecoStmt:
 		// Put the current block on the stack
 		decoLen := len(c.decos)
@@ -329,6 +417,10 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 		if n.Expiry > 0 {
 			c.obj.Program[pc].Opcode = code.Expire
 		}
+		// or the mapget instruction, when deleting from a map variable.
+		if ix, ok := n.N.(*ast.IndexedExpr); ok && types.IsMap(ix.LHS.Type()) {
+			c.obj.Program[pc].Opcode = code.Mapdel
+		}
 
 	case *ast.BinaryExpr:
 		switch n.Op {
@@ -337,6 +429,7 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 			lEnd := c.newLabel()
 			ast.Walk(c, n.LHS)
 			c.emit(n, code.Jnm, lFalse)
+			c.branch(code.AndBranch)
 			ast.Walk(c, n.RHS)
 			c.emit(n, code.Jnm, lFalse)
 			c.emit(n, code.Push, true)
@@ -351,6 +444,7 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 			lEnd := c.newLabel()
 			ast.Walk(c, n.LHS)
 			c.emit(n, code.Jm, lTrue)
+			c.branch(code.OrBranch)
 			ast.Walk(c, n.RHS)
 			c.emit(n, code.Jm, lTrue)
 			c.emit(n, code.Push, false)
@@ -360,6 +454,16 @@ func (c *codegen) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
 			c.setLabel(lEnd)
 			return nil, n
 
+		case parser.ASSIGN:
+			// An element of a map variable is set by one instruction, rather than through a datum.
+			if ix, ok := n.LHS.(*ast.IndexedExpr); ok && types.IsMap(ix.LHS.Type()) {
+				c.emitIndex(ix)
+				ast.Walk(c, n.RHS)
+				c.emit(n, code.Mapset, nil)
+				return nil, n
+			}
+			return c, n
+
 		case parser.ADD_ASSIGN:
 			if !types.Equals(n.Type(), types.Int) {
 				// Double-emit the lhs so that it can be assigned to
@@ -425,12 +529,63 @@ func getOpcodeForType(op int, opT types.Type) (code.Opcode, error) {
 var builtin = map[string]code.Opcode{
 	"getfilename": code.Getfilename,
 	"len":         code.Length,
+	"lookup":      code.Maplookup,
 	"settime":     code.Settime,
 	"strptime":    code.Strptime,
 	"strtol":      code.S2i,
 	"subst":       code.Subst,
 	"timestamp":   code.Timestamp,
 	"tolower":     code.Tolower,
+	"toupper":     code.Toupper,
+	"trim":        code.Trim,
+	"substr":      code.Substr,
+	"split":       code.Split,
+	"index":       code.Index,
+	"hasprefix":   code.Hasprefix,
+	"hassuffix":   code.Hassuffix,
+	"sprintf":     code.Sprintf,
+	"duration":    code.Duration,
+	"bytes":       code.Bytes,
+	"ipnet":       code.Ipnet,
+	"incidr":      code.Incidr,
+	"isipv6":      code.Isipv6,
+	"clientip":    code.Clientip,
+	// Time builtins.
+	"strftime":     code.Strftime,
+	"hour":         code.Hour,
+	"weekday":      code.Weekday,
+	"dayofyear":    code.Dayofyear,
+	"now":          code.Now,
+	"timestamp_ns": code.TimestampNs,
+	// Math builtins.
+	"floor": code.Floor,
+	"ceil":  code.Ceil,
+	"round": code.Round,
+	"log":   code.Log,
+	"log10": code.Log10,
+	"exp":   code.Exp,
+	"sqrt":  code.Sqrt,
+}
+
+// typedBuiltins are the overloads of the numeric builtins, selected by the
+// type the checker gave the call.
+var typedBuiltins = map[string]map[types.Type]code.Opcode{
+	"abs": {
+		types.Int:   code.Iabs,
+		types.Float: code.Fabs,
+	},
+	"min": {
+		types.Int:   code.Imin,
+		types.Float: code.Fmin,
+	},
+	"max": {
+		types.Int:   code.Imax,
+		types.Float: code.Fmax,
+	},
+	"clamp": {
+		types.Int:   code.Iclamp,
+		types.Float: code.Fclamp,
+	},
 }
 
 func (c *codegen) VisitAfter(node ast.Node) ast.Node {
@@ -463,6 +618,25 @@ func (c *codegen) VisitAfter(node ast.Node) ast.Node {
 				c.emit(n, code.Subst, arglen)
 			}
 
+		case "abs", "min", "max", "clamp":
+			for t, opcode := range typedBuiltins[n.Name] {
+				if types.Equals(t, n.Type()) {
+					c.emit(n, opcode, arglen)
+					return n
+				}
+			}
+			c.errorf(n.Pos(), "no opcode for type %s in builtin %q", n.Type(), n.Name)
+
+		case "incidr":
+			// The network is on the stack, not in the object.
+			c.emit(n, code.Incidr, nil)
+
+		case "floor", "ceil", "round":
+			// Integers are already integral.
+			if !types.Equals(n.Type(), types.Int) {
+				c.emit(n, builtin[n.Name], arglen)
+			}
+
 		default:
 			c.emit(n, builtin[n.Name], arglen)
 		}
@@ -567,6 +741,9 @@ func (c *codegen) VisitAfter(node ast.Node) ast.Node {
 		case parser.SHR:
 			c.emit(n, code.Shr, nil)
 
+		case parser.IN:
+			c.emit(n, code.Mapin, nil)
+
 		case parser.MATCH, parser.NOT_MATCH:
 			switch v := n.RHS.(type) {
 			case *ast.PatternExpr:
@@ -585,6 +762,12 @@ func (c *codegen) VisitAfter(node ast.Node) ast.Node {
 			c.errorf(n.Pos(), "unexpected op %v", n.Op)
 		}
 
+	case *ast.CallExpr:
+		c.emit(n, code.Call, n.Decl.Symbol.Addr)
+
+	case *ast.ReturnStmt:
+		c.emit(n, code.Ret, nil)
+
 	case *ast.ConvExpr:
 		if err := c.emitConversion(n, n.N.Type(), n.Type()); err != nil {
 			c.errorf(n.Pos(), "internal error: %s on node %v", err.Error(), n)
@@ -617,6 +800,63 @@ func (c *codegen) emitConversion(n ast.Node, inType, outType types.Type) error {
 	return nil
 }
 
+// emitIndex emits the keys of the index expression n, converted to strings,
+// followed by the metric or map being indexed.
+func (c *codegen) emitIndex(n *ast.IndexedExpr) {
+	if args, ok := n.Index.(*ast.ExprList); ok {
+		for _, arg := range args.Children {
+			_ = ast.Walk(c, arg)
+			if types.Equals(arg.Type(), types.Float) {
+				c.emit(n, code.F2s, nil)
+			} else if types.Equals(arg.Type(), types.Int) {
+				c.emit(n, code.I2s, nil)
+			}
+		}
+	}
+	ast.Walk(c, n.LHS)
+}
+
+// mapIndex returns the index in the object of the table for the map literal
+// m, the value of a map constant or the initial contents of a map variable,
+// adding the table the first time the literal is used.
+func (c *codegen) mapIndex(m *ast.MapLit) int {
+	if i, ok := c.maps[m]; ok {
+		return i
+	}
+	table := make(map[string]interface{}, len(m.Keys))
+	for i, k := range m.Keys {
+		key := k.(*ast.StringLit).Text
+		switch v := m.Values[i].(type) {
+		case *ast.StringLit:
+			table[key] = v.Text
+		case *ast.IntLit:
+			table[key] = v.I
+		case *ast.FloatLit:
+			table[key] = v.F
+		}
+	}
+	if c.maps == nil {
+		c.maps = make(map[*ast.MapLit]int)
+	}
+	c.maps[m] = len(c.obj.Maps)
+	c.obj.Maps = append(c.obj.Maps, table)
+	return c.maps[m]
+}
+
+// zeroValue returns the value a function returns of type t if its body ends
+// without a return statement.
+func zeroValue(t types.Type) interface{} {
+	switch {
+	case types.Equals(types.Float, t):
+		return 0.0
+	case types.Equals(types.String, t):
+		return ""
+	case types.Equals(types.Bool, t):
+		return false
+	}
+	return int64(0)
+}
+
 func (c *codegen) writeJumps() {
 	for j, i := range c.obj.Program {
 		switch i.Opcode {
@@ -492,6 +492,172 @@ del a["string"] after 1h
 			{code.Expire, 1, 2},
 		},
 	},
+	{
+		"del after constant", `
+const EXPIRY 1h
+counter a by b
+del a["string"] after EXPIRY
+`,
+		[]code.Instr{
+			{code.Push, time.Hour, 3},
+			{code.Str, 0, 3},
+			{code.Mload, 0, 3},
+			{code.Expire, 1, 3},
+		},
+	},
+	{
+		"strptime with constant format", `
+const FORMAT "2006-01-02T15:04:05"
+/(.*)/ {
+  strptime($1, FORMAT)
+}
+`,
+		[]code.Instr{
+			{code.Match, 0, 2},
+			{code.Jnm, 8, 2},
+			{code.Setmatched, false, 2},
+			{code.Push, 0, 3},
+			{code.Capref, 1, 3},
+			{code.Str, 0, 3},
+			{code.Strptime, 2, 3},
+			{code.Setmatched, true, 2},
+		},
+	},
+	{
+		"function call", `
+func double(x int) int {
+  return x * 2
+}
+counter a
+a = double(3)
+`,
+		[]code.Instr{
+			{code.Jmp, 7, 1},
+			{code.Lload, 0, 2},
+			{code.Push, int64(2), 2},
+			{code.Imul, nil, 2},
+			{code.Ret, nil, 2},
+			{code.Push, int64(0), 1},
+			{code.Ret, nil, 1},
+			{code.Mload, 0, 5},
+			{code.Dload, 0, 5},
+			{code.Push, int64(3), 5},
+			{code.Call, 0, 5},
+			{code.Iset, nil, 5},
+		},
+	},
+	{
+		"map constant", `
+const CLASS {200: "ok"}
+counter a by class
+"200" in CLASS {
+  a[CLASS["200"]]++
+}
+`,
+		[]code.Instr{
+			{code.Str, 0, 3},
+			{code.Push, 0, 3},
+			{code.Mapin, nil, 3},
+			{code.Jnm, 12, 3},
+			{code.Setmatched, false, 3},
+			{code.Str, 1, 4},
+			{code.Push, 0, 4},
+			{code.Mapget, nil, 4},
+			{code.Mload, 0, 4},
+			{code.Dload, 1, 4},
+			{code.Inc, nil, 4},
+			{code.Setmatched, true, 3},
+		},
+	},
+	{
+		"map variable", `
+hidden map m {"a": 1}
+/(\S+)/ {
+  m[$1] = 2
+  del m[$1]
+}
+`,
+		[]code.Instr{
+			{code.Match, 0, 2},
+			{code.Jnm, 13, 2},
+			{code.Setmatched, false, 2},
+			{code.Push, 0, 3},
+			{code.Capref, 1, 3},
+			{code.Push, 0, 3},
+			{code.Push, int64(2), 3},
+			{code.Mapset, nil, 3},
+			{code.Push, 0, 4},
+			{code.Capref, 1, 4},
+			{code.Push, 0, 4},
+			{code.Mapdel, nil, 4},
+			{code.Setmatched, true, 2},
+		},
+	},
+	{
+		"numeric builtin overloads", `
+gauge i
+gauge f
+/(\d+)/ {
+  i = max($1, 2)
+  f = max($1, 0.5)
+  i = floor($1)
+}
+`,
+		[]code.Instr{
+			{code.Match, 0, 3},
+			{code.Jnm, 27, 3},
+			{code.Setmatched, false, 3},
+			{code.Mload, 0, 4},
+			{code.Dload, 0, 4},
+			{code.Push, 0, 4},
+			{code.Capref, 1, 4},
+			{code.S2i, nil, 4},
+			{code.Push, int64(2), 4},
+			{code.Imax, 2, 4},
+			{code.Iset, nil, 4},
+			{code.Mload, 1, 5},
+			{code.Dload, 0, 5},
+			{code.Push, 0, 5},
+			{code.Capref, 1, 5},
+			{code.S2i, nil, 5},
+			{code.I2f, nil, 5},
+			{code.Push, 0.5, 5},
+			{code.Fmax, 2, 5},
+			{code.Fset, nil, 5},
+			{code.Mload, 0, 6},
+			{code.Dload, 0, 6},
+			{code.Push, 0, 6},
+			{code.Capref, 1, 6},
+			{code.S2i, nil, 6},
+			{code.Iset, nil, 6},
+			{code.Setmatched, true, 3},
+		},
+	},
+	{
+		"constant cidr", `
+counter c
+/(\S+)/ {
+  incidr($1, "10.0.0.0/8") {
+    c++
+  }
+}
+`,
+		[]code.Instr{
+			{code.Match, 0, 2},
+			{code.Jnm, 13, 2},
+			{code.Setmatched, false, 2},
+			{code.Push, 0, 3},
+			{code.Capref, 1, 3},
+			{code.Incidr, 0, 3},
+			{code.Jnm, 12, 3},
+			{code.Setmatched, false, 3},
+			{code.Mload, 0, 4},
+			{code.Dload, 0, 4},
+			{code.Inc, nil, 4},
+			{code.Setmatched, true, 3},
+			{code.Setmatched, true, 2},
+		},
+	},
 	{
 		"types", `
 gauge i
@@ -956,6 +1122,19 @@ settime(int($1))
 		{code.Settime, 1, 2},
 		{code.Setmatched, true, 1},
 	}},
+	{"settime with fraction", `
+/(\d+\.\d+)/ {
+  settime($1)
+}`, []code.Instr{
+		{code.Match, 0, 1},
+		{code.Jnm, 8, 1},
+		{code.Setmatched, false, 1},
+		{code.Push, 0, 2},
+		{code.Capref, 1, 2},
+		{code.S2f, nil, 2},
+		{code.Settime, 1, 2},
+		{code.Setmatched, true, 1},
+	}},
 	{"stop", `
 stop
 `, []code.Instr{
@@ -0,0 +1,88 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package codegen
+
+import (
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// minLiteralLength is the shortest literal worth prefiltering on; single
+// bytes appear in almost every log line.
+const minLiteralLength = 2
+
+// requiredLiterals returns the literal substrings that must all appear in any
+// input matched by the regular expression pattern.  It may return fewer
+// literals than are really required, but never one that is not.
+func requiredLiterals(pattern string) []string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	var lits []string
+	seen := map[string]struct{}{}
+	for _, l := range literalsOf(re.Simplify()) {
+		if _, ok := seen[l]; ok || len(l) < minLiteralLength {
+			continue
+		}
+		seen[l] = struct{}{}
+		lits = append(lits, l)
+	}
+	return lits
+}
+
+// literalsOf walks the syntax tree of a regular expression collecting the
+// literals found along every path through it.
+func literalsOf(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if l, ok := literalString(re); ok {
+			return []string{l}
+		}
+	case syntax.OpCapture, syntax.OpPlus:
+		return literalsOf(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return literalsOf(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// Adjacent literals in a concatenation form one longer literal.
+		var lits []string
+		var run strings.Builder
+		flush := func() {
+			if run.Len() > 0 {
+				lits = append(lits, run.String())
+				run.Reset()
+			}
+		}
+		for _, sub := range re.Sub {
+			if l, ok := literalString(sub); ok {
+				run.WriteString(l)
+				continue
+			}
+			flush()
+			lits = append(lits, literalsOf(sub)...)
+		}
+		flush()
+		return lits
+	}
+	// Alternations, optional and empty-width expressions require nothing.
+	return nil
+}
+
+// literalString returns the text of a case-sensitive literal node.
+func literalString(re *syntax.Regexp) (string, bool) {
+	if re.Op != syntax.OpLiteral || re.Flags&syntax.FoldCase != 0 {
+		return "", false
+	}
+	for _, r := range re.Rune {
+		// The regexp engine matches invalid UTF-8 in the input as
+		// RuneError, which a byte search cannot find.
+		if r == utf8.RuneError {
+			return "", false
+		}
+	}
+	return string(re.Rune), true
+}
@@ -0,0 +1,36 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package codegen
+
+import (
+	"testing"
+
+	"github.com/google/mtail/internal/testutil"
+)
+
+var requiredLiteralsTests = []struct {
+	pattern string
+	want    []string
+}{
+	{`GET /`, []string{"GET /"}},
+	{`^(?P<method>GET|POST) (\S+) HTTP/1\.1$`, []string{" HTTP/1.1"}},
+	{`postfix/smtpd\[\d+\]: connect from`, []string{"postfix/smtpd[", "]: connect from"}},
+	{`(foo)+bar`, []string{"foo", "bar"}},
+	{`(foo)?bar`, []string{"bar"}},
+	{`(?i)error`, nil},
+	{`a|b`, nil},
+	{`\d+`, nil},
+	{`x`, nil},
+	{`(?:ab){2,}cd`, []string{"ab", "cd"}},
+	{`(`, nil},
+}
+
+func TestRequiredLiterals(t *testing.T) {
+	for _, tc := range requiredLiteralsTests {
+		tc := tc
+		t.Run(tc.pattern, func(t *testing.T) {
+			testutil.ExpectNoDiff(t, tc.want, requiredLiterals(tc.pattern))
+		})
+	}
+}
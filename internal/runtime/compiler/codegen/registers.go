@@ -0,0 +1,70 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package codegen
+
+import (
+	"github.com/google/mtail/internal/runtime/code"
+)
+
+// lowerRegisters translates the stack machine program into a program for the
+// register machine.  Each slot of the data stack is given its own register,
+// so the depth of the stack before an instruction names the registers that
+// instruction reads and writes.  mtail programs only ever jump forwards, so a
+// single pass over the program sees every predecessor of an instruction
+// before the instruction itself.
+func (c *codegen) lowerRegisters() {
+	prog := c.obj.Program
+	// depth records the stack depth on entry to each instruction, or -1 if
+	// no path to the instruction has been seen yet.
+	depth := make([]int, len(prog)+1)
+	for i := range depth {
+		depth[i] = -1
+	}
+	depth[0] = 0
+	// Statements like `x++` leave their value on the stack, so the two
+	// branches of a conditional can rejoin at different depths.  The values
+	// below the top are never read again, so taking the deepest is safe.
+	merge := func(pc, d int) {
+		if d > depth[pc] {
+			depth[pc] = d
+		}
+	}
+	regs := 0
+	c.obj.RegProgram = make([]code.RegInstr, len(prog))
+	for pc, i := range prog {
+		d := depth[pc]
+		if d < 0 {
+			// Unreachable instruction.
+			d = 0
+		}
+		pop, push, err := code.StackEffect(i)
+		if err != nil {
+			c.errorf(nil, "%s", err)
+			return
+		}
+		if pop > d {
+			c.errorf(nil, "stack underflow at instruction %d %s, depth is %d", pc, i, d)
+			return
+		}
+		base := d - pop
+		c.obj.RegProgram[pc] = code.RegInstr{Opcode: i.Opcode, Operand: i.Operand, Reg: base, SourceLine: i.SourceLine}
+		next := base + push
+		if next > regs {
+			regs = next
+		}
+		switch i.Opcode {
+		case code.Jmp, code.Jm, code.Jnm:
+			target := i.Operand.(int)
+			if target <= pc || target > len(prog) {
+				c.errorf(nil, "jump target %d out of range at instruction %d %s", target, pc, i)
+				return
+			}
+			merge(target, next)
+		}
+		if i.Opcode != code.Jmp {
+			merge(pc+1, next)
+		}
+	}
+	c.obj.Registers = regs
+}
@@ -0,0 +1,75 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package codegen_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/mtail/internal/runtime/code"
+	"github.com/google/mtail/internal/runtime/compiler/checker"
+	"github.com/google/mtail/internal/runtime/compiler/codegen"
+	"github.com/google/mtail/internal/runtime/compiler/parser"
+	"github.com/google/mtail/internal/testutil"
+)
+
+func TestLowerRegisters(t *testing.T) {
+	source := "counter foo\n" +
+		"/(.*)/ { strptime($1, \"2006-01-02T15:04:05\")\n" +
+		"foo++\n}\n"
+	ast, err := parser.Parse("regs", strings.NewReader(source))
+	testutil.FatalIfErr(t, err)
+	ast, err = checker.Check(ast, 0, 0)
+	testutil.FatalIfErr(t, err)
+	obj, err := codegen.CodeGen("regs", ast)
+	testutil.FatalIfErr(t, err)
+
+	expected := []code.RegInstr{
+		{Opcode: code.Match, Operand: 0, Reg: 0, SourceLine: 1},
+		{Opcode: code.Jnm, Operand: 11, Reg: 0, SourceLine: 1},
+		{Opcode: code.Setmatched, Operand: false, Reg: 0, SourceLine: 1},
+		{Opcode: code.Push, Operand: 0, Reg: 0, SourceLine: 1},
+		{Opcode: code.Capref, Operand: 1, Reg: 0, SourceLine: 1},
+		{Opcode: code.Str, Operand: 0, Reg: 1, SourceLine: 1},
+		{Opcode: code.Strptime, Operand: 2, Reg: 0, SourceLine: 1},
+		{Opcode: code.Mload, Operand: 0, Reg: 0, SourceLine: 2},
+		{Opcode: code.Dload, Operand: 0, Reg: 0, SourceLine: 2},
+		{Opcode: code.Inc, Operand: nil, Reg: 0, SourceLine: 2},
+		{Opcode: code.Setmatched, Operand: true, Reg: 1, SourceLine: 1},
+	}
+	testutil.ExpectNoDiff(t, expected, obj.RegProgram)
+	if obj.Registers != 2 {
+		t.Errorf("unexpected register count: want 2, got %d", obj.Registers)
+	}
+}
+
+// Every program the code generator emits must lower to the register machine,
+// except those with functions, which only run on the stack machine.
+func TestLowerRegistersAllPrograms(t *testing.T) {
+	for _, tc := range testCodeGenPrograms {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ast, err := parser.Parse(tc.name, strings.NewReader(tc.source))
+			testutil.FatalIfErr(t, err)
+			ast, err = checker.Check(ast, 0, 0)
+			testutil.FatalIfErr(t, err)
+			obj, err := codegen.CodeGen(tc.name, ast)
+			testutil.FatalIfErr(t, err)
+			if len(obj.Functions) > 0 {
+				if obj.RegProgram != nil {
+					t.Errorf("program with functions lowered to the register machine")
+				}
+				return
+			}
+			if len(obj.RegProgram) != len(obj.Program) {
+				t.Fatalf("register program length %d, want %d", len(obj.RegProgram), len(obj.Program))
+			}
+			for pc, i := range obj.RegProgram {
+				if i.Reg < 0 || i.Reg > obj.Registers {
+					t.Errorf("instruction %d %s uses register outside of 0..%d", pc, i, obj.Registers)
+				}
+			}
+		})
+	}
+}
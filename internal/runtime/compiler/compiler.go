@@ -12,6 +12,7 @@ import (
 	"github.com/google/mtail/internal/runtime/compiler/ast"
 	"github.com/google/mtail/internal/runtime/compiler/checker"
 	"github.com/google/mtail/internal/runtime/compiler/codegen"
+	"github.com/google/mtail/internal/runtime/compiler/importer"
 	"github.com/google/mtail/internal/runtime/compiler/opt"
 	"github.com/google/mtail/internal/runtime/compiler/parser"
 )
@@ -22,6 +23,7 @@ type Compiler struct {
 	maxRegexpLength     int
 	maxRecursionDepth   int
 	disableOptimisation bool
+	importPath          []string
 }
 
 func New(options ...Option) (*Compiler, error) {
@@ -68,7 +70,8 @@ func MaxRegexpLength(maxRegexpLength int) Option {
 	}
 }
 
-// MaxRecursionDepth sets the maximum allowable depth of the AST.
+// MaxRecursionDepth sets the maximum allowable depth of the AST, and of
+// nested calls to user-defined functions when the program runs.
 func MaxRecursionDepth(maxRecursionDepth int) Option {
 	return func(c *Compiler) error {
 		c.maxRecursionDepth = maxRecursionDepth
@@ -84,6 +87,15 @@ func DisableOptimisation() Option {
 	}
 }
 
+// ImportPath sets the directories searched, in order, for the modules named
+// in import statements, before the standard library.
+func ImportPath(dirs ...string) Option {
+	return func(c *Compiler) error {
+		c.importPath = append(c.importPath, dirs...)
+		return nil
+	}
+}
+
 // Compile compiles a program from the input into bytecode and data stored in an Object, or a list
 // of compile errors.
 func (c *Compiler) Compile(name string, input io.Reader) (obj *code.Object, err error) {
@@ -95,6 +107,10 @@ func (c *Compiler) Compile(name string, input io.Reader) (obj *code.Object, err
 	if err != nil {
 		return
 	}
+	ast, err = importer.Resolve(ast, c.importPath)
+	if err != nil {
+		return
+	}
 	if c.emitAst {
 		s := parser.Sexp{}
 		glog.Infof("%s AST:\n%s", name, s.Dump(ast))
@@ -134,5 +150,9 @@ func (c *Compiler) Compile(name string, input io.Reader) (obj *code.Object, err
 	}
 
 	obj, err = codegen.CodeGen(name, ast)
+	if err != nil {
+		return
+	}
+	obj.MaxCallDepth = c.maxRecursionDepth
 	return
 }
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/google/mtail/internal/runtime/code"
 	"github.com/google/mtail/internal/runtime/compiler"
 	"github.com/google/mtail/internal/testutil"
 )
@@ -49,3 +50,24 @@ func TestCompileCodegen(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestCompileFoldsConstants(t *testing.T) {
+	c := makeCompiler(t)
+	r := strings.NewReader(`const N 2
+const MAX_KEYS 10
+counter i by x limit MAX_KEYS
+// {
+  i["y"] += N * 3
+}`)
+	obj, err := c.Compile("test", r)
+	testutil.FatalIfErr(t, err)
+	if obj.Metrics[0].Limit != 10 {
+		t.Errorf("limit is %d, want 10", obj.Metrics[0].Limit)
+	}
+	for _, i := range obj.Program {
+		if i.Opcode == code.Push && i.Operand == int64(6) {
+			return
+		}
+	}
+	t.Errorf("expected folded constant 6 in program %v", obj.Program)
+}
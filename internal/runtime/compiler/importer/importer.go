@@ -0,0 +1,159 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+// Package importer implements the resolution of `import' statements in mtail
+// programs.  A module named in an import is read from the first directory in
+// the import path that contains it, or else from the standard library bundled
+// with mtail.  Its `const' and `def' declarations are attached to the import
+// statement, so that the checker brings them into the program's scope.
+package importer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/mtail/internal/runtime/compiler/ast"
+	"github.com/google/mtail/internal/runtime/compiler/errors"
+	"github.com/google/mtail/internal/runtime/compiler/parser"
+)
+
+// Extension is the filename extension of a module, not given in the import.
+const Extension = ".mtail"
+
+//go:embed stdlib/*.mtail
+var stdlib embed.FS
+
+// importer holds the state of the import resolution of one program.
+type importer struct {
+	path []string // Directories searched for modules, in order, before the standard library.
+
+	imported map[string]bool // Modules already imported into the program.
+	stack    []string        // Modules being imported, innermost last, to detect cycles.
+	blocks   int             // Depth of nested blocks at the current node.
+
+	errors errors.ErrorList
+}
+
+// Resolve reads and parses the modules named by the import statements in the
+// program, recursively, and attaches their declarations to the statements.
+// Each module is imported into a program at most once.  Errors are positioned
+// at the import statement that caused them.
+func Resolve(node ast.Node, importPath []string) (ast.Node, error) {
+	i := &importer{path: importPath, imported: make(map[string]bool)}
+	node = ast.Walk(i, node)
+	if len(i.errors) > 0 {
+		return node, i.errors
+	}
+	return node, nil
+}
+
+// VisitBefore implements the ast.Visitor interface.
+func (i *importer) VisitBefore(node ast.Node) (ast.Visitor, ast.Node) {
+	switch n := node.(type) {
+	case *ast.StmtList:
+		i.blocks++
+	case *ast.ImportStmt:
+		if i.blocks > 1 {
+			i.errors.Add(n.Pos(), fmt.Sprintf("Can't import `%s' here.\n\tImports are only allowed at the top level of a program.", n.Name))
+			return nil, n
+		}
+		i.resolve(n)
+		return nil, n
+	}
+	return i, node
+}
+
+// VisitAfter implements the ast.Visitor interface.
+func (i *importer) VisitAfter(node ast.Node) ast.Node {
+	if _, ok := node.(*ast.StmtList); ok {
+		i.blocks--
+	}
+	return node
+}
+
+// resolve attaches the declarations of the module named by the import
+// statement n, after resolving the module's own imports.
+func (i *importer) resolve(n *ast.ImportStmt) {
+	if !validName(n.Name) {
+		i.errors.Add(n.Pos(), fmt.Sprintf("Invalid module name `%s'.\n\tModule names are slash-separated paths relative to a directory in the import path, without the %s extension.", n.Name, Extension))
+		return
+	}
+	for j, name := range i.stack {
+		if name == n.Name {
+			cycle := append(append([]string{}, i.stack[j:]...), n.Name)
+			i.errors.Add(n.Pos(), fmt.Sprintf("Import cycle: %s.", strings.Join(cycle, " -> ")))
+			return
+		}
+	}
+	if i.imported[n.Name] {
+		return
+	}
+	filename, src, ok := i.find(n)
+	if !ok {
+		return
+	}
+	module, err := parser.Parse(filename, bytes.NewReader(src))
+	if err != nil {
+		i.errors.Add(n.Pos(), fmt.Sprintf("Can't parse module `%s':\n%s", n.Name, err))
+		return
+	}
+	stmts, ok := module.(*ast.StmtList)
+	if !ok {
+		i.errors.Add(n.Pos(), fmt.Sprintf("Internal error: module `%s' is a %T, not a statement list.", n.Name, module))
+		return
+	}
+	for _, child := range stmts.Children {
+		switch child.(type) {
+		case *ast.PatternFragment, *ast.ConstDecl, *ast.DecoDecl, *ast.FuncDecl, *ast.ImportStmt:
+		default:
+			i.errors.Add(n.Pos(), fmt.Sprintf("Module `%s' can't be imported: only const, def and func declarations are allowed in a module, but %s is not one.", n.Name, child.Pos()))
+			return
+		}
+	}
+	i.imported[n.Name] = true
+	i.stack = append(i.stack, n.Name)
+	for _, child := range stmts.Children {
+		if imp, ok := child.(*ast.ImportStmt); ok {
+			i.resolve(imp)
+		}
+	}
+	i.stack = i.stack[:len(i.stack)-1]
+	n.Children = stmts.Children
+}
+
+// validName returns false if the module name could refer to a file outside of
+// the directories in the import path.
+func validName(name string) bool {
+	return name != "" && path.Clean(name) == name && !path.IsAbs(name) && !filepath.IsAbs(name) &&
+		name != ".." && !strings.HasPrefix(name, "../") && !strings.Contains(name, `\`)
+}
+
+// find returns the filename and contents of the module named by the import
+// statement n, from the first directory in the import path that contains it,
+// or from the standard library.
+func (i *importer) find(n *ast.ImportStmt) (string, []byte, bool) {
+	for _, dir := range i.path {
+		filename := filepath.Join(dir, filepath.FromSlash(n.Name)+Extension)
+		src, err := os.ReadFile(filename)
+		if err == nil {
+			return filename, src, true
+		}
+		if !os.IsNotExist(err) {
+			i.errors.Add(n.Pos(), fmt.Sprintf("Can't read module `%s': %s", n.Name, err))
+			return "", nil, false
+		}
+	}
+	filename := path.Join("stdlib", n.Name+Extension)
+	src, err := fs.ReadFile(stdlib, filename)
+	if err != nil {
+		i.errors.Add(n.Pos(), fmt.Sprintf("Module `%s' not found in the import path %q or the standard library.", n.Name, i.path))
+		return "", nil, false
+	}
+	return filename, src, true
+}
@@ -0,0 +1,106 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package importer_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/mtail/internal/runtime/compiler"
+	"github.com/google/mtail/internal/testutil"
+)
+
+var modules = map[string]string{
+	"http.mtail":      "const PATH /(?P<path>\\S+)/\n",
+	"net.mtail":       "const IP /(?P<ip>\\S+)/\n",
+	"a.mtail":         "import \"b\"\nconst A /a/\n",
+	"b.mtail":         "import \"a\"\n",
+	"d/left.mtail":    "import \"syslog\"\n",
+	"d/right.mtail":   "import \"syslog\"\n",
+	"counter.mtail":   "counter requests_total\n",
+	"syntax.mtail":    "const X\n",
+	"badmodule.mtail": "import \"nope\"\n",
+}
+
+var importTests = []struct {
+	name    string
+	program string
+	errs    []string // Errors, with the import path directory replaced by DIR.
+}{
+	{"stdlib", `import "syslog"
+counter lines_total
+@syslog {
+  $application == "sshd" {
+    lines_total++
+  }
+}
+`, nil},
+	{"search path", `import "http"
+counter requests_total by path
+// + PATH + / / {
+  requests_total[$path]++
+}
+`, nil},
+	{"search path before stdlib", `import "net"
+counter requests_total by ip
+// + IP + / / {
+  requests_total[$ip]++
+}
+`, nil},
+	{"imported once", `import "d/left"
+import "d/right"
+import "syslog"
+`, nil},
+	{"unused imports", `import "time"
+import "net"
+`, nil},
+	{"not found", `import "nope"
+`, []string{"not found:1:1-13: Module `nope' not found in the import path [\"DIR\"] or the standard library."}},
+	{"not found in module", `import "badmodule"
+`, []string{"DIR/badmodule.mtail:1:1-13: Module `nope' not found in the import path [\"DIR\"] or the standard library."}},
+	{"cycle", `import "a"
+`, []string{"DIR/b.mtail:1:1-10: Import cycle: a -> b -> a."}},
+	{"self", `import "a"
+import "b"
+`, []string{"DIR/b.mtail:1:1-10: Import cycle: a -> b -> a."}},
+	{"invalid name", `import "../secrets"
+`, []string{"invalid name:1:1-19: Invalid module name `../secrets'.", "\tModule names are slash-separated paths relative to a directory in the import path, without the .mtail extension."}},
+	{"not a declaration", `import "counter"
+`, []string{"not a declaration:1:1-16: Module `counter' can't be imported: only const, def and func declarations are allowed in a module, but DIR/counter.mtail:1:9-22 is not one."}},
+	{"syntax error", `import "syntax"
+`, []string{"syntax error:1:1-15: Can't parse module `syntax':", "DIR/syntax.mtail:2:1: syntax error: unexpected $end, expecting DIV or LCURLY"}},
+	{"not top level", `/foo/ {
+  import "net"
+}
+`, []string{"not top level:2:3-14: Can't import `net' here.", "\tImports are only allowed at the top level of a program."}},
+	{"redeclaration", `import "net"
+const IP /ip/
+`, []string{"redeclaration:2:7-8: Redefinition of pattern constant `IP' previously defined at DIR/net.mtail:1:7-8"}},
+}
+
+func TestImport(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	for name, src := range modules {
+		testutil.FatalIfErr(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o700))
+		testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0o600))
+	}
+	c, err := compiler.New(compiler.ImportPath(dir))
+	testutil.FatalIfErr(t, err)
+	for _, tc := range importTests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := c.Compile(tc.name, strings.NewReader(tc.program))
+			if tc.errs == nil {
+				testutil.FatalIfErr(t, err)
+				return
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			testutil.ExpectNoDiff(t, tc.errs, strings.Split(strings.ReplaceAll(err.Error(), dir, "DIR"), "\n"))
+		})
+	}
+}
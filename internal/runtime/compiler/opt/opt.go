@@ -164,7 +164,95 @@ func (o *optimiser) VisitAfter(node ast.Node) ast.Node {
 		default:
 			return node
 		}
+	case *ast.BuiltinExpr:
+		return foldBuiltin(n)
 	default:
 		return node
 	}
 }
+
+// foldBuiltin evaluates a call to a numeric builtin whose arguments are all
+// literals.  Calls that would produce NaN or infinity are left to the runtime.
+func foldBuiltin(n *ast.BuiltinExpr) ast.Node {
+	args, ok := n.Args.(*ast.ExprList)
+	if !ok {
+		return n
+	}
+	fn, ok := types.Builtins[n.Name].(*types.Operator)
+	if !ok || len(fn.Args)-1 != len(args.Children) {
+		return n
+	}
+	allInt := true
+	ints := make([]int64, len(args.Children))
+	floats := make([]float64, len(args.Children))
+	for i, arg := range args.Children {
+		switch a := arg.(type) {
+		case *ast.IntLit:
+			ints[i], floats[i] = a.I, float64(a.I)
+		case *ast.FloatLit:
+			allInt = false
+			floats[i] = a.F
+		default:
+			return n
+		}
+	}
+	var f float64
+	switch n.Name {
+	case "abs", "min", "max", "clamp", "floor", "ceil", "round":
+		if allInt {
+			r := &ast.IntLit{P: n.P, I: ints[0]}
+			switch n.Name {
+			case "abs":
+				if r.I < 0 {
+					r.I = -r.I
+				}
+			case "min":
+				if ints[1] < r.I {
+					r.I = ints[1]
+				}
+			case "max":
+				if ints[1] > r.I {
+					r.I = ints[1]
+				}
+			case "clamp":
+				if r.I < ints[1] {
+					r.I = ints[1]
+				}
+				if r.I > ints[2] {
+					r.I = ints[2]
+				}
+			}
+			return r
+		}
+		switch n.Name {
+		case "abs":
+			f = math.Abs(floats[0])
+		case "min":
+			f = math.Min(floats[0], floats[1])
+		case "max":
+			f = math.Max(floats[0], floats[1])
+		case "clamp":
+			f = math.Min(math.Max(floats[0], floats[1]), floats[2])
+		case "floor":
+			f = math.Floor(floats[0])
+		case "ceil":
+			f = math.Ceil(floats[0])
+		case "round":
+			f = math.Round(floats[0])
+		}
+	case "log":
+		f = math.Log(floats[0])
+	case "log10":
+		f = math.Log10(floats[0])
+	case "exp":
+		f = math.Exp(floats[0])
+	case "sqrt":
+		f = math.Sqrt(floats[0])
+	default:
+		return n
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return n
+	}
+	return &ast.FloatLit{P: n.P, F: f}
+}
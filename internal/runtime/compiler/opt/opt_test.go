@@ -72,6 +72,71 @@ var optimiserTests = []struct {
 		},
 		&ast.IntLit{I: 15},
 	},
+	{
+		"int abs",
+		&ast.BuiltinExpr{
+			Name: "abs",
+			Args: &ast.ExprList{Children: []ast.Node{&ast.IntLit{I: -3}}},
+		},
+		&ast.IntLit{I: 3},
+	},
+	{
+		"int float max",
+		&ast.BuiltinExpr{
+			Name: "max",
+			Args: &ast.ExprList{Children: []ast.Node{&ast.IntLit{I: 2}, &ast.FloatLit{F: 1.5}}},
+		},
+		&ast.FloatLit{F: 2},
+	},
+	{
+		"int clamp",
+		&ast.BuiltinExpr{
+			Name: "clamp",
+			Args: &ast.ExprList{Children: []ast.Node{&ast.IntLit{I: 12}, &ast.IntLit{I: 0}, &ast.IntLit{I: 10}}},
+		},
+		&ast.IntLit{I: 10},
+	},
+	{
+		"float round",
+		&ast.BuiltinExpr{
+			Name: "round",
+			Args: &ast.ExprList{Children: []ast.Node{&ast.FloatLit{F: 2.5}}},
+		},
+		&ast.FloatLit{F: 3},
+	},
+	{
+		"int sqrt",
+		&ast.BuiltinExpr{
+			Name: "sqrt",
+			Args: &ast.ExprList{Children: []ast.Node{&ast.IntLit{I: 16}}},
+		},
+		&ast.FloatLit{F: 4},
+	},
+	{
+		"log of zero is not folded",
+		&ast.BuiltinExpr{
+			Name: "log",
+			Args: &ast.ExprList{Children: []ast.Node{&ast.IntLit{I: 0}}},
+		},
+		&ast.BuiltinExpr{
+			Name: "log",
+			Args: &ast.ExprList{Children: []ast.Node{&ast.IntLit{I: 0}}},
+		},
+	},
+	{
+		"nested builtins",
+		&ast.BuiltinExpr{
+			Name: "min",
+			Args: &ast.ExprList{Children: []ast.Node{
+				&ast.BuiltinExpr{
+					Name: "abs",
+					Args: &ast.ExprList{Children: []ast.Node{&ast.IntLit{I: -7}}},
+				},
+				&ast.IntLit{I: 5},
+			}},
+		},
+		&ast.IntLit{I: 5},
+	},
 }
 
 func TestOptimiser(t *testing.T) {
@@ -80,7 +145,7 @@ func TestOptimiser(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			got, err := opt.Optimise(tc.ast)
 			testutil.FatalIfErr(t, err)
-			testutil.ExpectNoDiff(t, tc.want, got)
+			testutil.ExpectNoDiff(t, tc.want, got, testutil.IgnoreUnexported(ast.BuiltinExpr{}, ast.ExprList{}))
 		})
 	}
 }
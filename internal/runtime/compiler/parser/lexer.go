@@ -27,31 +27,70 @@ var keywords = map[string]Kind{
 	"def":       DEF,
 	"del":       DEL,
 	"else":      ELSE,
+	"func":      FUNC,
 	"gauge":     GAUGE,
 	"hidden":    HIDDEN,
 	"histogram": HISTOGRAM,
+	"import":    IMPORT,
+	"in":        IN,
 	"limit":     LIMIT,
 	"next":      NEXT,
 	"otherwise": OTHERWISE,
+	"pragma":    PRAGMA,
+	"return":    RETURN,
 	"stop":      STOP,
 	"text":      TEXT,
 	"timer":     TIMER,
 }
 
-// List of builtin functions.  Keep this list sorted!
+// List of builtin functions.  Keep this list sorted!  A builtin name is only
+// lexed as a builtin when it is called, so that programs can still use the
+// names of builtins added later as metric and variable names.
 var builtins = []string{
+	"abs",
 	"bool",
+	"bytes",
+	"ceil",
+	"clamp",
+	"clientip",
+	"dayofyear",
+	"duration",
+	"exp",
 	"float",
+	"floor",
 	"getfilename",
+	"hasprefix",
+	"hassuffix",
+	"hour",
+	"incidr",
+	"index",
 	"int",
+	"ipnet",
+	"isipv6",
 	"len",
+	"log",
+	"log10",
+	"lookup",
+	"max",
+	"min",
+	"now",
+	"round",
 	"settime",
+	"split",
+	"sprintf",
+	"sqrt",
+	"strftime",
 	"string",
 	"strptime",
 	"strtol",
 	"subst",
+	"substr",
 	"timestamp",
+	"timestamp_ns",
 	"tolower",
+	"toupper",
+	"trim",
+	"weekday",
 }
 
 // Dictionary returns a list of all keywords and builtins of the language.
@@ -85,6 +124,7 @@ type Lexer struct {
 	text     strings.Builder // the text of the current token
 
 	tokens chan Token // Output channel for tokens emitted.
+	last   Kind       // Kind of the last token emitted.
 }
 
 // NewLexer creates a new scanner type that reads the input provided.
@@ -116,6 +156,7 @@ func (l *Lexer) emit(kind Kind) {
 	pos := position.Position{l.name, l.line, l.startcol, l.col - 1}
 	glog.V(2).Infof("Emitting %v spelled %q at %v", kind, l.text.String(), pos)
 	l.tokens <- Token{kind, l.text.String(), pos}
+	l.last = kind
 	// Reset the current token
 	l.text.Reset()
 	l.startcol = l.col
@@ -236,6 +277,9 @@ func lexProg(l *Lexer) stateFn {
 	case r == ',':
 		l.accept()
 		l.emit(COMMA)
+	case r == ':':
+		l.accept()
+		l.emit(COLON)
 	case r == '-':
 		l.accept()
 		switch r = l.next(); {
@@ -527,6 +571,29 @@ Loop:
 	return lexProg
 }
 
+// peekCall reports whether the unread input starts with an opening
+// parenthesis, after any spaces or tabs, as when an identifier names a
+// function that is being called.
+func (l *Lexer) peekCall() bool {
+	for n := 1; ; n++ {
+		b, err := l.input.Peek(n)
+		if len(b) < n {
+			if err != nil {
+				glog.V(2).Infof("peek for call: %s", err)
+			}
+			return false
+		}
+		switch b[n-1] {
+		case ' ', '\t':
+			continue
+		case '(':
+			return true
+		default:
+			return false
+		}
+	}
+}
+
 // Lex an identifier, or builtin keyword.
 func lexIdentifier(l *Lexer) stateFn {
 	l.accept()
@@ -542,7 +609,11 @@ Loop:
 	}
 	if r, ok := keywords[l.text.String()]; ok {
 		l.emit(r)
-	} else if r := sort.SearchStrings(builtins, l.text.String()); r >= 0 && r < len(builtins) && builtins[r] == l.text.String() {
+	} else if l.text.String() == "map" && l.last == HIDDEN {
+		// `map' is only a keyword in a declaration, so that it can still be
+		// the name of a metric.
+		l.emit(MAP)
+	} else if r := sort.SearchStrings(builtins, l.text.String()); r >= 0 && r < len(builtins) && builtins[r] == l.text.String() && l.peekCall() {
 		l.emit(BUILTIN)
 	} else {
 		l.emit(ID)
@@ -34,7 +34,7 @@ var lexerTests = []lexerTest{
 	{"comment not at col 1", "  # comment", []Token{
 		{EOF, "", position.Position{"comment not at col 1", 0, 11, 11}},
 	}},
-	{"punctuation", "{}()[],", []Token{
+	{"punctuation", "{}()[],:", []Token{
 		{LCURLY, "{", position.Position{"punctuation", 0, 0, 0}},
 		{RCURLY, "}", position.Position{"punctuation", 0, 1, 1}},
 		{LPAREN, "(", position.Position{"punctuation", 0, 2, 2}},
@@ -42,7 +42,8 @@ var lexerTests = []lexerTest{
 		{LSQUARE, "[", position.Position{"punctuation", 0, 4, 4}},
 		{RSQUARE, "]", position.Position{"punctuation", 0, 5, 5}},
 		{COMMA, ",", position.Position{"punctuation", 0, 6, 6}},
-		{EOF, "", position.Position{"punctuation", 0, 7, 7}},
+		{COLON, ":", position.Position{"punctuation", 0, 7, 7}},
+		{EOF, "", position.Position{"punctuation", 0, 8, 8}},
 	}},
 	{"operators", "- + = ++ += < > <= >= == != * / << >> & | ^ ~ ** % || && =~ !~ --", []Token{
 		{MINUS, "-", position.Position{"operators", 0, 0, 0}},
@@ -75,7 +76,7 @@ var lexerTests = []lexerTest{
 	}},
 	{
 		"keywords",
-		"counter\ngauge\nas\nby\nhidden\ndef\nnext\nconst\ntimer\notherwise\nelse\ndel\ntext\nafter\nstop\nhistogram\nbuckets\n",
+		"counter\ngauge\nas\nby\nhidden\ndef\nnext\nconst\ntimer\notherwise\nelse\ndel\ntext\nafter\nstop\nhistogram\nbuckets\npragma\nimport\nfunc\nreturn\nin\n",
 		[]Token{
 			{COUNTER, "counter", position.Position{"keywords", 0, 0, 6}},
 			{NL, "\n", position.Position{"keywords", 1, 7, -1}},
@@ -111,38 +112,186 @@ var lexerTests = []lexerTest{
 			{NL, "\n", position.Position{"keywords", 16, 9, -1}},
 			{BUCKETS, "buckets", position.Position{"keywords", 16, 0, 6}},
 			{NL, "\n", position.Position{"keywords", 17, 7, -1}},
-			{EOF, "", position.Position{"keywords", 17, 0, 0}},
+			{PRAGMA, "pragma", position.Position{"keywords", 17, 0, 5}},
+			{NL, "\n", position.Position{"keywords", 18, 6, -1}},
+			{IMPORT, "import", position.Position{"keywords", 18, 0, 5}},
+			{NL, "\n", position.Position{"keywords", 19, 6, -1}},
+			{FUNC, "func", position.Position{"keywords", 19, 0, 3}},
+			{NL, "\n", position.Position{"keywords", 20, 4, -1}},
+			{RETURN, "return", position.Position{"keywords", 20, 0, 5}},
+			{NL, "\n", position.Position{"keywords", 21, 6, -1}},
+			{IN, "in", position.Position{"keywords", 21, 0, 1}},
+			{NL, "\n", position.Position{"keywords", 22, 2, -1}},
+			{EOF, "", position.Position{"keywords", 22, 0, 0}},
 		},
 	},
 	{
 		"builtins",
-		"strptime\ntimestamp\ntolower\nlen\nstrtol\nsettime\ngetfilename\nint\nbool\nfloat\nstring\nsubst\n",
+		"strptime(\ntimestamp(\ntolower(\nlen(\nstrtol(\nsettime(\ngetfilename(\nint(\nbool(\nfloat(\nstring(\nsubst(\nlookup(\ntoupper(\ntrim(\nsubstr(\nsplit(\nindex(\nsprintf(\nhasprefix(\nhassuffix(\nabs(\nmin(\nmax(\nclamp(\nfloor(\nceil(\nround(\nlog(\nlog10(\nexp(\nsqrt(\nduration(\nbytes(\nipnet(\nincidr(\nisipv6(\nclientip(\nstrftime(\nhour(\nweekday(\ndayofyear(\nnow(\ntimestamp_ns(\n",
 		[]Token{
 			{BUILTIN, "strptime", position.Position{"builtins", 0, 0, 7}},
-			{NL, "\n", position.Position{"builtins", 1, 8, -1}},
+			{LPAREN, "(", position.Position{"builtins", 0, 8, 8}},
+			{NL, "\n", position.Position{"builtins", 1, 9, -1}},
 			{BUILTIN, "timestamp", position.Position{"builtins", 1, 0, 8}},
-			{NL, "\n", position.Position{"builtins", 2, 9, -1}},
+			{LPAREN, "(", position.Position{"builtins", 1, 9, 9}},
+			{NL, "\n", position.Position{"builtins", 2, 10, -1}},
 			{BUILTIN, "tolower", position.Position{"builtins", 2, 0, 6}},
-			{NL, "\n", position.Position{"builtins", 3, 7, -1}},
+			{LPAREN, "(", position.Position{"builtins", 2, 7, 7}},
+			{NL, "\n", position.Position{"builtins", 3, 8, -1}},
 			{BUILTIN, "len", position.Position{"builtins", 3, 0, 2}},
-			{NL, "\n", position.Position{"builtins", 4, 3, -1}},
+			{LPAREN, "(", position.Position{"builtins", 3, 3, 3}},
+			{NL, "\n", position.Position{"builtins", 4, 4, -1}},
 			{BUILTIN, "strtol", position.Position{"builtins", 4, 0, 5}},
-			{NL, "\n", position.Position{"builtins", 5, 6, -1}},
+			{LPAREN, "(", position.Position{"builtins", 4, 6, 6}},
+			{NL, "\n", position.Position{"builtins", 5, 7, -1}},
 			{BUILTIN, "settime", position.Position{"builtins", 5, 0, 6}},
-			{NL, "\n", position.Position{"builtins", 6, 7, -1}},
+			{LPAREN, "(", position.Position{"builtins", 5, 7, 7}},
+			{NL, "\n", position.Position{"builtins", 6, 8, -1}},
 			{BUILTIN, "getfilename", position.Position{"builtins", 6, 0, 10}},
-			{NL, "\n", position.Position{"builtins", 7, 11, -1}},
+			{LPAREN, "(", position.Position{"builtins", 6, 11, 11}},
+			{NL, "\n", position.Position{"builtins", 7, 12, -1}},
 			{BUILTIN, "int", position.Position{"builtins", 7, 0, 2}},
-			{NL, "\n", position.Position{"builtins", 8, 3, -1}},
+			{LPAREN, "(", position.Position{"builtins", 7, 3, 3}},
+			{NL, "\n", position.Position{"builtins", 8, 4, -1}},
 			{BUILTIN, "bool", position.Position{"builtins", 8, 0, 3}},
-			{NL, "\n", position.Position{"builtins", 9, 4, -1}},
+			{LPAREN, "(", position.Position{"builtins", 8, 4, 4}},
+			{NL, "\n", position.Position{"builtins", 9, 5, -1}},
 			{BUILTIN, "float", position.Position{"builtins", 9, 0, 4}},
-			{NL, "\n", position.Position{"builtins", 10, 5, -1}},
+			{LPAREN, "(", position.Position{"builtins", 9, 5, 5}},
+			{NL, "\n", position.Position{"builtins", 10, 6, -1}},
 			{BUILTIN, "string", position.Position{"builtins", 10, 0, 5}},
-			{NL, "\n", position.Position{"builtins", 11, 6, -1}},
+			{LPAREN, "(", position.Position{"builtins", 10, 6, 6}},
+			{NL, "\n", position.Position{"builtins", 11, 7, -1}},
 			{BUILTIN, "subst", position.Position{"builtins", 11, 0, 4}},
-			{NL, "\n", position.Position{"builtins", 12, 5, -1}},
-			{EOF, "", position.Position{"builtins", 12, 0, 0}},
+			{LPAREN, "(", position.Position{"builtins", 11, 5, 5}},
+			{NL, "\n", position.Position{"builtins", 12, 6, -1}},
+			{BUILTIN, "lookup", position.Position{"builtins", 12, 0, 5}},
+			{LPAREN, "(", position.Position{"builtins", 12, 6, 6}},
+			{NL, "\n", position.Position{"builtins", 13, 7, -1}},
+			{BUILTIN, "toupper", position.Position{"builtins", 13, 0, 6}},
+			{LPAREN, "(", position.Position{"builtins", 13, 7, 7}},
+			{NL, "\n", position.Position{"builtins", 14, 8, -1}},
+			{BUILTIN, "trim", position.Position{"builtins", 14, 0, 3}},
+			{LPAREN, "(", position.Position{"builtins", 14, 4, 4}},
+			{NL, "\n", position.Position{"builtins", 15, 5, -1}},
+			{BUILTIN, "substr", position.Position{"builtins", 15, 0, 5}},
+			{LPAREN, "(", position.Position{"builtins", 15, 6, 6}},
+			{NL, "\n", position.Position{"builtins", 16, 7, -1}},
+			{BUILTIN, "split", position.Position{"builtins", 16, 0, 4}},
+			{LPAREN, "(", position.Position{"builtins", 16, 5, 5}},
+			{NL, "\n", position.Position{"builtins", 17, 6, -1}},
+			{BUILTIN, "index", position.Position{"builtins", 17, 0, 4}},
+			{LPAREN, "(", position.Position{"builtins", 17, 5, 5}},
+			{NL, "\n", position.Position{"builtins", 18, 6, -1}},
+			{BUILTIN, "sprintf", position.Position{"builtins", 18, 0, 6}},
+			{LPAREN, "(", position.Position{"builtins", 18, 7, 7}},
+			{NL, "\n", position.Position{"builtins", 19, 8, -1}},
+			{BUILTIN, "hasprefix", position.Position{"builtins", 19, 0, 8}},
+			{LPAREN, "(", position.Position{"builtins", 19, 9, 9}},
+			{NL, "\n", position.Position{"builtins", 20, 10, -1}},
+			{BUILTIN, "hassuffix", position.Position{"builtins", 20, 0, 8}},
+			{LPAREN, "(", position.Position{"builtins", 20, 9, 9}},
+			{NL, "\n", position.Position{"builtins", 21, 10, -1}},
+			{BUILTIN, "abs", position.Position{"builtins", 21, 0, 2}},
+			{LPAREN, "(", position.Position{"builtins", 21, 3, 3}},
+			{NL, "\n", position.Position{"builtins", 22, 4, -1}},
+			{BUILTIN, "min", position.Position{"builtins", 22, 0, 2}},
+			{LPAREN, "(", position.Position{"builtins", 22, 3, 3}},
+			{NL, "\n", position.Position{"builtins", 23, 4, -1}},
+			{BUILTIN, "max", position.Position{"builtins", 23, 0, 2}},
+			{LPAREN, "(", position.Position{"builtins", 23, 3, 3}},
+			{NL, "\n", position.Position{"builtins", 24, 4, -1}},
+			{BUILTIN, "clamp", position.Position{"builtins", 24, 0, 4}},
+			{LPAREN, "(", position.Position{"builtins", 24, 5, 5}},
+			{NL, "\n", position.Position{"builtins", 25, 6, -1}},
+			{BUILTIN, "floor", position.Position{"builtins", 25, 0, 4}},
+			{LPAREN, "(", position.Position{"builtins", 25, 5, 5}},
+			{NL, "\n", position.Position{"builtins", 26, 6, -1}},
+			{BUILTIN, "ceil", position.Position{"builtins", 26, 0, 3}},
+			{LPAREN, "(", position.Position{"builtins", 26, 4, 4}},
+			{NL, "\n", position.Position{"builtins", 27, 5, -1}},
+			{BUILTIN, "round", position.Position{"builtins", 27, 0, 4}},
+			{LPAREN, "(", position.Position{"builtins", 27, 5, 5}},
+			{NL, "\n", position.Position{"builtins", 28, 6, -1}},
+			{BUILTIN, "log", position.Position{"builtins", 28, 0, 2}},
+			{LPAREN, "(", position.Position{"builtins", 28, 3, 3}},
+			{NL, "\n", position.Position{"builtins", 29, 4, -1}},
+			{BUILTIN, "log10", position.Position{"builtins", 29, 0, 4}},
+			{LPAREN, "(", position.Position{"builtins", 29, 5, 5}},
+			{NL, "\n", position.Position{"builtins", 30, 6, -1}},
+			{BUILTIN, "exp", position.Position{"builtins", 30, 0, 2}},
+			{LPAREN, "(", position.Position{"builtins", 30, 3, 3}},
+			{NL, "\n", position.Position{"builtins", 31, 4, -1}},
+			{BUILTIN, "sqrt", position.Position{"builtins", 31, 0, 3}},
+			{LPAREN, "(", position.Position{"builtins", 31, 4, 4}},
+			{NL, "\n", position.Position{"builtins", 32, 5, -1}},
+			{BUILTIN, "duration", position.Position{"builtins", 32, 0, 7}},
+			{LPAREN, "(", position.Position{"builtins", 32, 8, 8}},
+			{NL, "\n", position.Position{"builtins", 33, 9, -1}},
+			{BUILTIN, "bytes", position.Position{"builtins", 33, 0, 4}},
+			{LPAREN, "(", position.Position{"builtins", 33, 5, 5}},
+			{NL, "\n", position.Position{"builtins", 34, 6, -1}},
+			{BUILTIN, "ipnet", position.Position{"builtins", 34, 0, 4}},
+			{LPAREN, "(", position.Position{"builtins", 34, 5, 5}},
+			{NL, "\n", position.Position{"builtins", 35, 6, -1}},
+			{BUILTIN, "incidr", position.Position{"builtins", 35, 0, 5}},
+			{LPAREN, "(", position.Position{"builtins", 35, 6, 6}},
+			{NL, "\n", position.Position{"builtins", 36, 7, -1}},
+			{BUILTIN, "isipv6", position.Position{"builtins", 36, 0, 5}},
+			{LPAREN, "(", position.Position{"builtins", 36, 6, 6}},
+			{NL, "\n", position.Position{"builtins", 37, 7, -1}},
+			{BUILTIN, "clientip", position.Position{"builtins", 37, 0, 7}},
+			{LPAREN, "(", position.Position{"builtins", 37, 8, 8}},
+			{NL, "\n", position.Position{"builtins", 38, 9, -1}},
+			{BUILTIN, "strftime", position.Position{"builtins", 38, 0, 7}},
+			{LPAREN, "(", position.Position{"builtins", 38, 8, 8}},
+			{NL, "\n", position.Position{"builtins", 39, 9, -1}},
+			{BUILTIN, "hour", position.Position{"builtins", 39, 0, 3}},
+			{LPAREN, "(", position.Position{"builtins", 39, 4, 4}},
+			{NL, "\n", position.Position{"builtins", 40, 5, -1}},
+			{BUILTIN, "weekday", position.Position{"builtins", 40, 0, 6}},
+			{LPAREN, "(", position.Position{"builtins", 40, 7, 7}},
+			{NL, "\n", position.Position{"builtins", 41, 8, -1}},
+			{BUILTIN, "dayofyear", position.Position{"builtins", 41, 0, 8}},
+			{LPAREN, "(", position.Position{"builtins", 41, 9, 9}},
+			{NL, "\n", position.Position{"builtins", 42, 10, -1}},
+			{BUILTIN, "now", position.Position{"builtins", 42, 0, 2}},
+			{LPAREN, "(", position.Position{"builtins", 42, 3, 3}},
+			{NL, "\n", position.Position{"builtins", 43, 4, -1}},
+			{BUILTIN, "timestamp_ns", position.Position{"builtins", 43, 0, 11}},
+			{LPAREN, "(", position.Position{"builtins", 43, 12, 12}},
+			{NL, "\n", position.Position{"builtins", 44, 13, -1}},
+			{EOF, "", position.Position{"builtins", 44, 0, 0}},
+		},
+	},
+	{
+		"builtin names as identifiers",
+		"max = abs (x)\ncounter log by index\n",
+		[]Token{
+			{ID, "max", position.Position{"builtin names as identifiers", 0, 0, 2}},
+			{ASSIGN, "=", position.Position{"builtin names as identifiers", 0, 4, 4}},
+			{BUILTIN, "abs", position.Position{"builtin names as identifiers", 0, 6, 8}},
+			{LPAREN, "(", position.Position{"builtin names as identifiers", 0, 10, 10}},
+			{ID, "x", position.Position{"builtin names as identifiers", 0, 11, 11}},
+			{RPAREN, ")", position.Position{"builtin names as identifiers", 0, 12, 12}},
+			{NL, "\n", position.Position{"builtin names as identifiers", 1, 13, -1}},
+			{COUNTER, "counter", position.Position{"builtin names as identifiers", 1, 0, 6}},
+			{ID, "log", position.Position{"builtin names as identifiers", 1, 8, 10}},
+			{BY, "by", position.Position{"builtin names as identifiers", 1, 12, 13}},
+			{ID, "index", position.Position{"builtin names as identifiers", 1, 15, 19}},
+			{NL, "\n", position.Position{"builtin names as identifiers", 2, 20, -1}},
+			{EOF, "", position.Position{"builtin names as identifiers", 2, 0, 0}},
+		},
+	},
+	{
+		"map only a keyword after hidden",
+		"hidden map map\n",
+		[]Token{
+			{HIDDEN, "hidden", position.Position{"map only a keyword after hidden", 0, 0, 5}},
+			{MAP, "map", position.Position{"map only a keyword after hidden", 0, 7, 9}},
+			{ID, "map", position.Position{"map only a keyword after hidden", 0, 11, 13}},
+			{NL, "\n", position.Position{"map only a keyword after hidden", 1, 14, -1}},
+			{EOF, "", position.Position{"map only a keyword after hidden", 1, 0, 0}},
 		},
 	},
 	{"numbers", "1 23 3.14 1.61.1 -1 -1.0 1h 0d 3d -1.5h 15m 24h0m0s 1e3 1e-3 .11 123.456e7", []Token{
@@ -28,16 +28,20 @@ import (
     n ast.Node
     kind metrics.Kind
     duration time.Duration
+    params []*ast.ParamDecl
 }
 
 %type <n> stmt_list stmt arg_expr_list compound_stmt conditional_stmt conditional_expr expr_stmt
 %type <n> expr primary_expr multiplicative_expr additive_expr postfix_expr unary_expr assign_expr
 %type <n> rel_expr shift_expr bitwise_expr logical_expr indexed_expr id_expr concat_expr pattern_expr
 %type <n> metric_declaration metric_decl_attr_spec decorator_declaration decoration_stmt regex_pattern match_expr
-%type <n> delete_stmt metric_name_spec builtin_expr arg_expr
+%type <n> delete_stmt metric_name_spec builtin_expr arg_expr import_stmt const_literal
+%type <n> function_declaration function_head param param_name return_stmt call_expr
+%type <n> map_literal map_entry_list map_key map_value map_declaration map_decl_head
+%type <params> param_list
 %type <kind> metric_type_spec
 %type <intVal> metric_limit_spec
-%type <text> metric_as_spec id_or_string metric_by_expr
+%type <text> metric_as_spec id_or_string metric_by_expr type_name
 %type <texts> metric_by_spec metric_by_expr_list
 %type <flag> metric_hide_spec
 %type <op> rel_op shift_op bitwise_op logical_op add_op mul_op match_op postfix_op
@@ -48,7 +52,7 @@ import (
 // Types
 %token COUNTER GAUGE TIMER TEXT HISTOGRAM
 // Reserved words
-%token AFTER AS BY CONST HIDDEN DEF DEL NEXT OTHERWISE ELSE STOP BUCKETS LIMIT
+%token AFTER AS BY CONST HIDDEN DEF DEL NEXT OTHERWISE ELSE STOP BUCKETS LIMIT PRAGMA IMPORT FUNC RETURN MAP
 // Builtins
 %token <text> BUILTIN
 // Literals: re2 syntax regular expression, quoted strings, regex capture group
@@ -69,11 +73,17 @@ import (
 %token <op> BITAND XOR BITOR NOT AND OR
 %token <op> ADD_ASSIGN ASSIGN
 %token <op> MATCH NOT_MATCH
+%token <op> IN
 // Punctuation
 %token LCURLY RCURLY LPAREN RPAREN LSQUARE RSQUARE
-%token COMMA
+%token COMMA COLON
 %token NL
 
+// An identifier followed by a parenthesis is a function call, not an
+// identifier expression ending a statement like `del' that has no newline.
+%nonassoc ID_EXPR
+%nonassoc LPAREN
+
 %start start
 
 // The %error directive takes a list of tokens describing a parser state in error, and an error message.
@@ -118,12 +128,20 @@ stmt
   { $$ = $1 }
   | metric_declaration
   { $$ = $1 }
+  | map_declaration
+  { $$ = $1 }
   | decorator_declaration
   { $$ = $1 }
   | decoration_stmt
   { $$ = $1 }
   | delete_stmt
   { $$ = $1 }
+  | import_stmt
+  { $$ = $1 }
+  | function_declaration
+  { $$ = $1 }
+  | return_stmt
+  { $$ = $1 }
   | NEXT
   {
     $$ = &ast.NextStmt{tokenpos(mtaillex)}
@@ -132,10 +150,18 @@ stmt
   {
     $$ = &ast.PatternFragment{ID: $2, Expr: $4}
   }
+  | CONST id_expr opt_nl const_literal
+  {
+    $$ = &ast.ConstDecl{ID: $2, Value: $4}
+  }
   | STOP
   {
     $$ = &ast.StopStmt{tokenpos(mtaillex)}
   }
+  | PRAGMA ID
+  {
+    $$ = &ast.PragmaStmt{tokenpos(mtaillex), $2}
+  }
   | INVALID
   {
     $$ = &ast.Error{tokenpos(mtaillex), $1}
@@ -281,6 +307,8 @@ rel_op
   { $$ = $1 }
   | NE
   { $$ = $1 }
+  | IN
+  { $$ = $1 }
   ;
 
 /* Shift expressions perform bitshift operations on the left hand side. */
@@ -413,6 +441,24 @@ primary_expr
   { $$ = $1 }
   | builtin_expr
   { $$ = $1 }
+  | builtin_expr LSQUARE arg_expr RSQUARE
+  {
+    // Only split may be indexed; the index becomes its last argument.
+    b := $1.(*ast.BuiltinExpr)
+    if b.Name != "split" {
+      mtaillex.(*parser).ErrorP("Only the result of split() can be indexed.", b.Pos())
+    }
+    if args, ok := b.Args.(*ast.ExprList); ok {
+      args.Children = append(args.Children, $3)
+    } else {
+      b.Args = &ast.ExprList{Children: []ast.Node{$3}}
+    }
+    tp := tokenpos(mtaillex)
+    b.P = *position.Merge(b.Pos(), &tp)
+    $$ = b
+  }
+  | call_expr
+  { $$ = $1 }
   | CAPREF
   {
     $$ = &ast.CaprefTerm{tokenpos(mtaillex), $1, false, nil}
@@ -439,9 +485,86 @@ primary_expr
   }
   ;
 
+/* Constant literal is the value of a named constant that isn't a pattern. */
+const_literal
+  : STRING
+  {
+    $$ = &ast.StringLit{tokenpos(mtaillex), $1}
+  }
+  | INTLITERAL
+  {
+    $$ = &ast.IntLit{tokenpos(mtaillex), $1}
+  }
+  | FLOATLITERAL
+  {
+    $$ = &ast.FloatLit{tokenpos(mtaillex), $1}
+  }
+  | DURATIONLITERAL
+  {
+    $$ = &ast.DurationLit{tokenpos(mtaillex), $1}
+  }
+  | map_literal
+  {
+    $$ = $1
+  }
+  ;
+
+/* Map literal is a table of keys and values, which may span several lines. */
+map_literal
+  : mark_pos LCURLY opt_nl map_entry_list opt_nl RCURLY
+  {
+    $$ = $4
+    $$.(*ast.MapLit).P = positionFromMark(mtaillex)
+  }
+  | mark_pos LCURLY opt_nl map_entry_list COMMA opt_nl RCURLY
+  {
+    $$ = $4
+    $$.(*ast.MapLit).P = positionFromMark(mtaillex)
+  }
+  ;
+
+map_entry_list
+  : map_key COLON map_value
+  {
+    $$ = &ast.MapLit{Keys: []ast.Node{$1}, Values: []ast.Node{$3}}
+  }
+  | map_entry_list COMMA opt_nl map_key COLON map_value
+  {
+    $$ = $1
+    $$.(*ast.MapLit).Keys = append($$.(*ast.MapLit).Keys, $4)
+    $$.(*ast.MapLit).Values = append($$.(*ast.MapLit).Values, $6)
+  }
+  ;
+
+map_key
+  : STRING
+  {
+    $$ = &ast.StringLit{tokenpos(mtaillex), $1}
+  }
+  | INTLITERAL
+  {
+    $$ = &ast.IntLit{tokenpos(mtaillex), $1}
+  }
+  ;
+
+map_value
+  : STRING
+  {
+    $$ = &ast.StringLit{tokenpos(mtaillex), $1}
+  }
+  | INTLITERAL
+  {
+    $$ = &ast.IntLit{tokenpos(mtaillex), $1}
+  }
+  | FLOATLITERAL
+  {
+    $$ = &ast.FloatLit{tokenpos(mtaillex), $1}
+  }
+  ;
+
 /* Indexed expression performs index lookup. */
 indexed_expr
-  : id_expr
+  : id_expr %prec ID_EXPR
   {
     // Build an empty IndexedExpr so that the recursive rule below doesn't need to handle the alternative.
     $$ = &ast.IndexedExpr{LHS: $1, Index: &ast.ExprList{}}
@@ -476,6 +599,20 @@ builtin_expr
   ;
 
 
+/* Call expression describes a call to a user-defined function. */
+call_expr
+  : id_expr LPAREN RPAREN
+  {
+    tp := tokenpos(mtaillex)
+    $$ = &ast.CallExpr{P: *position.Merge($1.Pos(), &tp), Name: $1.(*ast.IDTerm).Name}
+  }
+  | id_expr LPAREN arg_expr_list RPAREN
+  {
+    tp := tokenpos(mtaillex)
+    $$ = &ast.CallExpr{P: *position.Merge($1.Pos(), &tp), Name: $1.(*ast.IDTerm).Name, Args: $3}
+  }
+  ;
+
 /* Argument expression list describes the part of a builtin call inside the parentheses. */
 arg_expr_list
   : arg_expr
@@ -516,6 +653,26 @@ metric_declaration
   }
   ;
 
+/* Map declaration creates a new map variable, which is never exported, optionally with initial contents. */
+map_declaration
+  : map_decl_head
+  {
+    $$ = $1
+  }
+  | map_decl_head map_literal
+  {
+    $$ = $1
+    $$.(*ast.MapDecl).Init = $2.(*ast.MapLit)
+  }
+  ;
+
+map_decl_head
+  : HIDDEN MAP ID
+  {
+    $$ = &ast.MapDecl{P: tokenpos(mtaillex), Name: $3}
+  }
+  ;
+
 /* A hide specification can mark a metric as hidden from export. */
 metric_hide_spec
   : /* empty */
@@ -550,6 +707,11 @@ metric_decl_attr_spec
     $$ = $1
     $$.(*ast.VarDecl).Limit = $2
   }
+  | metric_decl_attr_spec LIMIT ID
+  {
+    $$ = $1
+    $$.(*ast.VarDecl).LimitConst = $3
+  }
   | metric_name_spec
   {
     $$ = $1
@@ -684,11 +846,94 @@ delete_stmt
   {
     $$ = &ast.DelStmt{P: positionFromMark(mtaillex), N: $3, Expiry: $5}
   }
+  | mark_pos DEL postfix_expr AFTER ID
+  {
+    $$ = &ast.DelStmt{P: positionFromMark(mtaillex), N: $3, ExpiryConst: $5}
+  }
   | mark_pos DEL postfix_expr
   {
     $$ = &ast.DelStmt{P: positionFromMark(mtaillex), N: $3}
   }
 
+/* Function declaration parses the declaration and definition of a function. */
+function_declaration
+  : function_head LPAREN RPAREN type_name compound_stmt
+  {
+    $$ = $1
+    $$.(*ast.FuncDecl).ReturnType = $4
+    $$.(*ast.FuncDecl).Block = $5
+  }
+  | function_head LPAREN param_list RPAREN type_name compound_stmt
+  {
+    $$ = $1
+    $$.(*ast.FuncDecl).Params = $3
+    $$.(*ast.FuncDecl).ReturnType = $5
+    $$.(*ast.FuncDecl).Block = $6
+  }
+  ;
+
+function_head
+  : FUNC ID
+  {
+    $$ = &ast.FuncDecl{P: tokenpos(mtaillex), Name: $2}
+  }
+  ;
+
+/* Parameter list describes the names and types of the parameters of a function. */
+param_list
+  : param
+  {
+    $$ = []*ast.ParamDecl{$1.(*ast.ParamDecl)}
+  }
+  | param_list COMMA param
+  {
+    $$ = append($1, $3.(*ast.ParamDecl))
+  }
+  ;
+
+param
+  : param_name type_name
+  {
+    $$ = $1
+    $$.(*ast.ParamDecl).TypeName = $2
+  }
+  ;
+
+param_name
+  : ID
+  {
+    $$ = &ast.ParamDecl{P: tokenpos(mtaillex), Name: $1}
+  }
+  ;
+
+/* Type name names the type of a function parameter or result, which are spelt like the conversion builtins. */
+type_name
+  : BUILTIN
+  {
+    $$ = $1
+  }
+  | ID
+  {
+    $$ = $1
+  }
+  ;
+
+/* Return statement parses the return of a value from a function. */
+return_stmt
+  : mark_pos RETURN logical_expr NL
+  {
+    $$ = &ast.ReturnStmt{P: positionFromMark(mtaillex), Expr: $3}
+  }
+  ;
+
+/* Import statement parses the import of the declarations of a module. */
+import_stmt
+  : mark_pos IMPORT STRING
+  {
+    $$ = &ast.ImportStmt{P: positionFromMark(mtaillex), Name: $3}
+  }
+  ;
+
 /* Identifier or String parses where an ID or a string can be expected. */
 id_or_string
   : ID
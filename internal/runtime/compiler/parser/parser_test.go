@@ -54,6 +54,11 @@ var parserTests = []struct {
 		"counter foo by bar, baz, quux\n",
 	},
 
+	{
+		"declare counter with builtin named keys",
+		"counter foo by hour, weekday\n",
+	},
+
 	{
 		"declare hidden counter",
 		"hidden counter foo\n",
@@ -429,6 +434,65 @@ $foo =~ X {
   stop
 }`},
 
+	{"pragma", `
+pragma serial
+`},
+
+	{"import", `
+import "syslog"
+import "net/http"
+`},
+
+	{"typed constants", `
+const STRPTIME_FORMAT "Jan _2 15:04:05"
+const MAX_KEYS 100
+const RATIO 0.5
+const EXPIRY 24h
+counter a by b limit MAX_KEYS
+del a["x"] after EXPIRY
+`},
+
+	{"function declaration and call", `
+func status_class(code int) string {
+  return string(code / 100) + "xx"
+}
+func retry() bool {
+  return 1 > 0
+}
+counter a by class
+/(\d+)/ {
+  a[status_class($1)]++
+}
+`},
+
+	{"map constants", `
+const CLASS {"200": "ok", "404": "not found"}
+const WEIGHT {
+  1: 0.5,
+  2: 1,
+}
+counter a by class
+/(\d+)/ {
+  $1 in CLASS {
+    a[CLASS[$1]]++
+  }
+  a[lookup(CLASS, $1, "other")]++
+}
+`},
+
+	{"map variables", `
+hidden map host_dc
+hidden map seen {"a": 1}
+counter map by dc
+/(\S+) (\S+)/ {
+  host_dc[$1] = $2
+  $1 in host_dc {
+    map[lookup(host_dc, $1, "unknown")]++
+  }
+  del host_dc[$1]
+}
+`},
+
 	{"substitution", `
 /(\d,\d)/ {
   subst(",", ",", $1)
@@ -438,6 +502,13 @@ $foo =~ X {
 	/(\d,\d)/ {
 	    subst(/,/, "", $1)
 	}`},
+
+	{"string builtins", `
+/(.*)/ {
+  toupper(trim($1))
+  split($1, ":")[2]
+  sprintf("%s=%d", substr($1, 0, 4), index($1, ":"))
+}`},
 }
 
 func TestParserRoundTrip(t *testing.T) {
@@ -588,6 +659,12 @@ var parserInvalidPrograms = []parserInvalidProgram{
 		"counter foo by a limit 10, b",
 		[]string{"dimensioned limit per dimension:1:26: syntax error: unexpected COMMA"},
 	},
+
+	{
+		"indexed builtin",
+		"tolower(\"a\")[0]\n",
+		[]string{"indexed builtin:1:1-12: Only the result of split() can be indexed."},
+	},
 }
 
 func TestParseInvalidPrograms(t *testing.T) {
@@ -636,8 +713,7 @@ var parsePositionTests = []struct {
 		"const ID\n" +
 			"/foo/ +\n" +
 			"/bar/",
-		// TODO: Update position for the first token to `1, 0, 4` when position tracking is fixed
-		[]*position.Position{{"multiline regex", 1, 4, 4}, {"multiline regex", 2, 0, 4}},
+		[]*position.Position{{"multiline regex", 1, 0, 4}, {"multiline regex", 2, 0, 4}},
 	},
 }
 
@@ -67,6 +67,12 @@ func (s *Sexp) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 		ast.Walk(s, v.ID)
 		s.emit(" ")
 
+	case *ast.ConstDecl:
+		s.emit("const ")
+		ast.Walk(s, v.ID)
+		s.emit(" ")
+		ast.Walk(s, v.Value)
+
 	case *ast.PatternLit:
 		s.emit(fmt.Sprintf("%q", v.Pattern))
 
@@ -120,6 +126,8 @@ func (s *Sexp) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 			s.emit("=~")
 		case NOT_MATCH:
 			s.emit("!~")
+		case IN:
+			s.emit("in")
 		default:
 			s.emit(fmt.Sprintf("Unexpected op: %s", Kind(v.Op)))
 		}
@@ -153,6 +161,13 @@ func (s *Sexp) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 			s.emit(")")
 		}
 
+	case *ast.MapDecl:
+		s.emit("hidden map " + v.Name)
+		if v.Init != nil {
+			s.emit(" ")
+			ast.Walk(s, v.Init)
+		}
+
 	case *ast.UnaryExpr:
 		switch v.Op {
 		case INC:
@@ -177,13 +192,29 @@ func (s *Sexp) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 	case *ast.FloatLit:
 		s.emit(strconv.FormatFloat(v.F, 'g', -1, 64))
 
+	case *ast.DurationLit:
+		s.emit(v.D.String())
+
+	case *ast.MapLit:
+		s.emit("map")
+		s.indent()
+		for i, k := range v.Keys {
+			s.newline()
+			ast.Walk(s, k)
+			s.emit(": ")
+			ast.Walk(s, v.Values[i])
+		}
+		s.outdent()
+
 	case *ast.NextStmt:
 		s.emit("next")
 	case *ast.OtherwiseStmt:
 		s.emit("otherwise")
 	case *ast.DelStmt:
 		s.emit("del")
-		if v.Expiry > 0 {
+		if v.ExpiryConst != "" {
+			s.emit(" after " + v.ExpiryConst)
+		} else if v.Expiry > 0 {
 			s.emit(fmt.Sprintf(" after %s", v.Expiry))
 		}
 
@@ -196,6 +227,13 @@ func (s *Sexp) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 	case *ast.StopStmt:
 		s.emit("stop")
 
+	case *ast.PragmaStmt:
+		s.emit(fmt.Sprintf("pragma %q", v.Name))
+
+	case *ast.ImportStmt:
+		s.emit(fmt.Sprintf("import %q", v.Name))
+		s.newline()
+
 	case *ast.DecoDecl:
 		s.emit(fmt.Sprintf("%q", v.Name))
 		s.newline()
@@ -205,6 +243,23 @@ func (s *Sexp) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 		s.emit(fmt.Sprintf("%q", v.Name))
 		s.newline()
 
+	case *ast.FuncDecl:
+		s.emit(fmt.Sprintf("%q", v.Name))
+		for _, p := range v.Params {
+			s.emit(fmt.Sprintf(" (%s %s)", p.Name, p.TypeName))
+		}
+		s.emit(" " + v.ReturnType)
+		s.newline()
+		s.emitScope(v.Scope)
+
+	case *ast.CallExpr:
+		s.emit(fmt.Sprintf("%q", v.Name))
+		s.newline()
+
+	case *ast.ReturnStmt:
+		s.emit("return")
+		s.newline()
+
 	case *ast.StmtList:
 		s.emitScope(v.Scope)
 
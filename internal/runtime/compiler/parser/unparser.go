@@ -91,6 +91,12 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 		u.emit(" ")
 		ast.Walk(u, v.Expr)
 
+	case *ast.ConstDecl:
+		u.emit("const ")
+		ast.Walk(u, v.ID)
+		u.emit(" ")
+		ast.Walk(u, v.Value)
+
 	case *ast.PatternLit:
 		u.emit("/" + strings.ReplaceAll(v.Pattern, "/", "\\/") + "/")
 
@@ -145,6 +151,8 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 			u.emit(" =~ ")
 		case NOT_MATCH:
 			u.emit(" !~ ")
+		case IN:
+			u.emit(" in ")
 		default:
 			u.emit(fmt.Sprintf("Unexpected op: %v", v.Op))
 		}
@@ -157,6 +165,22 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 		u.emit("$" + v.Name)
 
 	case *ast.BuiltinExpr:
+		u.emit(v.Name + "(")
+		if args, ok := v.Args.(*ast.ExprList); ok && v.Name == "split" && len(args.Children) == 3 {
+			ast.Walk(u, args.Children[0])
+			u.emit(", ")
+			ast.Walk(u, args.Children[1])
+			u.emit(")[")
+			ast.Walk(u, args.Children[2])
+			u.emit("]")
+			break
+		}
+		if v.Args != nil {
+			ast.Walk(u, v.Args)
+		}
+		u.emit(")")
+
+	case *ast.CallExpr:
 		u.emit(v.Name + "(")
 		if v.Args != nil {
 			ast.Walk(u, v.Args)
@@ -188,7 +212,9 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 		if len(v.Keys) > 0 {
 			u.emit(" by " + strings.Join(v.Keys, ", "))
 		}
-		if v.Limit > 0 {
+		if v.LimitConst != "" {
+			u.emit(" limit " + v.LimitConst)
+		} else if v.Limit > 0 {
 			u.emit(fmt.Sprintf(" limit %d", v.Limit))
 		}
 		if len(v.Buckets) > 0 {
@@ -200,6 +226,13 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 			u.emit(buckets.String()[:buckets.Len()-2])
 		}
 
+	case *ast.MapDecl:
+		u.emit("hidden map " + v.Name)
+		if v.Init != nil {
+			u.emit(" ")
+			ast.Walk(u, v.Init)
+		}
+
 	case *ast.UnaryExpr:
 		switch v.Op {
 		case INC:
@@ -226,6 +259,21 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 	case *ast.FloatLit:
 		u.emit(strconv.FormatFloat(v.F, 'g', -1, 64))
 
+	case *ast.DurationLit:
+		u.emit(v.D.String())
+
+	case *ast.MapLit:
+		u.emit("{")
+		for i, k := range v.Keys {
+			if i > 0 {
+				u.emit(", ")
+			}
+			ast.Walk(u, k)
+			u.emit(": ")
+			ast.Walk(u, v.Values[i])
+		}
+		u.emit("}")
+
 	case *ast.DecoDecl:
 		u.emit(fmt.Sprintf("def %s {", v.Name))
 		u.newline()
@@ -234,6 +282,23 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 		u.outdent()
 		u.emit("}")
 
+	case *ast.FuncDecl:
+		params := make([]string, 0, len(v.Params))
+		for _, p := range v.Params {
+			params = append(params, p.Name+" "+p.TypeName)
+		}
+		u.emit(fmt.Sprintf("func %s(%s) %s {", v.Name, strings.Join(params, ", "), v.ReturnType))
+		u.newline()
+		u.indent()
+		ast.Walk(u, v.Block)
+		u.outdent()
+		u.emit("}")
+
+	case *ast.ReturnStmt:
+		u.emit("return ")
+		ast.Walk(u, v.Expr)
+		u.newline()
+
 	case *ast.DecoStmt:
 		u.emit(fmt.Sprintf("@%s {", v.Name))
 		u.newline()
@@ -251,7 +316,9 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 	case *ast.DelStmt:
 		u.emit("del ")
 		ast.Walk(u, v.N)
-		if v.Expiry > 0 {
+		if v.ExpiryConst != "" {
+			u.emit(" after " + v.ExpiryConst)
+		} else if v.Expiry > 0 {
 			u.emit(fmt.Sprintf(" after %s", v.Expiry))
 		}
 		u.newline()
@@ -270,6 +337,12 @@ func (u *Unparser) VisitBefore(n ast.Node) (ast.Visitor, ast.Node) {
 	case *ast.StopStmt:
 		u.emit("stop")
 
+	case *ast.PragmaStmt:
+		u.emit("pragma " + v.Name)
+
+	case *ast.ImportStmt:
+		u.emit("import \"" + v.Name + "\"")
+
 	default:
 		panic(fmt.Sprintf("unfound undefined type %T", n))
 	}
@@ -20,6 +20,10 @@ const (
 	CaprefSymbol              // Capture group references
 	DecoSymbol                // Decorators
 	PatternSymbol             // Named pattern constants
+	ConstSymbol               // Named constants of other types
+	FuncSymbol                // Functions
+	ParamSymbol               // Function parameters
+	MapSymbol                 // Map variables
 	endSymbol                 // for testing
 )
 
@@ -33,6 +37,14 @@ func (k Kind) String() string {
 		return "decorator"
 	case PatternSymbol:
 		return "named pattern constant"
+	case ConstSymbol:
+		return "constant"
+	case FuncSymbol:
+		return "function"
+	case ParamSymbol:
+		return "parameter"
+	case MapSymbol:
+		return "map"
 	default:
 		panic("unexpected symbolkind")
 	}
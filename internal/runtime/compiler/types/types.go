@@ -152,6 +152,8 @@ func (t *Operator) Root() Type {
 
 func (t *Operator) String() (s string) {
 	switch l := len(t.Args); {
+	case t.Name == mapName && l == 2:
+		s = fmt.Sprintf("Map[%s]%s", t.Args[0], t.Args[1])
 	case l < 2:
 		s = t.Name
 		for _, a := range t.Args {
@@ -170,6 +172,7 @@ const (
 	functionName  = "→"
 	dimensionName = "⨯"
 	alternateName = "|"
+	mapName       = "Map"
 )
 
 // Function is a convenience method, which instantiates a new Function type
@@ -216,6 +219,20 @@ func IsAlternate(t Type) bool {
 	return false
 }
 
+// Map is a convenience method which instantiates a new Map type scheme, a
+// table of values of type value indexed by keys of type key.
+func Map(key, value Type) *Operator {
+	return &Operator{mapName, []Type{key, value}}
+}
+
+// IsMap returns true if the given type is a Map type.
+func IsMap(t Type) bool {
+	if v, ok := t.Root().(*Operator); ok {
+		return v.Name == mapName
+	}
+	return false
+}
+
 // IsComplete returns true if the type and all its arguments have non-variable exemplars.
 func IsComplete(t Type) bool {
 	switch v := t.Root().(type) {
@@ -243,6 +260,7 @@ var (
 	Float         = &Operator{"Float", []Type{}}
 	String        = &Operator{"String", []Type{}}
 	Pattern       = &Operator{"Pattern", []Type{}}
+	Duration      = &Operator{"Duration", []Type{}}
 	// TODO(jaq): use composite type so we can typecheck the bucket directly, e.g. hist[j] = i.
 	Buckets = &Operator{"Buckets", []Type{}}
 
@@ -258,12 +276,59 @@ var Builtins = map[string]Type{
 	"string":      Function(NewVariable(), String),
 	"timestamp":   Function(Int),
 	"len":         Function(String, Int),
-	"settime":     Function(Int, None),
+	"settime":     Function(Numeric, None),
 	"strptime":    Function(String, String, None),
 	"strtol":      Function(String, Int, Int),
 	"tolower":     Function(String, String),
 	"getfilename": Function(String),
 	"subst":       Function(Pattern, String, String, String),
+	"lookup":      lookupType(),
+	"toupper":     Function(String, String),
+	"trim":        Function(String, String),
+	"substr":      Function(String, Int, Int, String),
+	"split":       Function(String, String, Int, String),
+	"index":       Function(String, String, Int),
+	"hasprefix":   Function(String, String, Bool),
+	"hassuffix":   Function(String, String, Bool),
+	// sprintf takes any number of arguments after the format, so its
+	// arguments are checked specially.
+	"sprintf":  Function(String, String),
+	"duration": Function(String, Float),
+	"bytes":    Function(String, Int),
+	"ipnet":    Function(String, Int, String),
+	"incidr":   Function(String, String, Bool),
+	"isipv6":   Function(String, Bool),
+	"clientip": Function(String, String, String),
+	// Time builtins take a timestamp in seconds, which the checker converts
+	// to a Float so that fractions of a second are kept.
+	"strftime":  Function(String, Numeric, String),
+	"hour":      Function(Numeric, Int),
+	"weekday":   Function(Numeric, Int),
+	"dayofyear": Function(Numeric, Int),
+	"now":       Function(Int),
+	// timestamp_ns returns the timestamp register with its fraction of a
+	// second, in nanoseconds.
+	"timestamp_ns": Function(Int),
+	// Numeric builtins return the type of their arguments, and the checker
+	// chooses the Int or Float overload.
+	"abs":   Function(Numeric, Numeric),
+	"min":   Function(Numeric, Numeric, Numeric),
+	"max":   Function(Numeric, Numeric, Numeric),
+	"clamp": Function(Numeric, Numeric, Numeric, Numeric),
+	"floor": Function(Numeric, Numeric),
+	"ceil":  Function(Numeric, Numeric),
+	"round": Function(Numeric, Numeric),
+	"log":   Function(Numeric, Float),
+	"log10": Function(Numeric, Float),
+	"exp":   Function(Numeric, Float),
+	"sqrt":  Function(Numeric, Float),
+}
+
+// lookupType returns the type scheme of the lookup builtin, which takes a
+// map, a key, and a default value of the map's value type.
+func lookupType() Type {
+	v := NewVariable()
+	return Function(Map(String, v), String, v, v)
 }
 
 // FreshType returns a new type from the provided type scheme, replacing any
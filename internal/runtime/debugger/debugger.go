@@ -0,0 +1,423 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+// Package debugger provides an interactive, line oriented debugger that
+// steps an mtail program through the lines of a log file.
+package debugger
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/runtime/compiler"
+	"github.com/google/mtail/internal/runtime/vm"
+	"github.com/pkg/errors"
+)
+
+const prompt = "(mtail) "
+
+// Debugger holds the state of a debugging session of one program on one log.
+type Debugger struct {
+	name   string
+	source []string // program source, by zero-based line number
+	v      *vm.VM
+
+	logName string
+	logs    *bufio.Scanner
+	lineNum int              // number of the current log line, one-based
+	line    *logline.LogLine // current log line, or nil if none is being processed
+	eof     bool             // no more log lines
+
+	breakpoints map[int]struct{} // zero-based program source lines
+	lastCmd     string           // repeated on an empty command
+
+	out io.Writer
+}
+
+// New compiles the program source called name with the compiler options
+// given, and creates a Debugger that runs it over the lines read from logs,
+// writing its output to out.
+func New(name string, source []byte, logName string, logs io.Reader, out io.Writer, loc *time.Location, options ...compiler.Option) (*Debugger, error) {
+	c, err := compiler.New(options...)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := c.Compile(name, bytes.NewReader(source))
+	if err != nil {
+		return nil, errors.Wrapf(err, "compile failed for %s", name)
+	}
+	return &Debugger{
+		name:        name,
+		source:      strings.Split(string(source), "\n"),
+		v:           vm.New(name, obj, true, loc, false, true),
+		logName:     logName,
+		logs:        bufio.NewScanner(logs),
+		breakpoints: make(map[int]struct{}),
+		out:         out,
+	}, nil
+}
+
+// Run reads commands from in until it is exhausted or a quit command is read.
+func (d *Debugger) Run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprintf(d.out, "Debugging %s on %s.  Type `help' for a list of commands.\n", d.name, d.logName)
+	for {
+		fmt.Fprint(d.out, prompt)
+		if !scanner.Scan() {
+			fmt.Fprintln(d.out)
+			return scanner.Err()
+		}
+		cmd := strings.TrimSpace(scanner.Text())
+		if cmd == "" {
+			cmd = d.lastCmd
+		}
+		d.lastCmd = cmd
+		if cmd == "" {
+			continue
+		}
+		if quit := d.Execute(cmd); quit {
+			return nil
+		}
+	}
+}
+
+// Execute runs a single debugger command, and reports whether the session should end.
+func (d *Debugger) Execute(cmd string) (quit bool) {
+	fields := strings.Fields(cmd)
+	args := fields[1:]
+	switch fields[0] {
+	case "q", "quit", "exit":
+		return true
+	case "h", "help":
+		d.help()
+	case "b", "break":
+		d.setBreakpoint(args)
+	case "d", "delete":
+		d.deleteBreakpoint(args)
+	case "i", "info":
+		d.info()
+	case "s", "step":
+		d.step()
+	case "n", "next":
+		d.next()
+	case "c", "continue":
+		d.cont()
+	case "l", "list":
+		d.list()
+	case "bt", "stack":
+		d.stack()
+	case "m", "matches":
+		d.matches()
+	case "t", "time":
+		d.timestamp()
+	case "p", "print", "metrics":
+		d.metrics(args)
+	case "input":
+		d.input()
+	case "trace":
+		d.trace()
+	case "disasm":
+		fmt.Fprint(d.out, d.v.DumpByteCode())
+	default:
+		fmt.Fprintf(d.out, "Unknown command %q.  Type `help' for a list of commands.\n", fields[0])
+	}
+	return false
+}
+
+func (d *Debugger) help() {
+	w := tabwriter.NewWriter(d.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "break N\tb\tstop before executing program line N")
+	fmt.Fprintln(w, "delete N\td\tremove the breakpoint on program line N")
+	fmt.Fprintln(w, "info\ti\tlist breakpoints")
+	fmt.Fprintln(w, "step\ts\texecute one instruction")
+	fmt.Fprintln(w, "next\tn\texecute until the program line changes")
+	fmt.Fprintln(w, "continue\tc\texecute until a breakpoint or the end of the log")
+	fmt.Fprintln(w, "list\tl\tshow the program source around the current line")
+	fmt.Fprintln(w, "stack\tbt\tshow the data stack")
+	fmt.Fprintln(w, "matches\tm\tshow the capture groups of the current log line")
+	fmt.Fprintln(w, "time\tt\tshow the timestamp register")
+	fmt.Fprintln(w, "print [NAME]\tp\tshow the values of all metrics, or just NAME")
+	fmt.Fprintln(w, "input\t\tshow the current log line")
+	fmt.Fprintln(w, "trace\t\tshow the instructions executed on the current log line")
+	fmt.Fprintln(w, "disasm\t\tshow the program bytecode")
+	fmt.Fprintln(w, "quit\tq\tend the session")
+	w.Flush()
+	fmt.Fprintln(d.out, "An empty command repeats the previous one.")
+}
+
+// parseLine parses a one-based program line number from args.
+func (d *Debugger) parseLine(args []string) (int, bool) {
+	if len(args) != 1 {
+		fmt.Fprintln(d.out, "A program line number is required.")
+		return 0, false
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(d.source) {
+		fmt.Fprintf(d.out, "Invalid program line %q.\n", args[0])
+		return 0, false
+	}
+	return n - 1, true
+}
+
+func (d *Debugger) setBreakpoint(args []string) {
+	line, ok := d.parseLine(args)
+	if !ok {
+		return
+	}
+	for _, i := range d.v.Program() {
+		if i.SourceLine == line {
+			d.breakpoints[line] = struct{}{}
+			fmt.Fprintf(d.out, "Breakpoint at %s:%d\n", d.name, line+1)
+			return
+		}
+	}
+	fmt.Fprintf(d.out, "No code on %s:%d\n", d.name, line+1)
+}
+
+func (d *Debugger) deleteBreakpoint(args []string) {
+	line, ok := d.parseLine(args)
+	if !ok {
+		return
+	}
+	if _, ok := d.breakpoints[line]; !ok {
+		fmt.Fprintf(d.out, "No breakpoint at %s:%d\n", d.name, line+1)
+		return
+	}
+	delete(d.breakpoints, line)
+}
+
+func (d *Debugger) info() {
+	if len(d.breakpoints) == 0 {
+		fmt.Fprintln(d.out, "No breakpoints.")
+		return
+	}
+	lines := make([]int, 0, len(d.breakpoints))
+	for l := range d.breakpoints {
+		lines = append(lines, l)
+	}
+	sort.Ints(lines)
+	for _, l := range lines {
+		fmt.Fprintf(d.out, "Breakpoint at %s:%d\n", d.name, l+1)
+	}
+}
+
+// nextLine starts the program on the next log line, and reports whether there was one.
+func (d *Debugger) nextLine() bool {
+	if !d.eof && !d.logs.Scan() {
+		d.eof = true
+		if err := d.logs.Err(); err != nil {
+			fmt.Fprintf(d.out, "Error reading %s: %s\n", d.logName, err)
+		}
+	}
+	if d.eof {
+		fmt.Fprintf(d.out, "End of %s.\n", d.logName)
+		return false
+	}
+	d.lineNum++
+	d.line = logline.New(context.Background(), d.logName, d.logs.Text())
+	d.v.StartLine(d.line)
+	fmt.Fprintf(d.out, "%s:%d: %s\n", d.logName, d.lineNum, d.line.Line)
+	return true
+}
+
+// sourceLine returns the program line of the next instruction, or -1 if the program has finished.
+func (d *Debugger) sourceLine() int {
+	pc := d.v.State().PC
+	prog := d.v.Program()
+	if d.line == nil || pc >= len(prog) {
+		return -1
+	}
+	return prog[pc].SourceLine
+}
+
+// stepInstr executes the next instruction of the current log line.
+func (d *Debugger) stepInstr() {
+	if d.v.Step() {
+		fmt.Fprintf(d.out, "Finished %s:%d.\n", d.logName, d.lineNum)
+		d.line = nil
+	}
+}
+
+// step executes one instruction.  If no log line is being processed, the
+// next one is started instead, stopping before its first instruction.
+func (d *Debugger) step() {
+	if d.line == nil {
+		if d.nextLine() {
+			d.where()
+		}
+		return
+	}
+	d.stepInstr()
+	d.where()
+}
+
+// next executes instructions until the program line changes.
+func (d *Debugger) next() {
+	if d.line == nil {
+		if d.nextLine() {
+			d.where()
+		}
+		return
+	}
+	start := d.sourceLine()
+	for d.line != nil && d.sourceLine() == start {
+		d.stepInstr()
+	}
+	d.where()
+}
+
+// cont executes instructions, starting new log lines as needed, until the
+// program enters a line with a breakpoint or the log is exhausted.
+func (d *Debugger) cont() {
+	prev := d.sourceLine()
+	for {
+		if d.line == nil {
+			if !d.nextLine() {
+				return
+			}
+			prev = -1
+		}
+		cur := d.sourceLine()
+		if _, ok := d.breakpoints[cur]; ok && cur != prev {
+			fmt.Fprintf(d.out, "Breakpoint at %s:%d\n", d.name, cur+1)
+			d.where()
+			return
+		}
+		prev = cur
+		d.stepInstr()
+	}
+}
+
+// where prints the next instruction to be executed and its program line.
+func (d *Debugger) where() {
+	pc := d.v.State().PC
+	if d.line == nil || pc >= len(d.v.Program()) {
+		return
+	}
+	i := d.v.Program()[pc]
+	fmt.Fprintf(d.out, "%s:%d pc=%d %s %v\t%s\n", d.name, i.SourceLine+1, pc, i.Opcode, i.Operand, strings.TrimSpace(d.source[i.SourceLine]))
+}
+
+func (d *Debugger) list() {
+	cur := d.sourceLine()
+	from, to := 0, len(d.source)
+	if cur >= 0 {
+		from, to = cur-5, cur+6
+	}
+	if from < 0 {
+		from = 0
+	}
+	if to > len(d.source) {
+		to = len(d.source)
+	}
+	for l := from; l < to; l++ {
+		marker := " "
+		if l == cur {
+			marker = ">"
+		}
+		bp := " "
+		if _, ok := d.breakpoints[l]; ok {
+			bp = "*"
+		}
+		fmt.Fprintf(d.out, "%s%s%4d  %s\n", bp, marker, l+1, d.source[l])
+	}
+}
+
+func (d *Debugger) stack() {
+	s := d.v.State().Stack
+	if len(s) == 0 {
+		fmt.Fprintln(d.out, "Stack is empty.")
+		return
+	}
+	for i := len(s) - 1; i >= 0; i-- {
+		fmt.Fprintf(d.out, "%3d: %T %v\n", i, s[i], s[i])
+	}
+}
+
+func (d *Debugger) matches() {
+	m := d.v.State().Matches
+	if len(m) == 0 {
+		fmt.Fprintln(d.out, "No matches.")
+		return
+	}
+	indexes := make([]int, 0, len(m))
+	for i := range m {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		fmt.Fprintf(d.out, "/%s/\n", d.v.Regexp(i))
+		for j, group := range m[i] {
+			fmt.Fprintf(d.out, "  $%d = %q\n", j, group)
+		}
+	}
+}
+
+func (d *Debugger) timestamp() {
+	t := d.v.State().Time
+	if t.IsZero() {
+		fmt.Fprintln(d.out, "Timestamp not set.")
+		return
+	}
+	fmt.Fprintln(d.out, t.Format(time.RFC3339Nano))
+}
+
+func (d *Debugger) metrics(args []string) {
+	found := false
+	for _, m := range d.v.Metrics {
+		if len(args) > 0 && m.Name != args[0] {
+			continue
+		}
+		found = true
+		writeMetric(d.out, m)
+	}
+	if !found && len(args) > 0 {
+		fmt.Fprintf(d.out, "No metric named %q.\n", args[0])
+	}
+}
+
+func writeMetric(w io.Writer, m *metrics.Metric) {
+	m.RLock()
+	defer m.RUnlock()
+	if len(m.LabelValues) == 0 {
+		fmt.Fprintf(w, "%s %s (no value)\n", m.Kind, m.Name)
+		return
+	}
+	for _, lv := range m.LabelValues {
+		labels := make([]string, 0, len(m.Keys))
+		for i, k := range m.Keys {
+			labels = append(labels, fmt.Sprintf("%s=%q", k, lv.Labels[i]))
+		}
+		if len(labels) == 0 {
+			fmt.Fprintf(w, "%s %s = %s\n", m.Kind, m.Name, lv.Value.ValueString())
+			continue
+		}
+		fmt.Fprintf(w, "%s %s{%s} = %s\n", m.Kind, m.Name, strings.Join(labels, ","), lv.Value.ValueString())
+	}
+}
+
+func (d *Debugger) input() {
+	if d.line == nil {
+		fmt.Fprintln(d.out, "No log line is being processed.")
+		return
+	}
+	fmt.Fprintf(d.out, "%s:%d: %s\n", d.logName, d.lineNum, d.line.Line)
+}
+
+func (d *Debugger) trace() {
+	prog := d.v.Program()
+	for _, pc := range d.v.Trace() {
+		i := prog[pc]
+		fmt.Fprintf(d.out, "%s:%d pc=%d %s %v\n", d.name, i.SourceLine+1, pc, i.Opcode, i.Operand)
+	}
+}
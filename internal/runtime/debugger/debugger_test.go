@@ -0,0 +1,144 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package debugger
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/runtime/compiler"
+	"github.com/google/mtail/internal/testutil"
+)
+
+const testProgram = `counter lines_total
+counter bytes_total by method
+
+/^(?P<method>[A-Z]+) (?P<size>\d+)$/ {
+  lines_total++
+  bytes_total[$method] += $size
+}
+`
+
+const testLog = "GET 10\nbogus\nPOST 5\n"
+
+var debuggerTests = []struct {
+	name     string
+	commands string
+	want     []string // substrings expected in order in the output
+}{
+	{
+		"breakpoint and inspect",
+		"break 6\nc\nmatches\nc\nc\np bytes_total\n",
+		[]string{
+			"Breakpoint at test.mtail:6\n",
+			"test.log:1: GET 10\n",
+			"Breakpoint at test.mtail:6\ntest.mtail:6 pc=6 push 0\t",
+			"  $1 = \"GET\"\n  $2 = \"10\"\n",
+			"test.log:2: bogus\nFinished test.log:2.\ntest.log:3: POST 5\nBreakpoint at test.mtail:6\n",
+			"End of test.log.\n",
+			"Counter bytes_total{method=\"GET\"} = 10\nCounter bytes_total{method=\"POST\"} = 5\n",
+		},
+	},
+	{
+		"step and next",
+		"s\ns\n\nbt\nn\nn\np lines_total\n",
+		[]string{
+			"test.log:1: GET 10\ntest.mtail:4 pc=0 match 0\t",
+			"test.mtail:4 pc=1 jnm ",
+			"test.mtail:4 pc=2 setmatched false",
+			"Stack is empty.\n",
+			"test.mtail:5 pc=3 mload 0",
+			"test.mtail:6 pc=6 push 0",
+			"Counter lines_total = 1\n",
+		},
+	},
+	{
+		"no code",
+		"break 2\nbreak 100\ninfo\ndelete 6\nfrob\nquit\np\n",
+		[]string{
+			"No code on test.mtail:2\n",
+			"Invalid program line \"100\".\n",
+			"No breakpoints.\n",
+			"No breakpoint at test.mtail:6\n",
+			"Unknown command \"frob\".",
+		},
+	},
+	{
+		"timestamp",
+		"time\nc\ntrace\n",
+		[]string{
+			"Timestamp not set.\n",
+			"End of test.log.\n",
+		},
+	},
+}
+
+func TestDebugger(t *testing.T) {
+	for _, tc := range debuggerTests {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			d, err := New("test.mtail", []byte(testProgram), "test.log", strings.NewReader(testLog), &out, time.UTC)
+			testutil.FatalIfErr(t, err)
+			testutil.FatalIfErr(t, d.Run(strings.NewReader(tc.commands)))
+			got := out.String()
+			for _, w := range tc.want {
+				i := strings.Index(got, w)
+				if i < 0 {
+					t.Fatalf("output missing %q, remaining output:\n%s", w, got)
+				}
+				got = got[i+len(w):]
+			}
+		})
+	}
+}
+
+func TestDebuggerQuit(t *testing.T) {
+	var out bytes.Buffer
+	d, err := New("test.mtail", []byte(testProgram), "test.log", strings.NewReader(testLog), &out, time.UTC)
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, d.Run(strings.NewReader("quit\nprint\n")))
+	if strings.Contains(out.String(), "Counter") {
+		t.Errorf("commands executed after quit:\n%s", out.String())
+	}
+}
+
+func TestDebuggerCompileError(t *testing.T) {
+	_, err := New("test.mtail", []byte("/(/ {}\n"), "test.log", strings.NewReader(testLog), &bytes.Buffer{}, time.UTC)
+	if err == nil {
+		t.Error("expected compile error")
+	}
+}
+
+func TestDebuggerImportPath(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, "mine.mtail"), []byte("const REQUEST /^(?P<method>[A-Z]+) (?P<size>\\d+)$/\n"), 0o600))
+	program := []byte("import \"mine\"\ncounter lines_total\nREQUEST {\n  lines_total++\n}\n")
+	if _, err := New("test.mtail", program, "test.log", strings.NewReader(testLog), &bytes.Buffer{}, time.UTC); err == nil {
+		t.Fatal("debugged a program importing a module not in the import path")
+	}
+	var out bytes.Buffer
+	d, err := New("test.mtail", program, "test.log", strings.NewReader(testLog), &out, time.UTC, compiler.ImportPath(dir))
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, d.Run(strings.NewReader("c\np lines_total\n")))
+	if !strings.Contains(out.String(), "Counter lines_total = 2\n") {
+		t.Errorf("output missing lines_total = 2:\n%s", out.String())
+	}
+}
+
+func TestDebuggerCompilerOptions(t *testing.T) {
+	program := []byte("counter lines_total\n/^[A-Z]+ \\d+$/ {\n  lines_total++\n}\n")
+	_, err := New("test.mtail", program, "test.log", strings.NewReader(testLog), &bytes.Buffer{}, time.UTC)
+	testutil.FatalIfErr(t, err)
+	if _, err := New("test.mtail", program, "test.log", strings.NewReader(testLog), &bytes.Buffer{}, time.UTC, compiler.MaxRegexpLength(5)); err == nil {
+		t.Error("debugged a program with a regexp longer than the maximum length")
+	}
+	if _, err := New("test.mtail", program, "test.log", strings.NewReader(testLog), &bytes.Buffer{}, time.UTC, compiler.MaxRecursionDepth(2)); err == nil {
+		t.Error("debugged a program deeper than the maximum recursion depth")
+	}
+}
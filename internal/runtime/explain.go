@@ -0,0 +1,58 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/runtime/compiler"
+	"github.com/google/mtail/internal/runtime/vm"
+	"github.com/pkg/errors"
+)
+
+// Explain runs the named program over a single line from filename and
+// returns the path it took.  The program is run on a new VM so the exported
+// metrics are unaffected, which also means any state the loaded program has
+// built up from earlier lines is not visible to it.
+func (r *Runtime) Explain(name, filename, line string) (*vm.Explanation, error) {
+	r.handleMu.RLock()
+	h, ok := r.handles[name]
+	r.handleMu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("no program named %q is loaded", name)
+	}
+	e, err := explainLines(r.c, name, bytes.NewReader(h.source), filename, []string{line}, r.syslogUseCurrentYear, r.overrideLocation)
+	if err != nil {
+		return nil, err
+	}
+	return e[0], nil
+}
+
+// ExplainLines compiles the program called name from source with the
+// compiler options given, and returns the path it took over each line from
+// filename in turn.
+func ExplainLines(name string, source io.Reader, filename string, lines []string, loc *time.Location, options ...compiler.Option) ([]*vm.Explanation, error) {
+	c, err := compiler.New(options...)
+	if err != nil {
+		return nil, err
+	}
+	return explainLines(c, name, source, filename, lines, true, loc)
+}
+
+func explainLines(c *compiler.Compiler, name string, source io.Reader, filename string, lines []string, syslogUseCurrentYear bool, loc *time.Location) ([]*vm.Explanation, error) {
+	obj, err := c.Compile(name, source)
+	if err != nil {
+		return nil, errors.Errorf("compile failed for %s:\n%s", name, err)
+	}
+	v := vm.New(name, obj, syslogUseCurrentYear, loc, false, false)
+	explanations := make([]*vm.Explanation, 0, len(lines))
+	for _, line := range lines {
+		explanations = append(explanations, v.Explain(logline.New(context.Background(), filename, line)))
+	}
+	return explanations, nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/runtime/compiler"
+	"github.com/google/mtail/internal/testutil"
+)
+
+func TestExplainLinesImportPath(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, "mine.mtail"), []byte("const WORD /^hello/\n"), 0o600))
+	program := `import "mine"
+counter matched
+WORD {
+  matched++
+}
+`
+	if _, err := ExplainLines("prog.mtail", strings.NewReader(program), "log", []string{"hello"}, time.UTC); err == nil {
+		t.Fatal("explained a program importing a module not in the import path")
+	}
+	e, err := ExplainLines("prog.mtail", strings.NewReader(program), "log", []string{"hello", "world"}, time.UTC, compiler.ImportPath(dir))
+	testutil.FatalIfErr(t, err)
+	if len(e) != 2 {
+		t.Fatalf("got %d explanations, want 2", len(e))
+	}
+	if len(e[0].Changes) != 1 || e[0].Changes[0].Metric != "matched" {
+		t.Errorf("line %q changed %v, want matched", e[0].Line, e[0].Changes)
+	}
+	if len(e[1].Changes) != 0 {
+		t.Errorf("line %q changed %v, want no changes", e[1].Line, e[1].Changes)
+	}
+}
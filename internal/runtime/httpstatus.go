@@ -4,11 +4,13 @@
 package runtime
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
 
+	"github.com/golang/glog"
 	"github.com/google/mtail/internal/runtime/vm"
 )
 
@@ -22,6 +24,7 @@ const loaderTemplate = `
 <th>load successes</th>
 <th>unloads</th>
 <th>runtime errors</th>
+<th>lines over budget</th>
 <th>last runtime error</th>
 </tr>
 <tr>
@@ -38,6 +41,7 @@ No compile errors
 <td>{{index $.Loadsuccess $name}}</td>
 <td>{{index $.Unloads $name}}</td>
 <td>{{index $.RuntimeErrors $name}}</td>
+<td>{{index $.BudgetExceeded $name}}</td>
 <td><pre>{{index $.RuntimeErrorString $name}}</pre></td>
 </tr>
 {{end}}
@@ -59,6 +63,7 @@ func (r *Runtime) WriteStatusHTML(w io.Writer) error {
 		Loadsuccess        map[string]string
 		Unloads            map[string]string
 		RuntimeErrors      map[string]string
+		BudgetExceeded     map[string]string
 		RuntimeErrorString map[string]string
 	}{
 		make(map[string]bool),
@@ -68,6 +73,7 @@ func (r *Runtime) WriteStatusHTML(w io.Writer) error {
 		make(map[string]string),
 		make(map[string]string),
 		make(map[string]string),
+		make(map[string]string),
 	}
 	for name := range r.programErrors {
 		if ProgLoadErrors.Get(name) != nil {
@@ -82,16 +88,41 @@ func (r *Runtime) WriteStatusHTML(w io.Writer) error {
 		if vm.ProgRuntimeErrors.Get(name) != nil {
 			data.RuntimeErrors[name] = vm.ProgRuntimeErrors.Get(name).String()
 		}
+		if vm.LineBudgetExceeded.Get(name) != nil {
+			data.BudgetExceeded[name] = vm.LineBudgetExceeded.Get(name).String()
+		}
 		r.handleMu.RLock()
 		if h, ok := r.handles[name]; ok {
 			data.ProgLoaded[name] = true
-			data.RuntimeErrorString[name] = h.vm.RuntimeErrorString()
+			data.RuntimeErrorString[name] = h.runtimeErrorString()
 		}
 		r.handleMu.RUnlock()
 	}
 	return t.Execute(w, data)
 }
 
+// writeProfile writes the execution profile of the program in handle, either
+// as a pprof protocol buffer or as an annotated source listing.
+func (r *Runtime) writeProfile(w http.ResponseWriter, prog string, handle *vmHandle, pprof bool) {
+	profile := handle.profile()
+	if profile == nil {
+		http.Error(w, "Profiling is not enabled", http.StatusNotFound)
+		return
+	}
+	var err error
+	if pprof {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", prog+".pprof"))
+		err = vm.WritePprof(w, prog, profile)
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err = vm.WriteAnnotatedSource(w, handle.source, profile)
+	}
+	if err != nil {
+		glog.Warning(err)
+	}
+}
+
 func (r *Runtime) ProgzHandler(w http.ResponseWriter, req *http.Request) {
 	prog := req.URL.Query().Get("prog")
 	if prog != "" {
@@ -102,8 +133,20 @@ func (r *Runtime) ProgzHandler(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, "No program found", http.StatusNotFound)
 			return
 		}
-		fmt.Fprint(w, handle.vm.DumpByteCode())
-		fmt.Fprintf(w, "\nLast runtime error:\n%s", handle.vm.RuntimeErrorString())
+		switch req.URL.Query().Get("profile") {
+		case "":
+		case "1":
+			r.writeProfile(w, prog, handle, false)
+			return
+		case "pprof":
+			r.writeProfile(w, prog, handle, true)
+			return
+		default:
+			http.Error(w, "The profile parameter must be 1 or pprof", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, handle.vm.DumpByteCode(handle.replicas...))
+		fmt.Fprintf(w, "\nLast runtime error:\n%s", handle.runtimeErrorString())
 		return
 	}
 	r.handleMu.RLock()
@@ -115,3 +158,63 @@ func (r *Runtime) ProgzHandler(w http.ResponseWriter, req *http.Request) {
 	}
 	fmt.Fprintf(w, "</ul>")
 }
+
+// ExplainHandler explains how the program named by the `prog' parameter
+// processes the log line in the `line' parameter, with `filename' optionally
+// setting the name of the log it came from.  The explanation is plain text,
+// or JSON if the `format' parameter is `json'.
+func (r *Runtime) ExplainHandler(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	prog := q.Get("prog")
+	if prog == "" {
+		http.Error(w, "The prog parameter is required", http.StatusBadRequest)
+		return
+	}
+	e, err := r.Explain(prog, q.Get("filename"), q.Get("line"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if q.Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(e); err != nil {
+			glog.Warning(err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, e)
+}
+
+// ShadowzHandler compares the metrics of the program named by the `prog'
+// parameter with those of its shadow.  The comparison is plain text, or JSON
+// if the `format' parameter is `json'.
+func (r *Runtime) ShadowzHandler(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	prog := q.Get("prog")
+	if prog == "" {
+		r.handleMu.RLock()
+		defer r.handleMu.RUnlock()
+		w.Header().Add("Content-type", "text/html")
+		fmt.Fprintf(w, "<ul>")
+		for prog := range r.shadows {
+			fmt.Fprintf(w, "<li><a href=\"?prog=%s\">%s</a></li>", prog, prog)
+		}
+		fmt.Fprintf(w, "</ul>")
+		return
+	}
+	d, err := r.DiffShadow(prog)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if q.Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(d); err != nil {
+			glog.Warning(err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, d)
+}
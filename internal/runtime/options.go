@@ -8,6 +8,7 @@ import (
 
 	"github.com/google/mtail/internal/runtime/compiler"
 	"github.com/google/mtail/internal/runtime/vm"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -78,7 +79,8 @@ func MaxRegexpLength(maxRegexpLength int) Option {
 	}
 }
 
-// MaxRecursionDepth sets the maximum depth the abstract syntax tree built during lexation can have.
+// MaxRecursionDepth sets the maximum depth the abstract syntax tree built during lexation can have,
+// and the maximum depth of nested calls to user-defined functions when a program runs.
 func MaxRecursionDepth(maxRecursionDepth int) Option {
 	return func(r *Runtime) error {
 		r.cOpts = append(r.cOpts, compiler.MaxRecursionDepth(maxRecursionDepth))
@@ -86,6 +88,15 @@ func MaxRecursionDepth(maxRecursionDepth int) Option {
 	}
 }
 
+// ImportPath sets the directories searched, in order, for the modules named in
+// import statements, before the standard library.
+func ImportPath(dirs ...string) Option {
+	return func(r *Runtime) error {
+		r.cOpts = append(r.cOpts, compiler.ImportPath(dirs...))
+		return nil
+	}
+}
+
 // OmitMetricSource instructs the Runtime to not annotate metrics with their program source when added to the metric store.
 func OmitMetricSource() Option {
 	return func(r *Runtime) error {
@@ -94,6 +105,13 @@ func OmitMetricSource() Option {
 	}
 }
 
+// Routes sends the lines of the logs that match each Route only to its programs.
+func Routes(routes []Route) Option {
+	return func(r *Runtime) error {
+		return r.SetRoutes(routes)
+	}
+}
+
 // PrometheusRegisterer passes in a registry for setting up exported metrics.
 func PrometheusRegisterer(reg prometheus.Registerer) Option {
 	return func(r *Runtime) error {
@@ -117,3 +135,81 @@ func TraceExecution() Option {
 		return nil
 	}
 }
+
+// VMReplicas sets the number of VMs that run each program, with lines shared
+// between them.  Programs declaring `pragma serial` or a map variable always
+// run on a single VM.
+func VMReplicas(n int) Option {
+	return func(r *Runtime) error {
+		if n < 1 {
+			return errors.Errorf("vm replicas must be at least 1, got %d", n)
+		}
+		r.vmReplicas = n
+		return nil
+	}
+}
+
+// LineInstructionBudget limits the number of instructions each VM may execute on a single log line.
+func LineInstructionBudget(n int) Option {
+	return func(r *Runtime) error {
+		r.vmOpts = append(r.vmOpts, vm.InstructionBudget(n))
+		return nil
+	}
+}
+
+// LineTimeBudget limits the time each VM may spend executing a single log line.
+func LineTimeBudget(d time.Duration) Option {
+	return func(r *Runtime) error {
+		r.vmOpts = append(r.vmOpts, vm.TimeBudget(d))
+		return nil
+	}
+}
+
+// MatchLengthBudget limits the length of the text each VM may match a regular expression against.
+func MatchLengthBudget(n int) Option {
+	return func(r *Runtime) error {
+		r.vmOpts = append(r.vmOpts, vm.MatchLengthBudget(n))
+		return nil
+	}
+}
+
+// Profile instructs each VM to profile the execution of its program.
+func Profile() Option {
+	return func(r *Runtime) error {
+		r.vmOpts = append(r.vmOpts, vm.Profile())
+		return nil
+	}
+}
+
+// RegisterMachine instructs the Runtime to execute programs on the experimental register-based VM.
+func RegisterMachine() Option {
+	return func(r *Runtime) error {
+		r.vmOpts = append(r.vmOpts, vm.RegisterMachine())
+		return nil
+	}
+}
+
+// WatchPrograms instructs the Runtime to reload programs when the program path
+// changes, once no further changes have been seen for the debounce interval.
+func WatchPrograms(debounce time.Duration) Option {
+	return func(r *Runtime) error {
+		if debounce < 0 {
+			return errors.Errorf("program watch debounce must not be negative, got %s", debounce)
+		}
+		r.watchPrograms = true
+		r.watchDebounce = debounce
+		return nil
+	}
+}
+
+// ProgramPollInterval sets the interval at which the program path is polled
+// for changes when filesystem notifications are not available.
+func ProgramPollInterval(d time.Duration) Option {
+	return func(r *Runtime) error {
+		if d <= 0 {
+			return errors.Errorf("program poll interval must be positive, got %s", d)
+		}
+		r.programPollInterval = d
+		return nil
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/mtail/internal/metrics"
+)
+
+// reloadSummary records what happened to a program's metrics when it was reloaded.
+type reloadSummary struct {
+	preserved []string // metrics whose datums were carried over
+	reset     []string // metrics that changed incompatibly, with the reason
+	dropped   []string // metrics no longer declared
+	added     []string // metrics newly declared
+}
+
+func (s reloadSummary) String() string {
+	var parts []string
+	for _, p := range []struct {
+		verb  string
+		names []string
+	}{
+		{"preserved", s.preserved},
+		{"reset", s.reset},
+		{"dropped", s.dropped},
+		{"added", s.added},
+	} {
+		if len(p.names) > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s (%s)", len(p.names), p.verb, strings.Join(p.names, ", ")))
+		}
+	}
+	if len(parts) == 0 {
+		return "no metrics"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// carryOverMetrics moves the datums of each metric in old, the metrics of the
+// previous version of a program, into the metric of the same name in new, if
+// its kind, type, keys, and buckets are unchanged.  Metrics that changed
+// start again from no datums.
+func carryOverMetrics(old, new []*metrics.Metric) (reloadSummary, error) {
+	var s reloadSummary
+	oldByName := make(map[string]*metrics.Metric, len(old))
+	for _, m := range old {
+		oldByName[m.Name] = m
+	}
+	for _, m := range new {
+		o, ok := oldByName[m.Name]
+		if !ok {
+			s.added = append(s.added, m.Name)
+			continue
+		}
+		delete(oldByName, m.Name)
+		if reason := m.ChangedFrom(o); reason != "" {
+			s.reset = append(s.reset, fmt.Sprintf("%s: %s", m.Name, reason))
+			continue
+		}
+		if err := m.CarryOver(o); err != nil {
+			return s, err
+		}
+		s.preserved = append(s.preserved, m.Name)
+	}
+	for name := range oldByName {
+		s.dropped = append(s.dropped, name)
+	}
+	for _, names := range [][]string{s.preserved, s.reset, s.dropped, s.added} {
+		sort.Strings(names)
+	}
+	return s, nil
+}
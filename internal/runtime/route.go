@@ -0,0 +1,88 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+import (
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"github.com/golang/groupcache/lru"
+	"github.com/pkg/errors"
+)
+
+// routeMemoSize is the number of filenames whose programs are remembered, so
+// that logs that come and go, like rotated logs with dated names, don't grow
+// the memo without bound.
+const routeMemoSize = 1024
+
+// Route sends the lines of the logs whose filenames match Pattern only to
+// the named Programs, instead of to every loaded program.
+type Route struct {
+	Pattern  string   // A glob pattern matched against the filename of each log line.
+	Programs []string // Names of the programs to send the lines to.
+}
+
+// routeTable maps log filenames to the programs their lines are sent to.  The
+// routes don't change once the table is made, and only the goroutine that
+// dispatches lines uses the memo, so the table needs no lock.
+type routeTable struct {
+	routes []Route
+	byFile *lru.Cache // memoised programs for each filename, nil for all programs
+}
+
+func newRouteTable(routes []Route) (*routeTable, error) {
+	for _, r := range routes {
+		if _, err := filepath.Match(r.Pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "route pattern %q", r.Pattern)
+		}
+	}
+	return &routeTable{routes: routes, byFile: lru.New(routeMemoSize)}, nil
+}
+
+// programsFor returns the set of programs the lines of the named log are
+// sent to, or nil if they are sent to every program.  The first route whose
+// pattern matches applies.  It must only be called by the goroutine that
+// dispatches lines.
+func (t *routeTable) programsFor(filename string) map[string]struct{} {
+	if len(t.routes) == 0 {
+		return nil
+	}
+	if programs, ok := t.byFile.Get(filename); ok {
+		return programs.(map[string]struct{})
+	}
+	var programs map[string]struct{}
+	for _, r := range t.routes {
+		if ok, _ := filepath.Match(r.Pattern, filename); ok || r.Pattern == filename {
+			programs = make(map[string]struct{}, len(r.Programs))
+			for _, p := range r.Programs {
+				programs[p] = struct{}{}
+			}
+			glog.V(1).Infof("Routing lines of %s to programs %v", filename, r.Programs)
+			break
+		}
+	}
+	t.byFile.Add(filename, programs)
+	return programs
+}
+
+// SetRoutes replaces the routes of log lines to programs.  Lines of logs that
+// no route matches are sent to every program.
+func (r *Runtime) SetRoutes(routes []Route) error {
+	t, err := newRouteTable(routes)
+	if err != nil {
+		return err
+	}
+	r.routes.Store(t)
+	return nil
+}
+
+// routedTo returns whether lines routed to programs, as returned by
+// programsFor, are sent to the named program.
+func routedTo(programs map[string]struct{}, prog string) bool {
+	if programs == nil {
+		return true
+	}
+	_, ok := programs[prog]
+	return ok
+}
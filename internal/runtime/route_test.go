@@ -0,0 +1,82 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/testutil"
+)
+
+const routeProgram = `counter lines_total
+/$/ {
+  lines_total++
+}
+`
+
+func TestRoutes(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	r, err := New(lines, &wg, "", store, Routes([]Route{{Pattern: "/var/log/nginx/*.log", Programs: []string{"nginx.mtail"}}}))
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+	for _, name := range []string{"nginx.mtail", "other.mtail"} {
+		testutil.FatalIfErr(t, r.CompileAndRun(name, strings.NewReader(routeProgram)))
+	}
+	count := func(prog string) float64 {
+		return snapshotMetrics(store.ProgramMetrics(prog))["lines_total"].value
+	}
+	await := func(nginx, other float64) {
+		t.Helper()
+		ok, err := testutil.DoOrTimeout(func() (bool, error) {
+			return count("nginx.mtail") == nginx && count("other.mtail") == other, nil
+		}, 5*time.Second, 10*time.Millisecond)
+		testutil.FatalIfErr(t, err)
+		if !ok {
+			t.Fatalf("lines_total nginx %v other %v, want %v and %v", count("nginx.mtail"), count("other.mtail"), nginx, other)
+		}
+	}
+
+	lines <- logline.New(context.Background(), "/var/log/nginx/access.log", "GET /")
+	lines <- logline.New(context.Background(), "/var/log/syslog", "hello")
+	await(2, 1)
+
+	// Replacing the routes forgets the filenames already routed.
+	testutil.FatalIfErr(t, r.SetRoutes([]Route{{Pattern: "/var/log/syslog", Programs: []string{"other.mtail"}}}))
+	lines <- logline.New(context.Background(), "/var/log/nginx/access.log", "GET /")
+	lines <- logline.New(context.Background(), "/var/log/syslog", "hello")
+	await(3, 3)
+
+	if err := r.SetRoutes([]Route{{Pattern: "[", Programs: []string{"other.mtail"}}}); err == nil {
+		t.Error("expected error for bad route pattern")
+	}
+}
+
+func TestRouteMemoIsBounded(t *testing.T) {
+	rt, err := newRouteTable([]Route{{Pattern: "/var/log/nginx/*.log", Programs: []string{"nginx.mtail"}}})
+	testutil.FatalIfErr(t, err)
+	for i := 0; i < 2*routeMemoSize; i++ {
+		rt.programsFor(fmt.Sprintf("/var/log/nginx/access-%d.log", i))
+	}
+	if n := rt.byFile.Len(); n > routeMemoSize {
+		t.Errorf("memo holds %d filenames, want at most %d", n, routeMemoSize)
+	}
+	if !routedTo(rt.programsFor("/var/log/nginx/access-0.log"), "nginx.mtail") {
+		t.Error("a forgotten filename is no longer routed")
+	}
+	if routedTo(rt.programsFor("/var/log/nginx/access-0.log"), "other.mtail") {
+		t.Error("a forgotten filename is routed to every program")
+	}
+}
@@ -4,7 +4,8 @@
 package runtime
 
 // mtail programs may be created, updated, and deleted while mtail is running, and they will be
-// reloaded without having to restart the mtail process -- mtail will handle these on a HUP signal.
+// reloaded without having to restart the mtail process -- mtail will handle these on a HUP signal,
+// or as they happen if the program path is watched.
 
 import (
 	"bytes"
@@ -16,6 +17,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -24,6 +26,7 @@ import (
 	"github.com/google/mtail/internal/metrics"
 	"github.com/google/mtail/internal/runtime/compiler"
 	"github.com/google/mtail/internal/runtime/vm"
+	"github.com/google/mtail/internal/selfmetrics"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -37,6 +40,15 @@ var (
 	ProgUnloads = expvar.NewMap("prog_unloads_total")
 	// ProgLoadErrors counts the number of program load errors.
 	ProgLoadErrors = expvar.NewMap("prog_load_errors_total")
+
+	// SelfMetrics describes the counters above for export to Prometheus.
+	SelfMetrics = []selfmetrics.Var{
+		selfmetrics.Counter(LineCount, "lines_total", "number of lines received by the program loader"),
+		selfmetrics.CounterMap(ProgLoads, "prog_loads_total", "number of program load events by program source filename", "prog"),
+		selfmetrics.CounterMap(ProgUnloads, "prog_unloads_total", "number of program unload events by program source filename", "prog"),
+		selfmetrics.CounterMap(ProgLoadErrors, "prog_load_errors_total", "number of errors encountered when loading per program source filename", "prog"),
+		selfmetrics.CounterMap(ShadowLinesDropped, "shadow_lines_dropped_total", "number of lines not processed by a program's shadow because it was busy", "prog"),
+	}
 )
 
 const (
@@ -47,6 +59,13 @@ const (
 // directory for filesystem changes.  Any compile errors are stored for later retrieival.
 // This function returns an error if an internal error occurs.
 func (r *Runtime) LoadAllPrograms() error {
+	r.loadMu.Lock()
+	defer r.loadMu.Unlock()
+	return r.loadAllPrograms()
+}
+
+// loadAllPrograms implements LoadAllPrograms.  The caller holds loadMu.
+func (r *Runtime) loadAllPrograms() error {
 	if r.programPath == "" {
 		glog.V(2).Info("Programpath is empty, loading nothing")
 		return nil
@@ -136,8 +155,9 @@ func (r *Runtime) LoadProgram(programPath string) error {
 // CompileAndRun compiles a program read from the input, starting execution if
 // it succeeds.  If an existing virtual machine of the same name already
 // exists, the previous virtual machine is terminated and the new loaded over
-// it.  If the new program fails to compile, any existing virtual machine with
-// the same name remains running.
+// it, unless the source is unchanged and imports no modules.  If the new
+// program fails to compile, any existing virtual machine with the same name
+// remains running.
 func (r *Runtime) CompileAndRun(name string, input io.Reader) error {
 	glog.V(2).Infof("CompileAndRun %s", name)
 	var buf bytes.Buffer
@@ -151,11 +171,12 @@ func (r *Runtime) CompileAndRun(name string, input io.Reader) error {
 	r.handleMu.RLock()
 	vh, ok := r.handles[name]
 	r.handleMu.RUnlock()
-	if ok && bytes.Equal(vh.contentHash, contentHash) {
+	if ok && !vh.imports && bytes.Equal(vh.contentHash, contentHash) {
 		glog.V(1).Infof("contents match, not recompiling %q", name)
 		return nil
 	}
-	obj, errs := r.c.Compile(name, &buf)
+	source := buf.Bytes()
+	obj, errs := r.compile(name, &buf)
 	if errs != nil {
 		ProgLoadErrors.Add(name, 1)
 		return errors.Errorf("compile failed for %s:\n%s", name, errs)
@@ -164,49 +185,138 @@ func (r *Runtime) CompileAndRun(name string, input io.Reader) error {
 		ProgLoadErrors.Add(name, 1)
 		return errors.Errorf("internal error: compilation failed for %s: no program returned, but no errors", name)
 	}
-	v := vm.New(name, obj, r.syslogUseCurrentYear, r.overrideLocation, r.logRuntimeErrors, r.trace)
+	v := vm.New(name, obj, r.syslogUseCurrentYear, r.overrideLocation, r.logRuntimeErrors, r.trace, r.vmOpts...)
+	// Each replica shares the program's metrics, which are safe for concurrent
+	// update, but has its own execution state.  Programs that carry state from
+	// one line to the next, by `pragma serial` or in a map variable, are never
+	// replicated.
+	var replicas []*vm.VM
+	if !obj.Serial {
+		for i := 1; i < r.vmReplicas; i++ {
+			replicas = append(replicas, vm.New(name, obj, r.syslogUseCurrentYear, r.overrideLocation, r.logRuntimeErrors, r.trace, r.vmOpts...))
+		}
+	} else if r.vmReplicas > 1 {
+		glog.Infof("Program %s is serial, not replicating", name)
+	}
 
 	if r.dumpBytecode {
 		glog.Info("Dumping program objects and bytecode\n", v.DumpByteCode())
 	}
 
+	r.handleMu.Lock()
+	defer r.handleMu.Unlock()
+	// Carry over the state of the metrics of the previous version of the
+	// program, if any, so that a reload doesn't reset counters that haven't
+	// changed.  The previous VM's metrics include hidden ones, which aren't in
+	// the store.  Its VMs are stopped first, so that no datum they create
+	// while finishing their last lines is left behind on the old metrics; they
+	// are restarted if the new version can't be loaded.
+	handle, loaded := r.handles[name]
+	var old []*metrics.Metric
+	if loaded {
+		handle.stop()
+		old = handle.vm.Metrics
+	} else {
+		old = r.ms.ProgramMetrics(name)
+	}
+	summary, err := carryOverMetrics(old, v.Metrics)
+	if err != nil {
+		if loaded {
+			r.start(handle)
+		}
+		ProgLoadErrors.Add(name, 1)
+		return errors.Wrapf(err, "carrying over metrics of %s", name)
+	}
+
 	// Load the metrics from the compilation into the global metric storage for export.
+	var exported []*metrics.Metric
 	for _, m := range v.Metrics {
 		if !m.Hidden {
 			if r.omitMetricSource {
 				m.Source = ""
 			}
-			err := r.ms.Add(m)
-			if err != nil {
-				return err
-			}
+			exported = append(exported, m)
 		}
 	}
+	if err := r.ms.ReplaceProgram(name, exported); err != nil {
+		if loaded {
+			r.start(handle)
+		}
+		ProgLoadErrors.Add(name, 1)
+		return err
+	}
 
 	ProgLoads.Add(name, 1)
-	glog.Infof("Loaded program %s", name)
+	if len(old) > 0 {
+		glog.Infof("Reloaded program %s: %s", name, summary)
+	} else {
+		glog.Infof("Loaded program %s", name)
+	}
 
 	if r.compileOnly {
 		return nil
 	}
 
-	r.handleMu.Lock()
-	defer r.handleMu.Unlock()
-	// Terminates the existing vm.
-	if handle, ok := r.handles[name]; ok {
-		close(handle.lines)
-	}
-	lines := make(chan *logline.LogLine)
-	r.handles[name] = &vmHandle{contentHash: contentHash, vm: v, lines: lines}
-	r.wg.Add(1)
-	go v.Run(lines, &r.wg)
+	h := &vmHandle{contentHash: contentHash, imports: obj.Imports, source: source, vm: v, replicas: replicas}
+	r.handles[name] = h
+	r.start(h)
 	return nil
 }
 
+// start runs the VMs of the handle h on a new lines channel.  Replicas read
+// from the same channel, so each line is processed by exactly one VM.
+func (r *Runtime) start(h *vmHandle) {
+	lines := make(chan *logline.LogLine)
+	h.lines = lines
+	vms := append([]*vm.VM{h.vm}, h.replicas...)
+	r.wg.Add(len(vms))
+	h.running.Add(len(vms))
+	for _, v := range vms {
+		go func(v *vm.VM) {
+			defer r.wg.Done()
+			v.Run(lines, &h.running)
+		}(v)
+	}
+}
+
 type vmHandle struct {
 	contentHash []byte
-	vm          *vm.VM
+	imports     bool     // the program imports modules, which may have changed even if the source hasn't
+	source      []byte   // the program source text
+	vm          *vm.VM   // the program's VM
+	replicas    []*vm.VM // additional VMs sharing the program's lines, if any
 	lines       chan *logline.LogLine
+	running     sync.WaitGroup // the VMs that are still running
+}
+
+// stop closes the handle's lines channel, and waits for its VMs to finish
+// processing the lines they have already received.
+func (h *vmHandle) stop() {
+	close(h.lines)
+	h.running.Wait()
+}
+
+// runtimeErrorString returns the last runtime error seen by any of the VMs in this handle.
+func (h *vmHandle) runtimeErrorString() string {
+	if s := h.vm.RuntimeErrorString(); s != "" {
+		return s
+	}
+	for _, replica := range h.replicas {
+		if s := replica.RuntimeErrorString(); s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// profile returns the combined execution profile of the VMs in this handle,
+// or nil if they are not profiling.
+func (h *vmHandle) profile() []vm.InstrProfile {
+	profiles := [][]vm.InstrProfile{h.vm.Profile()}
+	for _, replica := range h.replicas {
+		profiles = append(profiles, replica.Profile())
+	}
+	return vm.MergeProfiles(profiles...)
 }
 
 // Runtime handles the lifecycle of programs and virtual machines, by watching
@@ -221,10 +331,17 @@ type Runtime struct {
 	cOpts []compiler.Option // options for constructing `c`
 	c     *compiler.Compiler
 
+	vmOpts []vm.Option // options for constructing each VM
+
 	programPath string // Path that contains mtail programs.
 
-	handleMu sync.RWMutex         // guards accesses to handles
-	handles  map[string]*vmHandle // map of program names to virtual machines
+	handleMu sync.RWMutex             // guards accesses to handles
+	handles  map[string]*vmHandle     // map of program names to virtual machines
+	shadows  map[string]*shadowHandle // map of program names to shadow versions, also guarded by handleMu
+
+	loadMu sync.Mutex // serialises loading programs from the program path and changes made through the admin API
+
+	routes atomic.Pointer[routeTable] // which programs the lines of each log are sent to
 
 	programErrorMu sync.RWMutex     // guards access to programErrors
 	programErrors  map[string]error // errors from the last compile attempt of the program
@@ -237,6 +354,11 @@ type Runtime struct {
 	omitMetricSource     bool
 	logRuntimeErrors     bool // Instruct the VM to emit runtime errors to the log.
 	trace                bool // Trace execution of each VM.
+	vmReplicas           int  // Number of VMs to run for each program that is not serial.
+
+	watchPrograms       bool          // Reload programs when the program path changes.
+	watchDebounce       time.Duration // Quiet period after a change before programs are reloaded.
+	programPollInterval time.Duration // Interval to poll the program path when notifications are unavailable.
 
 	signalQuit chan struct{} // When closed stops the signal handler goroutine.
 }
@@ -250,9 +372,13 @@ func New(lines <-chan *logline.LogLine, wg *sync.WaitGroup, programPath string,
 		ms:            store,
 		programPath:   programPath,
 		handles:       make(map[string]*vmHandle),
+		shadows:       make(map[string]*shadowHandle),
 		programErrors: make(map[string]error),
 		signalQuit:    make(chan struct{}),
+
+		programPollInterval: defaultProgramPollInterval,
 	}
+	r.routes.Store(&routeTable{})
 	initDone := make(chan struct{})
 	defer close(initDone)
 	var err error
@@ -278,9 +404,17 @@ func New(lines <-chan *logline.LogLine, wg *sync.WaitGroup, programPath string,
 		<-initDone
 		for line := range lines {
 			LineCount.Add(1)
+			programs := r.routes.Load().programsFor(line.Filename)
 			r.handleMu.RLock()
 			for prog := range r.handles {
-				r.handles[prog].lines <- line
+				if routedTo(programs, prog) {
+					r.handles[prog].lines <- line
+				}
+			}
+			for prog := range r.shadows {
+				if routedTo(programs, prog) {
+					sendToShadow(prog, r.shadows[prog], line)
+				}
 			}
 			r.handleMu.RUnlock()
 		}
@@ -292,6 +426,10 @@ func New(lines <-chan *logline.LogLine, wg *sync.WaitGroup, programPath string,
 			close(r.handles[prog].lines)
 			delete(r.handles, prog)
 		}
+		for prog := range r.shadows {
+			close(r.shadows[prog].lines)
+			delete(r.shadows, prog)
+		}
 		r.handleMu.Unlock()
 	}()
 	if r.programPath == "" {
@@ -322,6 +460,14 @@ func New(lines <-chan *logline.LogLine, wg *sync.WaitGroup, programPath string,
 			}
 		}
 	}()
+	if r.watchPrograms {
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			<-initDone
+			r.watchProgramPath()
+		}()
+	}
 	// Guarantee all existing programmes get loaded before we leave.
 	if err := r.LoadAllPrograms(); err != nil {
 		return nil, err
@@ -344,7 +490,11 @@ func (r *Runtime) UnloadProgram(pathname string) {
 	name := filepath.Base(pathname)
 	r.handleMu.Lock()
 	defer r.handleMu.Unlock()
-	close(r.handles[name].lines)
+	handle, ok := r.handles[name]
+	if !ok {
+		return
+	}
+	close(handle.lines)
 	delete(r.handles, name)
 	ProgUnloads.Add(name, 1)
 }
@@ -1049,6 +1049,602 @@ a
 			},
 		},
 	},
+	{
+		name: "user-defined functions",
+		prog: `counter requests_total by class
+gauge fact_value
+
+func status_class(code int) string {
+  code >= 500 {
+    return "5xx"
+  }
+  return string(code / 100) + "xx"
+}
+
+func fact(n int) int {
+  n <= 1 {
+    return 1
+  }
+  return n * fact(n - 1)
+}
+
+/^(?P<code>\d+)$/ {
+  requests_total[status_class($code)]++
+  fact_value = fact(5)
+}
+`,
+		log: `200
+503
+404
+201
+`,
+		errs: 0,
+		metrics: metrics.MetricSlice{
+			{
+				Name:    "requests_total",
+				Program: "user-defined functions",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{"class"},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{"2xx"},
+						Value:  &datum.Int{Value: 2},
+					},
+					{
+						Labels: []string{"5xx"},
+						Value:  &datum.Int{Value: 1},
+					},
+					{
+						Labels: []string{"4xx"},
+						Value:  &datum.Int{Value: 1},
+					},
+				},
+			},
+			{
+				Name:    "fact_value",
+				Program: "user-defined functions",
+				Kind:    metrics.Gauge,
+				Type:    metrics.Int,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{},
+						Value:  &datum.Int{Value: 120},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "unbounded recursion",
+		prog: `counter c
+func forever(n int) int {
+  return forever(n + 1)
+}
+/.*/ {
+  c = forever(0)
+}
+`,
+		log: `a
+b
+`,
+		errs: 2,
+		metrics: metrics.MetricSlice{
+			{
+				Name:    "c",
+				Program: "unbounded recursion",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Value: &datum.Int{Value: 0},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "map constants",
+		prog: `counter requests_total by class
+counter unknown_total
+gauge scale
+
+const CLASS {
+  200: "ok",
+  404: "not found",
+}
+const UNITS {"ms": 0.001, "s": 1}
+
+/^(?P<code>\d+) (?P<unit>\w+)$/ {
+  $code in CLASS {
+    requests_total[CLASS[$code]]++
+  } else {
+    unknown_total++
+  }
+  scale = lookup(UNITS, $unit, 0.0)
+}
+`,
+		log: `200 ms
+404 s
+500 h
+`,
+		errs: 0,
+		metrics: metrics.MetricSlice{
+			{
+				Name:    "requests_total",
+				Program: "map constants",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{"class"},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{"ok"},
+						Value:  &datum.Int{Value: 1},
+					},
+					{
+						Labels: []string{"not found"},
+						Value:  &datum.Int{Value: 1},
+					},
+				},
+			},
+			{
+				Name:    "unknown_total",
+				Program: "map constants",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Value: &datum.Int{Value: 1},
+					},
+				},
+			},
+			{
+				Name:    "scale",
+				Program: "map constants",
+				Kind:    metrics.Gauge,
+				Type:    metrics.Float,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{},
+						Value:  &datum.Float{Valuebits: math.Float64bits(0)},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "string builtins",
+		prog: `counter requests_total by method, host, path
+counter www_total
+
+/^(?P<method>\w+) (?P<url>\S+)/ {
+  requests_total[toupper(trim($method)), split($url, "/")[2], sprintf("path=%s", substr($url, index($url, "/v"), 3))]++
+  hasprefix(split($url, "/")[2], "www.") && hassuffix($url, ".html") {
+    www_total++
+  }
+}
+`,
+		log: `get http://www.example.com/v1/index.html
+post http://api.example.com/v2/items
+`,
+		errs: 0,
+		metrics: metrics.MetricSlice{
+			{
+				Name:    "requests_total",
+				Program: "string builtins",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{"method", "host", "path"},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{"GET", "www.example.com", "path=/v1"},
+						Value:  &datum.Int{Value: 1},
+					},
+					{
+						Labels: []string{"POST", "api.example.com", "path=/v2"},
+						Value:  &datum.Int{Value: 1},
+					},
+				},
+			},
+			{
+				Name:    "www_total",
+				Program: "string builtins",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Value: &datum.Int{Value: 1},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "math builtins",
+		prog: `gauge percent
+gauge log_latency
+counter distance
+
+/^(?P<used>\d+) (?P<total>\d+) (?P<latency>\d+\.\d+)$/ {
+  percent = clamp(round(float($used) * 100 / max($total, 1)), 0, 100)
+  log_latency = log10($latency)
+  distance += min(abs($used - $total), 5)
+}
+`,
+		log: `25 200 100.0
+300 200 10.0
+`,
+		errs: 0,
+		metrics: metrics.MetricSlice{
+			{
+				Name:    "percent",
+				Program: "math builtins",
+				Kind:    metrics.Gauge,
+				Type:    metrics.Float,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{},
+						Value:  &datum.Float{Valuebits: math.Float64bits(100)},
+					},
+				},
+			},
+			{
+				Name:    "log_latency",
+				Program: "math builtins",
+				Kind:    metrics.Gauge,
+				Type:    metrics.Float,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{},
+						Value:  &datum.Float{Valuebits: math.Float64bits(1)},
+					},
+				},
+			},
+			{
+				Name:    "distance",
+				Program: "math builtins",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Value: &datum.Int{Value: 10},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "duration and size units",
+		prog: `gauge latency_seconds_total
+counter size_total
+
+/^(?P<latency>\S+) (?P<size>\S+)$/ {
+  latency_seconds_total += duration($latency)
+  size_total += bytes($size)
+}
+`,
+		log: `250ms 4KiB
+1m2.5s 1.1G
+125000µs 512
+slow 1K
+`,
+		errs: 1,
+		metrics: metrics.MetricSlice{
+			{
+				Name:    "latency_seconds_total",
+				Program: "duration and size units",
+				Kind:    metrics.Gauge,
+				Type:    metrics.Float,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{},
+						Value:  &datum.Float{Valuebits: math.Float64bits(62.875)},
+					},
+				},
+			},
+			{
+				Name:    "size_total",
+				Program: "duration and size units",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Value: &datum.Int{Value: 1100004608},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "network builtins",
+		prog: `counter requests_total by network
+counter internal_total
+counter ipv6_total
+
+/^(?P<peer>\S+) (?P<forwarded>\S+)$/ {
+  requests_total[ipnet(clientip($peer, $forwarded), 24)]++
+  incidr($peer, "10.0.0.0/8") {
+    internal_total++
+  }
+  isipv6($peer) {
+    ipv6_total++
+  }
+}
+`,
+		log: `10.0.0.2 198.51.100.7,10.0.0.3
+203.0.113.9 -
+10.1.1.1 198.51.100.200
+2001:db8::1 -
+unknown -
+`,
+		errs: 1,
+		metrics: metrics.MetricSlice{
+			{
+				Name:    "requests_total",
+				Program: "network builtins",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{"network"},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{"198.51.100.0/24"},
+						Value:  &datum.Int{Value: 2},
+					},
+					{
+						Labels: []string{"203.0.113.0/24"},
+						Value:  &datum.Int{Value: 1},
+					},
+					{
+						Labels: []string{"2001:d00::/24"},
+						Value:  &datum.Int{Value: 1},
+					},
+				},
+			},
+			{
+				Name:    "internal_total",
+				Program: "network builtins",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Value: &datum.Int{Value: 2},
+					},
+				},
+			},
+			{
+				Name:    "ipv6_total",
+				Program: "network builtins",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Value: &datum.Int{Value: 1},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "time builtins",
+		prog: `counter requests_total by hour, weekday
+text last_day
+
+/^(?P<ts>\S+)$/ {
+  strptime($ts, "2006-01-02T15:04:05.000Z07:00")
+  requests_total[hour(timestamp())][weekday(timestamp())]++
+  last_day = strftime("Mon Jan 2 (day ", timestamp()) + string(dayofyear(timestamp())) + ")"
+}
+`,
+		log: `2023-11-14T22:13:20.500Z
+2023-11-15T09:00:00.000+11:00
+2023-11-15T01:00:00.250Z
+`,
+		errs: 0,
+		metrics: metrics.MetricSlice{
+			{
+				Name:    "requests_total",
+				Program: "time builtins",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{"hour", "weekday"},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{"22", "2"},
+						Value:  &datum.Int{Value: 2},
+					},
+					{
+						Labels: []string{"1", "3"},
+						Value:  &datum.Int{Value: 1},
+					},
+				},
+			},
+			{
+				Name:    "last_day",
+				Program: "time builtins",
+				Kind:    metrics.Text,
+				Type:    metrics.String,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{},
+						Value:  &datum.String{Value: "Wed Nov 15 (day 319)"},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "nanosecond timestamps",
+		prog: `gauge start_ns by id
+gauge latency_ms by id
+gauge set_ns
+
+/^(?P<ts>\S+) (?P<id>\S+) start$/ {
+  strptime($ts, "2006-01-02T15:04:05.000Z07:00")
+  start_ns[$id] = timestamp_ns()
+}
+/^(?P<ts>\S+) (?P<id>\S+) end$/ {
+  strptime($ts, "2006-01-02T15:04:05.000Z07:00")
+  latency_ms[$id] = (timestamp_ns() - start_ns[$id]) / 1000000.0
+  del start_ns[$id]
+}
+/^set (?P<ts>\d+\.\d+)$/ {
+  settime($ts)
+  set_ns = timestamp_ns()
+}
+`,
+		log: `2023-11-14T22:13:20.125Z a start
+2023-11-14T22:13:20.250Z b start
+2023-11-14T22:13:20.467Z a end
+2023-11-14T22:13:21.002Z b end
+set 1700000000.125
+`,
+		errs: 0,
+		metrics: metrics.MetricSlice{
+			{
+				Name:        "start_ns",
+				Program:     "nanosecond timestamps",
+				Kind:        metrics.Gauge,
+				Type:        metrics.Int,
+				Keys:        []string{"id"},
+				LabelValues: []*metrics.LabelValue{},
+			},
+			{
+				Name:    "latency_ms",
+				Program: "nanosecond timestamps",
+				Kind:    metrics.Gauge,
+				Type:    metrics.Float,
+				Keys:    []string{"id"},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{"a"},
+						Value:  &datum.Float{Valuebits: math.Float64bits(342)},
+					},
+					{
+						Labels: []string{"b"},
+						Value:  &datum.Float{Valuebits: math.Float64bits(752)},
+					},
+				},
+			},
+			{
+				Name:    "set_ns",
+				Program: "nanosecond timestamps",
+				Kind:    metrics.Gauge,
+				Type:    metrics.Int,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{},
+						Value:  &datum.Int{Value: 1700000000125000000},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "missing map key",
+		prog: `counter c by class
+const CLASS {200: "ok"}
+/^(?P<code>\d+)$/ {
+  c[CLASS[$code]]++
+}
+`,
+		log: `200
+500
+`,
+		errs: 1,
+		metrics: metrics.MetricSlice{
+			{
+				Name:    "c",
+				Program: "missing map key",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{"class"},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{"ok"},
+						Value:  &datum.Int{Value: 1},
+					},
+				},
+			},
+		},
+	},
+	{
+		name: "map variables",
+		prog: `hidden map host_dc
+hidden map scale {"ms": 0.001, "s": 1}
+counter requests by dc
+gauge latency
+/^dc (?P<host>\S+) (?P<dc>\S+)$/ {
+  host_dc[$host] = $dc
+}
+/^gone (?P<host>\S+)$/ {
+  del host_dc[$host]
+}
+/^req (?P<host>\S+) (?P<t>\d+)(?P<unit>[a-z]+)$/ {
+  requests[lookup(host_dc, $host, "unknown")]++
+  $host in host_dc {
+    latency = $t * scale[$unit]
+  }
+}
+`,
+		log: `dc a east
+dc b west
+req a 5s
+req b 2ms
+gone a
+req a 1s
+`,
+		errs: 0,
+		metrics: metrics.MetricSlice{
+			{
+				Name:    "requests",
+				Program: "map variables",
+				Kind:    metrics.Counter,
+				Type:    metrics.Int,
+				Keys:    []string{"dc"},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{"east"},
+						Value:  &datum.Int{Value: 1},
+					},
+					{
+						Labels: []string{"west"},
+						Value:  &datum.Int{Value: 1},
+					},
+					{
+						Labels: []string{"unknown"},
+						Value:  &datum.Int{Value: 1},
+					},
+				},
+			},
+			{
+				Name:    "latency",
+				Program: "map variables",
+				Kind:    metrics.Gauge,
+				Type:    metrics.Float,
+				Keys:    []string{},
+				LabelValues: []*metrics.LabelValue{
+					{
+						Labels: []string{},
+						Value:  &datum.Float{Valuebits: math.Float64bits(0.002)},
+					},
+				},
+			},
+		},
+	},
 	{
 		name: "match a pattern in a binary expr",
 		prog: `const N /n/
@@ -1065,6 +1661,16 @@ N && 1 {
 }
 
 func TestRuntimeEndToEnd(t *testing.T) {
+	testRuntimeEndToEnd(t)
+}
+
+// The register machine must produce the same results as the stack machine.
+func TestRuntimeEndToEndRegisterMachine(t *testing.T) {
+	testRuntimeEndToEnd(t, RegisterMachine())
+}
+
+func testRuntimeEndToEnd(t *testing.T, options ...Option) {
+	t.Helper()
 	testutil.SkipIfShort(t)
 	if testing.Verbose() {
 		testutil.SetFlag(t, "vmodule", "vm=2,loader=2,checker=2")
@@ -1077,7 +1683,7 @@ func TestRuntimeEndToEnd(t *testing.T) {
 			store := metrics.NewStore()
 			lines := make(chan *logline.LogLine, 1)
 			var wg sync.WaitGroup
-			r, err := New(lines, &wg, "", store, ErrorsAbort(), DumpAst(), DumpAstTypes(), DumpBytecode(), OmitMetricSource(), TraceExecution())
+			r, err := New(lines, &wg, "", store, append([]Option{ErrorsAbort(), DumpAst(), DumpAstTypes(), DumpBytecode(), OmitMetricSource(), TraceExecution()}, options...)...)
 			testutil.FatalIfErr(t, err)
 			compileErrors := r.CompileAndRun(tc.name, strings.NewReader(tc.prog))
 			testutil.FatalIfErr(t, compileErrors)
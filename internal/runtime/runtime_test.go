@@ -4,14 +4,21 @@
 package runtime
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/google/mtail/internal/logline"
 	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/metrics/datum"
 	"github.com/google/mtail/internal/testutil"
 )
 
@@ -50,6 +57,146 @@ func TestCompileAndRun(t *testing.T) {
 	wg.Wait()
 }
 
+func TestCompileAndRunReplicas(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		program  string
+		replicas int
+	}{
+		{"parallel", "counter line_count\n/$/ {\n  line_count++\n}\n", 3},
+		{"serial", "pragma serial\ncounter line_count\n/$/ {\n  line_count++\n}\n", 0},
+		{"persist_time", "pragma persist_time\ncounter line_count\n/$/ {\n  line_count++\n}\n", 0},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			store := metrics.NewStore()
+			lines := make(chan *logline.LogLine)
+			var wg sync.WaitGroup
+			l, err := New(lines, &wg, "", store, VMReplicas(4))
+			testutil.FatalIfErr(t, err)
+			testutil.FatalIfErr(t, l.CompileAndRun(tc.name, strings.NewReader(tc.program)))
+			l.handleMu.RLock()
+			if got := len(l.handles[tc.name].replicas); got != tc.replicas {
+				t.Errorf("replicas: got %d, want %d", got, tc.replicas)
+			}
+			l.handleMu.RUnlock()
+			const n = 1000
+			for i := 0; i < n; i++ {
+				lines <- logline.New(context.Background(), "test", "line")
+			}
+			close(lines)
+			wg.Wait()
+			m := store.FindMetricOrNil("line_count", tc.name)
+			if m == nil {
+				t.Fatalf("metric not found in store: %v", store)
+			}
+			d, err := m.GetDatum()
+			testutil.FatalIfErr(t, err)
+			if got := datum.GetInt(d); got != n {
+				t.Errorf("line_count: got %d, want %d", got, n)
+			}
+		})
+	}
+}
+
+func TestExplainHandler(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, "", store)
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, l.CompileAndRun("explain", strings.NewReader("counter gets\n/^GET / {\n  gets++\n}\n")))
+
+	for _, tc := range []struct {
+		query    string
+		wantCode int
+		want     string
+	}{
+		{"?prog=explain&line=GET+/", http.StatusOK, "explain:3:3-8: inc gets 1\n"},
+		{"?prog=explain&line=PUT+/", http.StatusOK, "explain:2:1-9: /^GET / did not match\n"},
+		{"?prog=explain&line=GET+/&format=json", http.StatusOK, `"Message":"inc gets 1"`},
+		{"?prog=missing&line=GET+/", http.StatusNotFound, "no program named \"missing\" is loaded"},
+		{"?line=GET+/", http.StatusBadRequest, "The prog parameter is required"},
+	} {
+		w := httptest.NewRecorder()
+		l.ExplainHandler(w, httptest.NewRequest("GET", "/explainz"+tc.query, nil))
+		if w.Code != tc.wantCode {
+			t.Errorf("%s: status got %d, want %d", tc.query, w.Code, tc.wantCode)
+		}
+		if !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: body doesn't contain %q:\n%s", tc.query, tc.want, w.Body.String())
+		}
+	}
+	// Explaining doesn't change the exported metrics.
+	d, err := store.FindMetricOrNil("gets", "explain").GetDatum()
+	testutil.FatalIfErr(t, err)
+	if got := datum.GetInt(d); got != 0 {
+		t.Errorf("exported gets: got %d, want 0", got)
+	}
+	close(lines)
+	wg.Wait()
+}
+
+func TestProgzHandlerProfile(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, "", store, Profile())
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, l.CompileAndRun("prof", strings.NewReader("counter gets\n/^GET / {\n  gets++\n}\n")))
+
+	for _, tc := range []struct {
+		query    string
+		wantCode int
+		want     string
+	}{
+		{"?prog=prof", http.StatusOK, "Last runtime error:"},
+		{"?prog=prof&profile=1", http.StatusOK, "gets++"},
+		{"?prog=prof&profile=pprof", http.StatusOK, ""},
+		{"?prog=prof&profile=0", http.StatusBadRequest, "The profile parameter must be 1 or pprof"},
+		{"?prog=prof&profile=bogus", http.StatusBadRequest, "The profile parameter must be 1 or pprof"},
+		{"?prog=missing&profile=1", http.StatusNotFound, "No program found"},
+	} {
+		w := httptest.NewRecorder()
+		l.ProgzHandler(w, httptest.NewRequest("GET", "/progz"+tc.query, nil))
+		if w.Code != tc.wantCode {
+			t.Errorf("%s: status got %d, want %d", tc.query, w.Code, tc.wantCode)
+		}
+		if !strings.Contains(w.Body.String(), tc.want) {
+			t.Errorf("%s: body doesn't contain %q:\n%s", tc.query, tc.want, w.Body.String())
+		}
+	}
+	close(lines)
+	wg.Wait()
+}
+
+func TestProgzHandlerSumsReplicas(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	l, err := New(lines, &wg, "", store, VMReplicas(2))
+	testutil.FatalIfErr(t, err)
+	testutil.FatalIfErr(t, l.CompileAndRun("prof", strings.NewReader("counter gets\n/^GET / {\n  gets++\n}\n")))
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		lines <- logline.New(ctx, "test", "POST /form")
+		lines <- logline.New(ctx, "test", "GET /index.html")
+	}
+	var body string
+	ok, err := testutil.DoOrTimeout(func() (bool, error) {
+		w := httptest.NewRecorder()
+		l.ProgzHandler(w, httptest.NewRequest("GET", "/progz?prog=prof", nil))
+		body = w.Body.String()
+		return strings.Contains(body, "skip=10 hit=10"), nil
+	}, 5*time.Second, 10*time.Millisecond)
+	testutil.FatalIfErr(t, err)
+	if !ok {
+		t.Errorf("body doesn't contain the prefilter counts of all the replicas:\n%s", body)
+	}
+	close(lines)
+	wg.Wait()
+}
+
 var testProgram = "/$/ {}\n"
 
 var testProgFiles = []string{
@@ -79,3 +226,174 @@ func TestLoadProg(t *testing.T) {
 	close(lines)
 	wg.Wait()
 }
+
+func TestReloadPreservesMetrics(t *testing.T) {
+	v1 := `counter kept
+counter rekeyed
+counter dropped
+/(\w+)/ {
+  kept++
+  rekeyed++
+  dropped++
+}
+`
+	v2 := `# A comment moves every declaration down a line.
+counter kept
+counter rekeyed by word
+counter added
+/(\w+)/ {
+  kept++
+  rekeyed[$1]++
+  added++
+}
+`
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	r, err := New(lines, &wg, "", store)
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+	value := func(name string, labels ...string) int64 {
+		m := store.FindMetricOrNil(name, "prog.mtail")
+		if m == nil {
+			return -1
+		}
+		lv := m.FindLabelValueOrNil(labels)
+		if lv == nil {
+			return 0
+		}
+		return datum.GetInt(lv.Value)
+	}
+
+	testutil.FatalIfErr(t, r.CompileAndRun("prog.mtail", strings.NewReader(v1)))
+	lines <- logline.New(context.Background(), "log", "hello")
+	lines <- logline.New(context.Background(), "log", "world")
+	ok, err := testutil.DoOrTimeout(func() (bool, error) { return value("dropped") == 2, nil }, 5*time.Second, 10*time.Millisecond)
+	testutil.FatalIfErr(t, err)
+	if !ok {
+		t.Fatal("lines not processed by first version")
+	}
+
+	testutil.FatalIfErr(t, r.CompileAndRun("prog.mtail", strings.NewReader(v2)))
+	if got := value("kept"); got != 2 {
+		t.Errorf("kept = %d after reload, want 2", got)
+	}
+	if got := value("rekeyed", "hello"); got != 0 {
+		t.Errorf("rekeyed{word=hello} = %d after reload, want 0", got)
+	}
+	if got := value("dropped"); got != -1 {
+		t.Errorf("dropped metric still in store")
+	}
+	if got := len(store.Metrics["kept"]); got != 1 {
+		t.Errorf("%d kept metrics in store, want 1", got)
+	}
+
+	lines <- logline.New(context.Background(), "log", "hello")
+	ok, err = testutil.DoOrTimeout(func() (bool, error) { return value("added") == 1, nil }, 5*time.Second, 10*time.Millisecond)
+	testutil.FatalIfErr(t, err)
+	if !ok {
+		t.Fatal("line not processed by second version")
+	}
+	if got := value("kept"); got != 3 {
+		t.Errorf("kept = %d, want 3", got)
+	}
+	if got := value("rekeyed", "hello"); got != 1 {
+		t.Errorf("rekeyed{word=hello} = %d, want 1", got)
+	}
+}
+
+func TestReloadKeepsDatumsOfLastLines(t *testing.T) {
+	// Each line is long, and scanned several times before its datum is
+	// created, so that the previous VM is still busy when a reload starts.
+	prog := `counter seen by word
+/^word\d+x+$/ {
+  /^word\d+x+$/ {
+    /^(?P<word>word\d+)x+$/ {
+      seen[$word]++
+    }
+  }
+}
+`
+	padding := strings.Repeat("x", 1<<16)
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	r, err := New(lines, &wg, "", store)
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+	seen := func() int {
+		m := store.FindMetricOrNil("seen", "prog.mtail")
+		if m == nil {
+			return 0
+		}
+		m.RLock()
+		defer m.RUnlock()
+		return len(m.LabelValues)
+	}
+
+	// Each reload changes the source so the program is recompiled, and must
+	// carry over the datums created by the lines sent just before it.
+	const reloads, perReload = 20, 2
+	const n = reloads * perReload
+	for i := 0; i < reloads; i++ {
+		testutil.FatalIfErr(t, r.CompileAndRun("prog.mtail", strings.NewReader(strings.Repeat("\n", i%2)+prog)))
+		for j := 0; j < perReload; j++ {
+			lines <- logline.New(context.Background(), "log", "word"+strconv.Itoa(i*perReload+j)+padding)
+		}
+	}
+	ok, err := testutil.DoOrTimeout(func() (bool, error) { return seen() == n, nil }, 5*time.Second, 10*time.Millisecond)
+	testutil.FatalIfErr(t, err)
+	if !ok {
+		t.Errorf("seen has %d datums, want %d", seen(), n)
+	}
+}
+
+func TestReloadRecompilesImports(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	module := filepath.Join(dir, "words.mtail")
+	testutil.FatalIfErr(t, os.WriteFile(module, []byte("const WORD /^hello/\n"), 0o600))
+	program := `import "words"
+counter matched
+WORD {
+  matched++
+}
+`
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	r, err := New(lines, &wg, "", store, ImportPath(dir))
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+	matched := func() float64 {
+		return snapshotMetrics(store.ProgramMetrics("prog.mtail"))["matched"].value
+	}
+	await := func(want float64) {
+		t.Helper()
+		ok, err := testutil.DoOrTimeout(func() (bool, error) { return matched() == want, nil }, 5*time.Second, 10*time.Millisecond)
+		testutil.FatalIfErr(t, err)
+		if !ok {
+			t.Fatalf("matched = %v, want %v", matched(), want)
+		}
+	}
+
+	testutil.FatalIfErr(t, r.CompileAndRun("prog.mtail", strings.NewReader(program)))
+	lines <- logline.New(context.Background(), "log", "world")
+	lines <- logline.New(context.Background(), "log", "hello")
+	await(1)
+
+	// The program's source is unchanged, but the module it imports isn't.
+	testutil.FatalIfErr(t, os.WriteFile(module, []byte("const WORD /^world/\n"), 0o600))
+	testutil.FatalIfErr(t, r.CompileAndRun("prog.mtail", strings.NewReader(program)))
+	lines <- logline.New(context.Background(), "log", "hello")
+	lines <- logline.New(context.Background(), "log", "world")
+	await(2)
+}
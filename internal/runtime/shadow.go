@@ -0,0 +1,327 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+// A new version of a program can be loaded in shadow alongside the current
+// one, to validate it on live traffic before it replaces the current version.
+// The shadow sees the same lines as the loaded programs, but its metrics are
+// kept in a store of their own that is never exported.  Because the shadow
+// starts counting from nothing, counters and histograms are compared by how
+// much the current version's have grown since the shadow was loaded, while
+// gauges and text are compared by their present values.  The shadow must not
+// hold up the loaded programs, so lines it isn't ready for are dropped.
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/metrics/datum"
+	"github.com/google/mtail/internal/runtime/vm"
+	"github.com/pkg/errors"
+)
+
+// ShadowLinesDropped counts the lines not sent to a shadow because it was
+// still busy with earlier ones.
+var ShadowLinesDropped = expvar.NewMap("shadow_lines_dropped_total")
+
+// shadowLineBuffer is the number of lines queued for a shadow before any more are dropped.
+const shadowLineBuffer = 1000
+
+// shadowHandle is a program loaded in shadow.
+type shadowHandle struct {
+	vmHandle
+	store    *metrics.Store    // the shadow's metrics
+	baseline map[string]sample // the current version's metrics when the shadow was loaded
+	loaded   time.Time
+}
+
+// LoadShadow compiles a program read from input and runs it in shadow of the
+// loaded program of the same name, replacing any shadow already loaded.
+func (r *Runtime) LoadShadow(name string, input io.Reader) error {
+	source, err := io.ReadAll(input)
+	if err != nil {
+		return errors.Wrapf(err, "reading shadow of %q", name)
+	}
+	obj, errs := r.compile(name, bytes.NewReader(source))
+	if errs != nil {
+		return errors.Errorf("compile failed for %s:\n%s", name, errs)
+	}
+	if obj == nil {
+		return errors.Errorf("internal error: compilation failed for %s: no program returned, but no errors", name)
+	}
+	// The shadow's VM is named apart from the loaded program's, so that its
+	// runtime errors and timings aren't counted against the loaded program.
+	v := vm.New(name+"@shadow", obj, r.syslogUseCurrentYear, r.overrideLocation, r.logRuntimeErrors, r.trace, r.vmOpts...)
+	store := metrics.NewStore()
+	var exported []*metrics.Metric
+	for _, m := range v.Metrics {
+		if !m.Hidden {
+			exported = append(exported, m)
+		}
+	}
+	if err := store.ReplaceProgram(name, exported); err != nil {
+		return err
+	}
+	contentHash := sha256.Sum256(source)
+
+	r.handleMu.Lock()
+	defer r.handleMu.Unlock()
+	if old, ok := r.shadows[name]; ok {
+		close(old.lines)
+	}
+	lines := make(chan *logline.LogLine, shadowLineBuffer)
+	r.shadows[name] = &shadowHandle{
+		vmHandle: vmHandle{contentHash: contentHash[:], source: source, vm: v, lines: lines},
+		store:    store,
+		baseline: snapshotMetrics(r.ms.ProgramMetrics(name)),
+		loaded:   time.Now(),
+	}
+	r.wg.Add(1)
+	go v.Run(lines, &r.wg)
+	glog.Infof("Loaded shadow of program %s", name)
+	return nil
+}
+
+// sendToShadow queues line for the shadow s of the named program, dropping it
+// if the shadow's queue is full.
+func sendToShadow(name string, s *shadowHandle, line *logline.LogLine) {
+	select {
+	case s.lines <- line:
+	default:
+		ShadowLinesDropped.Add(name, 1)
+	}
+}
+
+// UnloadShadow stops the shadow of the named program, returning false if there is none.
+func (r *Runtime) UnloadShadow(name string) bool {
+	r.handleMu.Lock()
+	defer r.handleMu.Unlock()
+	s, ok := r.shadows[name]
+	if !ok {
+		return false
+	}
+	close(s.lines)
+	delete(r.shadows, name)
+	glog.Infof("Unloaded shadow of program %s", name)
+	return true
+}
+
+// ShadowSource returns the source and content hash of the shadow of the named program, if one is loaded.
+func (r *Runtime) ShadowSource(name string) (source []byte, contentHash string, ok bool) {
+	r.handleMu.RLock()
+	defer r.handleMu.RUnlock()
+	s, ok := r.shadows[name]
+	if !ok {
+		return nil, "", false
+	}
+	return s.source, hex.EncodeToString(s.contentHash), true
+}
+
+// MetricDiff compares one series of a program's metrics between the loaded and shadow versions.
+type MetricDiff struct {
+	Metric  string `json:"metric"`            // Metric name and labels, e.g. `requests{code="200"}`.
+	Current string `json:"current,omitempty"` // Value in the loaded version, empty if it has no such metric.
+	Shadow  string `json:"shadow,omitempty"`  // Value in the shadow version, empty if it has no such metric.
+	Equal   bool   `json:"equal"`
+}
+
+// ShadowDiff compares the metrics of a program's loaded and shadow versions.
+type ShadowDiff struct {
+	Program string       `json:"program"`
+	Since   time.Time    `json:"since"` // When the shadow was loaded.
+	Metrics []MetricDiff `json:"metrics"`
+}
+
+// Differences returns the number of series whose values differ.
+func (d *ShadowDiff) Differences() int {
+	n := 0
+	for _, m := range d.Metrics {
+		if !m.Equal {
+			n++
+		}
+	}
+	return n
+}
+
+// String renders the diff for people, differing series first.
+func (d *ShadowDiff) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Shadow of %s loaded at %s: %d of %d series differ\n", d.Program, d.Since.Format(time.RFC3339), d.Differences(), len(d.Metrics))
+	for _, equal := range []bool{false, true} {
+		for _, m := range d.Metrics {
+			if m.Equal != equal {
+				continue
+			}
+			current, shadow := m.Current, m.Shadow
+			if current == "" {
+				current = "(none)"
+			}
+			if shadow == "" {
+				shadow = "(none)"
+			}
+			mark := "!"
+			if m.Equal {
+				mark = "="
+			}
+			fmt.Fprintf(&b, "%s %s current %s shadow %s\n", mark, m.Metric, current, shadow)
+		}
+	}
+	return b.String()
+}
+
+// DiffShadow compares the metrics of the named program with those of its shadow.
+func (r *Runtime) DiffShadow(name string) (*ShadowDiff, error) {
+	r.handleMu.RLock()
+	s, ok := r.shadows[name]
+	r.handleMu.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("no shadow of program %q is loaded", name)
+	}
+	current := r.ms.ProgramMetrics(name)
+	shadow := s.store.ProgramMetrics(name)
+	currentSamples := snapshotMetrics(current)
+	shadowSamples := snapshotMetrics(shadow)
+	for k, c := range currentSamples {
+		if b, ok := s.baseline[k]; ok && c.cumulative() {
+			currentSamples[k] = c.sub(b)
+		}
+	}
+	// Cumulative series without a datum count as zero in versions that
+	// declare the metric, so that series with no lines since the shadow was
+	// loaded don't show as differences.
+	currentKinds := metricKinds(current)
+	shadowKinds := metricKinds(shadow)
+	keys := make(map[string]struct{})
+	for k := range currentSamples {
+		keys[k] = struct{}{}
+	}
+	for k := range shadowSamples {
+		keys[k] = struct{}{}
+	}
+	d := &ShadowDiff{Program: name, Since: s.loaded}
+	for k := range keys {
+		c, cok := currentSamples[k]
+		sh, sok := shadowSamples[k]
+		metric := metricName(k)
+		if !cok {
+			c, cok = zeroSample(currentKinds, metric)
+		}
+		if !sok {
+			sh, sok = zeroSample(shadowKinds, metric)
+		}
+		md := MetricDiff{Metric: k}
+		if cok {
+			md.Current = c.String()
+		}
+		if sok {
+			md.Shadow = sh.String()
+		}
+		md.Equal = cok && sok && md.Current == md.Shadow
+		d.Metrics = append(d.Metrics, md)
+	}
+	sort.Slice(d.Metrics, func(i, j int) bool { return d.Metrics[i].Metric < d.Metrics[j].Metric })
+	return d, nil
+}
+
+// sample is the value of one series of a metric at a point in time.
+type sample struct {
+	kind  metrics.Kind
+	typ   metrics.Type
+	value float64 // Int and Float values, and the sum of Buckets.
+	count uint64  // The count of Buckets.
+	text  string  // String values.
+}
+
+// cumulative reports whether the sample only grows, so is compared by its growth.
+func (s sample) cumulative() bool {
+	return s.kind == metrics.Counter || s.kind == metrics.Histogram
+}
+
+func (s sample) sub(b sample) sample {
+	s.value -= b.value
+	s.count -= b.count
+	return s
+}
+
+func (s sample) String() string {
+	switch s.typ {
+	case metrics.String:
+		return strconv.Quote(s.text)
+	case metrics.Buckets:
+		return fmt.Sprintf("count=%d sum=%s", s.count, strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+	return strconv.FormatFloat(s.value, 'g', -1, 64)
+}
+
+// snapshotMetrics returns the value of each series of the metrics, keyed by metric name and labels.
+func snapshotMetrics(ms []*metrics.Metric) map[string]sample {
+	samples := make(map[string]sample)
+	for _, m := range ms {
+		m.RLock()
+		for _, lv := range m.LabelValues {
+			s := sample{kind: m.Kind, typ: m.Type}
+			switch m.Type {
+			case metrics.Int:
+				s.value = float64(datum.GetInt(lv.Value))
+			case metrics.Float:
+				s.value = datum.GetFloat(lv.Value)
+			case metrics.String:
+				s.text = datum.GetString(lv.Value)
+			case metrics.Buckets:
+				s.value = datum.GetBucketsSum(lv.Value)
+				s.count = datum.GetBucketsCount(lv.Value)
+			}
+			samples[seriesName(m, lv.Labels)] = s
+		}
+		m.RUnlock()
+	}
+	return samples
+}
+
+// seriesName names the series of m with the given labels, e.g. `requests{code="200"}`.
+func seriesName(m *metrics.Metric, labels []string) string {
+	if len(m.Keys) == 0 {
+		return m.Name
+	}
+	pairs := make([]string, len(m.Keys))
+	for i, k := range m.Keys {
+		pairs[i] = fmt.Sprintf("%s=%q", k, labels[i])
+	}
+	return m.Name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// metricName returns the metric name of a series name.
+func metricName(series string) string {
+	if i := strings.IndexByte(series, '{'); i >= 0 {
+		return series[:i]
+	}
+	return series
+}
+
+// metricKinds returns the kind and type of each of the metrics, by name.
+func metricKinds(ms []*metrics.Metric) map[string]sample {
+	kinds := make(map[string]sample, len(ms))
+	for _, m := range ms {
+		kinds[m.Name] = sample{kind: m.Kind, typ: m.Type}
+	}
+	return kinds
+}
+
+// zeroSample returns a zero sample for a series of the named metric if it is
+// declared and cumulative.
+func zeroSample(kinds map[string]sample, name string) (sample, bool) {
+	s, ok := kinds[name]
+	return s, ok && s.cumulative()
+}
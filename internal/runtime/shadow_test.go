@@ -0,0 +1,215 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/testutil"
+)
+
+const shadowCurrent = `counter lines_total
+counter requests_total by code
+gauge last_code
+/^(?P<code>\d+)/ {
+  requests_total[$code]++
+  last_code = $code
+}
+/$/ {
+  lines_total++
+}
+`
+
+// The shadow only recognises 2xx codes, and counts a new metric.
+const shadowNew = `counter lines_total
+counter requests_total by code
+gauge last_code
+counter ok_total
+/^(?P<code>2\d+)/ {
+  requests_total[$code]++
+  last_code = $code
+  ok_total++
+}
+/$/ {
+  lines_total++
+}
+`
+
+func TestShadow(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	r, err := New(lines, &wg, "", store)
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+	send := func(ls ...string) {
+		for _, l := range ls {
+			lines <- logline.New(context.Background(), "log", l)
+		}
+	}
+	testutil.FatalIfErr(t, r.CompileAndRun("prog.mtail", strings.NewReader(shadowCurrent)))
+	send("200", "500")
+	ok, err := testutil.DoOrTimeout(func() (bool, error) {
+		return snapshotMetrics(store.ProgramMetrics("prog.mtail"))["lines_total"].value == 2, nil
+	}, 5*time.Second, 10*time.Millisecond)
+	testutil.FatalIfErr(t, err)
+	if !ok {
+		t.Fatal("lines not processed before loading the shadow")
+	}
+	testutil.FatalIfErr(t, r.LoadShadow("prog.mtail", strings.NewReader(shadowNew)))
+	send("200", "404", "201")
+	// Each version counts lines last, so has processed them all once it has counted three.
+	var d *ShadowDiff
+	ok, err = testutil.DoOrTimeout(func() (bool, error) {
+		var err error
+		d, err = r.DiffShadow("prog.mtail")
+		if err != nil {
+			return false, err
+		}
+		for _, m := range d.Metrics {
+			if m.Metric == "lines_total" {
+				return m.Current == "3" && m.Shadow == "3", nil
+			}
+		}
+		return false, nil
+	}, 5*time.Second, 10*time.Millisecond)
+	testutil.FatalIfErr(t, err)
+	if !ok {
+		t.Fatalf("lines not processed: %s", d)
+	}
+	want := []MetricDiff{
+		{Metric: "last_code", Current: "201", Shadow: "201", Equal: true},
+		{Metric: "lines_total", Current: "3", Shadow: "3", Equal: true},
+		{Metric: "ok_total", Shadow: "2"},
+		{Metric: `requests_total{code="200"}`, Current: "1", Shadow: "1", Equal: true},
+		{Metric: `requests_total{code="201"}`, Current: "1", Shadow: "1", Equal: true},
+		{Metric: `requests_total{code="404"}`, Current: "1", Shadow: "0"},
+		{Metric: `requests_total{code="500"}`, Current: "0", Shadow: "0", Equal: true},
+	}
+	testutil.ExpectNoDiff(t, want, d.Metrics)
+	if got := d.Differences(); got != 2 {
+		t.Errorf("Differences() = %d, want 2", got)
+	}
+	if !strings.HasPrefix(d.String(), "Shadow of prog.mtail loaded at ") || !strings.Contains(d.String(), "2 of 7 series differ\n! ok_total current (none) shadow 2\n") {
+		t.Errorf("unexpected String():\n%s", d)
+	}
+
+	// The shadow's metrics are not exported.
+	if store.FindMetricOrNil("ok_total", "prog.mtail") != nil {
+		t.Error("shadow metric exported")
+	}
+
+	w := httptest.NewRecorder()
+	r.ShadowzHandler(w, httptest.NewRequest(http.MethodGet, "/shadowz?prog=prog.mtail&format=json", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"metric":"ok_total"`) {
+		t.Errorf("shadowz: status %d, body %q", w.Code, w.Body.String())
+	}
+
+	if !r.UnloadShadow("prog.mtail") {
+		t.Error("UnloadShadow returned false")
+	}
+	if _, err := r.DiffShadow("prog.mtail"); err == nil {
+		t.Error("expected error diffing unloaded shadow")
+	}
+	w = httptest.NewRecorder()
+	r.ShadowzHandler(w, httptest.NewRequest(http.MethodGet, "/shadowz?prog=prog.mtail", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("shadowz of unloaded shadow: status %d", w.Code)
+	}
+}
+
+func TestAdminShadow(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	r, err := New(lines, &wg, "", store)
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+	testutil.FatalIfErr(t, r.CompileAndRun("prog.mtail", strings.NewReader(shadowCurrent)))
+
+	w := adminRequest(t, r, http.MethodPut, "/admin/shadows/prog.mtail", "counter foo\n/(/ {\n  foo++\n}\n", nil)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid shadow: status %d, body %q", w.Code, w.Body.String())
+	}
+	w = adminRequest(t, r, http.MethodPost, "/admin/shadows/prog.mtail/promote", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("promote missing shadow: status %d", w.Code)
+	}
+	w = adminRequest(t, r, http.MethodPut, "/admin/shadows/prog.mtail", shadowNew, nil)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("put shadow: status %d, body %q", w.Code, w.Body.String())
+	}
+	w = adminRequest(t, r, http.MethodGet, "/admin/shadows/prog.mtail", "", nil)
+	if w.Code != http.StatusOK || w.Body.String() != shadowNew {
+		t.Errorf("get shadow: status %d, body %q", w.Code, w.Body.String())
+	}
+
+	// Promotion is conditional on the program being replaced.
+	w = adminRequest(t, r, http.MethodPost, "/admin/shadows/prog.mtail/promote", "", map[string]string{"If-Match": `"stale"`})
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale promote: status %d", w.Code)
+	}
+	if _, _, ok := r.ShadowSource("prog.mtail"); !ok {
+		t.Error("shadow unloaded by failed promotion")
+	}
+	w = adminRequest(t, r, http.MethodPost, "/admin/shadows/prog.mtail/promote", "", nil)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("promote: status %d, body %q", w.Code, w.Body.String())
+	}
+	if source, _, _ := r.ProgramSource("prog.mtail"); string(source) != shadowNew {
+		t.Errorf("shadow not promoted, program is %q", source)
+	}
+	if _, _, ok := r.ShadowSource("prog.mtail"); ok {
+		t.Error("shadow still loaded after promotion")
+	}
+	w = adminRequest(t, r, http.MethodDelete, "/admin/shadows/prog.mtail", "", nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("delete promoted shadow: status %d", w.Code)
+	}
+}
+
+func TestShadowCountedApart(t *testing.T) {
+	store := metrics.NewStore()
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	r, err := New(lines, &wg, "", store)
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+	testutil.FatalIfErr(t, r.CompileAndRun("apart.mtail", strings.NewReader("counter lines_total\n/$/ {\n  lines_total++\n}\n")))
+	// The shadow fails on every line.
+	testutil.FatalIfErr(t, r.LoadShadow("apart.mtail", strings.NewReader("gauge latency\n/^(?P<latency>.*)$/ {\n  latency = duration($latency)\n}\n")))
+	shadowErrors := testutil.ExpectMapExpvarDeltaWithDeadline(t, "prog_runtime_errors_total", "apart.mtail@shadow", 1)
+	currentErrors := testutil.ExpectMapExpvarDeltaWithDeadline(t, "prog_runtime_errors_total", "apart.mtail", 0)
+	lines <- logline.New(context.Background(), "log", "slow")
+	shadowErrors()
+	currentErrors()
+}
+
+func TestSendToShadowDropsWhenFull(t *testing.T) {
+	s := &shadowHandle{vmHandle: vmHandle{lines: make(chan *logline.LogLine, 1)}}
+	dropped := testutil.ExpectMapExpvarDeltaWithDeadline(t, "shadow_lines_dropped_total", "full.mtail", 1)
+	sendToShadow("full.mtail", s, logline.New(context.Background(), "log", "queued"))
+	sendToShadow("full.mtail", s, logline.New(context.Background(), "log", "dropped"))
+	dropped()
+	if got := (<-s.lines).Line; got != "queued" {
+		t.Errorf("queued line %q, want %q", got, "queued")
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+import (
+	"context"
+	"io"
+
+	"github.com/google/mtail/internal/runtime/code"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var tracer = otel.Tracer("github.com/google/mtail/internal/runtime")
+
+// compile compiles the program within a span, which records the compilation
+// errors, if any.
+func (r *Runtime) compile(name string, input io.Reader) (*code.Object, error) {
+	_, span := tracer.Start(context.Background(), "runtime.Compile")
+	defer span.End()
+	span.SetAttributes(attribute.String("mtail.program", name))
+	obj, errs := r.c.Compile(name, input)
+	if errs != nil {
+		span.RecordError(errs)
+		span.SetStatus(codes.Error, "compile failed")
+	}
+	return obj, errs
+}
@@ -0,0 +1,60 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/testutil"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestCompileSpan(t *testing.T) {
+	exp := testutil.RecordSpans(t)
+
+	lines := make(chan *logline.LogLine)
+	var wg sync.WaitGroup
+	r, err := New(lines, &wg, "", metrics.NewStore())
+	testutil.FatalIfErr(t, err)
+	defer func() {
+		close(lines)
+		wg.Wait()
+	}()
+	testutil.FatalIfErr(t, r.CompileAndRun("good.mtail", strings.NewReader(routeProgram)))
+	if err := r.CompileAndRun("bad.mtail", strings.NewReader("counter\n")); err == nil {
+		t.Fatal("expected compile error")
+	}
+
+	got := exp.GetSpans()
+	if len(got) != 2 {
+		t.Fatalf("got %d spans, want 2: %v", len(got), got)
+	}
+	for i, want := range []struct {
+		program string
+		code    codes.Code
+	}{
+		{"good.mtail", codes.Unset},
+		{"bad.mtail", codes.Error},
+	} {
+		s := got[i]
+		if s.Name != "runtime.Compile" {
+			t.Errorf("span %d name %q", i, s.Name)
+		}
+		attrs := attribute.NewSet(s.Attributes...)
+		if v, _ := attrs.Value("mtail.program"); v.AsString() != want.program {
+			t.Errorf("span %d program %q, want %q", i, v.AsString(), want.program)
+		}
+		if s.Status.Code != want.code {
+			t.Errorf("span %d status %v, want %v", i, s.Status.Code, want.code)
+		}
+	}
+	if len(got[1].Events) == 0 || got[1].Events[0].Name != "exception" {
+		t.Errorf("compile errors not recorded on span: %v", got[1].Events)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"time"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/runtime/code"
+)
+
+// The methods in this file let a debugger drive the stack machine one
+// instruction at a time, instead of a whole line at a time with
+// ProcessLogLine.
+
+// StartLine prepares the VM to execute its program on line, one instruction
+// at a time with Step.  If the VM was created with tracing enabled, the trace
+// is cleared.
+func (v *VM) StartLine(line *logline.LogLine) {
+	if v.pf != nil {
+		v.pf.reset()
+	}
+	if v.trace != nil {
+		v.trace = v.trace[:0]
+	}
+	v.t = &thread{
+		stack:   make([]interface{}, 0),
+		matches: make(map[int][]string, len(v.re)),
+	}
+	if v.persistTime {
+		v.t.time = v.lastTime
+	}
+	v.input = line
+	v.terminate = false
+}
+
+// Step executes the next instruction of the line started by StartLine, and
+// reports whether the program has finished with the line.
+func (v *VM) Step() (done bool) {
+	t := v.t
+	if t == nil || t.pc >= len(v.prog) {
+		return true
+	}
+	if v.trace != nil {
+		v.trace = append(v.trace, t.pc)
+	}
+	i := v.prog[t.pc]
+	t.pc++
+	v.execute(t, i)
+	if v.terminate {
+		v.terminate = false
+		t.pc = len(v.prog)
+	}
+	if t.pc >= len(v.prog) {
+		if v.persistTime {
+			v.keepTime()
+		}
+		return true
+	}
+	return false
+}
+
+// ThreadState is a snapshot of the VM's thread of execution.
+type ThreadState struct {
+	PC      int              // Program counter of the next instruction to execute.
+	Matched bool             // Whether any match has been found on this line.
+	Matches map[int][]string // Capture groups of the matches, by regular expression index.
+	Time    time.Time        // Time register.
+	Stack   []interface{}    // Data stack, bottom first.
+}
+
+// State returns a copy of the current thread state.
+func (v *VM) State() ThreadState {
+	if v.t == nil {
+		return ThreadState{}
+	}
+	s := ThreadState{
+		PC:      v.t.pc,
+		Matched: v.t.matched,
+		Matches: make(map[int][]string, len(v.t.matches)),
+		Time:    v.t.time,
+		Stack:   append([]interface{}(nil), v.t.stack...),
+	}
+	for k, m := range v.t.matches {
+		s.Matches[k] = append([]string(nil), m...)
+	}
+	return s
+}
+
+// Program returns the stack machine bytecode of the VM's program.
+func (v *VM) Program() []code.Instr {
+	return v.prog
+}
+
+// Regexp returns the source of the program's regular expression at index.
+func (v *VM) Regexp(index int) string {
+	if index < 0 || index >= len(v.re) {
+		return ""
+	}
+	return v.re[index].String()
+}
+
+// Trace returns the program counters executed on the current line, if the
+// VM was created with tracing enabled.
+func (v *VM) Trace() []int {
+	return v.trace
+}
@@ -0,0 +1,56 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/mtail/internal/logline"
+)
+
+func TestStepMatchesProcessLogLine(t *testing.T) {
+	whole := compileForTest(t, benchmarkProgram)
+	stepped := compileForTest(t, benchmarkProgram)
+	for _, l := range benchmarkLines {
+		line := logline.New(context.Background(), "test", l)
+		whole.ProcessLogLine(context.Background(), line)
+		stepped.StartLine(line)
+		steps := 0
+		for !stepped.Step() {
+			steps++
+			if steps > len(stepped.Program()) {
+				t.Fatalf("program did not finish on %q", l)
+			}
+		}
+		if s := stepped.State(); s.PC != len(stepped.Program()) {
+			t.Errorf("PC after finishing: got %d, want %d", s.PC, len(stepped.Program()))
+		}
+	}
+	for i, m := range whole.Metrics {
+		for _, lv := range m.LabelValues {
+			d, err := stepped.Metrics[i].GetDatum(lv.Labels...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := d.ValueString(), lv.Value.ValueString(); got != want {
+				t.Errorf("%s%v: got %s, want %s", m.Name, lv.Labels, got, want)
+			}
+		}
+	}
+}
+
+func TestStateCopiesMatches(t *testing.T) {
+	v := compileForTest(t, benchmarkProgram)
+	v.StartLine(logline.New(context.Background(), "test", benchmarkLines[0]))
+	v.Step()
+	s := v.State()
+	if len(s.Matches[0]) == 0 {
+		t.Fatalf("no match recorded: %+v", s)
+	}
+	s.Matches[0][1] = "changed"
+	if v.State().Matches[0][1] == "changed" {
+		t.Error("State shares matches with the VM")
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/runtime/code"
+	"github.com/google/mtail/internal/runtime/compiler/position"
+)
+
+// ExplainStep describes one decision or side effect made by the program on a line.
+type ExplainStep struct {
+	Pos     position.Position // Source position of the instruction that took the step.
+	PC      int               // Program counter of the instruction.
+	Message string
+}
+
+// MetricChange describes a datum whose value was changed by the program on a line.
+type MetricChange struct {
+	Metric string // Metric name and labels, e.g. `requests{code="200"}`.
+	Old    string // Value before the line, empty if the datum was created by this line.
+	New    string // Value after the line, empty if the datum was deleted by this line.
+}
+
+// Explanation is the path a program took executing a single line.
+type Explanation struct {
+	Program      string
+	Line         string
+	Steps        []ExplainStep
+	Changes      []MetricChange
+	RuntimeError string // The runtime error that ended the program on this line, if any.
+}
+
+// String renders the explanation for people.
+func (e *Explanation) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Program %s on line %q\n", e.Program, e.Line)
+	for _, s := range e.Steps {
+		fmt.Fprintf(&b, "%s: %s\n", s.Pos, s.Message)
+	}
+	if e.RuntimeError != "" {
+		fmt.Fprintf(&b, "Runtime error: %s\n", e.RuntimeError)
+	}
+	if len(e.Changes) == 0 {
+		fmt.Fprintln(&b, "No metrics changed.")
+		return b.String()
+	}
+	fmt.Fprintln(&b, "Metrics changed:")
+	for _, c := range e.Changes {
+		switch {
+		case c.Old == "":
+			fmt.Fprintf(&b, "  %s = %s (new)\n", c.Metric, c.New)
+		case c.New == "":
+			fmt.Fprintf(&b, "  %s deleted (was %s)\n", c.Metric, c.Old)
+		default:
+			fmt.Fprintf(&b, "  %s = %s (was %s)\n", c.Metric, c.New, c.Old)
+		}
+	}
+	return b.String()
+}
+
+// mutates reports whether the opcode may change the value of a datum.
+func mutates(o code.Opcode) bool {
+	switch o {
+	case code.Inc, code.Dec, code.Iset, code.Fset, code.Sset, code.Del, code.Expire:
+		return true
+	}
+	return false
+}
+
+// Explain executes the program on line one instruction at a time on the
+// stack machine, recording the patterns tried, the branches taken, and the
+// datums changed.  It changes the VM's metrics like ProcessLogLine does, so
+// it is usually called on a VM whose metrics are not exported.
+func (v *VM) Explain(line *logline.LogLine) *Explanation {
+	e := &Explanation{Program: v.name, Line: line.Line}
+	v.runtimeErrorMu.Lock()
+	v.runtimeError = ""
+	v.runtimeErrorMu.Unlock()
+	v.StartLine(line)
+	for v.t.pc < len(v.prog) {
+		pc := v.t.pc
+		i := v.prog[pc]
+		var before map[string]string
+		if mutates(i.Opcode) {
+			before = v.snapshot()
+		}
+		done := v.Step()
+		if msg := v.explainInstr(pc, i); msg != "" {
+			e.Steps = append(e.Steps, ExplainStep{Pos: v.position(pc), PC: pc, Message: msg})
+		}
+		if before != nil {
+			changes := diffSnapshots(before, v.snapshot())
+			e.Changes = append(e.Changes, changes...)
+			for _, c := range changes {
+				msg := fmt.Sprintf("%s %s %s", i.Opcode, c.Metric, c.New)
+				if c.New == "" {
+					msg = fmt.Sprintf("%s %s", i.Opcode, c.Metric)
+				}
+				e.Steps = append(e.Steps, ExplainStep{Pos: v.position(pc), PC: pc, Message: msg})
+			}
+		}
+		if done {
+			break
+		}
+	}
+	e.RuntimeError = v.RuntimeErrorString()
+	return e
+}
+
+// position returns the source position of the instruction at pc.
+func (v *VM) position(pc int) position.Position {
+	if pc < len(v.pos) {
+		return v.pos[pc]
+	}
+	if pc < len(v.prog) {
+		return position.Position{Filename: v.name, Line: v.prog[pc].SourceLine}
+	}
+	return position.Position{Filename: v.name}
+}
+
+// explainInstr describes the decision made by the instruction i at pc, just
+// executed, or returns the empty string if it made none worth explaining.
+func (v *VM) explainInstr(pc int, i code.Instr) string {
+	t := v.t
+	top := func() interface{} {
+		if len(t.stack) == 0 {
+			return nil
+		}
+		return t.stack[len(t.stack)-1]
+	}
+	switch i.Opcode {
+	case code.Match, code.Smatch:
+		index := i.Operand.(int)
+		if m, ok := top().(bool); ok && m {
+			return fmt.Sprintf("/%s/ matched%s", v.re[index], v.captures(index))
+		}
+		return fmt.Sprintf("/%s/ did not match", v.re[index])
+	case code.Otherwise:
+		if m, ok := top().(bool); ok && m {
+			return "otherwise taken, as nothing before it in this block matched"
+		}
+		return "otherwise not taken, as something before it in this block matched"
+	case code.Jnm, code.Jm:
+		jumped := t.pc != pc+1
+		switch v.branches[pc] {
+		case code.CondBranch:
+			if jumped {
+				return "condition is false, skipping block"
+			}
+			return "condition is true, entering block"
+		case code.AndBranch:
+			if jumped {
+				return "left side of && is false, skipping the right side"
+			}
+		case code.OrBranch:
+			if jumped {
+				return "left side of || is true, skipping the right side"
+			}
+		}
+	case code.Stop:
+		return "stop"
+	}
+	return ""
+}
+
+// captures formats the capture groups of the regular expression at index.
+func (v *VM) captures(index int) string {
+	m := v.t.matches[index]
+	if len(m) < 2 {
+		return ""
+	}
+	names := v.re[index].SubexpNames()
+	var b strings.Builder
+	b.WriteString(":")
+	for j := 1; j < len(m); j++ {
+		name := names[j]
+		if name == "" {
+			name = fmt.Sprint(j)
+		}
+		fmt.Fprintf(&b, " $%s=%q", name, m[j])
+	}
+	return b.String()
+}
+
+// snapshot returns the value of every datum of the program's metrics, keyed by metric name and labels.
+func (v *VM) snapshot() map[string]string {
+	s := make(map[string]string)
+	for _, m := range v.Metrics {
+		m.RLock()
+		for _, lv := range m.LabelValues {
+			labels := make([]string, len(m.Keys))
+			for i, k := range m.Keys {
+				labels[i] = fmt.Sprintf("%s=%q", k, lv.Labels[i])
+			}
+			key := m.Name
+			if len(labels) > 0 {
+				key += "{" + strings.Join(labels, ",") + "}"
+			}
+			s[key] = lv.Value.ValueString()
+		}
+		m.RUnlock()
+	}
+	return s
+}
+
+// diffSnapshots returns the changes from before to after, ordered by metric.
+func diffSnapshots(before, after map[string]string) []MetricChange {
+	var changes []MetricChange
+	for k, a := range after {
+		if b, ok := before[k]; !ok || a != b {
+			changes = append(changes, MetricChange{Metric: k, Old: before[k], New: a})
+		}
+	}
+	for k, b := range before {
+		if _, ok := after[k]; !ok {
+			changes = append(changes, MetricChange{Metric: k, Old: b})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Metric < changes[j].Metric })
+	return changes
+}
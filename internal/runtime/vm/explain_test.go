@@ -0,0 +1,88 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/mtail/internal/logline"
+)
+
+const explainProgram = `counter requests by method
+counter other
+
+/^(?P<method>[A-Z]+) / {
+  $method == "GET" || $method == "HEAD" {
+    requests[$method]++
+  }
+}
+otherwise {
+  other++
+}
+`
+
+func TestExplain(t *testing.T) {
+	for _, tc := range []struct {
+		line        string
+		wantSteps   []string
+		wantChanges []MetricChange
+	}{
+		{
+			"GET /",
+			[]string{
+				"bench:4:1-24: /^(?P<method>[A-Z]+) / matched: $method=\"GET\"",
+				"bench:4:1-24: condition is true, entering block",
+				"bench:5:3-39: left side of || is true, skipping the right side",
+				"bench:5:3-39: condition is true, entering block",
+				"bench:6:5-23: inc requests{method=\"GET\"} 1",
+				"bench:9:1-9: otherwise not taken, as something before it in this block matched",
+				"bench:9:1-9: condition is false, skipping block",
+			},
+			[]MetricChange{{Metric: "requests{method=\"GET\"}", Old: "0", New: "1"}},
+		},
+		{
+			"POST /",
+			[]string{
+				"bench:4:1-24: /^(?P<method>[A-Z]+) / matched: $method=\"POST\"",
+				"bench:4:1-24: condition is true, entering block",
+				"bench:5:3-39: condition is false, skipping block",
+				"bench:9:1-9: otherwise not taken, as something before it in this block matched",
+				"bench:9:1-9: condition is false, skipping block",
+			},
+			nil,
+		},
+		{
+			"nothing",
+			[]string{
+				"bench:4:1-24: /^(?P<method>[A-Z]+) / did not match",
+				"bench:4:1-24: condition is false, skipping block",
+				"bench:9:1-9: otherwise taken, as nothing before it in this block matched",
+				"bench:9:1-9: condition is true, entering block",
+				"bench:10:3-9: inc other 1",
+			},
+			[]MetricChange{{Metric: "other", Old: "0", New: "1"}},
+		},
+	} {
+		tc := tc
+		t.Run(tc.line, func(t *testing.T) {
+			v := compileForTest(t, explainProgram)
+			e := v.Explain(logline.New(context.Background(), "test", tc.line))
+			var steps []string
+			for _, s := range e.Steps {
+				steps = append(steps, s.Pos.String()+": "+s.Message)
+			}
+			if diff := cmp.Diff(tc.wantSteps, steps); diff != "" {
+				t.Errorf("steps diff (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantChanges, e.Changes); diff != "" {
+				t.Errorf("changes diff (-want +got):\n%s", diff)
+			}
+			if e.RuntimeError != "" {
+				t.Errorf("unexpected runtime error: %s", e.RuntimeError)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"net/netip"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// parseAddr parses the IP address in s, which may be followed by a port as in
+// "1.2.3.4:80" or "[::1]:80".  IPv4-mapped IPv6 addresses are returned as
+// IPv4 addresses.
+func parseAddr(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		ap, perr := netip.ParseAddrPort(s)
+		if perr != nil {
+			return netip.Addr{}, errors.Errorf("invalid IP address %q", s)
+		}
+		a = ap.Addr()
+	}
+	return a.Unmap(), nil
+}
+
+// ipnet returns the network of prefix length bits containing the address s,
+// in CIDR notation.
+func ipnet(s string, bits int64) (string, error) {
+	a, err := parseAddr(s)
+	if err != nil {
+		return "", err
+	}
+	if bits < 0 || bits > int64(a.BitLen()) {
+		return "", errors.Errorf("prefix length %d out of range for address %q", bits, s)
+	}
+	p, err := a.Prefix(int(bits))
+	if err != nil {
+		return "", errors.Wrapf(err, "ipnet of %q", s)
+	}
+	return p.String(), nil
+}
+
+// incidr returns true if s is an IP address in the network p.  Strings that
+// are not IP addresses are in no network.
+func incidr(s string, p netip.Prefix) bool {
+	a, err := parseAddr(s)
+	if err != nil {
+		return false
+	}
+	return p.Contains(a)
+}
+
+// isipv6 returns true if s is an IPv6 address.
+func isipv6(s string) bool {
+	a, err := parseAddr(s)
+	return err == nil && a.Is6()
+}
+
+// isInternal returns true if a is an address that a reverse proxy on the
+// local network would connect from.
+func isInternal(a netip.Addr) bool {
+	return a.IsPrivate() || a.IsLoopback() || a.IsLinkLocalUnicast() || a.IsUnspecified()
+}
+
+// clientip returns the address of the client that originated a request,
+// given the address of the peer that connected, and the comma separated list
+// of addresses from an X-Forwarded-For header that the peer passed on.  The
+// list is only trusted while the addresses in it are internal, so the result
+// is the rightmost public address in the chain of proxies, or the leftmost
+// address if all are internal.
+func clientip(peer, forwarded string) (string, error) {
+	a, err := parseAddr(peer)
+	if err != nil {
+		return "", err
+	}
+	hops := strings.Split(forwarded, ",")
+	for i := len(hops) - 1; i >= 0 && isInternal(a); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" || hop == "-" {
+			continue
+		}
+		next, err := parseAddr(hop)
+		if err != nil {
+			break
+		}
+		a = next
+	}
+	return a.String(), nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"net/netip"
+	"testing"
+)
+
+var ipnetTests = []struct {
+	ip   string
+	bits int64
+	want string
+}{
+	{"10.1.2.3", 24, "10.1.2.0/24"},
+	{"10.1.2.3", 8, "10.0.0.0/8"},
+	{"10.1.2.3", 32, "10.1.2.3/32"},
+	{"10.1.2.3:8080", 16, "10.1.0.0/16"},
+	{"::ffff:192.0.2.1", 24, "192.0.2.0/24"},
+	{"2001:db8:1:2::1", 48, "2001:db8:1::/48"},
+	{"[2001:db8::1]:443", 32, "2001:db8::/32"},
+}
+
+func TestIpnet(t *testing.T) {
+	for _, tc := range ipnetTests {
+		got, err := ipnet(tc.ip, tc.bits)
+		if err != nil {
+			t.Errorf("ipnet(%q, %d) failed: %s", tc.ip, tc.bits, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ipnet(%q, %d) = %q, want %q", tc.ip, tc.bits, got, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		ip   string
+		bits int64
+	}{{"10.1.2.3", 33}, {"10.1.2.3", -1}, {"-", 24}, {"10.1.2", 24}} {
+		if got, err := ipnet(tc.ip, tc.bits); err == nil {
+			t.Errorf("ipnet(%q, %d) = %q, want error", tc.ip, tc.bits, got)
+		}
+	}
+}
+
+func TestIncidr(t *testing.T) {
+	p8 := netip.MustParsePrefix("10.0.0.0/8")
+	p6 := netip.MustParsePrefix("2001:db8::/32")
+	for _, tc := range []struct {
+		ip   string
+		p    netip.Prefix
+		want bool
+	}{
+		{"10.200.3.4", p8, true},
+		{"11.0.0.1", p8, false},
+		{"::ffff:10.0.0.1", p8, true},
+		{"2001:db8::1", p8, false},
+		{"2001:db8::1", p6, true},
+		{"not an address", p8, false},
+	} {
+		if got := incidr(tc.ip, tc.p); got != tc.want {
+			t.Errorf("incidr(%q, %s) = %v, want %v", tc.ip, tc.p, got, tc.want)
+		}
+	}
+}
+
+func TestIsipv6(t *testing.T) {
+	for ip, want := range map[string]bool{
+		"2001:db8::1":      true,
+		"[::1]:80":         true,
+		"192.0.2.1":        false,
+		"::ffff:192.0.2.1": false,
+		"-":                false,
+	} {
+		if got := isipv6(ip); got != want {
+			t.Errorf("isipv6(%q) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+var clientipTests = []struct {
+	peer      string
+	forwarded string
+	want      string
+}{
+	{"203.0.113.5", "198.51.100.7", "203.0.113.5"},
+	{"10.0.0.2", "", "10.0.0.2"},
+	{"10.0.0.2", "-", "10.0.0.2"},
+	{"10.0.0.2", "198.51.100.7", "198.51.100.7"},
+	{"127.0.0.1:5000", "198.51.100.7, 10.0.0.3", "198.51.100.7"},
+	{"10.0.0.2", "1.1.1.1, 198.51.100.7, 10.0.0.3", "198.51.100.7"},
+	{"10.0.0.2", "192.168.1.5, 10.0.0.3", "192.168.1.5"},
+	{"10.0.0.2", "garbage, 10.0.0.3", "10.0.0.3"},
+	{"::1", "2001:db8::7", "2001:db8::7"},
+}
+
+func TestClientip(t *testing.T) {
+	for _, tc := range clientipTests {
+		got, err := clientip(tc.peer, tc.forwarded)
+		if err != nil {
+			t.Errorf("clientip(%q, %q) failed: %s", tc.peer, tc.forwarded, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("clientip(%q, %q) = %q, want %q", tc.peer, tc.forwarded, got, tc.want)
+		}
+	}
+	if got, err := clientip("-", "198.51.100.7"); err == nil {
+		t.Errorf("clientip(\"-\", ...) = %q, want error", got)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"sync/atomic"
+)
+
+// acNode is a state in the Aho-Corasick automaton.
+type acNode struct {
+	next map[byte]int // goto function
+	fail int          // failure function
+	out  []int        // literals recognised in this state, including by way of the failure function
+}
+
+// prefilter decides which regular expressions cannot match a line, by
+// searching the line once for every literal that the regular expressions of
+// a program require.
+type prefilter struct {
+	nodes    []acNode
+	required [][]int // literal indexes required by each regular expression
+	found    []bool  // literals found in the current line, indexed by literal index
+	scanned  bool    // true once the current line has been searched
+
+	skips []atomic.Int64 // count of regular expression executions avoided
+	hits  []atomic.Int64 // count of regular expressions executed after passing the prefilter
+}
+
+// newPrefilter builds a prefilter from the required literals of each regular
+// expression.  It returns nil if no regular expression has any required
+// literal, as there's nothing to gain from scanning lines.
+func newPrefilter(literals [][]string) *prefilter {
+	p := &prefilter{
+		nodes:    []acNode{{next: map[byte]int{}}},
+		required: make([][]int, len(literals)),
+		skips:    make([]atomic.Int64, len(literals)),
+		hits:     make([]atomic.Int64, len(literals)),
+	}
+	index := map[string]int{}
+	for re, lits := range literals {
+		for _, l := range lits {
+			id, ok := index[l]
+			if !ok {
+				id = len(index)
+				index[l] = id
+				p.add(l, id)
+			}
+			p.required[re] = append(p.required[re], id)
+		}
+	}
+	if len(index) == 0 {
+		return nil
+	}
+	p.found = make([]bool, len(index))
+	p.link()
+	return p
+}
+
+// add inserts literal l with index id into the trie.
+func (p *prefilter) add(l string, id int) {
+	s := 0
+	for i := 0; i < len(l); i++ {
+		n, ok := p.nodes[s].next[l[i]]
+		if !ok {
+			n = len(p.nodes)
+			p.nodes = append(p.nodes, acNode{next: map[byte]int{}})
+			p.nodes[s].next[l[i]] = n
+		}
+		s = n
+	}
+	p.nodes[s].out = append(p.nodes[s].out, id)
+}
+
+// link computes the failure function breadth-first over the trie.
+func (p *prefilter) link() {
+	queue := make([]int, 0, len(p.nodes))
+	for _, n := range p.nodes[0].next {
+		queue = append(queue, n)
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for c, n := range p.nodes[s].next {
+			queue = append(queue, n)
+			f := p.nodes[s].fail
+			for {
+				if m, ok := p.nodes[f].next[c]; ok && m != n {
+					p.nodes[n].fail = m
+					break
+				}
+				if f == 0 {
+					break
+				}
+				f = p.nodes[f].fail
+			}
+			p.nodes[n].out = append(p.nodes[n].out, p.nodes[p.nodes[n].fail].out...)
+		}
+	}
+}
+
+// reset prepares the prefilter for a new line of input.
+func (p *prefilter) reset() {
+	p.scanned = false
+}
+
+// scan searches line for all literals in a single pass.
+func (p *prefilter) scan(line string) {
+	for i := range p.found {
+		p.found[i] = false
+	}
+	s := 0
+	for i := 0; i < len(line); i++ {
+		for {
+			if n, ok := p.nodes[s].next[line[i]]; ok {
+				s = n
+				break
+			}
+			if s == 0 {
+				break
+			}
+			s = p.nodes[s].fail
+		}
+		for _, id := range p.nodes[s].out {
+			p.found[id] = true
+		}
+	}
+	p.scanned = true
+}
+
+// mayMatch reports whether regular expression re could match line, scanning
+// the line if it hasn't yet been seen.
+func (p *prefilter) mayMatch(re int, line string) bool {
+	if len(p.required[re]) == 0 {
+		return true
+	}
+	if !p.scanned {
+		p.scan(line)
+	}
+	for _, id := range p.required[re] {
+		if !p.found[id] {
+			p.skips[re].Add(1)
+			return false
+		}
+	}
+	p.hits[re].Add(1)
+	return true
+}
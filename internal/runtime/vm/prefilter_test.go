@@ -0,0 +1,77 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/mtail/internal/logline"
+)
+
+func TestPrefilter(t *testing.T) {
+	p := newPrefilter([][]string{
+		{"he", "she"},
+		{"hers"},
+		nil,
+		{"his", "GET "},
+	})
+	for _, tc := range []struct {
+		line string
+		want []bool
+	}{
+		{"ushers", []bool{true, true, true, false}},
+		{"she", []bool{true, false, true, false}},
+		{"GET /his", []bool{false, false, true, true}},
+		{"", []bool{false, false, true, false}},
+	} {
+		p.reset()
+		for re, want := range tc.want {
+			if got := p.mayMatch(re, tc.line); got != want {
+				t.Errorf("mayMatch(%d, %q): want %v, got %v", re, tc.line, want, got)
+			}
+		}
+	}
+	if got := p.skips[1].Load(); got != 3 {
+		t.Errorf("skips: want 3, got %d", got)
+	}
+	if got := p.hits[1].Load(); got != 1 {
+		t.Errorf("hits: want 1, got %d", got)
+	}
+}
+
+func TestPrefilterNoLiterals(t *testing.T) {
+	if p := newPrefilter([][]string{nil, {}}); p != nil {
+		t.Errorf("expected no prefilter, got %v", p)
+	}
+}
+
+func TestPrefilterSkipsMatch(t *testing.T) {
+	v := compileForTest(t, "counter gets\n/^GET / {\n  gets++\n}\n")
+	if v.pf == nil {
+		t.Fatal("expected a prefilter")
+	}
+	ctx := context.Background()
+	v.ProcessLogLine(ctx, logline.New(ctx, "test", "this line does not match"))
+	v.ProcessLogLine(ctx, logline.New(ctx, "test", "GET /index.html 200 1024 0.5"))
+	dump := v.DumpByteCode()
+	if !strings.Contains(dump, "skip=1 hit=1") {
+		t.Errorf("expected skip and hit counts in dump:\n%s", dump)
+	}
+}
+
+func TestPrefilterCountsReplicas(t *testing.T) {
+	const prog = "counter gets\n/^GET / {\n  gets++\n}\n"
+	v := compileForTest(t, prog)
+	replica := compileForTest(t, prog)
+	ctx := context.Background()
+	v.ProcessLogLine(ctx, logline.New(ctx, "test", "this line does not match"))
+	replica.ProcessLogLine(ctx, logline.New(ctx, "test", "nor does this one"))
+	replica.ProcessLogLine(ctx, logline.New(ctx, "test", "GET /index.html 200 1024 0.5"))
+	dump := v.DumpByteCode(replica)
+	if !strings.Contains(dump, "skip=2 hit=1") {
+		t.Errorf("expected skip and hit counts of both VMs in dump:\n%s", dump)
+	}
+}
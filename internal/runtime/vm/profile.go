@@ -0,0 +1,248 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/mtail/internal/runtime/code"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Profile instructs the VM to count the executions of, and time spent in,
+// each instruction of the program.
+func Profile() Option {
+	return func(v *VM) {
+		v.profiling = true
+	}
+}
+
+// profiler accumulates execution counts and times per program counter.  The
+// counters are read concurrently with execution by the status handlers.
+type profiler struct {
+	counts []atomic.Int64
+	nanos  []atomic.Int64
+}
+
+func newProfiler(size int) *profiler {
+	return &profiler{
+		counts: make([]atomic.Int64, size),
+		nanos:  make([]atomic.Int64, size),
+	}
+}
+
+func (p *profiler) record(pc int, d time.Duration) {
+	p.counts[pc].Add(1)
+	p.nanos[pc].Add(int64(d))
+}
+
+// InstrProfile is the execution profile of a single instruction.
+type InstrProfile struct {
+	PC         int
+	Opcode     code.Opcode
+	SourceLine int // Zero-based line number in the program source.
+	Count      int64
+	Time       time.Duration
+}
+
+// Profile returns the execution profile of each instruction in the program,
+// in program order, or nil if the VM is not profiling.
+func (v *VM) Profile() []InstrProfile {
+	if v.prof == nil {
+		return nil
+	}
+	var p []InstrProfile
+	if v.useRegisters {
+		p = make([]InstrProfile, len(v.regProg))
+		for pc, i := range v.regProg {
+			p[pc] = InstrProfile{PC: pc, Opcode: i.Opcode, SourceLine: i.SourceLine}
+		}
+	} else {
+		p = make([]InstrProfile, len(v.prog))
+		for pc, i := range v.prog {
+			p[pc] = InstrProfile{PC: pc, Opcode: i.Opcode, SourceLine: i.SourceLine}
+		}
+	}
+	for pc := range p {
+		p[pc].Count = v.prof.counts[pc].Load()
+		p[pc].Time = time.Duration(v.prof.nanos[pc].Load())
+	}
+	return p
+}
+
+// MergeProfiles sums the profiles of VMs executing the same program.
+func MergeProfiles(profiles ...[]InstrProfile) []InstrProfile {
+	var merged []InstrProfile
+	for _, p := range profiles {
+		if merged == nil {
+			merged = append(merged, p...)
+			continue
+		}
+		for pc := range p {
+			if pc >= len(merged) {
+				break
+			}
+			merged[pc].Count += p[pc].Count
+			merged[pc].Time += p[pc].Time
+		}
+	}
+	return merged
+}
+
+// WriteAnnotatedSource writes the program source to w, each line prefixed by
+// the number of times it was executed and the total time spent in the
+// instructions compiled from it.  A line is counted as executed as many times
+// as the most frequently executed of its instructions.
+func WriteAnnotatedSource(w io.Writer, source []byte, profile []InstrProfile) error {
+	counts := make(map[int]int64)
+	times := make(map[int]time.Duration)
+	for _, p := range profile {
+		if p.Count > counts[p.SourceLine] {
+			counts[p.SourceLine] = p.Count
+		}
+		times[p.SourceLine] += p.Time
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "count\ttime\tline\t")
+	scanner := bufio.NewScanner(bytes.NewReader(source))
+	for n := 0; scanner.Scan(); n++ {
+		if _, ok := counts[n]; ok {
+			fmt.Fprintf(tw, "%d\t%s\t%d\t  %s\n", counts[n], times[n], n+1, scanner.Text())
+		} else {
+			fmt.Fprintf(tw, "\t\t%d\t  %s\n", n+1, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+// Field numbers of the messages in pprof's profile.proto.
+const (
+	profileSampleType = 1
+	profileSample     = 2
+	profileLocation   = 4
+	profileFunction   = 5
+	profileStrings    = 6
+	profileTimeNanos  = 9
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID       = 1
+	functionName     = 2
+	functionFilename = 4
+)
+
+// WritePprof writes the profile of the named program to w as a gzipped
+// pprof protocol buffer, so it can be read by `go tool pprof`.  Each
+// instruction is a location in a function named after its opcode, at the
+// source line it was compiled from.
+func WritePprof(w io.Writer, name string, profile []InstrProfile) error {
+	table := []string{""}
+	stringIndex := map[string]int{"": 0}
+	str := func(s string) uint64 {
+		if i, ok := stringIndex[s]; ok {
+			return uint64(i)
+		}
+		stringIndex[s] = len(table)
+		table = append(table, s)
+		return uint64(len(table) - 1)
+	}
+	valueType := func(typ, unit string) []byte {
+		var b []byte
+		b = protowire.AppendTag(b, valueTypeType, protowire.VarintType)
+		b = protowire.AppendVarint(b, str(typ))
+		b = protowire.AppendTag(b, valueTypeUnit, protowire.VarintType)
+		b = protowire.AppendVarint(b, str(unit))
+		return b
+	}
+
+	var b []byte
+	b = protowire.AppendTag(b, profileSampleType, protowire.BytesType)
+	b = protowire.AppendBytes(b, valueType("instructions", "count"))
+	b = protowire.AppendTag(b, profileSampleType, protowire.BytesType)
+	b = protowire.AppendBytes(b, valueType("time", "nanoseconds"))
+
+	filename := str(name)
+	functions := make(map[code.Opcode]uint64)
+	var functionOrder []code.Opcode
+	for _, p := range profile {
+		if p.Count == 0 {
+			continue
+		}
+		// Location IDs must be nonzero.
+		id := uint64(p.PC + 1)
+		fid, ok := functions[p.Opcode]
+		if !ok {
+			fid = uint64(len(functions) + 1)
+			functions[p.Opcode] = fid
+			functionOrder = append(functionOrder, p.Opcode)
+		}
+
+		var sample []byte
+		sample = protowire.AppendTag(sample, sampleLocationID, protowire.BytesType)
+		sample = protowire.AppendBytes(sample, protowire.AppendVarint(nil, id))
+		var values []byte
+		values = protowire.AppendVarint(values, uint64(p.Count))
+		values = protowire.AppendVarint(values, uint64(p.Time.Nanoseconds()))
+		sample = protowire.AppendTag(sample, sampleValue, protowire.BytesType)
+		sample = protowire.AppendBytes(sample, values)
+		b = protowire.AppendTag(b, profileSample, protowire.BytesType)
+		b = protowire.AppendBytes(b, sample)
+
+		var line []byte
+		line = protowire.AppendTag(line, lineFunctionID, protowire.VarintType)
+		line = protowire.AppendVarint(line, fid)
+		line = protowire.AppendTag(line, lineLine, protowire.VarintType)
+		line = protowire.AppendVarint(line, uint64(p.SourceLine+1))
+		var location []byte
+		location = protowire.AppendTag(location, locationID, protowire.VarintType)
+		location = protowire.AppendVarint(location, id)
+		location = protowire.AppendTag(location, locationLine, protowire.BytesType)
+		location = protowire.AppendBytes(location, line)
+		b = protowire.AppendTag(b, profileLocation, protowire.BytesType)
+		b = protowire.AppendBytes(b, location)
+	}
+	for _, op := range functionOrder {
+		var function []byte
+		function = protowire.AppendTag(function, functionID, protowire.VarintType)
+		function = protowire.AppendVarint(function, functions[op])
+		function = protowire.AppendTag(function, functionName, protowire.VarintType)
+		function = protowire.AppendVarint(function, str(op.String()))
+		function = protowire.AppendTag(function, functionFilename, protowire.VarintType)
+		function = protowire.AppendVarint(function, filename)
+		b = protowire.AppendTag(b, profileFunction, protowire.BytesType)
+		b = protowire.AppendBytes(b, function)
+	}
+	for _, s := range table {
+		b = protowire.AppendTag(b, profileStrings, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	b = protowire.AppendTag(b, profileTimeNanos, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(time.Now().UnixNano()))
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,114 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/testutil"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestProfile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		options []Option
+	}{
+		{"stack", []Option{Profile()}},
+		{"register", []Option{Profile(), RegisterMachine()}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			v := compileForTest(t, benchmarkProgram, tc.options...)
+			for _, l := range benchmarkLines {
+				v.ProcessLogLine(context.Background(), logline.New(context.Background(), "test", l))
+			}
+			p := v.Profile()
+			if len(p) == 0 {
+				t.Fatal("no profile")
+			}
+			// The first instruction is the match of the outermost pattern, executed once per line.
+			if p[0].Count != int64(len(benchmarkLines)) {
+				t.Errorf("first instruction count: got %d, want %d", p[0].Count, len(benchmarkLines))
+			}
+			if p[0].SourceLine != 5 {
+				t.Errorf("first instruction line: got %d, want 5", p[0].SourceLine)
+			}
+
+			merged := MergeProfiles(p, p)
+			if merged[0].Count != 2*p[0].Count {
+				t.Errorf("merged count: got %d, want %d", merged[0].Count, 2*p[0].Count)
+			}
+
+			var b bytes.Buffer
+			testutil.FatalIfErr(t, WriteAnnotatedSource(&b, []byte(benchmarkProgram), p))
+			lines := strings.Split(b.String(), "\n")
+			// Header, then line 6 of the program.
+			if !strings.HasPrefix(strings.TrimSpace(lines[6]), "4 ") {
+				t.Errorf("annotated line 6 doesn't start with the execution count:\n%s", b.String())
+			}
+		})
+	}
+}
+
+func TestProfileDisabled(t *testing.T) {
+	v := compileForTest(t, benchmarkProgram)
+	if p := v.Profile(); p != nil {
+		t.Errorf("unexpected profile: %v", p)
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+	v := compileForTest(t, benchmarkProgram, Profile())
+	for _, l := range benchmarkLines {
+		v.ProcessLogLine(context.Background(), logline.New(context.Background(), "test", l))
+	}
+	var b bytes.Buffer
+	testutil.FatalIfErr(t, WritePprof(&b, "bench", v.Profile()))
+	zr, err := gzip.NewReader(&b)
+	testutil.FatalIfErr(t, err)
+	buf, err := io.ReadAll(zr)
+	testutil.FatalIfErr(t, err)
+
+	fields := make(map[protowire.Number]int)
+	var strs []string
+	for len(buf) > 0 {
+		num, typ, n := protowire.ConsumeTag(buf)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		buf = buf[n:]
+		if num == profileStrings {
+			s, m := protowire.ConsumeString(buf)
+			if m < 0 {
+				t.Fatal(protowire.ParseError(m))
+			}
+			strs = append(strs, s)
+		}
+		n = protowire.ConsumeFieldValue(num, typ, buf)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		buf = buf[n:]
+		fields[num]++
+	}
+	if fields[profileSampleType] != 2 {
+		t.Errorf("sample types: got %d, want 2", fields[profileSampleType])
+	}
+	if fields[profileSample] == 0 || fields[profileSample] != fields[profileLocation] {
+		t.Errorf("samples and locations: got %d and %d", fields[profileSample], fields[profileLocation])
+	}
+	if len(strs) == 0 || strs[0] != "" {
+		t.Errorf("string table must start with the empty string: %q", strs)
+	}
+	if !strings.Contains(strings.Join(strs, " "), "match") {
+		t.Errorf("string table has no match opcode: %q", strs)
+	}
+}
@@ -0,0 +1,1098 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/metrics/datum"
+	"github.com/google/mtail/internal/runtime/code"
+	"github.com/pkg/errors"
+)
+
+// valueKind records which field of a value is live.
+type valueKind uint8
+
+const (
+	kindNone valueKind = iota
+	kindBool
+	kindInt
+	kindFloat
+	kindString
+	kindDuration
+	kindDatum
+	kindMetric
+)
+
+// value is the contents of a register.  Unlike the stack machine's
+// interface{} slots, storing an integer, float or string into a register does
+// not allocate.
+type value struct {
+	kind valueKind
+	i    int64 // bool, int and duration values
+	f    float64
+	s    string
+	d    datum.Datum
+	m    *metrics.Metric
+}
+
+func boolValue(b bool) value {
+	if b {
+		return value{kind: kindBool, i: 1}
+	}
+	return value{kind: kindBool}
+}
+
+func intValue(i int64) value         { return value{kind: kindInt, i: i} }
+func floatValue(f float64) value     { return value{kind: kindFloat, f: f} }
+func stringValue(s string) value     { return value{kind: kindString, s: s} }
+func datumValue(d datum.Datum) value { return value{kind: kindDatum, d: d} }
+
+// valueOf converts an instruction operand into a register value.
+func valueOf(x interface{}) (value, error) {
+	switch n := x.(type) {
+	case bool:
+		return boolValue(n), nil
+	case int:
+		return intValue(int64(n)), nil
+	case int64:
+		return intValue(n), nil
+	case float64:
+		return floatValue(n), nil
+	case string:
+		return stringValue(n), nil
+	case time.Duration:
+		return value{kind: kindDuration, i: int64(n)}, nil
+	case datum.Datum:
+		return datumValue(n), nil
+	case *metrics.Metric:
+		return value{kind: kindMetric, m: n}, nil
+	}
+	return value{}, errors.Errorf("unexpected operand type %T %q", x, x)
+}
+
+// iface returns the register contents as the stack machine would hold them.
+func (r value) iface() interface{} {
+	switch r.kind {
+	case kindBool:
+		return r.i != 0
+	case kindInt:
+		return r.i
+	case kindFloat:
+		return r.f
+	case kindString:
+		return r.s
+	case kindDuration:
+		return time.Duration(r.i)
+	case kindDatum:
+		return r.d
+	case kindMetric:
+		return r.m
+	}
+	return nil
+}
+
+func (r value) String() string {
+	return fmt.Sprintf("%v", r.iface())
+}
+
+func (r value) asInt() (int64, error) {
+	switch r.kind {
+	case kindInt:
+		return r.i, nil
+	case kindString:
+		n, err := strconv.ParseInt(r.s, 10, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "conversion of %q to int failed", r.s)
+		}
+		return n, nil
+	case kindDatum:
+		return datum.GetInt(r.d), nil
+	}
+	return 0, errors.Errorf("unexpected int type %T %q", r.iface(), r.iface())
+}
+
+func (r value) asFloat() (float64, error) {
+	switch r.kind {
+	case kindFloat:
+		return r.f, nil
+	case kindInt:
+		return float64(r.i), nil
+	case kindString:
+		f, err := strconv.ParseFloat(r.s, 64)
+		if err != nil {
+			return 0, errors.Wrapf(err, "conversion of %q to float failed", r.s)
+		}
+		return f, nil
+	case kindDatum:
+		return datum.GetFloat(r.d), nil
+	}
+	return 0, errors.Errorf("unexpected float type %T %q", r.iface(), r.iface())
+}
+
+func (r value) asString() (string, error) {
+	switch r.kind {
+	case kindString:
+		return r.s, nil
+	case kindFloat:
+		return strconv.FormatFloat(r.f, 'G', -1, 64), nil
+	case kindInt:
+		return strconv.FormatInt(r.i, 10), nil
+	case kindDatum:
+		return datum.GetString(r.d), nil
+	}
+	return "", errors.Errorf("unexpected type for string %T %q", r.iface(), r.iface())
+}
+
+// truth returns the truth of a register used as a jump condition, and false
+// in ok if the register holds no truth value.
+func (r value) truth() (truth, ok bool) {
+	switch r.kind {
+	case kindBool, kindInt:
+		return r.i != 0, true
+	}
+	return false, false
+}
+
+// keys reads n datum keys from the registers starting at a.
+func (v *VM) keys(t *thread, a, n int) ([]string, bool) {
+	keys := make([]string, n)
+	for j := 0; j < n; j++ {
+		s, err := t.regs[a+j].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return nil, false
+		}
+		keys[j] = s
+	}
+	return keys, true
+}
+
+// executeRegister performs an instruction cycle in the register machine,
+// acting on the instruction i at program counter pc in thread t.  It mirrors
+// execute, with arguments read from registers instead of popped off the
+// stack.
+func (v *VM) executeRegister(t *thread, pc int, i code.RegInstr) {
+	// In normal operation, recover from panics, otherwise dump that state and repanic.
+	defer func() {
+		if r := recover(); r != nil {
+			if v.HardCrash {
+				fmt.Printf("panic in thread %#v at instr %q: %s\n", t, i, r)
+				panic(r)
+			}
+			v.errorf("panic in thread %#v at instr %q: %s", t, i, r)
+			v.terminate = true
+		}
+	}()
+
+	r := t.regs
+	a := i.Reg
+
+	switch i.Opcode {
+	case code.Bad:
+		panic("Invalid instruction.  Aborting.")
+
+	case code.Stop:
+		v.terminate = true
+
+	case code.Match:
+		r[a] = boolValue(v.matchLine(t, i.Operand.(int)))
+
+	case code.Smatch:
+		index := i.Operand.(int)
+		line, err := r[a].asString()
+		if err != nil {
+			v.errorf("+%v", err)
+			return
+		}
+		if v.overMatchBudget(line) {
+			return
+		}
+		t.matches[index] = v.re[index].FindStringSubmatch(line)
+		r[a] = boolValue(t.matches[index] != nil)
+
+	case code.Cmp:
+		match, err := compare(r[a].iface(), r[a+1].iface(), i.Operand.(int))
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = boolValue(match)
+
+	case code.Icmp:
+		x, err := r[a].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		y, err := r[a+1].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		match, err := compareInt(x, y, i.Operand.(int))
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = boolValue(match)
+
+	case code.Fcmp:
+		x, err := r[a].asFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		y, err := r[a+1].asFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		match, err := compareFloat(x, y, i.Operand.(int))
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = boolValue(match)
+
+	case code.Scmp:
+		x, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		y, err := r[a+1].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		match, err := compareString(x, y, i.Operand.(int))
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = boolValue(match)
+
+	case code.Jnm:
+		if match, ok := r[a].truth(); ok && !match {
+			t.pc = i.Operand.(int)
+		}
+
+	case code.Jm:
+		if match, ok := r[a].truth(); ok && match {
+			t.pc = i.Operand.(int)
+		}
+
+	case code.Jmp:
+		t.pc = i.Operand.(int)
+
+	case code.Inc, code.Dec:
+		var delta int64 = 1
+		// If opnd is non-nil, the delta is in the next register.
+		if i.Operand != nil {
+			var err error
+			delta, err = r[a+1].asInt()
+			if err != nil {
+				v.errorf("%s", err)
+				return
+			}
+		}
+		if r[a].kind != kindDatum {
+			v.errorf("Unexpected type to increment: %T %q", r[a].iface(), r[a].iface())
+			return
+		}
+		d := r[a].d
+		if i.Opcode == code.Inc {
+			datum.IncIntBy(d, delta, t.time)
+		} else {
+			datum.DecIntBy(d, delta, t.time)
+		}
+		r[a] = intValue(datum.GetInt(d))
+
+	case code.Iset, code.Fset, code.Sset:
+		if r[a].kind != kindDatum {
+			v.errorf("Unexpected type to %s: %T %q", i.Opcode, r[a].iface(), r[a].iface())
+			return
+		}
+		switch i.Opcode {
+		case code.Iset:
+			value, err := r[a+1].asInt()
+			if err != nil {
+				v.errorf("%s", err)
+				return
+			}
+			datum.SetInt(r[a].d, value, t.time)
+		case code.Fset:
+			value, err := r[a+1].asFloat()
+			if err != nil {
+				v.errorf("%s", err)
+				return
+			}
+			datum.SetFloat(r[a].d, value, t.time)
+		case code.Sset:
+			value, err := r[a+1].asString()
+			if err != nil {
+				v.errorf("%+v", err)
+				return
+			}
+			datum.SetString(r[a].d, value, t.time)
+		}
+
+	case code.Strptime:
+		ts, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		layout, err := r[a+1].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		if cached, ok := v.timeMemos.Get(ts); !ok {
+			tm := v.ParseTime(layout, ts)
+			v.timeMemos.Add(ts, tm)
+			t.time = tm
+		} else {
+			t.time = cached.(time.Time)
+		}
+
+	case code.Timestamp:
+		if t.time.IsZero() {
+			r[a] = intValue(time.Now().Unix())
+		} else {
+			r[a] = intValue(t.time.Unix())
+		}
+
+	case code.TimestampNs:
+		if t.time.IsZero() {
+			r[a] = intValue(time.Now().UnixNano())
+		} else {
+			r[a] = intValue(t.time.UnixNano())
+		}
+
+	case code.Settime:
+		switch r[a].kind {
+		case kindInt:
+			t.time = time.Unix(r[a].i, 0).UTC()
+		case kindFloat:
+			t.time = unixTime(r[a].f, nil)
+		default:
+			v.errorf("Failed to pop a timestamp off the stack: %v instead", r[a])
+			return
+		}
+
+	case code.Capref:
+		if r[a].kind != kindInt {
+			v.errorf("Invalid re index %v, not an int", r[a])
+			return
+		}
+		re := int(r[a].i)
+		op, ok := i.Operand.(int)
+		if !ok {
+			v.errorf("Invalid operand %v, not an int", i.Operand)
+			return
+		}
+		if len(t.matches[re]) <= op {
+			v.errorf("Not enough capture groups matched from %v to select %dth", t.matches[re], op)
+			return
+		}
+		r[a] = stringValue(t.matches[re][op])
+
+	case code.Str:
+		r[a] = stringValue(v.str[i.Operand.(int)])
+
+	case code.Push:
+		r[a] = v.regConsts[pc]
+
+	case code.Fadd, code.Fsub, code.Fmul, code.Fdiv, code.Fmod, code.Fpow:
+		x, err := r[a].asFloat()
+		if err != nil {
+			v.errorf("%s", err)
+			return
+		}
+		y, err := r[a+1].asFloat()
+		if err != nil {
+			v.errorf("%s", err)
+			return
+		}
+		switch i.Opcode {
+		case code.Fadd:
+			r[a] = floatValue(x + y)
+		case code.Fsub:
+			r[a] = floatValue(x - y)
+		case code.Fmul:
+			r[a] = floatValue(x * y)
+		case code.Fdiv:
+			r[a] = floatValue(x / y)
+		case code.Fmod:
+			r[a] = floatValue(math.Mod(x, y))
+		case code.Fpow:
+			r[a] = floatValue(math.Pow(x, y))
+		}
+
+	case code.Iadd, code.Isub, code.Imul, code.Idiv, code.Imod, code.Ipow, code.Shl, code.Shr, code.And, code.Or, code.Xor:
+		x, err := r[a].asInt()
+		if err != nil {
+			v.errorf("%s", err)
+			return
+		}
+		y, err := r[a+1].asInt()
+		if err != nil {
+			v.errorf("%s", err)
+			return
+		}
+		switch i.Opcode {
+		case code.Iadd:
+			r[a] = intValue(x + y)
+		case code.Isub:
+			r[a] = intValue(x - y)
+		case code.Imul:
+			r[a] = intValue(x * y)
+		case code.Idiv:
+			if y == 0 {
+				v.errorf("Divide by zero %d %% %d", x, y)
+				return
+			}
+			r[a] = intValue(x / y)
+		case code.Imod:
+			if y == 0 {
+				v.errorf("Divide by zero %d %% %d", x, y)
+				return
+			}
+			r[a] = intValue(x % y)
+		case code.Ipow:
+			r[a] = intValue(int64(math.Pow(float64(x), float64(y))))
+		case code.Shl:
+			if y < 0 || y >= math.MaxInt32 {
+				v.errorf("shift int out of range")
+				return
+			}
+			r[a] = intValue(x << uint(y))
+		case code.Shr:
+			if y < 0 || y >= math.MaxInt32 {
+				v.errorf("shift int out of range")
+				return
+			}
+			r[a] = intValue(x >> uint(y))
+		case code.And:
+			r[a] = intValue(x & y)
+		case code.Or:
+			r[a] = intValue(x | y)
+		case code.Xor:
+			r[a] = intValue(x ^ y)
+		}
+
+	case code.Neg:
+		x, err := r[a].asInt()
+		if err != nil {
+			v.errorf("%s", err)
+			return
+		}
+		r[a] = intValue(^x)
+
+	case code.Not:
+		if r[a].kind != kindBool {
+			v.errorf("Unexpected type to not: %T %q", r[a].iface(), r[a].iface())
+			return
+		}
+		r[a] = boolValue(r[a].i == 0)
+
+	case code.Mload:
+		r[a] = value{kind: kindMetric, m: v.Metrics[i.Operand.(int)]}
+
+	case code.Dload:
+		n := i.Operand.(int)
+		keys, ok := v.keys(t, a, n)
+		if !ok {
+			return
+		}
+		d, err := r[a+n].m.GetDatum(keys...)
+		if err != nil {
+			v.errorf("dload (GetDatum) failed: %s", err)
+			return
+		}
+		r[a] = datumValue(d)
+
+	case code.Iget, code.Fget, code.Sget:
+		if r[a].kind != kindDatum {
+			v.errorf("Unexpected value on stack: %q", r[a].iface())
+			return
+		}
+		switch i.Opcode {
+		case code.Iget:
+			r[a] = intValue(datum.GetInt(r[a].d))
+		case code.Fget:
+			r[a] = floatValue(datum.GetFloat(r[a].d))
+		case code.Sget:
+			r[a] = stringValue(datum.GetString(r[a].d))
+		}
+
+	case code.Del:
+		n := i.Operand.(int)
+		keys, ok := v.keys(t, a, n)
+		if !ok {
+			return
+		}
+		if err := r[a+n].m.RemoveDatum(keys...); err != nil {
+			v.errorf("del (RemoveDatum) failed: %s", err)
+			return
+		}
+
+	case code.Expire:
+		n := i.Operand.(int)
+		keys, ok := v.keys(t, a+1, n)
+		if !ok {
+			return
+		}
+		if err := r[a+n+1].m.ExpireDatum(time.Duration(r[a].i), keys...); err != nil {
+			v.errorf("%s", err)
+			return
+		}
+
+	case code.Tolower:
+		s, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = stringValue(strings.ToLower(s))
+
+	case code.Toupper:
+		s, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = stringValue(strings.ToUpper(s))
+
+	case code.Trim:
+		s, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = stringValue(strings.TrimSpace(s))
+
+	case code.Substr:
+		s, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		start, err := r[a+1].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		length, err := r[a+2].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = stringValue(substr(s, start, length))
+
+	case code.Split:
+		s, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		sep, err := r[a+1].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		n, err := r[a+2].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = stringValue(splitField(s, sep, n))
+
+	case code.Index, code.Hasprefix, code.Hassuffix:
+		s, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		sub, err := r[a+1].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		switch i.Opcode {
+		case code.Index:
+			r[a] = intValue(int64(strings.Index(s, sub)))
+		case code.Hasprefix:
+			r[a] = boolValue(strings.HasPrefix(s, sub))
+		case code.Hassuffix:
+			r[a] = boolValue(strings.HasSuffix(s, sub))
+		}
+
+	case code.Sprintf:
+		format, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		args := make([]interface{}, i.Operand.(int)-1)
+		for j := range args {
+			args[j] = r[a+1+j].iface()
+		}
+		r[a] = stringValue(fmt.Sprintf(format, args...))
+
+	case code.Duration:
+		s, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		d, err := parseDuration(s)
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = floatValue(d)
+
+	case code.Bytes:
+		s, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		n, err := parseBytes(s)
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = intValue(n)
+
+	case code.Ipnet:
+		s, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		bits, err := r[a+1].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		n, err := ipnet(s, bits)
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = stringValue(n)
+
+	case code.Incidr:
+		s, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		var p netip.Prefix
+		if i.Operand != nil {
+			p = v.prefixes[i.Operand.(int)]
+		} else {
+			cidr, err := r[a+1].asString()
+			if err != nil {
+				v.errorf("%+v", err)
+				return
+			}
+			p, err = code.ParsePrefix(cidr)
+			if err != nil {
+				v.errorf("%+v", err)
+				return
+			}
+		}
+		r[a] = boolValue(incidr(s, p))
+
+	case code.Isipv6:
+		s, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = boolValue(isipv6(s))
+
+	case code.Clientip:
+		peer, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		forwarded, err := r[a+1].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		ip, err := clientip(peer, forwarded)
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = stringValue(ip)
+
+	case code.Strftime:
+		layout, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		ts, err := r[a+1].asFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = stringValue(strftime(layout, ts, v.loc))
+
+	case code.Hour, code.Weekday, code.Dayofyear:
+		ts, err := r[a].asFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = intValue(timeFuncs[i.Opcode](ts, v.loc))
+
+	case code.Now:
+		r[a] = intValue(time.Now().Unix())
+
+	case code.Iabs:
+		x, err := r[a].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		if x < 0 {
+			x = -x
+		}
+		r[a] = intValue(x)
+
+	case code.Fabs, code.Floor, code.Ceil, code.Round, code.Log, code.Log10, code.Exp, code.Sqrt:
+		x, err := r[a].asFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = floatValue(mathFuncs[i.Opcode](x))
+
+	case code.Imin, code.Imax:
+		x, err := r[a].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		y, err := r[a+1].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		if (i.Opcode == code.Imin) == (y < x) {
+			x = y
+		}
+		r[a] = intValue(x)
+
+	case code.Fmin, code.Fmax:
+		x, err := r[a].asFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		y, err := r[a+1].asFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		if i.Opcode == code.Fmin {
+			r[a] = floatValue(math.Min(x, y))
+		} else {
+			r[a] = floatValue(math.Max(x, y))
+		}
+
+	case code.Iclamp:
+		x, err := r[a].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		lo, err := r[a+1].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		hi, err := r[a+2].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = intValue(iclamp(x, lo, hi))
+
+	case code.Fclamp:
+		x, err := r[a].asFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		lo, err := r[a+1].asFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		hi, err := r[a+2].asFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = floatValue(math.Min(math.Max(x, lo), hi))
+
+	case code.Length:
+		s, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = intValue(int64(len(s)))
+
+	case code.S2i:
+		base := 10
+		if i.Operand != nil {
+			// strtol is emitted with an arglen, int is not
+			val, err := r[a+1].asInt()
+			if err != nil {
+				v.errorf("%s", err)
+				return
+			}
+			if val <= 0 || val >= math.MaxInt32 {
+				v.errorf("int32 out of range")
+				return
+			}
+			base = int(val)
+		}
+		str, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		val, err := strconv.ParseInt(str, base, 64)
+		if err != nil {
+			v.errorf("%s", err)
+			return
+		}
+		r[a] = intValue(val)
+
+	case code.S2f:
+		str, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		f, err := strconv.ParseFloat(str, 64)
+		if err != nil {
+			v.errorf("%s", err)
+			return
+		}
+		r[a] = floatValue(f)
+
+	case code.I2f:
+		x, err := r[a].asInt()
+		if err != nil {
+			v.errorf("%s", err)
+			return
+		}
+		r[a] = floatValue(float64(x))
+
+	case code.I2s:
+		x, err := r[a].asInt()
+		if err != nil {
+			v.errorf("%s", err)
+			return
+		}
+		r[a] = stringValue(strconv.FormatInt(x, 10))
+
+	case code.F2s:
+		f, err := r[a].asFloat()
+		if err != nil {
+			v.errorf("%s", err)
+			return
+		}
+		r[a] = stringValue(fmt.Sprintf("%g", f))
+
+	case code.Setmatched:
+		t.matched = i.Operand.(bool)
+
+	case code.Otherwise:
+		r[a] = boolValue(!t.matched)
+
+	case code.Getfilename:
+		r[a] = stringValue(v.input.Filename)
+
+	case code.Cat:
+		x, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		y, err := r[a+1].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = stringValue(x + y)
+
+	case code.Subst:
+		old, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		repl, err := r[a+1].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		val, err := r[a+2].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		r[a] = stringValue(strings.ReplaceAll(val, old, repl))
+
+	case code.Rsubst:
+		repl, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		val, err := r[a+1].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		pat, err := r[a+2].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		if v.overMatchBudget(val) {
+			return
+		}
+		r[a] = stringValue(v.re[pat].ReplaceAllLiteralString(val, repl))
+
+	case code.Mapget, code.Mapin:
+		key, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		m, err := r[a+1].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		val, ok := v.maps[m][key]
+		if i.Opcode == code.Mapin {
+			r[a] = boolValue(ok)
+			return
+		}
+		if !ok {
+			v.errorf("key %q not found in map", key)
+			return
+		}
+		if r[a], err = valueOf(val); err != nil {
+			v.errorf("%+v", err)
+		}
+
+	case code.Maplookup:
+		m, err := r[a].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		key, err := r[a+1].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		if val, ok := v.maps[m][key]; ok {
+			if r[a], err = valueOf(val); err != nil {
+				v.errorf("%+v", err)
+			}
+		} else {
+			r[a] = r[a+2]
+		}
+
+	case code.Mapset:
+		key, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		m, err := r[a+1].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		v.maps[m][key] = r[a+2].iface()
+
+	case code.Mapdel:
+		key, err := r[a].asString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		m, err := r[a+1].asInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		delete(v.maps[m], key)
+
+	default:
+		v.errorf("illegal instruction: %d", i.Opcode)
+	}
+}
+
+// initRegisters prepares the VM to run the register bytecode in obj.  If obj
+// has no register bytecode, for example because it was assembled by hand, the
+// VM falls back to the stack machine.
+func (v *VM) initRegisters(obj *code.Object) {
+	if len(obj.Functions) > 0 {
+		glog.Infof("%s: program declares functions, running on the stack machine", v.name)
+		v.useRegisters = false
+		return
+	}
+	if len(obj.RegProgram) != len(obj.Program) {
+		glog.Warningf("%s: no register bytecode, running on the stack machine", v.name)
+		v.useRegisters = false
+		return
+	}
+	v.regProg = obj.RegProgram
+	v.registers = obj.Registers
+	v.regConsts = make([]value, len(v.regProg))
+	for pc, i := range v.regProg {
+		if i.Opcode != code.Push {
+			continue
+		}
+		c, err := valueOf(i.Operand)
+		if err != nil {
+			glog.Warningf("%s: %s at instruction %d, running on the stack machine", v.name, err, pc)
+			v.useRegisters = false
+			return
+		}
+		v.regConsts[pc] = c
+	}
+	v.regThread = &thread{
+		regs:    make([]value, v.registers),
+		matches: make(map[int][]string, len(v.re)),
+	}
+}
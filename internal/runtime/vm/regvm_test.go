@@ -0,0 +1,100 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/runtime/compiler"
+	"github.com/google/mtail/internal/testutil"
+)
+
+const benchmarkProgram = `counter request_total by method, code
+counter request_bytes_total by method
+histogram request_time buckets 0, 1, 5, 10
+gauge last_code
+
+/^(?P<method>[A-Z]+) (?P<path>\S+) (?P<code>\d{3}) (?P<size>\d+) (?P<time>\d+\.\d+)$/ {
+  request_total[$method][$code]++
+  request_bytes_total[$method] += $size
+  request_time = $time
+  $code >= 500 {
+    last_code = $code
+  }
+  len($path) > 10 && tolower($method) == "get" {
+    request_total[$method]["long"]++
+  }
+}
+`
+
+var benchmarkLines = []string{
+	"GET /index.html 200 1024 0.5",
+	"POST /api/v1/users/create 500 20 3.25",
+	"GET /favicon.ico 404 0 0.01",
+	"this line does not match",
+}
+
+// compileForTest compiles source into a new VM constructed with options.
+func compileForTest(tb testing.TB, source string, options ...Option) *VM {
+	tb.Helper()
+	c, err := compiler.New()
+	testutil.FatalIfErr(tb, err)
+	obj, err := c.Compile("bench", strings.NewReader(source))
+	testutil.FatalIfErr(tb, err)
+	return New("bench", obj, true, nil, false, false, options...)
+}
+
+func TestRegisterMachineMatchesStackMachine(t *testing.T) {
+	stack := compileForTest(t, benchmarkProgram)
+	reg := compileForTest(t, benchmarkProgram, RegisterMachine())
+	if !reg.useRegisters {
+		t.Fatal("register machine not enabled")
+	}
+	for _, l := range benchmarkLines {
+		stack.ProcessLogLine(context.Background(), logline.New(context.Background(), "test", l))
+		reg.ProcessLogLine(context.Background(), logline.New(context.Background(), "test", l))
+	}
+	if stack.RuntimeErrorString() != "" || reg.RuntimeErrorString() != "" {
+		t.Fatalf("unexpected runtime errors: %q, %q", stack.RuntimeErrorString(), reg.RuntimeErrorString())
+	}
+	for i, m := range stack.Metrics {
+		want := map[string]string{}
+		for _, lv := range m.LabelValues {
+			want[strings.Join(lv.Labels, ",")] = lv.Value.ValueString()
+		}
+		got := map[string]string{}
+		for _, lv := range reg.Metrics[i].LabelValues {
+			got[strings.Join(lv.Labels, ",")] = lv.Value.ValueString()
+		}
+		testutil.ExpectNoDiff(t, want, got)
+	}
+}
+
+func BenchmarkProcessLogLine(b *testing.B) {
+	for _, bm := range []struct {
+		name    string
+		options []Option
+	}{
+		{"stack", nil},
+		{"register", []Option{RegisterMachine()}},
+	} {
+		bm := bm
+		b.Run(bm.name, func(b *testing.B) {
+			v := compileForTest(b, benchmarkProgram, bm.options...)
+			ctx := context.Background()
+			lines := make([]*logline.LogLine, 0, len(benchmarkLines))
+			for _, l := range benchmarkLines {
+				lines = append(lines, logline.New(ctx, "bench", l))
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				v.ProcessLogLine(ctx, lines[i%len(lines)])
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/logline"
+)
+
+const headerProgram = `counter lines_total
+/^# time (?P<t>\S+)$/ {
+  strptime($t, "2006-01-02T15:04:05")
+}
+/^set (?P<t>\d+)$/ {
+  settime($t)
+}
+/^\w+ up$/ {
+  lines_total++
+}
+`
+
+var persistTimeTests = []struct {
+	name  string
+	prog  string
+	lines []string
+	want  []int64 // Unix time of the time register after each line, or 0 if unset.
+}{
+	{
+		"header",
+		"pragma persist_time\n" + headerProgram,
+		[]string{"# time 2026-01-02T03:04:05", "a up", "b up", "# time 2026-01-02T03:05:05", "c up"},
+		[]int64{1767323045, 1767323045, 1767323045, 1767323105, 1767323105},
+	},
+	{
+		"settime",
+		"pragma persist_time\n" + headerProgram,
+		[]string{"a up", "set 1000", "b up", "# time 2026-01-02T03:04:05", "set 2000", "c up"},
+		[]int64{0, 1000, 1000, 1767323045, 2000, 2000},
+	},
+	{
+		"no strptime",
+		"pragma persist_time\ncounter lines_total\n/up/ {\n  lines_total++\n}\n",
+		[]string{"a up", "b up"},
+		[]int64{0, 0},
+	},
+	{
+		"not persisted",
+		headerProgram,
+		[]string{"# time 2026-01-02T03:04:05", "a up", "set 1000", "b up"},
+		[]int64{1767323045, 0, 1000, 0},
+	},
+}
+
+func TestPersistTime(t *testing.T) {
+	for _, tc := range persistTimeTests {
+		tc := tc
+		for _, machine := range []struct {
+			name string
+			opts []Option
+		}{
+			{"stack", nil},
+			{"register", []Option{RegisterMachine()}},
+		} {
+			machine := machine
+			t.Run(tc.name+"/"+machine.name, func(t *testing.T) {
+				v := compileForTest(t, tc.prog, machine.opts...)
+				for i, l := range tc.lines {
+					v.ProcessLogLine(context.Background(), logline.New(context.Background(), "test", l))
+					if err := v.RuntimeErrorString(); err != "" {
+						t.Fatalf("line %q: runtime error %s", l, err)
+					}
+					got := v.State().Time
+					if tc.want[i] == 0 {
+						if !got.IsZero() {
+							t.Errorf("line %q: time register %v, want unset", l, got)
+						}
+						continue
+					}
+					if want := time.Unix(tc.want[i], 0); !got.Equal(want) {
+						t.Errorf("line %q: time register %v, want %v", l, got, want)
+					}
+				}
+			})
+		}
+	}
+}
+
+// The debugger steps through lines with StartLine and Step, which must keep
+// the time register as ProcessLogLine does.
+func TestPersistTimeStepped(t *testing.T) {
+	v := compileForTest(t, "pragma persist_time\n"+headerProgram)
+	for _, l := range []string{"set 1000", "a up"} {
+		v.StartLine(logline.New(context.Background(), "test", l))
+		for !v.Step() {
+		}
+	}
+	if got, want := v.State().Time, time.Unix(1000, 0); !got.Equal(want) {
+		t.Errorf("time register %v, want %v", got, want)
+	}
+}
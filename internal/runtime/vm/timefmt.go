@@ -0,0 +1,49 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"math"
+	"time"
+
+	"github.com/google/mtail/internal/runtime/code"
+)
+
+// unixTime returns the time of ts seconds since the Unix epoch, with any
+// fraction of a second kept, in the location loc, or UTC if loc is nil.
+func unixTime(ts float64, loc *time.Location) time.Time {
+	if loc == nil {
+		loc = time.UTC
+	}
+	sec, frac := math.Modf(ts)
+	return time.Unix(int64(sec), int64(math.Round(frac*1e9))).In(loc)
+}
+
+// strftime returns the timestamp ts formatted with the Go time layout.
+func strftime(layout string, ts float64, loc *time.Location) string {
+	return unixTime(ts, loc).Format(layout)
+}
+
+// hour returns the hour of the day of timestamp ts, from 0 to 23.
+func hour(ts float64, loc *time.Location) int64 {
+	return int64(unixTime(ts, loc).Hour())
+}
+
+// weekday returns the day of the week of timestamp ts, from 0 for Sunday to
+// 6 for Saturday.
+func weekday(ts float64, loc *time.Location) int64 {
+	return int64(unixTime(ts, loc).Weekday())
+}
+
+// dayofyear returns the day of the year of timestamp ts, from 1 to 366.
+func dayofyear(ts float64, loc *time.Location) int64 {
+	return int64(unixTime(ts, loc).YearDay())
+}
+
+// timeFuncs are the implementations of the calendar field opcodes.
+var timeFuncs = map[code.Opcode]func(float64, *time.Location) int64{
+	code.Hour:      hour,
+	code.Weekday:   weekday,
+	code.Dayofyear: dayofyear,
+}
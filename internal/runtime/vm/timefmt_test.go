@@ -0,0 +1,58 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStrftime(t *testing.T) {
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Skipf("timezone database not available: %s", err)
+	}
+	for _, tc := range []struct {
+		layout string
+		ts     float64
+		loc    *time.Location
+		want   string
+	}{
+		{time.RFC3339, 1700000000, nil, "2023-11-14T22:13:20Z"},
+		{time.RFC3339, 1700000000, sydney, "2023-11-15T09:13:20+11:00"},
+		{"15:04:05.000", 1700000000.125, nil, "22:13:20.125"},
+		{"15:04:05.000000", -0.5, nil, "23:59:59.500000"},
+		{"2006-01-02", 0, nil, "1970-01-01"},
+	} {
+		if got := strftime(tc.layout, tc.ts, tc.loc); got != tc.want {
+			t.Errorf("strftime(%q, %v, %v) = %q, want %q", tc.layout, tc.ts, tc.loc, got, tc.want)
+		}
+	}
+}
+
+func TestCalendarFields(t *testing.T) {
+	sydney, err := time.LoadLocation("Australia/Sydney")
+	if err != nil {
+		t.Skipf("timezone database not available: %s", err)
+	}
+	// 2023-11-14T22:13:20Z is a Tuesday, and Wednesday morning in Sydney.
+	const ts = 1700000000
+	for _, tc := range []struct {
+		loc                 *time.Location
+		hour, weekday, yday int64
+	}{
+		{nil, 22, 2, 318},
+		{sydney, 9, 3, 319},
+	} {
+		if got := hour(ts, tc.loc); got != tc.hour {
+			t.Errorf("hour(%d, %v) = %d, want %d", ts, tc.loc, got, tc.hour)
+		}
+		if got := weekday(ts, tc.loc); got != tc.weekday {
+			t.Errorf("weekday(%d, %v) = %d, want %d", ts, tc.loc, got, tc.weekday)
+		}
+		if got := dayofyear(ts, tc.loc); got != tc.yday {
+			t.Errorf("dayofyear(%d, %v) = %d, want %d", ts, tc.loc, got, tc.yday)
+		}
+	}
+}
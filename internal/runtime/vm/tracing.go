@@ -0,0 +1,49 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"context"
+
+	"github.com/google/mtail/internal/logline"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/google/mtail/internal/runtime/vm")
+
+// startLineSpan starts the span of the program's execution on a line, as a
+// child of the span in ctx if any.  Whether it is recorded is up to the
+// sampler of the global tracer provider.
+func (v *VM) startLineSpan(ctx context.Context, line *logline.LogLine) trace.Span {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := tracer.Start(ctx, "vm.ProcessLogLine")
+	if span.IsRecording() {
+		span.SetAttributes(
+			attribute.String("mtail.program", v.name),
+			attribute.String("mtail.log", line.Filename),
+		)
+		v.span = span
+	}
+	return span
+}
+
+// endLineSpan ends the span of the execution on the current line.
+func (v *VM) endLineSpan(span trace.Span) {
+	v.span = nil
+	span.End()
+}
+
+// recordSpanError marks the span of the execution on the current line, if it
+// is recorded, as failed with the runtime error.
+func (v *VM) recordSpanError(msg string) {
+	if v.span == nil {
+		return
+	}
+	v.span.SetStatus(codes.Error, msg)
+}
@@ -0,0 +1,53 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+func TestProcessLogLineSpan(t *testing.T) {
+	exp := testutil.RecordSpans(t)
+	v := compileForTest(t, `/(\d+)/ {
+  strptime($1, "2006-01-02")
+}
+`)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	v.ProcessLogLine(ctx, logline.New(ctx, "/var/log/test.log", "no digits"))
+	v.ProcessLogLine(ctx, logline.New(ctx, "/var/log/test.log", "123"))
+	parent.End()
+
+	got := exp.GetSpans()
+	if len(got) != 3 {
+		t.Fatalf("got %d spans, want 3: %v", len(got), got)
+	}
+	for i, s := range got[:2] {
+		if s.Name != "vm.ProcessLogLine" {
+			t.Errorf("span %d name %q", i, s.Name)
+		}
+		if s.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %d is not a child of the line's span", i)
+		}
+		attrs := attribute.NewSet(s.Attributes...)
+		if v, _ := attrs.Value("mtail.program"); v.AsString() != "bench" {
+			t.Errorf("span %d program %q", i, v.AsString())
+		}
+		if v, _ := attrs.Value("mtail.log"); v.AsString() != "/var/log/test.log" {
+			t.Errorf("span %d log %q", i, v.AsString())
+		}
+	}
+	if got[0].Status.Code != codes.Unset {
+		t.Errorf("span of line without error has status %v", got[0].Status)
+	}
+	if got[1].Status.Code != codes.Error {
+		t.Errorf("span of line with runtime error has status %v", got[1].Status)
+	}
+}
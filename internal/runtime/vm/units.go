@@ -0,0 +1,123 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// durationUnits are the seconds in each unit accepted by parseDuration in
+// addition to those accepted by time.ParseDuration.
+var durationUnits = map[string]float64{
+	"":        1,
+	"ns":      1e-9,
+	"nsec":    1e-9,
+	"nsecs":   1e-9,
+	"us":      1e-6,
+	"µs":      1e-6, // U+00B5 micro sign
+	"μs":      1e-6, // U+03BC Greek letter mu
+	"usec":    1e-6,
+	"usecs":   1e-6,
+	"ms":      1e-3,
+	"msec":    1e-3,
+	"msecs":   1e-3,
+	"s":       1,
+	"sec":     1,
+	"secs":    1,
+	"second":  1,
+	"seconds": 1,
+	"m":       60,
+	"min":     60,
+	"mins":    60,
+	"minute":  60,
+	"minutes": 60,
+	"h":       3600,
+	"hr":      3600,
+	"hrs":     3600,
+	"hour":    3600,
+	"hours":   3600,
+	"d":       86400,
+	"day":     86400,
+	"days":    86400,
+}
+
+// parseDuration returns the number of seconds in the duration s.  s may be in
+// the syntax accepted by time.ParseDuration, like "1m2.5s", or a single
+// number followed by an optional unit like "12 msec" or "3 days".  A number
+// without a unit is in seconds.
+func parseDuration(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return d.Seconds(), nil
+	}
+	n, unit := splitNumber(s)
+	mult, ok := durationUnits[strings.ToLower(unit)]
+	if n == "" || !ok {
+		return 0, errors.Errorf("invalid duration %q", s)
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid duration %q", s)
+	}
+	return f * mult, nil
+}
+
+// byteUnits are the multipliers of the unit prefixes accepted by parseBytes.
+// Prefixes ending in i are IEC binary multiples, the others are SI decimal
+// multiples.
+var byteUnits = map[string]float64{
+	"":   1,
+	"k":  1e3,
+	"m":  1e6,
+	"g":  1e9,
+	"t":  1e12,
+	"p":  1e15,
+	"e":  1e18,
+	"ki": 1 << 10,
+	"mi": 1 << 20,
+	"gi": 1 << 30,
+	"ti": 1 << 40,
+	"pi": 1 << 50,
+	"ei": 1 << 60,
+}
+
+// parseBytes returns the number of bytes in the size s, a number followed by
+// an optional unit like "512", "4.2KiB", "1.1G" or "10 MB".  The unit is not
+// case sensitive, and a trailing "b" or "B" for bytes is optional.
+func parseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	n, unit := splitNumber(s)
+	unit = strings.TrimSuffix(strings.ToLower(unit), "b")
+	mult, ok := byteUnits[unit]
+	if n == "" || !ok {
+		return 0, errors.Errorf("invalid size %q", s)
+	}
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid size %q", s)
+	}
+	f = math.Round(f * mult)
+	if f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, errors.Errorf("size %q out of range", s)
+	}
+	return int64(f), nil
+}
+
+// splitNumber splits s into a leading decimal number and the unit that
+// follows it, ignoring white space between the two.
+func splitNumber(s string) (number, unit string) {
+	i := 0
+	if i < len(s) && (s[i] == '-' || s[i] == '+') {
+		i++
+	}
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
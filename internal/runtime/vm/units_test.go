@@ -0,0 +1,86 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package vm
+
+import (
+	"math"
+	"testing"
+)
+
+var parseDurationTests = []struct {
+	s    string
+	want float64
+}{
+	{"12.3ms", 0.0123},
+	{"1m2s", 62},
+	{"850µs", 0.00085},
+	{"850μs", 0.00085},
+	{"850us", 0.00085},
+	{"1h30m", 5400},
+	{"-1.5s", -1.5},
+	{"12 msec", 0.012},
+	{"3 days", 259200},
+	{"2min", 120},
+	{"1.5 Hours", 5400},
+	{"42", 42},
+	{" 7s ", 7},
+}
+
+func TestParseDuration(t *testing.T) {
+	for _, tc := range parseDurationTests {
+		tc := tc
+		t.Run(tc.s, func(t *testing.T) {
+			got, err := parseDuration(tc.s)
+			if err != nil {
+				t.Fatalf("parseDuration(%q) failed: %s", tc.s, err)
+			}
+			if math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("parseDuration(%q) = %v, want %v", tc.s, got, tc.want)
+			}
+		})
+	}
+}
+
+var parseBytesTests = []struct {
+	s    string
+	want int64
+}{
+	{"512", 512},
+	{"512B", 512},
+	{"4.2KiB", 4301},
+	{"1.1G", 1100000000},
+	{"10 MB", 10000000},
+	{"1.5kb", 1500},
+	{"2Mi", 2097152},
+	{"1TiB", 1 << 40},
+	{"-3K", -3000},
+}
+
+func TestParseBytes(t *testing.T) {
+	for _, tc := range parseBytesTests {
+		tc := tc
+		t.Run(tc.s, func(t *testing.T) {
+			got, err := parseBytes(tc.s)
+			if err != nil {
+				t.Fatalf("parseBytes(%q) failed: %s", tc.s, err)
+			}
+			if got != tc.want {
+				t.Errorf("parseBytes(%q) = %v, want %v", tc.s, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseUnitsInvalid(t *testing.T) {
+	for _, s := range []string{"", "ms", "12 parsecs", "1.2.3s", "-"} {
+		if got, err := parseDuration(s); err == nil {
+			t.Errorf("parseDuration(%q) = %v, want error", s, got)
+		}
+	}
+	for _, s := range []string{"", "KiB", "12 bits", "1.2.3M", "1 KiKi", "20EiB"} {
+		if got, err := parseBytes(s); err == nil {
+			t.Errorf("parseBytes(%q) = %v, want error", s, got)
+		}
+	}
+}
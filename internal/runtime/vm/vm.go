@@ -11,6 +11,7 @@ import (
 	"expvar"
 	"fmt"
 	"math"
+	"net/netip"
 	"regexp"
 	"runtime/debug"
 	"strconv"
@@ -25,12 +26,24 @@ import (
 	"github.com/google/mtail/internal/metrics"
 	"github.com/google/mtail/internal/metrics/datum"
 	"github.com/google/mtail/internal/runtime/code"
+	"github.com/google/mtail/internal/runtime/compiler/position"
+	"github.com/google/mtail/internal/selfmetrics"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
+	// ProgRuntimeErrors counts the runtime errors encountered by each program.
 	ProgRuntimeErrors = expvar.NewMap("prog_runtime_errors_total")
+	// LineBudgetExceeded counts the lines abandoned by each program for exceeding the execution budget.
+	LineBudgetExceeded = expvar.NewMap("prog_line_budget_exceeded_total")
+
+	// SelfMetrics describes the counters above for export to Prometheus.
+	SelfMetrics = []selfmetrics.Var{
+		selfmetrics.CounterMap(ProgRuntimeErrors, "prog_runtime_errors_total", "number of errors encountered when executing programs per source filename", "prog"),
+		selfmetrics.CounterMap(LineBudgetExceeded, "prog_line_budget_exceeded_total", "number of lines abandoned for exceeding the execution budget per source filename", "prog"),
+	}
 
 	LineProcessingDurations = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: "mtail",
@@ -47,8 +60,20 @@ type thread struct {
 	matches map[int][]string // Match result variables.
 	time    time.Time        // Time register.
 	stack   []interface{}    // Data stack.
+	regs    []value          // Register file, when running on the register machine.
+	frames  []frame          // Call stack of user-defined functions.
+}
+
+// frame records the state of the caller of a user-defined function.
+type frame struct {
+	ret  int // Program counter to return to.
+	base int // Offset in the data stack of the function's first argument.
 }
 
+// defaultMaxCallDepth is the limit on nested function calls when the program
+// doesn't set one.
+const defaultMaxCallDepth = 100
+
 // VM describes the virtual machine for each program.  It contains virtual
 // segments of the executable bytecode, constant data (string and regular
 // expressions), mutable state (metrics), and a stack for the current thread of
@@ -56,19 +81,34 @@ type thread struct {
 type VM struct {
 	name string
 	prog []code.Instr
+	pos  []position.Position // Source position of each instruction in prog, if known.
+
+	branches map[int]code.Branch // Control flow jumps in prog, by program counter.
 
 	re      []*regexp.Regexp  // Regular expression constants
 	str     []string          // String constants
 	Metrics []*metrics.Metric // Metrics accessible to this program.
 
+	maps     []map[string]interface{} // Lookup tables, copied so that map variables are this VM's own.
+	mapKeys  []int                    // Number of keys in each of maps when the VM was created.
+	prefixes []netip.Prefix           // Network constants
+
+	funcs        []code.Function // User-defined functions.
+	maxCallDepth int             // Limit on nested function calls.
+
 	timeMemos *lru.Cache // memo of time string parse results
 
+	pf *prefilter // Skips regular expressions whose required literals are absent from the input, if not nil.
+
 	t *thread // Current thread of execution
 
 	input *logline.LogLine // Log line input to this round of execution.
 
 	terminate bool // Flag to stop the VM on this line of input.
 
+	persistTime bool      // Keep the time register from one line to the next.
+	lastTime    time.Time // Time register at the end of the last line, if persistTime.
+
 	HardCrash bool // User settable flag to make the VM crash instead of recover on panic.
 
 	runtimeErrorMu sync.RWMutex // protects runtimeError
@@ -78,6 +118,96 @@ type VM struct {
 	syslogUseCurrentYear bool           // Overwrite zero years with the current year in a strptime.
 	loc                  *time.Location // Override local timezone with provided, if not empty.
 	trace                []int          // Record program counter in program execution, for testing.
+
+	useRegisters bool            // Execute regProg on the register machine instead of prog.
+	regProg      []code.RegInstr // Register machine bytecode.
+	regConsts    []value         // Decoded Push operands of regProg, indexed by program counter.
+	registers    int             // Size of the register file needed by regProg.
+	regThread    *thread         // Reused thread for the register machine.
+
+	profiling bool      // Profile the execution of each instruction.
+	prof      *profiler // Execution profile of the program, if profiling.
+
+	span trace.Span // Recorded span of the execution on the current line, if sampled.
+
+	instrBudget int           // Maximum number of instructions executed per line, if not zero.
+	timeBudget  time.Duration // Maximum time spent executing each line, if not zero.
+	matchBudget int           // Maximum length of the text each regular expression is matched against, if not zero.
+}
+
+// Option configures a new VM.
+type Option func(*VM)
+
+// RegisterMachine instructs the VM to execute the program on the register
+// machine, if the compiler emitted register bytecode for it.
+func RegisterMachine() Option {
+	return func(v *VM) {
+		v.useRegisters = true
+	}
+}
+
+// InstructionBudget limits the number of instructions the VM may execute on
+// any one line.  Lines exceeding the budget are abandoned with a runtime error.
+func InstructionBudget(n int) Option {
+	return func(v *VM) {
+		v.instrBudget = n
+	}
+}
+
+// TimeBudget limits the wall-clock time the VM may spend on any one line.
+// Lines exceeding the budget are abandoned with a runtime error.  The budget is
+// checked between instructions, so a single regular expression match is never
+// interrupted, but no further work is done on the line once it completes.  Use
+// MatchLengthBudget to bound the time of each match.
+func TimeBudget(d time.Duration) Option {
+	return func(v *VM) {
+		v.timeBudget = d
+	}
+}
+
+// MatchLengthBudget limits the length of the text any one regular expression
+// may be matched against.  Regular expressions match in time proportional to
+// the length of their input, so this bounds the time of each match, which the
+// time budget can't interrupt.  Lines with longer input are abandoned with a
+// runtime error instead of being matched.
+func MatchLengthBudget(n int) Option {
+	return func(v *VM) {
+		v.matchBudget = n
+	}
+}
+
+// budgetCheckInterval is the number of instructions executed between checks of the time budget.
+const budgetCheckInterval = 16
+
+// overBudget reports whether the current line, having executed n instructions
+// since start, may not execute any more.  If so it records a runtime error.
+func (v *VM) overBudget(n int, start time.Time) bool {
+	if v.instrBudget > 0 && n >= v.instrBudget {
+		LineBudgetExceeded.Add(v.name, 1)
+		v.errorf("line exceeded the instruction budget of %d", v.instrBudget)
+		v.terminate = false
+		return true
+	}
+	if v.timeBudget > 0 && n > 0 && n%budgetCheckInterval == 0 {
+		if elapsed := time.Since(start); elapsed > v.timeBudget {
+			LineBudgetExceeded.Add(v.name, 1)
+			v.errorf("line exceeded the time budget of %s after %s and %d instructions", v.timeBudget, elapsed, n)
+			v.terminate = false
+			return true
+		}
+	}
+	return false
+}
+
+// overMatchBudget reports whether s is too long to be matched against a
+// regular expression.  If so it records a runtime error, abandoning the line.
+func (v *VM) overMatchBudget(s string) bool {
+	if v.matchBudget > 0 && len(s) > v.matchBudget {
+		LineBudgetExceeded.Add(v.name, 1)
+		v.errorf("match input of %d bytes exceeded the match length budget of %d", len(s), v.matchBudget)
+		return true
+	}
+	return false
 }
 
 // Push a value onto the stack.
@@ -99,6 +229,7 @@ func (v *VM) errorf(format string, args ...interface{}) {
 	ProgRuntimeErrors.Add(v.name, 1)
 	v.runtimeErrorMu.Lock()
 	v.runtimeError = fmt.Sprintf(format+"\n", args...)
+	v.recordSpanError(fmt.Sprintf(format, args...))
 	v.runtimeError += fmt.Sprintf(
 		"Error occurred at instruction %d {%s, %v}, originating in %s at line %d\n",
 		v.t.pc-1, i.Opcode, i.Operand, v.name, i.SourceLine+1)
@@ -119,6 +250,9 @@ func (v *VM) errorf(format string, args ...interface{}) {
 		glog.Infof(" Matches %v", v.t.matches)
 		glog.Infof(" Timestamp %v", v.t.time)
 		glog.Infof(" Stack %v", v.t.stack)
+		if v.useRegisters {
+			glog.Infof(" Registers %v", v.t.regs)
+		}
 		glog.Infof(v.DumpByteCode())
 	}
 	if v.trace != nil {
@@ -306,6 +440,71 @@ func compare(a, b interface{}, opnd int) (bool, error) {
 	return false, errors.Errorf("cannot compare %T %q with %T %q", a, a, b, b)
 }
 
+// substr returns the length bytes of s starting at byte offset start, clamped
+// to the bounds of s.
+func substr(s string, start, length int64) string {
+	if start < 0 {
+		start = 0
+	}
+	if start >= int64(len(s)) || length <= 0 {
+		return ""
+	}
+	end := start + length
+	if end > int64(len(s)) || end < start {
+		end = int64(len(s))
+	}
+	return s[start:end]
+}
+
+// mathFuncs are the implementations of the single argument floating point
+// math opcodes.
+var mathFuncs = map[code.Opcode]func(float64) float64{
+	code.Fabs:  math.Abs,
+	code.Floor: math.Floor,
+	code.Ceil:  math.Ceil,
+	code.Round: math.Round,
+	code.Log:   math.Log,
+	code.Log10: math.Log10,
+	code.Exp:   math.Exp,
+	code.Sqrt:  math.Sqrt,
+}
+
+// iclamp returns x limited to the range lo to hi.
+func iclamp(x, lo, hi int64) int64 {
+	if x < lo {
+		x = lo
+	}
+	if x > hi {
+		x = hi
+	}
+	return x
+}
+
+// splitField returns field i of s split by sep, or the empty string if there
+// is no such field.
+func splitField(s, sep string, i int64) string {
+	fields := strings.Split(s, sep)
+	if i < 0 || i >= int64(len(fields)) {
+		return ""
+	}
+	return fields[i]
+}
+
+// matchLine matches the index'th regular expression against the input line,
+// storing the submatches in t.  The match is skipped if the prefilter shows
+// it cannot succeed.
+func (v *VM) matchLine(t *thread, index int) bool {
+	if v.pf != nil && !v.pf.mayMatch(index, v.input.Line) {
+		t.matches[index] = nil
+		return false
+	}
+	if v.overMatchBudget(v.input.Line) {
+		return false
+	}
+	t.matches[index] = v.re[index].FindStringSubmatch(v.input.Line)
+	return t.matches[index] != nil
+}
+
 // ParseTime performs location and syslog-year aware timestamp parsing.
 func (v *VM) ParseTime(layout, value string) (tm time.Time) {
 	var err error
@@ -358,8 +557,7 @@ func (v *VM) execute(t *thread, i code.Instr) {
 		// Store the results in the operandth element of the stack,
 		// where i.opnd == the matched re index
 		index := i.Operand.(int)
-		t.matches[index] = v.re[index].FindStringSubmatch(v.input.Line)
-		t.Push(t.matches[index] != nil)
+		t.Push(v.matchLine(t, index))
 
 	case code.Smatch:
 		// match regex against item on the stack
@@ -369,6 +567,9 @@ func (v *VM) execute(t *thread, i code.Instr) {
 			v.errorf("+%v", err)
 			return
 		}
+		if v.overMatchBudget(line) {
+			return
+		}
 		t.matches[index] = v.re[index].FindStringSubmatch(line)
 		t.Push(t.matches[index] != nil)
 
@@ -470,6 +671,30 @@ func (v *VM) execute(t *thread, i code.Instr) {
 	case code.Jmp:
 		t.pc = i.Operand.(int)
 
+	case code.Call:
+		// Call a user-defined function, whose arguments are on the stack.
+		f := v.funcs[i.Operand.(int)]
+		if len(t.frames) >= v.maxCallDepth {
+			v.errorf("call to %s exceeded the maximum recursion depth of %d", f.Name, v.maxCallDepth)
+			return
+		}
+		t.frames = append(t.frames, frame{ret: t.pc, base: len(t.stack) - f.Params})
+		t.pc = f.Entry
+
+	case code.Ret:
+		// Return from a function, replacing its arguments with the result.
+		top := len(t.frames) - 1
+		fr := t.frames[top]
+		t.frames = t.frames[:top]
+		result := t.Pop()
+		t.stack = append(t.stack[:fr.base], result)
+		t.pc = fr.ret
+
+	case code.Lload:
+		// Load an argument of the current function.
+		fr := t.frames[len(t.frames)-1]
+		t.Push(t.stack[fr.base+i.Operand.(int)])
+
 	case code.Inc:
 		// Increment a datum
 		var delta int64 = 1
@@ -597,15 +822,25 @@ func (v *VM) execute(t *thread, i code.Instr) {
 			t.Push(t.time.Unix())
 		}
 
+	case code.TimestampNs:
+		// Put the time register onto the stack in nanoseconds, unless it's zero in which case use system time.
+		if t.time.IsZero() {
+			t.Push(time.Now().UnixNano())
+		} else {
+			t.Push(t.time.UnixNano())
+		}
+
 	case code.Settime:
 		// Pop TOS and store in time register
-		val := t.Pop()
-		ts, ok := val.(int64)
-		if !ok {
-			v.errorf("Failed to pop a timestamp off the stack: %v instead", v)
+		switch ts := t.Pop().(type) {
+		case int64:
+			t.time = time.Unix(ts, 0).UTC()
+		case float64:
+			t.time = unixTime(ts, nil)
+		default:
+			v.errorf("Failed to pop a timestamp off the stack: %v instead", ts)
 			return
 		}
-		t.time = time.Unix(ts, 0).UTC()
 
 	case code.Capref:
 		// Put a capture group reference onto the stack.
@@ -820,6 +1055,298 @@ func (v *VM) execute(t *thread, i code.Instr) {
 		}
 		t.Push(strings.ToLower(s))
 
+	case code.Toupper:
+		// Uppercase a string from TOS, and push result back.
+		s, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(strings.ToUpper(s))
+
+	case code.Trim:
+		// Trim white space from a string from TOS, and push result back.
+		s, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(strings.TrimSpace(s))
+
+	case code.Substr:
+		length, err := t.PopInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		start, err := t.PopInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		s, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(substr(s, start, length))
+
+	case code.Split:
+		i, err := t.PopInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		sep, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		s, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(splitField(s, sep, i))
+
+	case code.Index, code.Hasprefix, code.Hassuffix:
+		sub, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		s, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		switch i.Opcode {
+		case code.Index:
+			t.Push(int64(strings.Index(s, sub)))
+		case code.Hasprefix:
+			t.Push(strings.HasPrefix(s, sub))
+		case code.Hassuffix:
+			t.Push(strings.HasSuffix(s, sub))
+		}
+
+	case code.Sprintf:
+		// Operand is the number of arguments including the format.
+		args := make([]interface{}, i.Operand.(int)-1)
+		for j := len(args) - 1; j >= 0; j-- {
+			args[j] = t.Pop()
+		}
+		format, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(fmt.Sprintf(format, args...))
+
+	case code.Duration:
+		s, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		d, err := parseDuration(s)
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(d)
+
+	case code.Bytes:
+		s, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		n, err := parseBytes(s)
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(n)
+
+	case code.Ipnet:
+		bits, err := t.PopInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		s, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		n, err := ipnet(s, bits)
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(n)
+
+	case code.Incidr:
+		var p netip.Prefix
+		if i.Operand != nil {
+			p = v.prefixes[i.Operand.(int)]
+		} else {
+			cidr, err := t.PopString()
+			if err != nil {
+				v.errorf("%+v", err)
+				return
+			}
+			p, err = code.ParsePrefix(cidr)
+			if err != nil {
+				v.errorf("%+v", err)
+				return
+			}
+		}
+		s, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(incidr(s, p))
+
+	case code.Isipv6:
+		s, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(isipv6(s))
+
+	case code.Clientip:
+		forwarded, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		peer, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		a, err := clientip(peer, forwarded)
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(a)
+
+	case code.Strftime:
+		ts, err := t.PopFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		layout, err := t.PopString()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(strftime(layout, ts, v.loc))
+
+	case code.Hour, code.Weekday, code.Dayofyear:
+		ts, err := t.PopFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(timeFuncs[i.Opcode](ts, v.loc))
+
+	case code.Now:
+		t.Push(time.Now().Unix())
+
+	case code.Iabs:
+		x, err := t.PopInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		if x < 0 {
+			x = -x
+		}
+		t.Push(x)
+
+	case code.Fabs, code.Floor, code.Ceil, code.Round, code.Log, code.Log10, code.Exp, code.Sqrt:
+		x, err := t.PopFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(mathFuncs[i.Opcode](x))
+
+	case code.Imin, code.Imax:
+		b, err := t.PopInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		a, err := t.PopInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		if (i.Opcode == code.Imin) == (b < a) {
+			a = b
+		}
+		t.Push(a)
+
+	case code.Fmin, code.Fmax:
+		b, err := t.PopFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		a, err := t.PopFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		if i.Opcode == code.Fmin {
+			t.Push(math.Min(a, b))
+		} else {
+			t.Push(math.Max(a, b))
+		}
+
+	case code.Iclamp:
+		hi, err := t.PopInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		lo, err := t.PopInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		x, err := t.PopInt()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(iclamp(x, lo, hi))
+
+	case code.Fclamp:
+		hi, err := t.PopFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		lo, err := t.PopFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		x, err := t.PopFloat()
+		if err != nil {
+			v.errorf("%+v", err)
+			return
+		}
+		t.Push(math.Min(math.Max(x, lo), hi))
+
 	case code.Length:
 		// Compute the length of a string from TOS, and push result back.
 		s, err := t.PopString()
@@ -950,8 +1477,78 @@ func (v *VM) execute(t *thread, i code.Instr) {
 			v.errorf("%+v", nerr)
 			return
 		}
+		if v.overMatchBudget(val) {
+			return
+		}
 		t.Push(v.re[pat].ReplaceAllLiteralString(val, repl))
 
+	case code.Mapget, code.Mapin:
+		m, merr := t.PopInt()
+		if merr != nil {
+			v.errorf("%+v", merr)
+			return
+		}
+		key, kerr := t.PopString()
+		if kerr != nil {
+			v.errorf("%+v", kerr)
+			return
+		}
+		val, ok := v.maps[m][key]
+		if i.Opcode == code.Mapin {
+			t.Push(ok)
+			return
+		}
+		if !ok {
+			v.errorf("key %q not found in map", key)
+			return
+		}
+		t.Push(val)
+
+	case code.Maplookup:
+		def := t.Pop()
+		key, kerr := t.PopString()
+		if kerr != nil {
+			v.errorf("%+v", kerr)
+			return
+		}
+		m, merr := t.PopInt()
+		if merr != nil {
+			v.errorf("%+v", merr)
+			return
+		}
+		if val, ok := v.maps[m][key]; ok {
+			t.Push(val)
+		} else {
+			t.Push(def)
+		}
+
+	case code.Mapset:
+		val := t.Pop()
+		m, merr := t.PopInt()
+		if merr != nil {
+			v.errorf("%+v", merr)
+			return
+		}
+		key, kerr := t.PopString()
+		if kerr != nil {
+			v.errorf("%+v", kerr)
+			return
+		}
+		v.maps[m][key] = val
+
+	case code.Mapdel:
+		m, merr := t.PopInt()
+		if merr != nil {
+			v.errorf("%+v", merr)
+			return
+		}
+		key, kerr := t.PopString()
+		if kerr != nil {
+			v.errorf("%+v", kerr)
+			return
+		}
+		delete(v.maps[m], key)
+
 	default:
 		v.errorf("illegal instruction: %d", i.Opcode)
 	}
@@ -959,27 +1556,51 @@ func (v *VM) execute(t *thread, i code.Instr) {
 
 // ProcessLogLine handles the incoming lines by running a fetch-execute cycle
 // on the VM bytecode with the line as input to the program, until termination.
-func (v *VM) ProcessLogLine(_ context.Context, line *logline.LogLine) {
+func (v *VM) ProcessLogLine(ctx context.Context, line *logline.LogLine) {
 	start := time.Now()
+	span := v.startLineSpan(ctx, line)
 	defer func() {
+		v.endLineSpan(span)
 		LineProcessingDurations.WithLabelValues(v.name).Observe(time.Since(start).Seconds())
 	}()
+	if v.pf != nil {
+		v.pf.reset()
+	}
+	if v.useRegisters {
+		v.processRegisters(line, start)
+		return
+	}
 	t := new(thread)
 	t.matched = false
 	v.t = t
 	v.input = line
 	t.stack = make([]interface{}, 0)
 	t.matches = make(map[int][]string, len(v.re))
-	for {
+	if v.persistTime {
+		t.time = v.lastTime
+		defer v.keepTime()
+	}
+	budgeted := v.instrBudget > 0 || v.timeBudget > 0
+	for n := 0; ; n++ {
 		if t.pc >= len(v.prog) {
 			return
 		}
+		if budgeted && v.overBudget(n, start) {
+			return
+		}
 		if v.trace != nil {
 			v.trace = append(v.trace, t.pc)
 		}
-		i := v.prog[t.pc]
+		pc := t.pc
+		i := v.prog[pc]
 		t.pc++
-		v.execute(t, i)
+		if v.prof != nil {
+			instrStart := time.Now()
+			v.execute(t, i)
+			v.prof.record(pc, time.Since(instrStart))
+		} else {
+			v.execute(t, i)
+		}
 		if v.terminate {
 			// Terminate only stops this invocation on this line of input; reset the terminate flag.
 			v.terminate = false
@@ -988,28 +1609,113 @@ func (v *VM) ProcessLogLine(_ context.Context, line *logline.LogLine) {
 	}
 }
 
+// keepTime saves the time register at the end of a line, so that the next
+// line starts with it.
+func (v *VM) keepTime() {
+	v.lastTime = v.t.time
+}
+
+// processRegisters runs the fetch-execute cycle of the register machine on
+// the line.  The register file and match storage are reused between lines.
+func (v *VM) processRegisters(line *logline.LogLine, start time.Time) {
+	t := v.regThread
+	t.pc = 0
+	t.matched = false
+	t.time = time.Time{}
+	if v.persistTime {
+		t.time = v.lastTime
+		defer v.keepTime()
+	}
+	for k := range t.matches {
+		delete(t.matches, k)
+	}
+	v.t = t
+	v.input = line
+	budgeted := v.instrBudget > 0 || v.timeBudget > 0
+	for n := 0; ; n++ {
+		if t.pc >= len(v.regProg) {
+			return
+		}
+		if budgeted && v.overBudget(n, start) {
+			return
+		}
+		if v.trace != nil {
+			v.trace = append(v.trace, t.pc)
+		}
+		pc := t.pc
+		t.pc++
+		if v.prof != nil {
+			instrStart := time.Now()
+			v.executeRegister(t, pc, v.regProg[pc])
+			v.prof.record(pc, time.Since(instrStart))
+		} else {
+			v.executeRegister(t, pc, v.regProg[pc])
+		}
+		if v.terminate {
+			v.terminate = false
+			return
+		}
+	}
+}
+
 // New creates a new virtual machine with the given name, and compiler
 // artifacts for executable and data segments.
-func New(name string, obj *code.Object, syslogUseCurrentYear bool, loc *time.Location, log bool, trace bool) *VM {
+func New(name string, obj *code.Object, syslogUseCurrentYear bool, loc *time.Location, log bool, trace bool, options ...Option) *VM {
 	v := &VM{
 		name:                 name,
 		re:                   obj.Regexps,
 		str:                  obj.Strings,
+		maps:                 make([]map[string]interface{}, len(obj.Maps)),
+		mapKeys:              make([]int, len(obj.Maps)),
+		prefixes:             obj.Prefixes,
 		Metrics:              obj.Metrics,
 		prog:                 obj.Program,
+		pos:                  obj.Positions,
+		branches:             obj.Branches,
+		persistTime:          obj.PersistTime,
+		funcs:                obj.Functions,
+		maxCallDepth:         obj.MaxCallDepth,
 		timeMemos:            lru.New(64),
 		syslogUseCurrentYear: syslogUseCurrentYear,
 		loc:                  loc,
 		logRuntimeErrors:     log,
 	}
+	if v.maxCallDepth <= 0 {
+		v.maxCallDepth = defaultMaxCallDepth
+	}
+	for i, m := range obj.Maps {
+		v.maps[i] = make(map[string]interface{}, len(m))
+		for k, val := range m {
+			v.maps[i][k] = val
+		}
+		v.mapKeys[i] = len(m)
+	}
 	if trace {
 		v.trace = make([]int, 0, len(v.prog))
 	}
+	if len(obj.RegexpLiterals) == len(obj.Regexps) {
+		v.pf = newPrefilter(obj.RegexpLiterals)
+	}
+	for _, option := range options {
+		option(v)
+	}
+	if v.useRegisters {
+		v.initRegisters(obj)
+	}
+	if v.profiling {
+		if v.useRegisters {
+			v.prof = newProfiler(len(v.regProg))
+		} else {
+			v.prof = newProfiler(len(v.prog))
+		}
+	}
 	return v
 }
 
 // DumpByteCode emits the program disassembly and program objects to a string.
-func (v *VM) DumpByteCode() string {
+// The prefilter counts include those of replicas, the other VMs running the
+// same program, if any.
+func (v *VM) DumpByteCode(replicas ...*VM) string {
 	b := new(bytes.Buffer)
 	fmt.Fprintf(b, "Prog: %s\n", v.name)
 	fmt.Fprintln(b, "Metrics")
@@ -1020,18 +1726,55 @@ func (v *VM) DumpByteCode() string {
 	}
 	fmt.Fprintln(b, "Regexps")
 	for i, re := range v.re {
-		fmt.Fprintf(b, " %8d /%s/\n", i, re)
+		if v.pf != nil && len(v.pf.required[i]) > 0 {
+			skips, hits := v.pf.skips[i].Load(), v.pf.hits[i].Load()
+			for _, r := range replicas {
+				if r.pf != nil && i < len(r.pf.skips) {
+					skips += r.pf.skips[i].Load()
+					hits += r.pf.hits[i].Load()
+				}
+			}
+			fmt.Fprintf(b, " %8d /%s/ skip=%d hit=%d\n", i, re, skips, hits)
+		} else {
+			fmt.Fprintf(b, " %8d /%s/\n", i, re)
+		}
 	}
 	fmt.Fprintln(b, "Strings")
 	for i, str := range v.str {
 		fmt.Fprintf(b, " %8d \"%s\"\n", i, str)
 	}
+	if len(v.maps) > 0 {
+		fmt.Fprintln(b, "Maps")
+		// The maps themselves may be in use by the program.
+		for i, n := range v.mapKeys {
+			fmt.Fprintf(b, " %8d %d keys\n", i, n)
+		}
+	}
+	if len(v.prefixes) > 0 {
+		fmt.Fprintln(b, "Prefixes")
+		for i, p := range v.prefixes {
+			fmt.Fprintf(b, " %8d %s\n", i, p)
+		}
+	}
+	if len(v.funcs) > 0 {
+		fmt.Fprintln(b, "Functions")
+		for i, f := range v.funcs {
+			fmt.Fprintf(b, " %8d %s/%d entry=%d\n", i, f.Name, f.Params, f.Entry)
+		}
+	}
 	w := new(tabwriter.Writer)
 	w.Init(b, 0, 0, 1, ' ', tabwriter.AlignRight)
 
-	fmt.Fprintln(w, "disasm\tl\top\topnd\tline\t")
-	for n, i := range v.prog {
-		fmt.Fprintf(w, "\t%d\t%s\t%v\t%d\t\n", n, i.Opcode, i.Operand, i.SourceLine+1)
+	if v.useRegisters {
+		fmt.Fprintln(w, "disasm\tl\top\topnd\treg\tline\t")
+		for n, i := range v.regProg {
+			fmt.Fprintf(w, "\t%d\t%s\t%v\tr%d\t%d\t\n", n, i.Opcode, i.Operand, i.Reg, i.SourceLine+1)
+		}
+	} else {
+		fmt.Fprintln(w, "disasm\tl\top\topnd\tline\t")
+		for n, i := range v.prog {
+			fmt.Fprintf(w, "\t%d\t%s\t%v\t%d\t\n", n, i.Opcode, i.Operand, i.SourceLine+1)
+		}
 	}
 	if err := w.Flush(); err != nil {
 		glog.Infof("flush error: %s", err)
@@ -1052,9 +1795,8 @@ func (v *VM) RuntimeErrorString() string {
 func (v *VM) Run(lines <-chan *logline.LogLine, wg *sync.WaitGroup) {
 	defer wg.Done()
 	glog.V(1).Infof("started VM %q", v.name)
-	ctx := context.TODO()
 	for line := range lines {
-		v.ProcessLogLine(ctx, line)
+		v.ProcessLogLine(line.Context, line)
 	}
 	glog.Infof("VM %q finished", v.name)
 }
@@ -5,7 +5,9 @@ package vm
 
 import (
 	"context"
+	"expvar"
 	"regexp"
+	"strings"
 	"testing"
 	"time"
 
@@ -263,6 +265,357 @@ var instructions = []struct {
 		[]interface{}{"mixedcase"},
 		thread{pc: 0, matches: map[int][]string{}},
 	},
+	{
+		"toupper",
+		code.Instr{code.Toupper, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"mIxeDCasE"},
+		[]interface{}{"MIXEDCASE"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"trim",
+		code.Instr{code.Trim, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{" \tpadded \n"},
+		[]interface{}{"padded"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"substr",
+		code.Instr{code.Substr, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"abcdef", 1, 3},
+		[]interface{}{"bcd"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"substr clamped",
+		code.Instr{code.Substr, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"abcdef", 4, 10},
+		[]interface{}{"ef"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"substr past end",
+		code.Instr{code.Substr, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"abcdef", 10, 2},
+		[]interface{}{""},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"split",
+		code.Instr{code.Split, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"a:b:c", ":", 1},
+		[]interface{}{"b"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"split out of range",
+		code.Instr{code.Split, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"a:b:c", ":", 3},
+		[]interface{}{""},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"index",
+		code.Instr{code.Index, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"abcdef", "cd"},
+		[]interface{}{int64(2)},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"index not found",
+		code.Instr{code.Index, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"abcdef", "x"},
+		[]interface{}{int64(-1)},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"hasprefix",
+		code.Instr{code.Hasprefix, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"abcdef", "ab"},
+		[]interface{}{true},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"hassuffix",
+		code.Instr{code.Hassuffix, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"abcdef", "ab"},
+		[]interface{}{false},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"sprintf",
+		code.Instr{code.Sprintf, 3, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"%s=%d", "a", int64(1)},
+		[]interface{}{"a=1"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"iabs",
+		code.Instr{code.Iabs, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{int64(-3)},
+		[]interface{}{int64(3)},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"fabs",
+		code.Instr{code.Fabs, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{-2.5},
+		[]interface{}{2.5},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"imin",
+		code.Instr{code.Imin, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{int64(3), int64(2)},
+		[]interface{}{int64(2)},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"fmin",
+		code.Instr{code.Fmin, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{1.5, 2.5},
+		[]interface{}{1.5},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"imax",
+		code.Instr{code.Imax, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{int64(3), int64(2)},
+		[]interface{}{int64(3)},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"fmax",
+		code.Instr{code.Fmax, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{1.5, 2.5},
+		[]interface{}{2.5},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"iclamp",
+		code.Instr{code.Iclamp, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{int64(12), int64(0), int64(10)},
+		[]interface{}{int64(10)},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"fclamp",
+		code.Instr{code.Fclamp, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{-0.5, 0.0, 1.0},
+		[]interface{}{0.0},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"floor",
+		code.Instr{code.Floor, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{2.7},
+		[]interface{}{2.0},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"ceil",
+		code.Instr{code.Ceil, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{2.1},
+		[]interface{}{3.0},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"round",
+		code.Instr{code.Round, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{-2.5},
+		[]interface{}{-3.0},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"log",
+		code.Instr{code.Log, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{1.0},
+		[]interface{}{0.0},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"log10",
+		code.Instr{code.Log10, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{1000.0},
+		[]interface{}{3.0},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"exp",
+		code.Instr{code.Exp, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{0.0},
+		[]interface{}{1.0},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"sqrt",
+		code.Instr{code.Sqrt, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{9.0},
+		[]interface{}{3.0},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"duration",
+		code.Instr{code.Duration, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"1m2.5s"},
+		[]interface{}{62.5},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"duration unit variant",
+		code.Instr{code.Duration, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"250 msec"},
+		[]interface{}{0.25},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"bytes",
+		code.Instr{code.Bytes, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"4KiB"},
+		[]interface{}{int64(4096)},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"bytes SI",
+		code.Instr{code.Bytes, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"1.5M"},
+		[]interface{}{int64(1500000)},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"ipnet",
+		code.Instr{code.Ipnet, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"192.0.2.77", int64(24)},
+		[]interface{}{"192.0.2.0/24"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"incidr",
+		code.Instr{code.Incidr, nil, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"10.1.2.3", "10.0.0.0/8"},
+		[]interface{}{true},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"isipv6",
+		code.Instr{code.Isipv6, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"2001:db8::1"},
+		[]interface{}{true},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"clientip",
+		code.Instr{code.Clientip, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"10.0.0.2", "198.51.100.7, 10.0.0.3"},
+		[]interface{}{"198.51.100.7"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"strftime",
+		code.Instr{code.Strftime, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{"2006-01-02 15:04:05.000", 1700000000.25},
+		[]interface{}{"2023-11-14 22:13:20.250"},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"hour",
+		code.Instr{code.Hour, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{1700000000.0},
+		[]interface{}{int64(22)},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"weekday",
+		code.Instr{code.Weekday, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{1700000000.0},
+		[]interface{}{int64(2)},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
+	{
+		"dayofyear",
+		code.Instr{code.Dayofyear, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{1700000000.0},
+		[]interface{}{int64(318)},
+		thread{pc: 0, matches: map[int][]string{}},
+	},
 	{
 		"length",
 		code.Instr{code.Length, 0, 0},
@@ -416,6 +769,15 @@ var instructions = []struct {
 		[]interface{}{},
 		thread{pc: 0, time: time.Unix(0, 0).UTC(), matches: map[int][]string{}},
 	},
+	{
+		"settime float",
+		code.Instr{code.Settime, 0, 0},
+		[]*regexp.Regexp{},
+		[]string{},
+		[]interface{}{1700000000.25},
+		[]interface{}{},
+		thread{pc: 0, time: time.Unix(1700000000, 250000000).UTC(), matches: map[int][]string{}},
+	},
 	{
 		"push int",
 		code.Instr{code.Push, 1, 0},
@@ -794,6 +1156,31 @@ func TestStrptimeWithTimezone(t *testing.T) {
 	}
 }
 
+func TestStrptimeFractionalSeconds(t *testing.T) {
+	obj := &code.Object{Program: []code.Instr{{code.Strptime, 0, 0}}}
+	for _, tc := range []struct {
+		value, layout string
+		want          time.Time
+	}{
+		{"2012/01/18 06:25:00.123", "2006/01/02 15:04:05.000", time.Date(2012, 1, 18, 6, 25, 0, 123000000, time.UTC)},
+		{"2012/01/18 06:25:00,123456", "2006/01/02 15:04:05,000000", time.Date(2012, 1, 18, 6, 25, 0, 123456000, time.UTC)},
+		{"2012/01/18 06:25:00.123456789", "2006/01/02 15:04:05.999999999", time.Date(2012, 1, 18, 6, 25, 0, 123456789, time.UTC)},
+		{"2012/01/18 06:25:00.5", "2006/01/02 15:04:05.999999999", time.Date(2012, 1, 18, 6, 25, 0, 500000000, time.UTC)},
+		// Fractions are accepted after the seconds even if the layout has none.
+		{"2012/01/18 06:25:00.042", "2006/01/02 15:04:05", time.Date(2012, 1, 18, 6, 25, 0, 42000000, time.UTC)},
+	} {
+		vm := New("strptimefraction", obj, true, nil, false, false)
+		vm.t = new(thread)
+		vm.t.stack = make([]interface{}, 0)
+		vm.t.Push(tc.value)
+		vm.t.Push(tc.layout)
+		vm.execute(vm.t, obj.Program[0])
+		if !vm.t.time.Equal(tc.want) {
+			t.Errorf("strptime(%q, %q) = %s, want %s", tc.value, tc.layout, vm.t.time, tc.want)
+		}
+	}
+}
+
 func TestStrptimeWithoutTimezone(t *testing.T) {
 	obj := &code.Object{Program: []code.Instr{{code.Strptime, 0, 0}}}
 	vm := New("strptimezone", obj, true, nil, false, false)
@@ -902,6 +1289,30 @@ func TestDeleteInstrs(t *testing.T) {
 	}
 }
 
+func TestMapInstrs(t *testing.T) {
+	obj := &code.Object{
+		Maps:    []map[string]interface{}{{"a": int64(1)}},
+		Program: []code.Instr{{code.Mapset, nil, 0}, {code.Mapdel, nil, 0}},
+	}
+	v := New("test", obj, true, nil, false, false)
+	other := New("other", obj, true, nil, false, false)
+	v.t = new(thread)
+	v.t.Push("b")
+	v.t.Push(0)
+	v.t.Push(int64(2))
+	v.execute(v.t, v.prog[0])
+	v.t.Push("a")
+	v.t.Push(0)
+	v.execute(v.t, v.prog[1])
+	if v.terminate {
+		t.Fatal("execution failed, see info log")
+	}
+	testutil.ExpectNoDiff(t, map[string]interface{}{"b": int64(2)}, v.maps[0])
+	// Each VM changes its own copy of the map.
+	testutil.ExpectNoDiff(t, map[string]interface{}{"a": int64(1)}, other.maps[0])
+	testutil.ExpectNoDiff(t, map[string]interface{}{"a": int64(1)}, obj.Maps[0])
+}
+
 func TestTimestampInstr(t *testing.T) {
 	var m []*metrics.Metric
 	now := time.Now().UTC()
@@ -927,3 +1338,109 @@ func TestTimestampInstr(t *testing.T) {
 		t.Errorf("Expecting timestamp to be %s, was %s", newT, tos)
 	}
 }
+
+func TestTimestampNsInstr(t *testing.T) {
+	var m []*metrics.Metric
+	v := makeVM(code.Instr{code.TimestampNs, nil, 0}, m)
+	v.t.time = time.Unix(37, 123456789).UTC()
+	v.execute(v.t, v.prog[0])
+	if v.terminate {
+		t.Fatal("execution failed, see info log")
+	}
+	if tos := v.t.Pop().(int64); tos != 37123456789 {
+		t.Errorf("Expecting timestamp to be 37123456789, was %d", tos)
+	}
+}
+
+func TestLineBudget(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		options   []Option
+		exceeded  bool
+		errPrefix string
+		counted   bool // whether the line got as far as incrementing request_total
+	}{
+		{"unlimited", nil, false, "", true},
+		{"generous instructions", []Option{InstructionBudget(1000)}, false, "", true},
+		{"instructions", []Option{InstructionBudget(5)}, true, "line exceeded the instruction budget of 5", false},
+		{"instructions register", []Option{InstructionBudget(5), RegisterMachine()}, true, "line exceeded the instruction budget of 5", false},
+		{"generous time", []Option{TimeBudget(time.Hour)}, false, "", true},
+		// The time budget is only checked every budgetCheckInterval instructions.
+		{"time", []Option{TimeBudget(time.Nanosecond)}, true, "line exceeded the time budget of 1ns", true},
+		{"generous match length", []Option{MatchLengthBudget(1000)}, false, "", true},
+		{"match length", []Option{MatchLengthBudget(10)}, true, "match input of 28 bytes exceeded the match length budget of 10", false},
+		{"match length register", []Option{MatchLengthBudget(10), RegisterMachine()}, true, "match input of 28 bytes exceeded the match length budget of 10", false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			v := compileForTest(t, benchmarkProgram, tc.options...)
+			v.name = "budget " + tc.name
+			v.ProcessLogLine(context.Background(), logline.New(context.Background(), "test", benchmarkLines[0]))
+
+			var exceeded int64
+			if c := LineBudgetExceeded.Get(v.name); c != nil {
+				exceeded = c.(*expvar.Int).Value()
+			}
+			if got := exceeded == 1; got != tc.exceeded {
+				t.Errorf("budget exceeded count: got %d, want exceeded %v", exceeded, tc.exceeded)
+			}
+			errString := v.RuntimeErrorString()
+			if !strings.HasPrefix(errString, tc.errPrefix) || (tc.errPrefix == "") != (errString == "") {
+				t.Errorf("runtime error: got %q, want prefix %q", errString, tc.errPrefix)
+			}
+			if tc.exceeded && !strings.Contains(errString, benchmarkLines[0]) {
+				t.Errorf("runtime error doesn't contain the offending line: %q", errString)
+			}
+			d, err := v.Metrics[0].GetDatum("GET", "200")
+			testutil.FatalIfErr(t, err)
+			if got := datum.GetInt(d) == 1; got != tc.counted {
+				t.Errorf("request_total counted: got %v, want %v", got, tc.counted)
+			}
+		})
+	}
+}
+
+func TestCallAndReturn(t *testing.T) {
+	obj := &code.Object{
+		Program: []code.Instr{
+			{code.Jmp, 3, 0},
+			{code.Lload, 1, 0},
+			{code.Ret, nil, 0},
+			{code.Push, "x", 0},
+			{code.Push, int64(1), 0},
+			{code.Push, int64(2), 0},
+			{code.Call, 0, 0},
+		},
+		Functions: []code.Function{{Name: "second", Entry: 1, Params: 2}},
+	}
+	v := New("call", obj, true, nil, false, false)
+	v.t = new(thread)
+	v.t.stack = make([]interface{}, 0)
+	v.input = logline.New(context.Background(), testFilename, "aaaab")
+	for v.t.pc < len(obj.Program) && !v.terminate {
+		i := obj.Program[v.t.pc]
+		v.t.pc++
+		v.execute(v.t, i)
+	}
+	if v.terminate {
+		t.Fatalf("Execution failed: %s", v.RuntimeErrorString())
+	}
+	testutil.ExpectNoDiff(t, []interface{}{"x", int64(2)}, v.t.stack)
+	if len(v.t.frames) != 0 {
+		t.Errorf("frames left after return: %v", v.t.frames)
+	}
+}
+
+func TestCallDepthLimit(t *testing.T) {
+	obj := &code.Object{
+		Program:      []code.Instr{{code.Call, 0, 0}},
+		Functions:    []code.Function{{Name: "forever", Entry: 0}},
+		MaxCallDepth: 3,
+	}
+	v := New("call depth", obj, true, nil, false, false)
+	v.ProcessLogLine(context.Background(), logline.New(context.Background(), testFilename, "a"))
+	want := "call to forever exceeded the maximum recursion depth of 3"
+	if got := v.RuntimeErrorString(); !strings.HasPrefix(got, want) {
+		t.Errorf("runtime error: got %q, want prefix %q", got, want)
+	}
+}
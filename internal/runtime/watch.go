@@ -0,0 +1,136 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+// The program path is watched for changes so that programs are reloaded as
+// they are edited, without a HUP signal.  Any change in the watched directory
+// triggers a reload of all programs, rather than only changes to files named
+// like programs: when a Kubernetes ConfigMap is mounted as the program
+// directory, an update creates a new hidden data directory and atomically
+// swaps the `..data` symlink to point at it, while the visible program names
+// stay symlinks through `..data` and never change themselves.  Reloading is
+// cheap when nothing changed, as programs whose content is the same are not
+// recompiled.
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const defaultProgramPollInterval = time.Second
+
+// notifyChanges watches for changes using filesystem notifications; it is a
+// variable so tests can exercise the polling fallback.
+var notifyChanges = watchNotify
+
+// watchProgramPath reloads all programs after changes to the program path,
+// waiting until no change has been seen for the debounce interval so that a
+// burst of changes causes only one reload.  It returns when the Runtime is
+// shutting down.
+func (r *Runtime) watchProgramPath() {
+	dir := r.programPath
+	if s, err := os.Stat(dir); err != nil || !s.IsDir() {
+		// A single program file may be replaced by rename, so watch its directory.
+		dir = filepath.Dir(dir)
+	}
+	changes := make(chan struct{}, 1)
+	watchDone := make(chan struct{})
+	defer func() { <-watchDone }()
+	go func() {
+		defer close(watchDone)
+		if err := notifyChanges(dir, changes, r.signalQuit); err != nil {
+			glog.Infof("Polling %q for program changes every %s: %s", dir, r.programPollInterval, err)
+			pollChanges(dir, r.programPollInterval, changes, r.signalQuit)
+		}
+	}()
+	var timer *time.Timer
+	var reload <-chan time.Time
+	for {
+		select {
+		case <-r.signalQuit:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-changes:
+			if timer == nil {
+				timer = time.NewTimer(r.watchDebounce)
+			} else {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(r.watchDebounce)
+			}
+			reload = timer.C
+		case <-reload:
+			reload = nil
+			glog.Infof("Program path %q changed, reloading programs", r.programPath)
+			// LoadAllPrograms waits for any reload on SIGHUP or change through the admin API to finish first.
+			if err := r.LoadAllPrograms(); err != nil {
+				glog.Info(err)
+			}
+		}
+	}
+}
+
+// notifyChange signals a change without blocking; changes not yet seen by
+// the receiver are coalesced.
+func notifyChange(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+// pollChanges signals a change whenever the signature of dir differs from
+// the one seen at the previous poll, until quit is closed.
+func pollChanges(dir string, interval time.Duration, changes chan<- struct{}, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := dirSignature(dir)
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+			if sig := dirSignature(dir); sig != last {
+				last = sig
+				notifyChange(changes)
+			}
+		}
+	}
+}
+
+// dirSignature summarises the entries of dir, including the targets of
+// symlinks and the size and modification time of what they point to, so that
+// any edit, rename, or symlink swap in dir changes the signature.
+func dirSignature(dir string) string {
+	dirents, err := os.ReadDir(dir)
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	entries := make([]string, 0, len(dirents))
+	for _, dirent := range dirents {
+		path := filepath.Join(dir, dirent.Name())
+		entry := dirent.Name()
+		if target, err := os.Readlink(path); err == nil {
+			entry += " -> " + target
+		}
+		if s, err := os.Stat(path); err == nil {
+			entry += fmt.Sprintf(" %s %d %d", s.Mode(), s.Size(), s.ModTime().UnixNano())
+		}
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, "\n")
+}
@@ -0,0 +1,64 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+//go:build linux
+// +build linux
+
+package runtime
+
+import (
+	"os"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const watchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ATTRIB | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// watchNotify signals a change whenever inotify reports an event in dir,
+// until quit is closed, when it returns nil.  It returns an error if dir
+// cannot be watched, or stops being watched because it was removed.
+func watchNotify(dir string, changes chan<- struct{}, quit <-chan struct{}) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return errors.Wrap(err, "inotify_init1")
+	}
+	// A nonblocking fd is read through the runtime poller, so closing the
+	// file unblocks a pending Read.
+	f := os.NewFile(uintptr(fd), "inotify")
+	if _, err := unix.InotifyAddWatch(fd, dir, watchMask); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "inotify_add_watch %q", dir)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-quit:
+		case <-done:
+		}
+		f.Close()
+	}()
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			select {
+			case <-quit:
+				return nil
+			default:
+				return errors.Wrap(err, "reading inotify events")
+			}
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+			notifyChange(changes)
+			if event.Mask&(unix.IN_IGNORED|unix.IN_DELETE_SELF|unix.IN_MOVE_SELF) != 0 {
+				return errors.Errorf("%q was moved or removed", dir)
+			}
+		}
+	}
+}
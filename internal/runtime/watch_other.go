@@ -0,0 +1,16 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+//go:build !linux
+// +build !linux
+
+package runtime
+
+import (
+	"github.com/pkg/errors"
+)
+
+// watchNotify returns an error, as filesystem notifications are only used on Linux.
+func watchNotify(dir string, changes chan<- struct{}, quit <-chan struct{}) error {
+	return errors.New("filesystem notifications are not supported on this platform")
+}
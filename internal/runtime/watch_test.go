@@ -0,0 +1,147 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package runtime
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/metrics"
+	"github.com/google/mtail/internal/testutil"
+	"github.com/pkg/errors"
+)
+
+// writeConfigMap lays out dir like a Kubernetes ConfigMap volume at the given
+// version: the files live in a hidden versioned directory, `..data` links to
+// it, and each program name links through `..data`.  Updating to a new
+// version swaps the `..data` link by rename and removes the old directory.
+func writeConfigMap(tb testing.TB, dir, version string, files map[string]string) {
+	tb.Helper()
+	versionDir := filepath.Join(dir, "..2026_"+version)
+	testutil.FatalIfErr(tb, os.Mkdir(versionDir, 0o700))
+	for name, content := range files {
+		testutil.FatalIfErr(tb, os.WriteFile(filepath.Join(versionDir, name), []byte(content), 0o600))
+	}
+	old, _ := os.Readlink(filepath.Join(dir, "..data"))
+	testutil.FatalIfErr(tb, os.Symlink(filepath.Base(versionDir), filepath.Join(dir, "..data_tmp")))
+	testutil.FatalIfErr(tb, os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")))
+	for name := range files {
+		link := filepath.Join(dir, name)
+		if _, err := os.Lstat(link); os.IsNotExist(err) {
+			testutil.FatalIfErr(tb, os.Symlink(filepath.Join("..data", name), link))
+		}
+	}
+	if old != "" {
+		testutil.FatalIfErr(tb, os.RemoveAll(filepath.Join(dir, old)))
+	}
+}
+
+func TestDirSignatureConfigMapSwap(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	writeConfigMap(t, dir, "1", map[string]string{"prog.mtail": "counter a\n/a/ {\n  a++\n}\n"})
+	before := dirSignature(dir)
+	if after := dirSignature(dir); after != before {
+		t.Errorf("signature changed without a change:\n%s\n%s", before, after)
+	}
+	writeConfigMap(t, dir, "2", map[string]string{"prog.mtail": "counter b\n/b/ {\n  b++\n}\n"})
+	if after := dirSignature(dir); after == before {
+		t.Errorf("signature unchanged after symlink swap:\n%s", after)
+	}
+}
+
+func TestPollChanges(t *testing.T) {
+	dir := testutil.TestTempDir(t)
+	changes := make(chan struct{}, 1)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pollChanges(dir, 10*time.Millisecond, changes, quit)
+	}()
+	defer func() {
+		close(quit)
+		<-done
+	}()
+	select {
+	case <-changes:
+		t.Fatal("change signalled before any change")
+	case <-time.After(50 * time.Millisecond):
+	}
+	testutil.FatalIfErr(t, os.WriteFile(filepath.Join(dir, "prog.mtail"), []byte("counter a\n/a/ {\n  a++\n}\n"), 0o600))
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change signalled after creating a file")
+	}
+}
+
+func TestWatchProgramsConfigMap(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		poll := poll
+		name := "notify"
+		if poll {
+			name = "poll"
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := testutil.TestTempDir(t)
+			writeConfigMap(t, dir, "1", map[string]string{"a.mtail": "counter a\n/a/ {\n  a++\n}\n"})
+
+			if poll {
+				defer func(f func(string, chan<- struct{}, <-chan struct{}) error) { notifyChanges = f }(notifyChanges)
+				notifyChanges = func(string, chan<- struct{}, <-chan struct{}) error {
+					return errors.New("disabled by test")
+				}
+			}
+			store := metrics.NewStore()
+			lines := make(chan *logline.LogLine)
+			var wg sync.WaitGroup
+			r, err := New(lines, &wg, dir, store, WatchPrograms(10*time.Millisecond), ProgramPollInterval(10*time.Millisecond))
+			testutil.FatalIfErr(t, err)
+			defer func() {
+				close(lines)
+				wg.Wait()
+			}()
+
+			loaded := func(want map[string]string) func() (bool, error) {
+				return func() (bool, error) {
+					r.handleMu.RLock()
+					defer r.handleMu.RUnlock()
+					if len(r.handles) != len(want) {
+						return false, nil
+					}
+					for name, source := range want {
+						h, ok := r.handles[name]
+						if !ok || string(h.source) != source {
+							return false, nil
+						}
+					}
+					return true, nil
+				}
+			}
+			ok, err := testutil.DoOrTimeout(loaded(map[string]string{"a.mtail": "counter a\n/a/ {\n  a++\n}\n"}), 5*time.Second, 10*time.Millisecond)
+			testutil.FatalIfErr(t, err)
+			if !ok {
+				t.Fatal("initial program not loaded")
+			}
+
+			writeConfigMap(t, dir, "2", map[string]string{"a.mtail": "counter a\n/a2/ {\n  a++\n}\n", "b.mtail": "counter b\n/b/ {\n  b++\n}\n"})
+			ok, err = testutil.DoOrTimeout(loaded(map[string]string{"a.mtail": "counter a\n/a2/ {\n  a++\n}\n", "b.mtail": "counter b\n/b/ {\n  b++\n}\n"}), 5*time.Second, 10*time.Millisecond)
+			testutil.FatalIfErr(t, err)
+			if !ok {
+				t.Fatal("programs not reloaded after symlink swap")
+			}
+
+			testutil.FatalIfErr(t, os.Remove(filepath.Join(dir, "b.mtail")))
+			ok, err = testutil.DoOrTimeout(loaded(map[string]string{"a.mtail": "counter a\n/a2/ {\n  a++\n}\n"}), 5*time.Second, 10*time.Millisecond)
+			testutil.FatalIfErr(t, err)
+			if !ok {
+				t.Fatal("program not unloaded after removal")
+			}
+		})
+	}
+}
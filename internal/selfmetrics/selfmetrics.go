@@ -0,0 +1,137 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+// Package selfmetrics exports mtail's own expvar counters as Prometheus
+// metrics.  The expvars remain the source of truth, so /debug/vars and
+// /metrics always agree; each is given a Prometheus name, HELP text, and for
+// expvar maps the name of the label that the map keys become.
+package selfmetrics
+
+import (
+	"expvar"
+	"strconv"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OtherLabelValue is the label value that series beyond a Collector's label
+// value limit are summed into.
+const OtherLabelValue = "other"
+
+// Var describes an expvar.Int or expvar.Map for export to Prometheus.
+type Var struct {
+	v         expvar.Var
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	capped    bool // whether the number of label values is limited
+}
+
+// Counter describes an expvar.Int that only grows.
+func Counter(v *expvar.Int, name, help string) Var {
+	return Var{v: v, desc: prometheus.NewDesc(name, help, nil, nil), valueType: prometheus.CounterValue}
+}
+
+// Gauge describes an expvar.Int that can go up and down.
+func Gauge(v *expvar.Int, name, help string) Var {
+	return Var{v: v, desc: prometheus.NewDesc(name, help, nil, nil), valueType: prometheus.GaugeValue}
+}
+
+// CounterMap describes an expvar.Map of counters whose keys are the values of
+// label.  The keys are bounded, such as program names, so every one is
+// exported.
+func CounterMap(v *expvar.Map, name, help, label string) Var {
+	return Var{v: v, desc: prometheus.NewDesc(name, help, []string{label}, nil), valueType: prometheus.CounterValue}
+}
+
+// CappedCounterMap describes an expvar.Map of counters whose keys are the
+// values of label, and are unbounded, such as log paths.  Only the first keys
+// seen up to the Collector's limit are exported as series of their own.
+func CappedCounterMap(v *expvar.Map, name, help, label string) Var {
+	m := CounterMap(v, name, help, label)
+	m.capped = true
+	return m
+}
+
+// Collector is a prometheus.Collector for a set of Vars.
+type Collector struct {
+	vars           []Var
+	maxLabelValues int // 0 for no limit
+
+	mu       sync.Mutex
+	admitted map[*prometheus.Desc]map[string]struct{} // label values exported as series of their own
+}
+
+// NewCollector creates a Collector for vars, which exports at most
+// maxLabelValues label values for each capped Var, summing the rest into the
+// label value OtherLabelValue.  A maxLabelValues of zero means no limit.
+func NewCollector(maxLabelValues int, vars ...Var) *Collector {
+	return &Collector{
+		vars:           vars,
+		maxLabelValues: maxLabelValues,
+		admitted:       make(map[*prometheus.Desc]map[string]struct{}),
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, v := range c.vars {
+		ch <- v.desc
+	}
+}
+
+// Collect implements the prometheus.Collector interface.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, v := range c.vars {
+		switch ev := v.v.(type) {
+		case *expvar.Int:
+			ch <- prometheus.MustNewConstMetric(v.desc, v.valueType, float64(ev.Value()))
+		case *expvar.Map:
+			for label, value := range c.series(v, ev) {
+				ch <- prometheus.MustNewConstMetric(v.desc, v.valueType, value, label)
+			}
+		}
+	}
+}
+
+// series returns the values of the map by label value, applying the label
+// value limit if v is capped.  Label values are admitted in the order they
+// are first seen so that a series, once exported, keeps its name.
+func (c *Collector) series(v Var, m *expvar.Map) map[string]float64 {
+	values := make(map[string]float64)
+	var keys []string
+	m.Do(func(kv expvar.KeyValue) {
+		f, err := strconv.ParseFloat(kv.Value.String(), 64)
+		if err != nil {
+			glog.V(2).Infof("Skipping %s %q: %s", v.desc, kv.Key, err)
+			return
+		}
+		values[kv.Key] = f
+		keys = append(keys, kv.Key)
+	})
+	if !v.capped || c.maxLabelValues <= 0 {
+		return values
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	admitted, ok := c.admitted[v.desc]
+	if !ok {
+		admitted = make(map[string]struct{})
+		c.admitted[v.desc] = admitted
+	}
+	// expvar.Map.Do visits keys in sorted order, so new label values seen in
+	// the same collection are admitted in that order.
+	series := make(map[string]float64)
+	for _, k := range keys {
+		if _, ok := admitted[k]; !ok && len(admitted) < c.maxLabelValues && k != OtherLabelValue {
+			admitted[k] = struct{}{}
+		}
+		if _, ok := admitted[k]; ok {
+			series[k] = values[k]
+		} else {
+			series[OtherLabelValue] += values[k]
+		}
+	}
+	return series
+}
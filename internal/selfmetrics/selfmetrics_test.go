@@ -0,0 +1,77 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package selfmetrics
+
+import (
+	"expvar"
+	"strings"
+	"testing"
+
+	"github.com/google/mtail/internal/testutil"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	lines := new(expvar.Int)
+	logs := new(expvar.Int)
+	loads := new(expvar.Map).Init()
+	lines.Add(3)
+	logs.Add(2)
+	loads.Add("a.mtail", 1)
+	loads.Add("b.mtail", 2)
+	c := NewCollector(1,
+		Counter(lines, "lines_total", "number of lines"),
+		Gauge(logs, "log_count", "number of logs"),
+		CounterMap(loads, "prog_loads_total", "number of loads", "prog"))
+	want := `# HELP lines_total number of lines
+# TYPE lines_total counter
+lines_total 3
+# HELP log_count number of logs
+# TYPE log_count gauge
+log_count 2
+# HELP prog_loads_total number of loads
+# TYPE prog_loads_total counter
+prog_loads_total{prog="a.mtail"} 1
+prog_loads_total{prog="b.mtail"} 2
+`
+	testutil.FatalIfErr(t, promtest.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestCollectorCapsLabelValues(t *testing.T) {
+	logLines := new(expvar.Map).Init()
+	c := NewCollector(2, CappedCounterMap(logLines, "log_lines_total", "number of lines", "logfile"))
+
+	logLines.Add("/var/log/c", 1)
+	logLines.Add("/var/log/d", 2)
+	want := `# HELP log_lines_total number of lines
+# TYPE log_lines_total counter
+log_lines_total{logfile="/var/log/c"} 1
+log_lines_total{logfile="/var/log/d"} 2
+`
+	testutil.FatalIfErr(t, promtest.CollectAndCompare(c, strings.NewReader(want)))
+
+	// Paths seen later are summed into other, even if they sort first, so
+	// the paths already exported keep their series.
+	logLines.Add("/var/log/a", 4)
+	logLines.Add("/var/log/b", 8)
+	logLines.Add("/var/log/d", 1)
+	want = `# HELP log_lines_total number of lines
+# TYPE log_lines_total counter
+log_lines_total{logfile="/var/log/c"} 1
+log_lines_total{logfile="/var/log/d"} 3
+log_lines_total{logfile="other"} 12
+`
+	testutil.FatalIfErr(t, promtest.CollectAndCompare(c, strings.NewReader(want)))
+}
+
+func TestCollectorNoLimit(t *testing.T) {
+	logLines := new(expvar.Map).Init()
+	c := NewCollector(0, CappedCounterMap(logLines, "log_lines_total", "number of lines", "logfile"))
+	for _, l := range []string{"a", "b", "c"} {
+		logLines.Add(l, 1)
+	}
+	if n := promtest.CollectAndCount(c); n != 3 {
+		t.Errorf("collected %d series, want 3", n)
+	}
+}
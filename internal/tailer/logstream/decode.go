@@ -6,7 +6,10 @@ package logstream
 import (
 	"bytes"
 	"context"
+	"errors"
 	"expvar"
+	"fmt"
+	"strings"
 	"unicode/utf8"
 
 	"github.com/golang/glog"
@@ -16,8 +19,40 @@ import (
 // logLines counts the number of lines read per log file.
 var logLines = expvar.NewMap("log_lines_total")
 
+// decodeFunc decodes the first character in b, returning it and its width in
+// bytes, or utf8.RuneError if b holds no complete valid character.
+type decodeFunc func(b []byte) (rune, int)
+
+// decodeLatin1 decodes ISO 8859-1, in which every byte is the character of the same code point.
+func decodeLatin1(b []byte) (rune, int) {
+	if len(b) == 0 {
+		return utf8.RuneError, 0
+	}
+	return rune(b[0]), 1
+}
+
+// decoders maps the names of the supported character encodings of logs to their decodeFuncs.
+var decoders = map[string]decodeFunc{
+	"":           utf8.DecodeRune,
+	"utf-8":      utf8.DecodeRune,
+	"utf8":       utf8.DecodeRune,
+	"latin1":     decodeLatin1,
+	"iso-8859-1": decodeLatin1,
+}
+
+// ErrUnsupportedEncoding is returned for the names of character encodings that logstreams can't decode.
+var ErrUnsupportedEncoding = errors.New("unsupported character encoding")
+
+// ValidateEncoding returns an error if logstreams can't decode the named character encoding.
+func ValidateEncoding(name string) error {
+	if _, ok := decoders[strings.ToLower(name)]; !ok {
+		return fmt.Errorf("%w %q; supported encodings are utf-8 and latin1", ErrUnsupportedEncoding, name)
+	}
+	return nil
+}
+
 // decodeAndSend transforms the byte array `b` into unicode in `partial`, sending to the llp as each newline is decoded.
-func decodeAndSend(ctx context.Context, lines chan<- *logline.LogLine, pathname string, n int, b []byte, partial *bytes.Buffer) int {
+func decodeAndSend(ctx context.Context, lines chan<- *logline.LogLine, decode decodeFunc, pathname string, n int, b []byte, partial *bytes.Buffer) int {
 	var (
 		r     rune
 		width int
@@ -25,7 +60,7 @@ func decodeAndSend(ctx context.Context, lines chan<- *logline.LogLine, pathname
 	)
 	var i int
 	for ; i < len(b) && i < n; i += width {
-		r, width = utf8.DecodeRune(b[i:])
+		r, width = decode(b[i:])
 		if r == utf8.RuneError {
 			if len(b)-i > 10 {
 				// If there are more than enough bytes in the buffer
@@ -16,8 +16,9 @@ import (
 )
 
 type dgramStream struct {
-	ctx   context.Context
-	lines chan<- *logline.LogLine
+	ctx    context.Context
+	lines  chan<- *logline.LogLine
+	decode decodeFunc // decodes the characters of the log
 
 	scheme  string // Datagram scheme, either "unixgram" or "udp".
 	address string // Given name for the underlying socket path on the filesystem or hostport.
@@ -30,11 +31,11 @@ type dgramStream struct {
 	stopChan chan struct{} // Close to start graceful shutdown.
 }
 
-func newDgramStream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, scheme, address string, lines chan<- *logline.LogLine) (LogStream, error) {
+func newDgramStream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, scheme, address string, lines chan<- *logline.LogLine, decode decodeFunc) (LogStream, error) {
 	if address == "" {
 		return nil, ErrEmptySocketAddress
 	}
-	ss := &dgramStream{ctx: ctx, scheme: scheme, address: address, lastReadTime: time.Now(), lines: lines, stopChan: make(chan struct{})}
+	ss := &dgramStream{ctx: ctx, scheme: scheme, address: address, lastReadTime: time.Now(), lines: lines, decode: decode, stopChan: make(chan struct{})}
 	if err := ss.stream(ctx, wg, waker); err != nil {
 		return nil, err
 	}
@@ -56,6 +57,7 @@ func (ss *dgramStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wak
 		return err
 	}
 	glog.V(2).Infof("opened new datagram socket %v", c)
+	traceEvent(ctx, "logstream.Open", ss.address)
 	b := make([]byte, datagramReadBufferSize)
 	partial := bytes.NewBufferString("")
 	var total int
@@ -71,6 +73,7 @@ func (ss *dgramStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wak
 				glog.Info(err)
 			}
 			logCloses.Add(ss.address, 1)
+			traceEvent(ctx, "logstream.Close", ss.address)
 			ss.mu.Lock()
 			ss.completed = true
 			ss.mu.Unlock()
@@ -99,7 +102,7 @@ func (ss *dgramStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wak
 			if n > 0 {
 				total += n
 				//nolint:contextcheck
-				decodeAndSend(ss.ctx, ss.lines, ss.address, n, b[:n], partial)
+				decodeAndSend(ss.ctx, ss.lines, ss.decode, ss.address, n, b[:n], partial)
 				ss.mu.Lock()
 				ss.lastReadTime = time.Now()
 				ss.mu.Unlock()
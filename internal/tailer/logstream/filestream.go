@@ -34,8 +34,9 @@ var fileTruncates = expvar.NewMap("file_truncates_total")
 // a new goroutine and closes itself down.  The shared context is used for
 // cancellation.
 type fileStream struct {
-	ctx   context.Context
-	lines chan<- *logline.LogLine
+	ctx    context.Context
+	lines  chan<- *logline.LogLine
+	decode decodeFunc // decodes the characters of the log
 
 	pathname string // Given name for the underlying file on the filesystem
 
@@ -48,8 +49,8 @@ type fileStream struct {
 }
 
 // newFileStream creates a new log stream from a regular file.
-func newFileStream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname string, fi os.FileInfo, lines chan<- *logline.LogLine, streamFromStart bool) (LogStream, error) {
-	fs := &fileStream{ctx: ctx, pathname: pathname, lastReadTime: time.Now(), lines: lines, stopChan: make(chan struct{})}
+func newFileStream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname string, fi os.FileInfo, lines chan<- *logline.LogLine, decode decodeFunc, streamFromStart bool) (LogStream, error) {
+	fs := &fileStream{ctx: ctx, pathname: pathname, lastReadTime: time.Now(), lines: lines, decode: decode, stopChan: make(chan struct{})}
 	if err := fs.stream(ctx, wg, waker, fi, streamFromStart); err != nil {
 		return nil, err
 	}
@@ -69,6 +70,7 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 		return err
 	}
 	logOpens.Add(fs.pathname, 1)
+	traceEvent(ctx, "logstream.Open", fs.pathname)
 	glog.V(2).Infof("%v: opened new file", fd)
 	if !streamFromStart {
 		if _, err := fd.Seek(0, io.SeekEnd); err != nil {
@@ -97,6 +99,7 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 				glog.Info(err)
 			}
 			logCloses.Add(fs.pathname, 1)
+			traceEvent(ctx, "logstream.Close", fs.pathname)
 		}()
 		close(started)
 		for {
@@ -109,7 +112,7 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 				glog.V(2).Infof("%v: decode and send", fd)
 				needSend := lastBytes
 				needSend = append(needSend, b[:count]...)
-				sendCount := decodeAndSend(ctx, fs.lines, fs.pathname, len(needSend), needSend, partial)
+				sendCount := decodeAndSend(ctx, fs.lines, fs.decode, fs.pathname, len(needSend), needSend, partial)
 				if sendCount < len(needSend) {
 					lastBytes = append([]byte{}, needSend[sendCount:]...)
 				} else {
@@ -200,6 +203,7 @@ func (fs *fileStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 					}
 					glog.V(2).Infof("%v: Seeked to %d", fd, p)
 					fileTruncates.Add(fs.pathname, 1)
+					traceEvent(ctx, "logstream.Truncate", fs.pathname)
 					continue
 				}
 			}
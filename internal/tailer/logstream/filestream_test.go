@@ -5,6 +5,7 @@ package logstream_test
 
 import (
 	"context"
+	"errors"
 	"path/filepath"
 	"sync"
 	"testing"
@@ -50,6 +51,49 @@ func TestFileStreamRead(t *testing.T) {
 	wg.Wait()
 }
 
+func TestFileStreamReadLatin1(t *testing.T) {
+	var wg sync.WaitGroup
+
+	tmpDir := testutil.TestTempDir(t)
+
+	name := filepath.Join(tmpDir, "log")
+	f := testutil.TestOpenFile(t, name)
+	defer f.Close()
+
+	lines := make(chan *logline.LogLine, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	waker, awaken := waker.NewTest(ctx, 1)
+	fs, err := logstream.New(ctx, &wg, waker, name, lines, true, logstream.Encoding("latin1"))
+	testutil.FatalIfErr(t, err)
+	awaken(1)
+
+	// "café" with the é in ISO 8859-1, which is not valid UTF-8.
+	testutil.WriteString(t, f, "caf\xe9\n")
+	awaken(1)
+
+	fs.Stop()
+	wg.Wait()
+	close(lines)
+	received := testutil.LinesReceived(lines)
+	expected := []*logline.LogLine{
+		{Filename: name, Line: "café"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+	cancel()
+	wg.Wait()
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	if err := logstream.ValidateEncoding("UTF-8"); err != nil {
+		t.Errorf("ValidateEncoding(UTF-8): %s", err)
+	}
+	var wg sync.WaitGroup
+	_, err := logstream.New(context.Background(), &wg, waker.NewTestAlways(), "/dev/null", nil, true, logstream.Encoding("ebcdic"))
+	if !errors.Is(err, logstream.ErrUnsupportedEncoding) {
+		t.Errorf("New with encoding ebcdic: error %v, want %v", err, logstream.ErrUnsupportedEncoding)
+	}
+}
+
 func TestFileStreamReadNonSingleByteEnd(t *testing.T) {
 	var wg sync.WaitGroup
 
@@ -15,11 +15,14 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/golang/glog"
 	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/selfmetrics"
 	"github.com/google/mtail/internal/waker"
 )
 
@@ -30,6 +33,16 @@ var (
 	logOpens = expvar.NewMap("log_opens_total")
 	// logCloses counts the closes of old log file descriptors/sockets.
 	logCloses = expvar.NewMap("log_closes_total")
+
+	// SelfMetrics describes the package's counters for export to Prometheus.
+	// Log paths are unbounded, so the number exported is capped.
+	SelfMetrics = []selfmetrics.Var{
+		selfmetrics.CappedCounterMap(logErrors, "log_errors_total", "number of IO errors encountered per log file", "logfile"),
+		selfmetrics.CappedCounterMap(logOpens, "log_opens_total", "number of opens of log files and sockets per log file", "logfile"),
+		selfmetrics.CappedCounterMap(logCloses, "log_closes_total", "number of closes of log files and sockets per log file", "logfile"),
+		selfmetrics.CappedCounterMap(logLines, "log_lines_total", "number of lines read per log file", "logfile"),
+		selfmetrics.CappedCounterMap(fileTruncates, "file_truncates_total", "number of truncations per log file", "logfile"),
+	}
 )
 
 // LogStream.
@@ -48,16 +61,41 @@ var (
 	ErrEmptySocketAddress   = errors.New("socket address cannot be empty, please provide a unix domain socket filename or host:port")
 )
 
+// Option configures a new LogStream.
+type Option func(*streamOptions) error
+
+type streamOptions struct {
+	decode decodeFunc
+}
+
+// Encoding sets the character encoding of the log, one of utf-8, the default, or latin1.
+func Encoding(name string) Option {
+	return func(o *streamOptions) error {
+		if err := ValidateEncoding(name); err != nil {
+			return err
+		}
+		o.decode = decoders[strings.ToLower(name)]
+		return nil
+	}
+}
+
 // New creates a LogStream from the file object located at the absolute path
 // `pathname`.  The LogStream will watch `ctx` for a cancellation signal, and
 // notify the `wg` when it is Done.  Log lines will be sent to the `lines`
 // channel.  `seekToStart` is only used for testing and only works for regular
 // files that can be seeked.
-func New(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname string, lines chan<- *logline.LogLine, oneShot bool) (LogStream, error) {
+func New(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname string, lines chan<- *logline.LogLine, oneShot bool, options ...Option) (LogStream, error) {
 	u, err := url.Parse(pathname)
 	if err != nil {
 		return nil, err
 	}
+	o := streamOptions{decode: utf8.DecodeRune}
+	for _, option := range options {
+		if err := option(&o); err != nil {
+			return nil, err
+		}
+	}
+	decode := o.decode
 	glog.Infof("Parsed url as %v", u)
 
 	path := pathname
@@ -65,13 +103,13 @@ func New(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname st
 	default:
 		glog.V(2).Infof("%v: %q in path pattern %q, treating as path", ErrUnsupportedURLScheme, u.Scheme, pathname)
 	case "unixgram":
-		return newDgramStream(ctx, wg, waker, u.Scheme, u.Path, lines)
+		return newDgramStream(ctx, wg, waker, u.Scheme, u.Path, lines, decode)
 	case "unix":
-		return newSocketStream(ctx, wg, waker, u.Scheme, u.Path, lines, oneShot)
+		return newSocketStream(ctx, wg, waker, u.Scheme, u.Path, lines, decode, oneShot)
 	case "tcp":
-		return newSocketStream(ctx, wg, waker, u.Scheme, u.Host, lines, oneShot)
+		return newSocketStream(ctx, wg, waker, u.Scheme, u.Host, lines, decode, oneShot)
 	case "udp":
-		return newDgramStream(ctx, wg, waker, u.Scheme, u.Host, lines)
+		return newDgramStream(ctx, wg, waker, u.Scheme, u.Host, lines, decode)
 	case "", "file":
 		path = u.Path
 	}
@@ -82,9 +120,9 @@ func New(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname st
 	}
 	switch m := fi.Mode(); {
 	case m.IsRegular():
-		return newFileStream(ctx, wg, waker, path, fi, lines, oneShot)
+		return newFileStream(ctx, wg, waker, path, fi, lines, decode, oneShot)
 	case m&os.ModeType == os.ModeNamedPipe:
-		return newPipeStream(ctx, wg, waker, path, fi, lines)
+		return newPipeStream(ctx, wg, waker, path, fi, lines, decode)
 	// TODO(jaq): in order to listen on an existing socket filepath, we must unlink and recreate it
 	// case m&os.ModeType == os.ModeSocket:
 	// 	return newSocketStream(ctx, wg, waker, pathname, lines)
@@ -17,8 +17,9 @@ import (
 )
 
 type pipeStream struct {
-	ctx   context.Context
-	lines chan<- *logline.LogLine
+	ctx    context.Context
+	lines  chan<- *logline.LogLine
+	decode decodeFunc // decodes the characters of the log
 
 	pathname string // Given name for the underlying named pipe on the filesystem
 
@@ -27,8 +28,8 @@ type pipeStream struct {
 	lastReadTime time.Time    // Last time a log line was read from this named pipe
 }
 
-func newPipeStream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname string, fi os.FileInfo, lines chan<- *logline.LogLine) (LogStream, error) {
-	ps := &pipeStream{ctx: ctx, pathname: pathname, lastReadTime: time.Now(), lines: lines}
+func newPipeStream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, pathname string, fi os.FileInfo, lines chan<- *logline.LogLine, decode decodeFunc) (LogStream, error) {
+	ps := &pipeStream{ctx: ctx, pathname: pathname, lastReadTime: time.Now(), lines: lines, decode: decode}
 	if err := ps.stream(ctx, wg, waker, fi); err != nil {
 		return nil, err
 	}
@@ -49,6 +50,7 @@ func (ps *pipeStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 		return err
 	}
 	glog.V(2).Infof("opened new pipe %v", fd)
+	traceEvent(ctx, "logstream.Open", ps.pathname)
 	b := make([]byte, defaultReadBufferSize)
 	partial := bytes.NewBufferString("")
 	var total int
@@ -64,6 +66,7 @@ func (ps *pipeStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 				glog.Info(err)
 			}
 			logCloses.Add(ps.pathname, 1)
+			traceEvent(ctx, "logstream.Close", ps.pathname)
 			ps.mu.Lock()
 			ps.completed = true
 			ps.mu.Unlock()
@@ -79,7 +82,7 @@ func (ps *pipeStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wake
 			if n > 0 {
 				total += n
 				//nolint:contextcheck
-				decodeAndSend(ps.ctx, ps.lines, ps.pathname, n, b[:n], partial)
+				decodeAndSend(ps.ctx, ps.lines, ps.decode, ps.pathname, n, b[:n], partial)
 				// Update the last read time if we were able to read anything.
 				ps.mu.Lock()
 				ps.lastReadTime = time.Now()
@@ -16,8 +16,9 @@ import (
 )
 
 type socketStream struct {
-	ctx   context.Context
-	lines chan<- *logline.LogLine
+	ctx    context.Context
+	lines  chan<- *logline.LogLine
+	decode decodeFunc // decodes the characters of the log
 
 	oneShot bool
 	scheme  string // URL Scheme to listen with, either tcp or unix
@@ -31,11 +32,11 @@ type socketStream struct {
 	stopChan chan struct{} // Close to start graceful shutdown.
 }
 
-func newSocketStream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, scheme, address string, lines chan<- *logline.LogLine, oneShot bool) (LogStream, error) {
+func newSocketStream(ctx context.Context, wg *sync.WaitGroup, waker waker.Waker, scheme, address string, lines chan<- *logline.LogLine, decode decodeFunc, oneShot bool) (LogStream, error) {
 	if address == "" {
 		return nil, ErrEmptySocketAddress
 	}
-	ss := &socketStream{ctx: ctx, oneShot: oneShot, scheme: scheme, address: address, lastReadTime: time.Now(), lines: lines, stopChan: make(chan struct{})}
+	ss := &socketStream{ctx: ctx, oneShot: oneShot, scheme: scheme, address: address, lastReadTime: time.Now(), lines: lines, decode: decode, stopChan: make(chan struct{})}
 	if err := ss.stream(ctx, wg, waker); err != nil {
 		return nil, err
 	}
@@ -56,6 +57,7 @@ func (ss *socketStream) stream(ctx context.Context, wg *sync.WaitGroup, waker wa
 		return err
 	}
 	glog.V(2).Infof("opened new socket listener %v", l)
+	traceEvent(ctx, "logstream.Open", ss.address)
 
 	initDone := make(chan struct{})
 	// Set up for shutdown
@@ -132,6 +134,7 @@ func (ss *socketStream) handleConn(ctx context.Context, wg *sync.WaitGroup, wake
 			glog.Info(err)
 		}
 		logCloses.Add(ss.address, 1)
+		traceEvent(ctx, "logstream.Close", ss.address)
 	}()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -144,7 +147,7 @@ func (ss *socketStream) handleConn(ctx context.Context, wg *sync.WaitGroup, wake
 		if n > 0 {
 			total += n
 			//nolint:contextcheck
-			decodeAndSend(ss.ctx, ss.lines, ss.address, n, b[:n], partial)
+			decodeAndSend(ss.ctx, ss.lines, ss.decode, ss.address, n, b[:n], partial)
 			ss.mu.Lock()
 			ss.lastReadTime = time.Now()
 			ss.mu.Unlock()
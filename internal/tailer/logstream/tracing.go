@@ -0,0 +1,21 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package logstream
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/google/mtail/internal/tailer/logstream")
+
+// traceEvent records a span for an event in the lifecycle of the log stream
+// of pathname: an open, close, or truncation of the underlying file or socket.
+func traceEvent(ctx context.Context, name, pathname string) {
+	_, span := tracer.Start(ctx, name, trace.WithAttributes(attribute.String("mtail.log", pathname)))
+	span.End()
+}
@@ -0,0 +1,60 @@
+// Copyright 2026 Google Inc. All Rights Reserved.
+// This file is available under the Apache license.
+
+package logstream_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/tailer/logstream"
+	"github.com/google/mtail/internal/testutil"
+	"github.com/google/mtail/internal/waker"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestFileStreamSpans(t *testing.T) {
+	exp := testutil.RecordSpans(t)
+	var wg sync.WaitGroup
+
+	tmpDir := testutil.TestTempDir(t)
+
+	name := filepath.Join(tmpDir, "log")
+	f := testutil.OpenLogFile(t, name)
+	defer f.Close()
+
+	lines := make(chan *logline.LogLine, 3)
+	ctx, cancel := context.WithCancel(context.Background())
+	waker, awaken := waker.NewTest(ctx, 1)
+	fs, err := logstream.New(ctx, &wg, waker, name, lines, true)
+	testutil.FatalIfErr(t, err)
+	defer fs.Stop()
+	awaken(1)
+
+	testutil.WriteString(t, f, "1\n2\n")
+	awaken(1)
+	testutil.FatalIfErr(t, f.Close())
+	awaken(1)
+	f = testutil.OpenLogFile(t, name)
+	defer f.Close()
+	testutil.WriteString(t, f, "3\n")
+	awaken(1)
+
+	fs.Stop()
+	wg.Wait()
+	close(lines)
+	cancel()
+
+	var names []string
+	for _, s := range exp.GetSpans() {
+		attrs := attribute.NewSet(s.Attributes...)
+		if v, _ := attrs.Value("mtail.log"); v.AsString() != name {
+			t.Errorf("span %q log %q, want %q", s.Name, v.AsString(), name)
+		}
+		names = append(names, s.Name)
+	}
+	testutil.ExpectNoDiff(t, []string{"logstream.Open", "logstream.Truncate", "logstream.Close"}, names)
+}
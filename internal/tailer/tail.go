@@ -19,13 +19,25 @@ import (
 
 	"github.com/golang/glog"
 	"github.com/google/mtail/internal/logline"
+	"github.com/google/mtail/internal/selfmetrics"
 	"github.com/google/mtail/internal/tailer/logstream"
 	"github.com/google/mtail/internal/waker"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // logCount records the number of logs that are being tailed.
 var logCount = expvar.NewInt("log_count")
 
+var tracer = otel.Tracer("github.com/google/mtail/internal/tailer")
+
+// SelfMetrics describes the package's counters for export to Prometheus.
+var SelfMetrics = []selfmetrics.Var{
+	selfmetrics.Gauge(logCount, "log_count", "number of logs being tailed"),
+}
+
 // Tailer polls the filesystem for log sources that match given
 // `LogPathPatterns` and creates `LogStream`s to tail them.
 type Tailer struct {
@@ -39,6 +51,9 @@ type Tailer struct {
 
 	socketPaths []string
 
+	sourcesMu sync.RWMutex // protects `sources'
+	sources   []LogSource  // patterns with settings for their logstreams, in the order added
+
 	oneShot bool
 
 	pollMu sync.Mutex // protects Poll()
@@ -78,6 +93,18 @@ func (opt LogPatterns) apply(t *Tailer) error {
 	return nil
 }
 
+// LogSource adds a glob pattern to match pathnames, as LogPatterns does, with
+// settings for the logstreams of the logs it matches.
+type LogSource struct {
+	Pattern   string
+	PollWaker waker.Waker // Wakes the idle logstreams, instead of the LogstreamPollWaker if set.
+	Encoding  string      // Character encoding of the logs; UTF-8 if empty.
+}
+
+func (opt LogSource) apply(t *Tailer) error {
+	return t.AddSource(opt)
+}
+
 // IgnoreRegex sets the regular expression to use to filter away pathnames that match the LogPatterns glob.
 type IgnoreRegex string
 
@@ -230,6 +257,74 @@ func (t *Tailer) AddPattern(pattern string) error {
 	return nil
 }
 
+// AddSource adds the pattern of the LogSource to the list of patterns to
+// filter filenames against, and applies its settings to the logstreams of the
+// logs that match it.  Where the patterns of several sources match a log, the
+// settings of the first added apply.
+func (t *Tailer) AddSource(s LogSource) error {
+	if err := logstream.ValidateEncoding(s.Encoding); err != nil {
+		return err
+	}
+	if err := t.AddPattern(s.Pattern); err != nil {
+		return err
+	}
+	s.Pattern = canonicalPattern(s.Pattern)
+	t.sourcesMu.Lock()
+	t.sources = append(t.sources, s)
+	t.sourcesMu.Unlock()
+	return nil
+}
+
+// TailSource adds the LogSource to a running Tailer, and starts tailing the
+// logs it matches.
+func (t *Tailer) TailSource(s LogSource) error {
+	if err := t.AddSource(s); err != nil {
+		return err
+	}
+	switch u, err := url.Parse(s.Pattern); {
+	case err == nil && (u.Scheme == "unix" || u.Scheme == "unixgram" || u.Scheme == "tcp" || u.Scheme == "udp"):
+		return t.TailPath(s.Pattern)
+	default:
+		return t.PollLogPatterns()
+	}
+}
+
+// canonicalPattern returns the pattern as it is stored by AddPattern, and so
+// the form of the pathnames TailPath is called with: socket URLs are
+// unchanged, and paths are made absolute.
+func canonicalPattern(pattern string) string {
+	u, err := url.Parse(pattern)
+	if err != nil {
+		return pattern
+	}
+	path := pattern
+	switch u.Scheme {
+	case "unix", "unixgram", "tcp", "udp":
+		return pattern
+	case "", "file":
+		path = u.Path
+	}
+	if absPath, err := filepath.Abs(path); err == nil {
+		return absPath
+	}
+	return pattern
+}
+
+// sourceFor returns the first LogSource whose pattern matches pathname, if any.
+func (t *Tailer) sourceFor(pathname string) (LogSource, bool) {
+	t.sourcesMu.RLock()
+	defer t.sourcesMu.RUnlock()
+	for _, s := range t.sources {
+		if s.Pattern == pathname {
+			return s, true
+		}
+		if ok, err := filepath.Match(s.Pattern, pathname); err == nil && ok {
+			return s, true
+		}
+	}
+	return LogSource{}, false
+}
+
 func (t *Tailer) Ignore(pathname string) bool {
 	absPath, err := filepath.Abs(pathname)
 	if err != nil {
@@ -265,6 +360,8 @@ func (t *Tailer) SetIgnorePattern(pattern string) error {
 
 // TailPath registers a filesystem pathname to be tailed.
 func (t *Tailer) TailPath(pathname string) error {
+	_, span := tracer.Start(t.ctx, "tailer.TailPath", trace.WithAttributes(attribute.String("mtail.log", pathname)))
+	defer span.End()
 	t.logstreamsMu.Lock()
 	defer t.logstreamsMu.Unlock()
 	if l, ok := t.logstreams[pathname]; ok {
@@ -275,8 +372,18 @@ func (t *Tailer) TailPath(pathname string) error {
 		logCount.Add(-1) // Removing the current entry before re-adding.
 		glog.V(2).Infof("Existing logstream is finished, creating a new one.")
 	}
-	l, err := logstream.New(t.ctx, &t.wg, t.logstreamPollWaker, pathname, t.lines, t.oneShot)
+	w := t.logstreamPollWaker
+	var opts []logstream.Option
+	if s, ok := t.sourceFor(pathname); ok {
+		if s.PollWaker != nil {
+			w = s.PollWaker
+		}
+		opts = append(opts, logstream.Encoding(s.Encoding))
+	}
+	l, err := logstream.New(t.ctx, &t.wg, w, pathname, t.lines, t.oneShot, opts...)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed to create logstream")
 		return err
 	}
 	if t.oneShot {
@@ -291,10 +398,13 @@ func (t *Tailer) TailPath(pathname string) error {
 
 // ExpireStaleLogstreams removes logstreams that have had no reads for 1h or more.
 func (t *Tailer) ExpireStaleLogstreams() error {
+	_, span := tracer.Start(t.ctx, "tailer.ExpireStaleLogstreams")
+	defer span.End()
 	t.logstreamsMu.Lock()
 	defer t.logstreamsMu.Unlock()
-	for _, v := range t.logstreams {
+	for pathname, v := range t.logstreams {
 		if time.Since(v.LastReadTime()) > (time.Hour * 24) {
+			span.AddEvent("expired", trace.WithAttributes(attribute.String("mtail.log", pathname)))
 			v.Stop()
 		}
 	}
@@ -72,6 +72,48 @@ func TestHandleLogUpdate(t *testing.T) {
 	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
 }
 
+func TestLogSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sourceWaker, sourceAwaken := waker.NewTest(ctx, 1)
+	tmpDir := testutil.TestTempDir(t)
+	ta, lines, _, dir, stop := makeTestTail(t, LogSource{Pattern: filepath.Join(tmpDir, "*.latin1"), PollWaker: sourceWaker, Encoding: "latin1"})
+
+	logfile := filepath.Join(tmpDir, "log.latin1")
+	f := testutil.TestOpenFile(t, logfile)
+	defer f.Close()
+	if s, ok := ta.sourceFor(logfile); !ok || s.Encoding != "latin1" {
+		t.Errorf("sourceFor(%q) = %+v, %v", logfile, s, ok)
+	}
+	if _, ok := ta.sourceFor(filepath.Join(dir, "log")); ok {
+		t.Error("sourceFor matched a log outside the source")
+	}
+
+	testutil.FatalIfErr(t, ta.TailPath(logfile))
+	// The logstream is woken by the source's waker.
+	sourceAwaken(1)
+	testutil.WriteString(t, f, "caf\xe9\n")
+	sourceAwaken(1)
+
+	stop()
+
+	received := testutil.LinesReceived(lines)
+	expected := []*logline.LogLine{
+		{Filename: logfile, Line: "café"},
+	}
+	testutil.ExpectNoDiff(t, expected, received, testutil.IgnoreFields(logline.LogLine{}, "Context"))
+}
+
+func TestLogSourceUnsupportedEncoding(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
+	_, err := New(ctx, &wg, make(chan *logline.LogLine), LogSource{Pattern: "/var/log/*", Encoding: "ebcdic"})
+	if err == nil {
+		t.Error("expected error for unsupported encoding")
+	}
+}
+
 // TestHandleLogTruncate writes to a file, waits for those
 // writes to be seen, then truncates the file and writes some more.
 // At the end all lines written must be reported by the tailer.